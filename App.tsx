@@ -3,6 +3,8 @@ import { AuthProvider, useAuth } from './hooks/useAuth';
 import { NavigationProvider } from './hooks/useNavigation';
 import LoginPage from './pages/LoginPage';
 import MainLayout from './components/MainLayout';
+import { ChangePasswordModal } from './components/Header';
+import { MFAVerifyModal, TwoFactorModal } from './components/TwoFactor';
 
 const App: React.FC = () => {
   return (
@@ -20,6 +22,17 @@ const AppContent: React.FC = () => {
   if (!user) {
     return <LoginPage />;
   }
+
+  // Until the login is complete the token is only good for its next step.
+  if (user.mfaPending === 'verify') {
+    return <div className="min-h-screen bg-background"><MFAVerifyModal /></div>;
+  }
+  if (user.mfaPending === 'enroll') {
+    return <div className="min-h-screen bg-background"><TwoFactorModal forced onClose={() => undefined} /></div>;
+  }
+  if (user.mustChangePassword) {
+    return <div className="min-h-screen bg-background"><ChangePasswordModal forced onClose={() => undefined} /></div>;
+  }
   
   return <MainLayout />;
 }
@@ -1,18 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 
-	"webtop-launcher/internal/auth"
 	"webtop-launcher/internal/config"
 	"webtop-launcher/internal/database"
 	"webtop-launcher/internal/handlers"
-	"webtop-launcher/internal/middleware"
-
-	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
+	"webtop-launcher/internal/store"
 )
 
 func main() {
@@ -22,69 +19,40 @@ func main() {
 		log.Fatalf("Could not load configuration: %v", err)
 	}
 
-	// Initialize database connection
-	err = database.InitDB(cfg.DatabaseURL)
+	// "webtop-launcher migrate ..." manages the schema and exits.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg.DatabaseDriver, cfg.DatabaseDSN, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// Initialize database connection and apply pending migrations
+	db, err := database.Open(cfg.DatabaseDriver, cfg.DatabaseDSN)
 	if err != nil {
 		log.Fatalf("Could not connect to the database: %v", err)
 	}
-	defer database.DB.Close()
+	defer db.Close()
+	stores := store.NewSQL(db)
 
 	// Optional seeding (set SEED=true in environment to seed default users/apps)
 	if os.Getenv("SEED") == "true" {
 		log.Println("Seeding initial data...")
-		if err := database.SeedInitialData(); err != nil {
+		if err := store.Seed(context.Background(), stores); err != nil {
 			log.Fatalf("Failed to seed data: %v", err)
 		}
 	}
 
-	r := mux.NewRouter().StrictSlash(true)
-
-	// Simple CORS for demo/homelab usage
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-	})
-
-	// API routes
-	api := r.PathPrefix("/api").Subrouter()
-
-	// Authentication routes
-	authRouter := api.PathPrefix("/auth").Subrouter()
-	auth.RegisterAuthRoutes(authRouter)
-
-	// Admin routes with auth middleware
-	adminRouter := api.PathPrefix("/admin").Subrouter()
-	adminRouter.Use(middleware.AuthMiddleware)
-
-	// User management routes
-	userRouter := adminRouter.PathPrefix("/users").Subrouter()
-	handlers.RegisterUserRoutes(userRouter)
-
-	// Application management routes
-	appRouter := adminRouter.PathPrefix("/apps").Subrouter()
-	handlers.RegisterAppRoutes(appRouter)
-
-	// Portainer management routes
-	portainerRouter := adminRouter.PathPrefix("/portainer").Subrouter()
-	handlers.RegisterPortainerRoutes(portainerRouter)
-
-	// Session management routes (some are protected, some are not)
-	sessionRouter := api.PathPrefix("/sessions").Subrouter()
-	handlers.RegisterSessionRoutes(sessionRouter, adminRouter)
+	srv, err := handlers.NewServer(cfg, stores)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// Public-facing application list route
-	appsListRouter := api.PathPrefix("/apps").Subrouter()
-	appsListRouter.Use(middleware.AuthMiddleware)
-	appsListRouter.HandleFunc("", handlers.GetApps).Methods("GET")
+	// Traefik's file provider only sees sessions once the file is written.
+	if err := srv.Traefik.Sync(context.Background()); err != nil {
+		log.Printf("Could not write Traefik configuration: %v", err)
+	}
 
 	log.Println("Starting server on :8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	log.Fatal(http.ListenAndServe(":8080", srv.Router()))
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"webtop-launcher/internal/database"
+)
+
+const migrateUsage = `usage: webtop-launcher migrate [command]
+
+commands:
+  up          apply all pending migrations (default)
+  down [N]    roll back the last N migrations (default 1)
+  status      list migrations and whether they are applied`
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(driver, dataSourceName string, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "up", "down", "status":
+	case "help", "-h", "--help":
+		fmt.Println(migrateUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", command, migrateUsage)
+	}
+
+	db, err := database.Connect(driver, dataSourceName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch command {
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[0])
+			}
+			steps = n
+		}
+		return database.Rollback(db, driver, steps)
+	case "status":
+		states, err := database.MigrationStatus(db, driver)
+		if err != nil {
+			return err
+		}
+		applied := 0
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range states {
+			at := "pending"
+			if s.AppliedAt != nil {
+				at = s.AppliedAt.Format("2006-01-02 15:04:05")
+				applied++
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, at)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if applied == 0 {
+			fmt.Println("No migrations applied")
+		}
+		return nil
+	default:
+		return database.Migrate(db, driver)
+	}
+}
@@ -3,9 +3,26 @@ module webtop-launcher
 go 1.19
 
 require (
+	github.com/coreos/go-oidc/v3 v3.9.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/fxamacker/cbor/v2 v2.5.0
+	github.com/go-asn1-ber/asn1-ber v1.5.5
+	github.com/go-ldap/ldap/v3 v3.4.6
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.7
-	golang.org/x/crypto v0.0.0-20220829220503-c86fa9a7ed90
+	github.com/mattn/go-sqlite3 v1.14.16
+	golang.org/x/crypto v0.14.0
+	golang.org/x/oauth2 v0.13.0
+)
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
+	github.com/go-jose/go-jose/v3 v3.0.1 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	google.golang.org/appengine v1.6.8 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
 )
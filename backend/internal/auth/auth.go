@@ -1,45 +1,102 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"webtop-launcher/internal/config"
-	"webtop-launcher/internal/database"
-	"webtop-launcher/internal/models"
-
+	"webtop-launcher/internal/ldap"
 	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/oidc"
+	"webtop-launcher/internal/password"
+	"webtop-launcher/internal/secrets"
+	"webtop-launcher/internal/security"
+	"webtop-launcher/internal/store"
+	"webtop-launcher/internal/webauthn"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtKey []byte
-
-func init() {
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		panic(err)
-	}
-	jwtKey = []byte(cfg.JWTSecret)
+// Handler serves the /api/auth routes.
+type Handler struct {
+	Users     store.UserStore
+	Settings  store.SettingsStore
+	Tokens    store.TokenStore
+	Auth      *middleware.Auth
+	Passwords *password.Policy
+	// Throttle slows down failed logins per client and per username.
+	Throttle  *security.Throttle
+	ClientIPs *security.ClientIPResolver
+	// OIDC is the single sign-on provider, nil unless configured.
+	OIDC *oidc.Provider
+	// Secrets encrypts the TOTP secrets.
+	Secrets     *secrets.Box
+	Credentials store.CredentialStore
+	// WebAuthn configures passkeys; empty fields are filled in from each
+	// request.
+	WebAuthn webauthn.Config
+	// Ceremonies holds the challenges of finished WebAuthn ceremonies, so
+	// that each state is only accepted once.
+	Ceremonies *security.OneTime
+	// LDAPDial connects to the LDAP directory; nil dials it over the
+	// network.
+	LDAPDial ldap.Dialer
 }
 
+// Token lifetimes. Access tokens are short-lived and renewed with the
+// refresh token; a restricted token only has to last long enough to choose
+// a new password.
+const (
+	accessTokenLifetime     = 15 * time.Minute
+	refreshTokenLifetime    = 7 * 24 * time.Hour
+	restrictedTokenLifetime = 15 * time.Minute
+)
+
 type Credentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
 // RegisterAuthRoutes registers authentication-related routes.
-func RegisterAuthRoutes(router *mux.Router) {
-	router.HandleFunc("/login", LoginHandler).Methods("POST")
-	// change-password requires authentication - wrap the handler with middleware
-	router.Handle("/change-password", middleware.AuthMiddleware(http.HandlerFunc(ChangePasswordHandler))).Methods("POST")
+func (h *Handler) RegisterAuthRoutes(router *mux.Router) {
+	router.HandleFunc("/login", h.LoginHandler).Methods("POST")
+	router.HandleFunc("/refresh", h.RefreshHandler).Methods("POST")
+	router.HandleFunc("/logout", h.LogoutHandler).Methods("POST")
+	router.Handle("/me", h.Auth.AuthMiddleware(http.HandlerFunc(h.MeHandler))).Methods("GET")
+	router.HandleFunc("/oidc/config", h.OIDCConfigHandler).Methods("GET")
+	router.HandleFunc("/oidc/login", h.OIDCLoginHandler).Methods("GET")
+	router.HandleFunc("/oidc/callback", h.OIDCCallbackHandler).Methods("GET")
+	router.Handle("/2fa", h.Auth.LoginMiddleware(http.HandlerFunc(h.MFAStatusHandler))).Methods("GET")
+	router.Handle("/2fa/verify", h.Auth.MFAChallengeMiddleware(http.HandlerFunc(h.MFAVerifyHandler))).Methods("POST")
+	// Users who must enroll before their first full login do so with a
+	// restricted token.
+	router.Handle("/2fa/totp/enroll", h.Auth.MFAEnrollMiddleware(http.HandlerFunc(h.TOTPEnrollHandler))).Methods("POST")
+	router.Handle("/2fa/totp/confirm", h.Auth.MFAEnrollMiddleware(http.HandlerFunc(h.TOTPConfirmHandler))).Methods("POST")
+	router.Handle("/2fa/totp/disable", h.Auth.LoginMiddleware(http.HandlerFunc(h.TOTPDisableHandler))).Methods("POST")
+	router.Handle("/2fa/recovery-codes", h.Auth.LoginMiddleware(http.HandlerFunc(h.RecoveryCodesHandler))).Methods("POST")
+	router.Handle("/2fa/webauthn/begin", h.Auth.MFAChallengeMiddleware(http.HandlerFunc(h.PasskeyVerifyBeginHandler))).Methods("POST")
+	router.Handle("/2fa/webauthn/finish", h.Auth.MFAChallengeMiddleware(http.HandlerFunc(h.PasskeyVerifyFinishHandler))).Methods("POST")
+	router.Handle("/webauthn/credentials", h.Auth.LoginMiddleware(http.HandlerFunc(h.ListPasskeysHandler))).Methods("GET")
+	router.Handle("/webauthn/credentials/{id}", h.Auth.LoginMiddleware(http.HandlerFunc(h.DeletePasskeyHandler))).Methods("DELETE")
+	router.Handle("/step-up", h.Auth.LoginMiddleware(http.HandlerFunc(h.StepUpHandler))).Methods("POST")
+	router.Handle("/webauthn/register/begin", h.Auth.MFAEnrollMiddleware(http.HandlerFunc(h.PasskeyRegisterBeginHandler))).Methods("POST")
+	router.Handle("/webauthn/register/finish", h.Auth.MFAEnrollMiddleware(http.HandlerFunc(h.PasskeyRegisterFinishHandler))).Methods("POST")
+	router.HandleFunc("/webauthn/login/begin", h.PasskeyLoginBeginHandler).Methods("POST")
+	router.HandleFunc("/webauthn/login/finish", h.PasskeyLoginFinishHandler).Methods("POST")
+	// change-password requires authentication, and is the one route that
+	// accepts the restricted token of a user who must change their password
+	router.Handle("/change-password", h.Auth.PasswordChangeMiddleware(http.HandlerFunc(h.ChangePasswordHandler))).Methods("POST")
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
 	err := json.NewDecoder(r.Body).Decode(&creds)
 	if err != nil {
@@ -47,45 +104,185 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Log login attempts for debugging (do not keep verbose logging in production)
-	log.Printf("Login attempt: username=%s remote=%s password_len=%d", creds.Username, r.RemoteAddr, len(creds.Password))
-
-	user := &models.User{}
-	err = database.DB.QueryRow("SELECT id, username, password_hash, is_admin FROM users WHERE username = $1", creds.Username).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.IsAdmin)
-	if err != nil {
+	// Both the client and the username back off, so neither spraying one
+	// password over many accounts nor many clients against one account
+	// gets far.
+	clientIP := h.ClientIPs.ClientIP(r)
+	ipKey, userKey := "ip:"+clientIP, "user:"+strings.ToLower(creds.Username)
+	event := security.Event{Username: creds.Username, ClientIP: clientIP}
+	if wait := maxDuration(h.Throttle.Wait(ipKey), h.Throttle.Wait(userKey)); wait > 0 {
+		event.Type = security.LoginThrottled
+		security.Log(event)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+	fail := func(reason string) {
+		h.Throttle.Fail(ipKey)
+		h.Throttle.Fail(userKey)
+		event.Type, event.Reason = security.LoginFailed, reason
+		security.Log(event)
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+	}
+
+	// The account is looked up first so a locked one is refused without
+	// asking the authenticators, and failures count against it.
+	user, err := h.Users.GetByUsername(r.Context(), creds.Username)
+	if err == store.ErrNotFound {
+		user = nil
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
+	} else {
+		event.UserID = user.ID
+		if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+			event.Type = security.LoginLocked
+			security.Log(event)
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*user.LockedUntil)/time.Second)+1))
+			http.Error(w, "Account is locked, try again later or ask an administrator", http.StatusLocked)
+			return
+		}
 	}
 
-	// Log stored hash info for debugging
-	log.Printf("Stored hash length=%d prefix=%.20s", len(user.PasswordHash), user.PasswordHash)
+	authenticated, err := h.authenticate(r.Context(), creds.Username, creds.Password)
+	switch err {
+	case nil:
+	case errUnknownUser:
+		// Take as long as a wrong password, so response times do not tell
+		// which usernames exist.
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(creds.Password))
+		fail("unknown user")
+		return
+	case errInvalidCredentials:
+		fail("wrong password")
+		if user != nil {
+			h.recordFailure(r.Context(), user, clientIP)
+		}
+		return
+	case errUsernameTaken:
+		fail("directory user clashes with an existing user")
+		return
+	default:
+		log.Printf("Could not authenticate %s: %v", creds.Username, err)
+		http.Error(w, "Authentication service unavailable, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	user = authenticated
+	event.UserID = user.ID
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password)); err != nil {
-		// Detailed debug log to help diagnose why password compare fails.
-		// WARNING: do not leave verbose password logs enabled in production.
-		log.Printf("Password compare failed for user=%s: %v", creds.Username, err)
-		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+	factors, err := h.secondFactors(r.Context(), user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if len(factors) > 0 {
+		// Failures are only cleared once the code is verified too, or a
+		// known password would give unlimited guesses at codes.
+		h.issueChallenge(w, r, user, factors)
+		return
+	}
+	h.completeLogin(w, r, user, event)
+}
 
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &jwt.StandardClaims{
-		Subject:   user.ID,
-		ExpiresAt: expirationTime.Unix(),
+// completeLogin answers with tokens for user, who has passed every factor
+// of their login, and clears their failed logins. Users who have to enroll
+// in two-factor authentication first get a restricted token for that.
+func (h *Handler) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, event security.Event) {
+	h.Throttle.Reset("user:" + strings.ToLower(user.Username))
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := h.Users.ClearLoginFailures(r.Context(), user.ID); err != nil {
+			log.Printf("Could not reset failed logins of %s: %v", user.Username, err)
+		}
 	}
+	event.Type = security.LoginSucceeded
+	security.Log(event)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	factors, err := h.secondFactors(r.Context(), user)
 	if err != nil {
-		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if len(factors) == 0 {
+		required, err := LoadMFARequired(r.Context(), h.Settings)
+		if err != nil {
+			log.Printf("Could not load the two-factor policy, requiring it: %v", err)
+			required = true
+		}
+		if required {
+			h.issueEnrollToken(w, r, user)
+			return
+		}
+	}
+	h.issueToken(w, r, user)
+}
 
-	json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
+// recordFailure counts a wrong password against the account and locks it
+// once the lockout threshold is reached.
+func (h *Handler) recordFailure(ctx context.Context, user *models.User, clientIP string) {
+	policy, err := LoadLockoutPolicy(ctx, h.Settings)
+	if err != nil {
+		log.Printf("Could not load lockout policy, using the default: %v", err)
+	}
+	if policy.Threshold == 0 {
+		return
+	}
+	locked, err := h.Users.RecordLoginFailure(ctx, user.ID, policy.Threshold, time.Now().Add(policy.Duration))
+	if err != nil {
+		log.Printf("Could not record failed login of %s: %v", user.Username, err)
+		return
+	}
+	if locked {
+		security.Log(security.Event{
+			Type:     security.AccountLocked,
+			Username: user.Username,
+			UserID:   user.ID,
+			ClientIP: clientIP,
+			Reason:   fmt.Sprintf("%d failed logins, locked for %s", policy.Threshold, policy.Duration),
+		})
+	}
+}
+
+var (
+	dummyHashOnce  sync.Once
+	dummyHashValue []byte
+)
+
+// dummyHash is a bcrypt hash to compare against when the user does not
+// exist.
+func dummyHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHashValue, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	})
+	return dummyHashValue
 }
 
-func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.Context().Value("userID").(string)
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// MeHandler returns the signed-in user and the permissions their roles
+// grant, which the frontend shows its pages by. With an API token, those
+// are limited to its scopes.
+func (h *Handler) MeHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	perms, err := h.Auth.Permissions(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(struct {
+		*models.User
+		Permissions []string `json:"permissions"`
+	}{user, perms})
+}
+
+// ChangePasswordHandler sets a new password that satisfies the policy,
+// revokes the user's other logins and answers with new unrestricted tokens.
+func (h *Handler) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r.Context())
 	var creds struct {
 		CurrentPassword string `json:"currentPassword"`
 		NewPassword     string `json:"newPassword"`
@@ -95,18 +292,29 @@ func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var passwordHash string
-	err := database.DB.QueryRow("SELECT password_hash FROM users WHERE id = $1", userID).Scan(&passwordHash)
+	user, err := h.Users.Get(r.Context(), userID)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	if user.AuthProvider != models.AuthLocal {
+		http.Error(w, "Password is managed by the identity provider", http.StatusBadRequest)
+		return
+	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(creds.CurrentPassword))
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.CurrentPassword))
 	if err != nil {
 		http.Error(w, "Invalid current password", http.StatusUnauthorized)
 		return
 	}
+	if creds.NewPassword == creds.CurrentPassword {
+		http.Error(w, "New password must differ from the current one", http.StatusBadRequest)
+		return
+	}
+	if err := h.Passwords.Check(user.Username, creds.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	newPasswordHash, err := bcrypt.GenerateFromPassword([]byte(creds.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
@@ -114,11 +322,24 @@ func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = database.DB.Exec("UPDATE users SET password_hash = $1 WHERE id = $2", string(newPasswordHash), userID)
+	// Changing the password also clears a reset's must-change flag.
+	err = h.Users.UpdatePassword(r.Context(), userID, string(newPasswordHash), false)
 	if err != nil {
 		http.Error(w, "Could not update password", http.StatusInternalServerError)
 		return
 	}
+	security.Log(security.Event{
+		Type:     security.PasswordChanged,
+		Username: user.Username,
+		UserID:   user.ID,
+		ClientIP: h.ClientIPs.ClientIP(r),
+	})
 
-	w.WriteHeader(http.StatusOK)
+	// Sign out every other login; this one continues with new tokens.
+	if err := h.Tokens.RevokeUser(r.Context(), user.ID, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user.MustChangePassword = false
+	h.issueToken(w, r, user)
 }
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"webtop-launcher/internal/ldap"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// An Authenticator checks a username and password against one source of
+// users. LoginHandler asks the authenticators of the auth_chain setting in
+// order until one knows the user.
+type Authenticator interface {
+	// Authenticate returns the user the credentials belong to. It returns
+	// errUnknownUser to pass the user on to the next authenticator, and
+	// errInvalidCredentials when the user is its own but the password is
+	// wrong.
+	Authenticate(ctx context.Context, username, password string) (*models.User, error)
+}
+
+var (
+	errUnknownUser        = errors.New("unknown user")
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Names of the authenticators in the auth_chain setting.
+const (
+	AuthenticatorLocal = "local"
+	AuthenticatorLDAP  = "ldap"
+)
+
+const authChainKey = "auth_chain"
+
+// DefaultAuthChain applies until an admin changes it.
+var DefaultAuthChain = []string{AuthenticatorLocal}
+
+// LoadAuthChain reads the names of the authenticators to try, in order.
+func LoadAuthChain(ctx context.Context, settings store.SettingsStore) ([]string, error) {
+	values, err := settings.Get(ctx, authChainKey)
+	if err != nil {
+		return DefaultAuthChain, err
+	}
+	if values[authChainKey] == "" {
+		return DefaultAuthChain, nil
+	}
+	return strings.Split(values[authChainKey], ","), nil
+}
+
+// SaveAuthChain validates and stores the authenticators to try. LDAP can
+// only be enabled once the directory is configured.
+func SaveAuthChain(ctx context.Context, settings store.SettingsStore, chain []string) error {
+	if len(chain) == 0 {
+		return errors.New("at least one authenticator is required")
+	}
+	seen := map[string]bool{}
+	for _, name := range chain {
+		switch name {
+		case AuthenticatorLocal:
+		case AuthenticatorLDAP:
+			cfg, err := LoadLDAPConfig(ctx, settings)
+			if err != nil {
+				return err
+			}
+			if err := cfg.Validate(); err != nil {
+				return fmt.Errorf("configure LDAP first: %v", err)
+			}
+		default:
+			return fmt.Errorf("unknown authenticator %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("authenticator %q is listed twice", name)
+		}
+		seen[name] = true
+	}
+	return settings.Set(ctx, map[string]string{authChainKey: strings.Join(chain, ",")})
+}
+
+// authenticate asks the configured authenticators in turn.
+func (h *Handler) authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	chain, err := LoadAuthChain(ctx, h.Settings)
+	if err != nil {
+		log.Printf("Could not load the authenticator chain, using the default: %v", err)
+	}
+	for _, name := range chain {
+		var a Authenticator
+		switch name {
+		case AuthenticatorLocal:
+			a = &localAuthenticator{users: h.Users}
+		case AuthenticatorLDAP:
+			cfg, err := LoadLDAPConfig(ctx, h.Settings)
+			if err != nil {
+				return nil, err
+			}
+			if cfg.URL == "" {
+				log.Printf("Skipping LDAP authenticator: no directory configured")
+				continue
+			}
+			cfg.Dial = h.LDAPDial
+			a = &ldapAuthenticator{h: h, cfg: cfg}
+		default:
+			log.Printf("Skipping unknown authenticator %q", name)
+			continue
+		}
+		user, err := a.Authenticate(ctx, username, password)
+		if err != errUnknownUser {
+			return user, err
+		}
+	}
+	return nil, errUnknownUser
+}
+
+// localAuthenticator checks the bcrypt hash of local users.
+type localAuthenticator struct {
+	users store.UserStore
+}
+
+func (a *localAuthenticator) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	user, err := a.users.GetByUsername(ctx, username)
+	if err == store.ErrNotFound {
+		return nil, errUnknownUser
+	} else if err != nil {
+		return nil, err
+	}
+	if user.AuthProvider != models.AuthLocal {
+		return nil, errUnknownUser
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, errInvalidCredentials
+	}
+	return user, nil
+}
+
+// ldapAuthenticator binds to the directory as the user, and mirrors users
+// it accepts into the users table, without a password.
+type ldapAuthenticator struct {
+	h   *Handler
+	cfg *ldap.Config
+}
+
+func (a *ldapAuthenticator) Authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	id, err := ldap.Authenticate(a.cfg, username, password)
+	if err == ldap.ErrUserNotFound {
+		return nil, errUnknownUser
+	} else if err == ldap.ErrInvalidCredentials {
+		return nil, errInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	return a.h.externalUser(ctx, externalIdentity{
+		Provider: models.AuthLDAP,
+		// DNs compare case-insensitively.
+		ID:         strings.ToLower(id.DN),
+		Username:   id.Username,
+		IsAdmin:    id.IsAdmin,
+		AdminKnown: id.AdminKnown,
+	})
+}
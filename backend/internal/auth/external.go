@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/security"
+	"webtop-launcher/internal/store"
+)
+
+// errUsernameTaken is returned when a new external user has the username of
+// an existing user from elsewhere. Linking them automatically would let the
+// provider sign in as that user.
+var errUsernameTaken = errors.New("a user with this username already exists; ask an administrator")
+
+// externalIdentity is a user as asserted by an identity provider or
+// directory.
+type externalIdentity struct {
+	// Provider is one of the models.Auth* constants, and ID the provider's
+	// stable ID for the user.
+	Provider string
+	ID       string
+	Username string
+	// IsAdmin is only meaningful when AdminKnown is set.
+	IsAdmin    bool
+	AdminKnown bool
+}
+
+// externalUser returns the user linked to id, creating it on first sign-in
+// without a password. When the provider decides who is an admin, the
+// admin flag follows it on every sign-in.
+func (h *Handler) externalUser(ctx context.Context, id externalIdentity) (*models.User, error) {
+	user, err := h.Users.GetByExternalID(ctx, id.Provider, id.ID)
+	if err == store.ErrNotFound {
+		user = &models.User{
+			Username:     id.Username,
+			IsAdmin:      id.AdminKnown && id.IsAdmin,
+			AuthProvider: id.Provider,
+			ExternalID:   id.ID,
+		}
+		if err := h.Users.Create(ctx, user); err == store.ErrConflict {
+			return nil, errUsernameTaken
+		} else if err != nil {
+			return nil, err
+		}
+		security.Log(security.Event{
+			Type:     security.UserProvisioned,
+			Username: user.Username,
+			UserID:   user.ID,
+			Reason:   id.Provider,
+		})
+		return user, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if id.AdminKnown && user.IsAdmin != id.IsAdmin {
+		updated := *user
+		updated.IsAdmin = id.IsAdmin
+		err := h.Users.Update(ctx, &updated)
+		if err == store.ErrLastAdmin {
+			log.Printf("Not demoting %s, who left the admin group: they are the last admin", user.Username)
+		} else if err != nil {
+			return nil, err
+		} else {
+			user = &updated
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+
+	"webtop-launcher/internal/ldap"
+	"webtop-launcher/internal/store"
+)
+
+// Settings keys of the LDAP directory.
+const (
+	ldapURLKey                = "ldap_url"
+	ldapStartTLSKey           = "ldap_start_tls"
+	ldapInsecureSkipVerifyKey = "ldap_insecure_skip_verify"
+	ldapBindDNKey             = "ldap_bind_dn"
+	ldapBindPasswordKey       = "ldap_bind_password"
+	ldapBaseDNKey             = "ldap_base_dn"
+	ldapUserFilterKey         = "ldap_user_filter"
+	ldapUsernameAttributeKey  = "ldap_username_attribute"
+	ldapGroupBaseDNKey        = "ldap_group_base_dn"
+	ldapGroupFilterKey        = "ldap_group_filter"
+	ldapAdminGroupKey         = "ldap_admin_group"
+)
+
+// LoadLDAPConfig reads the LDAP directory from the settings. The URL is
+// empty until an admin configures one.
+func LoadLDAPConfig(ctx context.Context, settings store.SettingsStore) (*ldap.Config, error) {
+	values, err := settings.Get(ctx, ldapURLKey, ldapStartTLSKey, ldapInsecureSkipVerifyKey,
+		ldapBindDNKey, ldapBindPasswordKey, ldapBaseDNKey, ldapUserFilterKey,
+		ldapUsernameAttributeKey, ldapGroupBaseDNKey, ldapGroupFilterKey, ldapAdminGroupKey)
+	if err != nil {
+		return nil, err
+	}
+	startTLS, _ := strconv.ParseBool(values[ldapStartTLSKey])
+	skipVerify, _ := strconv.ParseBool(values[ldapInsecureSkipVerifyKey])
+	return &ldap.Config{
+		URL:                values[ldapURLKey],
+		StartTLS:           startTLS,
+		InsecureSkipVerify: skipVerify,
+		BindDN:             values[ldapBindDNKey],
+		BindPassword:       values[ldapBindPasswordKey],
+		BaseDN:             values[ldapBaseDNKey],
+		UserFilter:         values[ldapUserFilterKey],
+		UsernameAttribute:  values[ldapUsernameAttributeKey],
+		GroupBaseDN:        values[ldapGroupBaseDNKey],
+		GroupFilter:        values[ldapGroupFilterKey],
+		AdminGroup:         values[ldapAdminGroupKey],
+	}, nil
+}
+
+// SaveLDAPConfig validates and stores the LDAP directory.
+func SaveLDAPConfig(ctx context.Context, settings store.SettingsStore, cfg *ldap.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	return settings.Set(ctx, map[string]string{
+		ldapURLKey:                cfg.URL,
+		ldapStartTLSKey:           strconv.FormatBool(cfg.StartTLS),
+		ldapInsecureSkipVerifyKey: strconv.FormatBool(cfg.InsecureSkipVerify),
+		ldapBindDNKey:             cfg.BindDN,
+		ldapBindPasswordKey:       cfg.BindPassword,
+		ldapBaseDNKey:             cfg.BaseDN,
+		ldapUserFilterKey:         cfg.UserFilter,
+		ldapUsernameAttributeKey:  cfg.UsernameAttribute,
+		ldapGroupBaseDNKey:        cfg.GroupBaseDN,
+		ldapGroupFilterKey:        cfg.GroupFilter,
+		ldapAdminGroupKey:         cfg.AdminGroup,
+	})
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"webtop-launcher/internal/store"
+)
+
+// Settings keys of the lockout policy.
+const (
+	lockoutThresholdKey = "lockout_threshold"
+	lockoutDurationKey  = "lockout_duration_minutes"
+)
+
+// LockoutPolicy locks an account for Duration after Threshold consecutive
+// failed logins. A Threshold of 0 disables lockout.
+type LockoutPolicy struct {
+	Threshold int
+	Duration  time.Duration
+}
+
+// DefaultLockoutPolicy applies until an admin changes it.
+var DefaultLockoutPolicy = LockoutPolicy{Threshold: 5, Duration: 15 * time.Minute}
+
+// LoadLockoutPolicy reads the lockout policy from the settings, falling
+// back to DefaultLockoutPolicy for missing or unreadable values.
+func LoadLockoutPolicy(ctx context.Context, settings store.SettingsStore) (LockoutPolicy, error) {
+	policy := DefaultLockoutPolicy
+	values, err := settings.Get(ctx, lockoutThresholdKey, lockoutDurationKey)
+	if err != nil {
+		return policy, err
+	}
+	if n, err := strconv.Atoi(values[lockoutThresholdKey]); err == nil && n >= 0 {
+		policy.Threshold = n
+	}
+	if n, err := strconv.Atoi(values[lockoutDurationKey]); err == nil && n > 0 {
+		policy.Duration = time.Duration(n) * time.Minute
+	}
+	return policy, nil
+}
+
+// SaveLockoutPolicy validates and stores the lockout policy.
+func SaveLockoutPolicy(ctx context.Context, settings store.SettingsStore, policy LockoutPolicy) error {
+	if policy.Threshold < 0 {
+		return errors.New("threshold must not be negative")
+	}
+	if policy.Duration < time.Minute {
+		return errors.New("duration must be at least one minute")
+	}
+	return settings.Set(ctx, map[string]string{
+		lockoutThresholdKey: strconv.Itoa(policy.Threshold),
+		lockoutDurationKey:  strconv.Itoa(int(policy.Duration / time.Minute)),
+	})
+}
@@ -0,0 +1,408 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/security"
+	"webtop-launcher/internal/store"
+	"webtop-launcher/internal/totp"
+)
+
+// Users with two-factor authentication log in in two steps: a correct
+// password gets them a challenge token, which /2fa/verify exchanges for
+// the real tokens given a TOTP code or a recovery code, and
+// /2fa/webauthn/finish given a passkey (see webauthn.go). Wrong codes count
+// as failed logins. When the mfa_required setting is on, users who log in
+// with a password but have not enrolled get a token that only allows
+// enrolling. Single sign-on users are left to their identity provider.
+
+const mfaRequiredKey = "mfa_required"
+
+const (
+	// mfaChallengeLifetime is how long a user has to enter their code
+	// after the password.
+	mfaChallengeLifetime = 5 * time.Minute
+	recoveryCodeCount    = 10
+	// totpIssuer names the launcher in authenticator apps.
+	totpIssuer = "Webtop Launcher"
+)
+
+// LoadMFARequired reads whether users who sign in with a password must use
+// two-factor authentication.
+func LoadMFARequired(ctx context.Context, settings store.SettingsStore) (bool, error) {
+	values, err := settings.Get(ctx, mfaRequiredKey)
+	if err != nil {
+		return false, err
+	}
+	required, _ := strconv.ParseBool(values[mfaRequiredKey])
+	return required, nil
+}
+
+// SaveMFARequired stores the two-factor policy.
+func SaveMFARequired(ctx context.Context, settings store.SettingsStore, required bool) error {
+	return settings.Set(ctx, map[string]string{mfaRequiredKey: strconv.FormatBool(required)})
+}
+
+// issueChallenge answers a correct password with a challenge token for the
+// second factor, listing the factors the user can choose from.
+func (h *Handler) issueChallenge(w http.ResponseWriter, r *http.Request, user *models.User, factors []string) {
+	h.issueRestrictedToken(w, r, user, middleware.ScopeMFA, mfaChallengeLifetime,
+		map[string]interface{}{"mfaRequired": true, "methods": factors})
+}
+
+// issueEnrollToken answers the login of a user who must enroll first.
+func (h *Handler) issueEnrollToken(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.issueRestrictedToken(w, r, user, middleware.ScopeMFAEnroll, restrictedTokenLifetime,
+		map[string]interface{}{"mfaEnrollmentRequired": true})
+}
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+// MFAVerifyHandler completes a login with the second factor.
+func (h *Handler) MFAVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	var req codeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.verifySecondFactor(w, r, user, req.Code) {
+		return
+	}
+	h.completeLogin(w, r, user, security.Event{
+		Username: user.Username,
+		UserID:   user.ID,
+		ClientIP: h.ClientIPs.ClientIP(r),
+	})
+}
+
+// verifySecondFactor checks code, a TOTP code or a recovery code, for user,
+// who must have a second factor, an authenticator app or a passkey. Wrong
+// codes are throttled and locked out like wrong passwords. When the
+// code is not accepted it answers the request and returns false.
+func (h *Handler) verifySecondFactor(w http.ResponseWriter, r *http.Request, user *models.User, code string) bool {
+	ctx := r.Context()
+	clientIP := h.ClientIPs.ClientIP(r)
+	ipKey, userKey := "ip:"+clientIP, "user:"+strings.ToLower(user.Username)
+	event := security.Event{Username: user.Username, UserID: user.ID, ClientIP: clientIP}
+	current, ok := h.secondFactorAllowed(w, r, user, event)
+	if !ok {
+		return false
+	}
+	factors, err := h.secondFactors(ctx, current)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if len(factors) == 0 {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return false
+	}
+
+	recovery, err := h.checkSecondFactor(ctx, current, code)
+	if err == errInvalidCredentials {
+		h.Throttle.Fail(ipKey)
+		h.Throttle.Fail(userKey)
+		event.Type, event.Reason = security.MFAFailed, "wrong code"
+		security.Log(event)
+		h.recordFailure(ctx, current, clientIP)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if recovery {
+		left, _ := h.Users.CountRecoveryCodes(ctx, user.ID)
+		event.Type, event.Reason = security.RecoveryUsed, strconv.Itoa(left)+" left"
+		security.Log(event)
+	}
+	return true
+}
+
+// secondFactorAllowed refuses to check a second factor while the client or
+// user is throttled or the account is locked, answering the request. It
+// returns the user as currently stored.
+func (h *Handler) secondFactorAllowed(w http.ResponseWriter, r *http.Request, user *models.User, event security.Event) (*models.User, bool) {
+	clientIP := h.ClientIPs.ClientIP(r)
+	ipKey, userKey := "ip:"+clientIP, "user:"+strings.ToLower(user.Username)
+	if wait := maxDuration(h.Throttle.Wait(ipKey), h.Throttle.Wait(userKey)); wait > 0 {
+		event.Type = security.LoginThrottled
+		security.Log(event)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return nil, false
+	}
+	// Reload: the account may have been locked since the password step.
+	current, err := h.Users.Get(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if current.LockedUntil != nil && time.Now().Before(*current.LockedUntil) {
+		event.Type = security.LoginLocked
+		security.Log(event)
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*current.LockedUntil)/time.Second)+1))
+		http.Error(w, "Account is locked, try again later or ask an administrator", http.StatusLocked)
+		return nil, false
+	}
+	return current, true
+}
+
+// checkSecondFactor accepts a TOTP code once, or an unused recovery code,
+// and reports whether it was a recovery code. Wrong codes are
+// errInvalidCredentials.
+func (h *Handler) checkSecondFactor(ctx context.Context, user *models.User, code string) (recovery bool, err error) {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		if !user.TOTPEnabled {
+			return false, errInvalidCredentials
+		}
+		step, err := h.validateTOTP(user, code)
+		if err != nil {
+			return false, err
+		}
+		err = h.Users.UseTOTPStep(ctx, user.ID, step)
+		if err == store.ErrConflict {
+			// Already used, or older than a code that was.
+			return false, errInvalidCredentials
+		}
+		return false, err
+	}
+	err = h.Users.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code), time.Now())
+	if err == store.ErrNotFound {
+		return false, errInvalidCredentials
+	}
+	return err == nil, err
+}
+
+// validateTOTP returns the time step code is valid for under the user's
+// secret.
+func (h *Handler) validateTOTP(user *models.User, code string) (int64, error) {
+	secret, err := h.Secrets.Decrypt(user.TOTPSecret)
+	if err != nil {
+		log.Printf("Could not decrypt the TOTP secret of %s, was ENCRYPTION_KEY changed? %v", user.Username, err)
+		return 0, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return 0, errInvalidCredentials
+	}
+	return step, nil
+}
+
+// MFAStatusHandler tells the user whether TOTP is enabled and how many
+// passkeys they have, whether two-factor authentication is required, and
+// how many recovery codes they have left.
+func (h *Handler) MFAStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	required, err := LoadMFARequired(r.Context(), h.Settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	left, err := h.Users.CountRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	creds, err := h.Credentials.ListByUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":           user.TOTPEnabled,
+		"passkeys":          len(creds),
+		"required":          required && user.AuthProvider != models.AuthOIDC,
+		"available":         user.AuthProvider != models.AuthOIDC,
+		"recoveryCodesLeft": left,
+	})
+}
+
+// TOTPEnrollHandler starts an enrollment: it stores a new secret and
+// returns it for the authenticator app. It only takes effect once
+// confirmed with a code.
+func (h *Handler) TOTPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	if user.AuthProvider == models.AuthOIDC {
+		http.Error(w, "Two-factor authentication is managed by the identity provider", http.StatusBadRequest)
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled; disable it first", http.StatusConflict)
+		return
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encrypted, err := h.Secrets.Encrypt(secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.Users.SetTOTPSecret(r.Context(), user.ID, encrypted); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"url":    totp.URL(totpIssuer, user.Username, secret),
+	})
+}
+
+// TOTPConfirmHandler enables two-factor authentication once the user
+// proves their app has the secret, and returns the recovery codes, which
+// are only shown this once. A user who had to enroll to log in also gets
+// their tokens.
+func (h *Handler) TOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, _ := middleware.UserFromContext(ctx)
+	var req codeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if user.TOTPSecret == "" {
+		http.Error(w, "Start the enrollment first", http.StatusBadRequest)
+		return
+	}
+	step, err := h.validateTOTP(user, strings.TrimSpace(req.Code))
+	if err == errInvalidCredentials {
+		http.Error(w, "Invalid code, check the time on your device", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	if err := h.Users.EnableTOTP(ctx, user.ID, step, hashes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	security.Log(security.Event{
+		Type:     security.MFAEnabled,
+		Username: user.Username,
+		UserID:   user.ID,
+		ClientIP: h.ClientIPs.ClientIP(r),
+	})
+
+	response := map[string]interface{}{"recoveryCodes": codes}
+	if middleware.TokenScope(ctx) == middleware.ScopeMFAEnroll {
+		user.TOTPEnabled = true
+		h.issueTokenWith(w, r, user, response)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// TOTPDisableHandler turns TOTP off, given a current code. While the policy
+// requires two-factor authentication it is refused unless the user has a
+// passkey.
+func (h *Handler) TOTPDisableHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, _ := middleware.UserFromContext(ctx)
+	var req codeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !user.TOTPEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	required, err := LoadMFARequired(ctx, h.Settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if required {
+		creds, err := h.Credentials.ListByUser(ctx, user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(creds) == 0 {
+			http.Error(w, "Two-factor authentication is required", http.StatusForbidden)
+			return
+		}
+	}
+	if !h.verifySecondFactor(w, r, user, req.Code) {
+		return
+	}
+	if err := h.Users.DisableTOTP(ctx, user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	security.Log(security.Event{
+		Type:     security.MFADisabled,
+		Username: user.Username,
+		UserID:   user.ID,
+		ClientIP: h.ClientIPs.ClientIP(r),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RecoveryCodesHandler replaces the recovery codes, given a current code,
+// for a user with any second factor.
+func (h *Handler) RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	var req codeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.verifySecondFactor(w, r, user, req.Code) {
+		return
+	}
+	codes, hashes := newRecoveryCodes()
+	if err := h.Users.ReplaceRecoveryCodes(r.Context(), user.ID, hashes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": codes})
+}
+
+// recoveryCodeEncoding leaves out 0, O, 1 and I, which are easily confused.
+var recoveryCodeEncoding = base32.NewEncoding("ABCDEFGHJKLMNPQRSTUVWXYZ23456789").WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns fresh recovery codes, formatted like
+// ABCD-EFGH-JKMN-PQRS, and their hashes.
+func newRecoveryCodes() (codes, hashes []string) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		raw := recoveryCodeEncoding.EncodeToString(b)
+		codes = append(codes, raw[:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:16])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes
+}
+
+// hashRecoveryCode is how recovery codes are stored. They carry 80 random
+// bits, so a plain SHA-256 is enough; dashes and case do not matter.
+func hashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return security.HashToken(code)
+}
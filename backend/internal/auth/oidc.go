@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/oidc"
+	"webtop-launcher/internal/security"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// The browser carries the state, nonce and PKCE verifier of a single
+// sign-on attempt from /oidc/login to /oidc/callback in a signed cookie.
+const (
+	oidcStateCookie   = "webtop_oidc"
+	oidcStateLifetime = 10 * time.Minute
+	oidcStateAudience = "oidc-state"
+	oidcCookiePath    = "/api/auth/oidc"
+)
+
+type oidcState struct {
+	jwt.StandardClaims
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// OIDCConfigHandler tells the login page whether to offer single sign-on.
+func (h *Handler) OIDCConfigHandler(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]bool{"enabled": h.OIDC != nil})
+}
+
+// OIDCLoginHandler sends the browser to the provider.
+func (h *Handler) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	state := &oidcState{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  oidcStateAudience,
+			ExpiresAt: time.Now().Add(oidcStateLifetime).Unix(),
+		},
+		State:    security.RandomToken(),
+		Nonce:    security.RandomToken(),
+		Verifier: oidc.NewVerifier(),
+	}
+	authURL, err := h.OIDC.AuthCodeURL(state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		log.Printf("Single sign-on unavailable: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, state).SignedString(h.Auth.Key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setCookie(w, r, oidcStateCookie, oidcCookiePath, cookie, time.Now().Add(oidcStateLifetime))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler completes single sign-on: it redeems the code,
+// provisions or updates the user and starts a login. The browser is sent
+// back to the launcher, which picks up the login with the refresh cookie;
+// failures are reported in the sso_error query parameter.
+func (h *Handler) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	ctx := r.Context()
+	event := security.Event{ClientIP: h.ClientIPs.ClientIP(r), Reason: "oidc"}
+	fail := func(reason, message string) {
+		event.Type, event.Reason = security.LoginFailed, "oidc: "+reason
+		security.Log(event)
+		http.Redirect(w, r, "/?sso_error="+url.QueryEscape(message), http.StatusFound)
+	}
+
+	state, err := h.oidcState(r)
+	setCookie(w, r, oidcStateCookie, oidcCookiePath, "", time.Unix(0, 0))
+	if err != nil || r.URL.Query().Get("state") != state.State {
+		fail("state mismatch", "Sign-in expired or was not started here, please try again")
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		fail("provider error "+e, "The identity provider refused the sign-in: "+e)
+		return
+	}
+
+	identity, err := h.OIDC.Exchange(ctx, r.URL.Query().Get("code"), state.Nonce, state.Verifier)
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		fail(err.Error(), "Sign-in with the identity provider failed")
+		return
+	}
+	event.Username = identity.Username
+
+	user, err := h.externalUser(ctx, externalIdentity{
+		Provider:   models.AuthOIDC,
+		ID:         identity.Subject,
+		Username:   identity.Username,
+		IsAdmin:    identity.IsAdmin,
+		AdminKnown: identity.AdminKnown,
+	})
+	if err == errUsernameTaken {
+		fail("username taken by a local user", "Cannot sign in as "+identity.Username+": "+err.Error())
+		return
+	} else if err != nil {
+		log.Printf("Could not provision single sign-on user %s: %v", identity.Username, err)
+		fail(err.Error(), "Could not sign in, please try again")
+		return
+	}
+	event.UserID = user.ID
+
+	refreshToken, refresh, err := h.newLogin(ctx, user)
+	if err == nil {
+		_, err = h.setTokenCookies(w, r, user, refreshToken, refresh)
+	}
+	if err != nil {
+		fail(err.Error(), "Could not sign in, please try again")
+		return
+	}
+	event.Type = security.LoginSucceeded
+	security.Log(event)
+	http.Redirect(w, r, "/?sso=1", http.StatusFound)
+}
+
+// oidcState returns the verified state cookie.
+func (h *Handler) oidcState(r *http.Request) (*oidcState, error) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, err
+	}
+	state := &oidcState{}
+	_, err = jwt.ParseWithClaims(cookie.Value, state, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return h.Auth.Key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !state.VerifyAudience(oidcStateAudience, true) {
+		return nil, errors.New("not a single sign-on state")
+	}
+	return state, nil
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/security"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Adding a passkey adds a way to sign in, so an access token alone is not
+// enough: the user proves again that it is them, with their password or a
+// second factor, and gets a short-lived step-up token to present.
+const (
+	stepUpLifetime = 5 * time.Minute
+	stepUpAudience = "step-up"
+)
+
+type stepUpRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// StepUpHandler answers the user's current password, or a code of their
+// second factor, with a step-up token. Wrong ones are throttled and locked
+// out like at login.
+func (h *Handler) StepUpHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	var req stepUpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Code != "" {
+		if !h.verifySecondFactor(w, r, user, req.Code) {
+			return
+		}
+	} else if !h.verifyPassword(w, r, user, req.Password) {
+		return
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
+		Audience:  stepUpAudience,
+		Subject:   user.ID,
+		ExpiresAt: time.Now().Add(stepUpLifetime).Unix(),
+	}).SignedString(h.Auth.Key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"stepUpToken": token})
+}
+
+// verifyPassword checks the password of user, who is signed in, with the
+// authenticators. When it is not accepted it answers the request and
+// returns false.
+func (h *Handler) verifyPassword(w http.ResponseWriter, r *http.Request, user *models.User, password string) bool {
+	ctx := r.Context()
+	clientIP := h.ClientIPs.ClientIP(r)
+	ipKey, userKey := "ip:"+clientIP, "user:"+strings.ToLower(user.Username)
+	event := security.Event{Username: user.Username, UserID: user.ID, ClientIP: clientIP}
+	current, ok := h.secondFactorAllowed(w, r, user, event)
+	if !ok {
+		return false
+	}
+	authenticated, err := h.authenticate(ctx, current.Username, password)
+	switch err {
+	case nil:
+		if authenticated.ID == current.ID {
+			return true
+		}
+	case errUnknownUser, errInvalidCredentials, errUsernameTaken:
+	default:
+		log.Printf("Could not authenticate %s: %v", current.Username, err)
+		http.Error(w, "Authentication service unavailable, try again later", http.StatusServiceUnavailable)
+		return false
+	}
+	h.Throttle.Fail(ipKey)
+	h.Throttle.Fail(userKey)
+	event.Type, event.Reason = security.LoginFailed, "wrong password at step-up"
+	security.Log(event)
+	h.recordFailure(ctx, current, clientIP)
+	http.Error(w, "Invalid password", http.StatusUnauthorized)
+	return false
+}
+
+// steppedUp reports whether token is a step-up token of user. A user who
+// is enrolling to log in needs none, having just given their password.
+// Otherwise it answers the request and returns false.
+func (h *Handler) steppedUp(w http.ResponseWriter, r *http.Request, user *models.User, token string) bool {
+	if middleware.TokenScope(r.Context()) == middleware.ScopeMFAEnroll {
+		return true
+	}
+	claims := &jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return h.Auth.Key, nil
+	})
+	if err != nil || !claims.VerifyAudience(stepUpAudience, true) || claims.Subject != user.ID {
+		http.Error(w, "Confirm your password first", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/security"
+	"webtop-launcher/internal/store"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// RefreshCookie holds the refresh token for browsers. It is only sent to
+// the /api/auth routes.
+const RefreshCookie = "webtop_refresh"
+
+// reuseGracePeriod is how long a rotated refresh token may be presented
+// again without revoking its family, for tabs that refresh at the same
+// time with the same cookie.
+const reuseGracePeriod = 10 * time.Second
+
+// Every login starts a family of refresh tokens. Each refresh rotates the
+// token: the presented one is marked used and a new one of the same family
+// is issued. Presenting a used token again means it was copied, so the
+// whole family is revoked. Access tokens carry the family as their session
+// ID and stop working as soon as it is revoked.
+
+// issueToken answers with new tokens for user, starting a new login. Users
+// who must change their password get a short-lived token that only
+// change-password accepts, and no refresh token.
+func (h *Handler) issueToken(w http.ResponseWriter, r *http.Request, user *models.User) {
+	h.issueTokenWith(w, r, user, nil)
+}
+
+// issueTokenWith is issueToken adding extra fields to the response.
+func (h *Handler) issueTokenWith(w http.ResponseWriter, r *http.Request, user *models.User, extra map[string]interface{}) {
+	if user.MustChangePassword {
+		h.issueRestrictedToken(w, r, user, middleware.ScopePasswordChange, restrictedTokenLifetime,
+			withFields(extra, "mustChangePassword", true))
+		return
+	}
+
+	refreshToken, refresh, err := h.newLogin(r.Context(), user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeTokens(w, r, user, refreshToken, refresh, extra)
+}
+
+// issueRestrictedToken answers with a token for user that only the routes
+// accepting scope accept, and no refresh token. The token is also set as
+// the cookie, which is all the web UI authenticates with.
+func (h *Handler) issueRestrictedToken(w http.ResponseWriter, r *http.Request, user *models.User, scope string, lifetime time.Duration, fields map[string]interface{}) {
+	expires := time.Now().Add(lifetime)
+	tokenString, err := h.signToken(user, scope, "", expires)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return
+	}
+	setCookie(w, r, middleware.TokenCookie, "/", tokenString, expires)
+	json.NewEncoder(w).Encode(withFields(fields, "token", tokenString))
+}
+
+// withFields returns a copy of fields with key set to value.
+func withFields(fields map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range fields {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+// newLogin starts a new refresh token family for user.
+func (h *Handler) newLogin(ctx context.Context, user *models.User) (string, *models.RefreshToken, error) {
+	// Opportunistic cleanup; used tokens are kept until they expire so
+	// reuse is still detected.
+	if err := h.Tokens.DeleteExpired(ctx, time.Now()); err != nil {
+		log.Printf("Could not delete expired refresh tokens: %v", err)
+	}
+
+	refreshToken, refresh := newRefreshToken(user.ID, "")
+	if err := h.Tokens.Create(ctx, refresh); err != nil {
+		return "", nil, err
+	}
+	return refreshToken, refresh, nil
+}
+
+// writeTokens answers with an access token for the family of refresh and
+// any extra fields, and sets both tokens as cookies.
+func (h *Handler) writeTokens(w http.ResponseWriter, r *http.Request, user *models.User, refreshToken string, refresh *models.RefreshToken, extra map[string]interface{}) {
+	tokenString, err := h.setTokenCookies(w, r, user, refreshToken, refresh)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return
+	}
+	response := map[string]interface{}{
+		"token":              tokenString,
+		"refreshToken":       refreshToken,
+		"mustChangePassword": false,
+	}
+	for k, v := range extra {
+		response[k] = v
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// setTokenCookies signs an access token for the family of refresh and sets
+// both as HttpOnly cookies. The web UI and the session proxy authenticate
+// with the cookie; the token in the response is for API clients.
+func (h *Handler) setTokenCookies(w http.ResponseWriter, r *http.Request, user *models.User, refreshToken string, refresh *models.RefreshToken) (string, error) {
+	expirationTime := time.Now().Add(accessTokenLifetime)
+	tokenString, err := h.signToken(user, "", refresh.FamilyID, expirationTime)
+	if err != nil {
+		return "", err
+	}
+	setCookie(w, r, middleware.TokenCookie, "/", tokenString, expirationTime)
+	setCookie(w, r, RefreshCookie, "/api/auth", refreshToken, refresh.ExpiresAt)
+	return tokenString, nil
+}
+
+func (h *Handler) signToken(user *models.User, scope, sessionID string, expires time.Time) (string, error) {
+	return h.Auth.SignToken(&middleware.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.ID,
+			ExpiresAt: expires.Unix(),
+		},
+		Scope:     scope,
+		SessionID: sessionID,
+	})
+}
+
+// newRefreshToken returns a random refresh token and its record, in a new
+// family if familyID is empty.
+func newRefreshToken(userID, familyID string) (string, *models.RefreshToken) {
+	token := security.RandomToken()
+	if familyID == "" {
+		familyID = uuid.New().String()
+	}
+	return token, &models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: security.HashToken(token),
+		ExpiresAt: time.Now().Add(refreshTokenLifetime).UTC(),
+	}
+}
+
+// refreshTokenFrom returns the refresh token of the RefreshCookie cookie,
+// or else of the JSON body's refreshToken, for clients without cookies.
+func refreshTokenFrom(r *http.Request) string {
+	if cookie, err := r.Cookie(RefreshCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	return body.RefreshToken
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a new
+// refresh token.
+func (h *Handler) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	refreshToken := refreshTokenFrom(r)
+	if refreshToken == "" {
+		http.Error(w, "Refresh token required", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	current, err := h.Tokens.GetByHash(ctx, security.HashToken(refreshToken))
+	if err == store.ErrNotFound {
+		h.rejectRefresh(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if current.RevokedAt != nil || !current.ExpiresAt.After(now) {
+		h.rejectRefresh(w, r)
+		return
+	}
+	if current.UsedAt != nil {
+		if now.Sub(*current.UsedAt) < reuseGracePeriod {
+			http.Error(w, "Refresh token already used, retry with the new one", http.StatusConflict)
+			return
+		}
+		h.revokeReused(ctx, r, current)
+		h.rejectRefresh(w, r)
+		return
+	}
+
+	user, err := h.Users.Get(ctx, current.UserID)
+	if err == store.ErrNotFound {
+		h.rejectRefresh(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	nextToken, next := newRefreshToken(user.ID, current.FamilyID)
+	err = h.Tokens.Rotate(ctx, current.ID, next)
+	if err == store.ErrConflict {
+		// Used or revoked since we read it: a concurrent refresh won.
+		http.Error(w, "Refresh token already used, retry with the new one", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeTokens(w, r, user, nextToken, next, nil)
+}
+
+// revokeReused revokes the family of a refresh token presented after it
+// was rotated.
+func (h *Handler) revokeReused(ctx context.Context, r *http.Request, token *models.RefreshToken) {
+	if err := h.Tokens.RevokeFamily(ctx, token.FamilyID, time.Now()); err != nil {
+		log.Printf("Could not revoke refresh token family %s: %v", token.FamilyID, err)
+	}
+	security.Log(security.Event{
+		Type:     security.TokenReused,
+		UserID:   token.UserID,
+		ClientIP: h.ClientIPs.ClientIP(r),
+		Reason:   "refresh token presented after rotation, login revoked",
+	})
+}
+
+// rejectRefresh clears the cookies of a login that cannot be refreshed.
+func (h *Handler) rejectRefresh(w http.ResponseWriter, r *http.Request) {
+	clearCookies(w, r)
+	http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+}
+
+// LogoutHandler ends the login of the refresh token and of the bearer
+// token, whichever are presented, and clears the cookies.
+func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	now := time.Now()
+	event := security.Event{Type: security.Logout, ClientIP: h.ClientIPs.ClientIP(r)}
+	families := map[string]bool{}
+	if refreshToken := refreshTokenFrom(r); refreshToken != "" {
+		if t, err := h.Tokens.GetByHash(ctx, security.HashToken(refreshToken)); err == nil {
+			families[t.FamilyID] = true
+			event.UserID = t.UserID
+		}
+	}
+	if tokenString := middleware.RequestToken(r); tokenString != "" {
+		if claims, err := h.Auth.ParseToken(tokenString); err == nil && claims.SessionID != "" {
+			families[claims.SessionID] = true
+			event.UserID = claims.Subject
+		}
+	}
+	for family := range families {
+		if err := h.Tokens.RevokeFamily(ctx, family, now); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(families) > 0 {
+		security.Log(event)
+	}
+
+	clearCookies(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func clearCookies(w http.ResponseWriter, r *http.Request) {
+	setCookie(w, r, middleware.TokenCookie, "/", "", time.Unix(0, 0))
+	setCookie(w, r, RefreshCookie, "/api/auth", "", time.Unix(0, 0))
+}
+
+func setCookie(w http.ResponseWriter, r *http.Request, name, path, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,518 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/security"
+	"webtop-launcher/internal/store"
+	"webtop-launcher/internal/webauthn"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+// Passkeys are WebAuthn credentials. One whose authenticator verifies the
+// user, with a PIN or biometrics, signs a local user in on its own; any
+// passkey serves as the second factor after a password. Every ceremony
+// takes two requests: begin answers with the options for
+// navigator.credentials and a signed state, which finish posts back with
+// the browser's response. The user handle stored on authenticators is the
+// user's ID.
+
+const (
+	webauthnStateLifetime = 5 * time.Minute
+	// Each ceremony signs its state for its own audience, so a state
+	// cannot be finished as another ceremony.
+	webauthnRegisterAudience = "webauthn-register"
+	webauthnLoginAudience    = "webauthn-login"
+	webauthnMFAAudience      = "webauthn-mfa"
+	passkeyNameMaxLength     = 64
+	defaultPasskeyName       = "Passkey"
+)
+
+// Second factors, as listed in a login challenge.
+const (
+	factorTOTP    = "totp"
+	factorPasskey = "passkey"
+)
+
+type webauthnState struct {
+	jwt.StandardClaims
+	Session webauthn.Session `json:"session"`
+}
+
+// relyingParty returns the relying party for r, filling in the RP ID and
+// origin from the request where they are not configured.
+func (h *Handler) relyingParty(r *http.Request) (*webauthn.RelyingParty, error) {
+	cfg := h.WebAuthn
+	if cfg.RPID == "" {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		cfg.RPID = host
+	}
+	if len(cfg.Origins) == 0 {
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		cfg.Origins = []string{scheme + "://" + r.Host}
+	}
+	if cfg.RPName == "" {
+		cfg.RPName = totpIssuer
+	}
+	return webauthn.New(cfg)
+}
+
+// signWebAuthnState signs the state of a ceremony for audience, run for the
+// user subject if it is not empty.
+func (h *Handler) signWebAuthnState(audience, subject string, session *webauthn.Session) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, &webauthnState{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  audience,
+			Subject:   subject,
+			ExpiresAt: time.Now().Add(webauthnStateLifetime).Unix(),
+		},
+		Session: *session,
+	}).SignedString(h.Auth.Key)
+}
+
+// webauthnSession returns the session of a state signed by
+// signWebAuthnState for the same audience and subject. Each state is
+// accepted once: a ceremony that failed has to begin again.
+func (h *Handler) webauthnSession(token, audience, subject string) (*webauthn.Session, error) {
+	state := &webauthnState{}
+	_, err := jwt.ParseWithClaims(token, state, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return h.Auth.Key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !state.VerifyAudience(audience, true) || state.Subject != subject {
+		return nil, errors.New("state is for another ceremony")
+	}
+	if !h.Ceremonies.Use(string(state.Session.Challenge), time.Unix(state.ExpiresAt, 0)) {
+		return nil, errors.New("state was already used")
+	}
+	return &state.Session, nil
+}
+
+// beginCeremony answers with the options of a ceremony and its signed
+// state.
+func (h *Handler) beginCeremony(w http.ResponseWriter, options interface{}, audience, subject string, session *webauthn.Session) {
+	state, err := h.signWebAuthnState(audience, subject, session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"publicKey": options,
+		"state":     state,
+	})
+}
+
+// secondFactors lists the second factors user has set up.
+func (h *Handler) secondFactors(ctx context.Context, user *models.User) ([]string, error) {
+	var factors []string
+	if user.TOTPEnabled {
+		factors = append(factors, factorTOTP)
+	}
+	creds, err := h.Credentials.ListByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) > 0 {
+		factors = append(factors, factorPasskey)
+	}
+	return factors, nil
+}
+
+func toWebAuthn(creds []models.WebAuthnCredential) []webauthn.Credential {
+	out := make([]webauthn.Credential, 0, len(creds))
+	for _, c := range creds {
+		out = append(out, webauthn.Credential{
+			ID:         c.CredentialID,
+			PublicKey:  c.PublicKey,
+			SignCount:  c.SignCount,
+			Transports: c.Transports,
+		})
+	}
+	return out
+}
+
+// ListPasskeysHandler lists the user's passkeys.
+func (h *Handler) ListPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	creds, err := h.Credentials.ListByUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(creds)
+}
+
+// DeletePasskeyHandler removes one of the user's passkeys. While the
+// policy requires two-factor authentication the last second factor cannot
+// be removed.
+func (h *Handler) DeletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, _ := middleware.UserFromContext(ctx)
+	id := mux.Vars(r)["id"]
+	creds, err := h.Credentials.ListByUser(ctx, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var removed *models.WebAuthnCredential
+	for i := range creds {
+		if creds[i].ID == id {
+			removed = &creds[i]
+		}
+	}
+	if removed == nil {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+	if len(creds) == 1 && !user.TOTPEnabled {
+		required, err := LoadMFARequired(ctx, h.Settings)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if required {
+			http.Error(w, "Two-factor authentication is required; add another passkey or an authenticator app first", http.StatusForbidden)
+			return
+		}
+	}
+	err = h.Credentials.Delete(ctx, user.ID, id)
+	if err == store.ErrNotFound {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	security.Log(security.Event{
+		Type:     security.PasskeyRemoved,
+		Username: user.Username,
+		UserID:   user.ID,
+		ClientIP: h.ClientIPs.ClientIP(r),
+		Reason:   removed.Name,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PasskeyRegisterBeginHandler starts registering a passkey for the user.
+// Both steps of the registration need a step-up token, except to enroll at
+// login.
+func (h *Handler) PasskeyRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	var req struct {
+		StepUpToken string `json:"stepUpToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if user.AuthProvider == models.AuthOIDC {
+		http.Error(w, "Two-factor authentication is managed by the identity provider", http.StatusBadRequest)
+		return
+	}
+	if !h.steppedUp(w, r, user, req.StepUpToken) {
+		return
+	}
+	rp, err := h.relyingParty(r)
+	if err != nil {
+		log.Printf("Passkeys unavailable: %v", err)
+		http.Error(w, "Passkeys are not configured", http.StatusInternalServerError)
+		return
+	}
+	creds, err := h.Credentials.ListByUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	options, session, err := rp.BeginRegistration(webauthn.User{
+		ID:          []byte(user.ID),
+		Name:        user.Username,
+		DisplayName: user.Username,
+	}, toWebAuthn(creds))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.beginCeremony(w, options, webauthnRegisterAudience, user.ID, session)
+}
+
+type passkeyRegistration struct {
+	State       string                         `json:"state"`
+	Name        string                         `json:"name"`
+	Credential  *webauthn.RegistrationResponse `json:"credential"`
+	StepUpToken string                         `json:"stepUpToken"`
+}
+
+// PasskeyRegisterFinishHandler verifies the new passkey and stores it. A
+// user who had to enroll to log in also gets their tokens.
+func (h *Handler) PasskeyRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, _ := middleware.UserFromContext(ctx)
+	var req passkeyRegistration
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Credential == nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.steppedUp(w, r, user, req.StepUpToken) {
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	if utf8.RuneCountInString(name) > passkeyNameMaxLength {
+		http.Error(w, "Name must be at most "+strconv.Itoa(passkeyNameMaxLength)+" characters", http.StatusBadRequest)
+		return
+	}
+	session, err := h.webauthnSession(req.State, webauthnRegisterAudience, user.ID)
+	if err != nil {
+		http.Error(w, "Registration expired, please try again", http.StatusBadRequest)
+		return
+	}
+	rp, err := h.relyingParty(r)
+	if err != nil {
+		http.Error(w, "Passkeys are not configured", http.StatusInternalServerError)
+		return
+	}
+	verified, err := rp.FinishRegistration(session, req.Credential)
+	if err != nil {
+		http.Error(w, "Passkey could not be verified: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transports := verified.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	cred := &models.WebAuthnCredential{
+		UserID:       user.ID,
+		CredentialID: verified.ID,
+		PublicKey:    verified.PublicKey,
+		SignCount:    verified.SignCount,
+		Name:         name,
+		Transports:   transports,
+	}
+	err = h.Credentials.Create(ctx, cred)
+	if err == store.ErrConflict {
+		http.Error(w, "This passkey is already registered", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	security.Log(security.Event{
+		Type:     security.PasskeyAdded,
+		Username: user.Username,
+		UserID:   user.ID,
+		ClientIP: h.ClientIPs.ClientIP(r),
+		Reason:   cred.Name,
+	})
+
+	if middleware.TokenScope(ctx) == middleware.ScopeMFAEnroll {
+		h.issueTokenWith(w, r, user, map[string]interface{}{"credential": cred})
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(cred)
+}
+
+// PasskeyLoginBeginHandler starts a passwordless login, with any passkey
+// the authenticator has for the launcher.
+func (h *Handler) PasskeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	rp, err := h.relyingParty(r)
+	if err != nil {
+		log.Printf("Passkeys unavailable: %v", err)
+		http.Error(w, "Passkeys are not configured", http.StatusInternalServerError)
+		return
+	}
+	options, session, err := rp.BeginLogin(nil, nil, webauthn.VerificationRequired)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.beginCeremony(w, options, webauthnLoginAudience, "", session)
+}
+
+type passkeyAssertion struct {
+	State      string                      `json:"state"`
+	Credential *webauthn.AssertionResponse `json:"credential"`
+}
+
+// PasskeyLoginFinishHandler completes a passwordless login. The passkey
+// stands for both factors, so only local users, whose password the
+// launcher itself checks, may sign in this way.
+func (h *Handler) PasskeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req passkeyAssertion
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Credential == nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	clientIP := h.ClientIPs.ClientIP(r)
+	ipKey := "ip:" + clientIP
+	event := security.Event{ClientIP: clientIP, Reason: "passkey"}
+	if wait := h.Throttle.Wait(ipKey); wait > 0 {
+		event.Type = security.LoginThrottled
+		security.Log(event)
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+	fail := func(reason string) {
+		h.Throttle.Fail(ipKey)
+		event.Type, event.Reason = security.LoginFailed, "passkey: "+reason
+		security.Log(event)
+		http.Error(w, "Passkey sign-in failed", http.StatusUnauthorized)
+	}
+
+	session, err := h.webauthnSession(req.State, webauthnLoginAudience, "")
+	if err != nil {
+		fail("invalid state")
+		return
+	}
+	cred, err := h.Credentials.GetByCredentialID(ctx, req.Credential.RawID)
+	if err == store.ErrNotFound {
+		fail("unknown credential")
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user, err := h.Users.Get(ctx, cred.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	event.Username, event.UserID = user.Username, user.ID
+	if string(req.Credential.Response.UserHandle) != user.ID {
+		fail("user handle does not match the credential")
+		return
+	}
+	if user.AuthProvider != "" && user.AuthProvider != models.AuthLocal {
+		fail("not a local user")
+		return
+	}
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		event.Type = security.LoginLocked
+		security.Log(event)
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(*user.LockedUntil)/time.Second)+1))
+		http.Error(w, "Account is locked, try again later or ask an administrator", http.StatusLocked)
+		return
+	}
+	if !h.finishAssertion(r, session, req.Credential, cred) {
+		fail("assertion did not verify")
+		return
+	}
+	h.completeLogin(w, r, user, event)
+}
+
+// PasskeyVerifyBeginHandler starts the second step of a login with one of
+// the user's passkeys.
+func (h *Handler) PasskeyVerifyBeginHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := middleware.UserFromContext(r.Context())
+	creds, err := h.Credentials.ListByUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(creds) == 0 {
+		http.Error(w, "No passkey is registered", http.StatusBadRequest)
+		return
+	}
+	rp, err := h.relyingParty(r)
+	if err != nil {
+		log.Printf("Passkeys unavailable: %v", err)
+		http.Error(w, "Passkeys are not configured", http.StatusInternalServerError)
+		return
+	}
+	options, session, err := rp.BeginLogin([]byte(user.ID), toWebAuthn(creds), webauthn.VerificationPreferred)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.beginCeremony(w, options, webauthnMFAAudience, user.ID, session)
+}
+
+// PasskeyVerifyFinishHandler completes a login with a passkey as the
+// second factor. Failures count like wrong codes.
+func (h *Handler) PasskeyVerifyFinishHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, _ := middleware.UserFromContext(ctx)
+	var req passkeyAssertion
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Credential == nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	clientIP := h.ClientIPs.ClientIP(r)
+	event := security.Event{Username: user.Username, UserID: user.ID, ClientIP: clientIP}
+	current, ok := h.secondFactorAllowed(w, r, user, event)
+	if !ok {
+		return
+	}
+	session, err := h.webauthnSession(req.State, webauthnMFAAudience, user.ID)
+	if err != nil {
+		http.Error(w, "Verification expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	cred, err := h.Credentials.GetByCredentialID(ctx, req.Credential.RawID)
+	if err != nil && err != store.ErrNotFound {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == store.ErrNotFound || cred.UserID != user.ID || !h.finishAssertion(r, session, req.Credential, cred) {
+		h.Throttle.Fail("ip:" + clientIP)
+		h.Throttle.Fail("user:" + strings.ToLower(user.Username))
+		event.Type, event.Reason = security.MFAFailed, "passkey did not verify"
+		security.Log(event)
+		h.recordFailure(ctx, current, clientIP)
+		http.Error(w, "Passkey verification failed", http.StatusUnauthorized)
+		return
+	}
+	event.Reason = "passkey"
+	h.completeLogin(w, r, current, event)
+}
+
+// finishAssertion verifies an assertion made with cred and records the
+// new signature counter. It reports whether the assertion verified.
+func (h *Handler) finishAssertion(r *http.Request, session *webauthn.Session, resp *webauthn.AssertionResponse, cred *models.WebAuthnCredential) bool {
+	rp, err := h.relyingParty(r)
+	if err != nil {
+		log.Printf("Passkeys unavailable: %v", err)
+		return false
+	}
+	signCount, err := rp.FinishLogin(session, resp, &toWebAuthn([]models.WebAuthnCredential{*cred})[0])
+	if err != nil {
+		log.Printf("Passkey %s of user %s did not verify: %v", cred.ID, cred.UserID, err)
+		return false
+	}
+	if err := h.Credentials.UpdateSignCount(r.Context(), cred.ID, signCount, time.Now()); err != nil {
+		log.Printf("Could not update the signature counter of passkey %s: %v", cred.ID, err)
+	}
+	return true
+}
@@ -0,0 +1,514 @@
+// Package compose rewrites the docker-compose files stored in the
+// applications table so that several sessions of the same application can
+// run side by side on one Docker host.
+package compose
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Session identifies the launch a compose file is being prepared for.
+type Session struct {
+	ID         string
+	UserID     string
+	Username   string
+	AppName    string
+	Persistent bool
+}
+
+// LabelSession is the label attached to every service of a session.
+const LabelSession = "webtop.session"
+
+// ConfigMountPath is where the persistent per-user volume is mounted.
+const ConfigMountPath = "/config"
+
+// configVolumeKey is the top-level volume key used for the persistent volume.
+const configVolumeKey = "webtop-config"
+
+// ErrNoServices is returned for files without a services section.
+var ErrNoServices = errors.New("compose: file defines no services")
+
+// Rewrite returns a copy of the compose file with session environment
+// variables and labels injected into every service, and with services,
+// networks, volumes and container names suffixed with the session ID. Host
+// port bindings are dropped so Docker assigns free ones. If the session is
+// persistent, a per-user named volume is mounted at /config in the first
+// service. Key order is preserved, so the output is deterministic.
+func Rewrite(src []byte, s Session) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return nil, fmt.Errorf("compose: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, ErrNoServices
+	}
+	root := doc.Content[0]
+
+	services := mapValue(root, "services")
+	if services == nil || services.Kind != yaml.MappingNode || len(services.Content) == 0 {
+		return nil, ErrNoServices
+	}
+
+	r := &rewriter{
+		session:  s,
+		suffix:   "-" + shortID(s.ID),
+		services: map[string]string{},
+		networks: map[string]string{},
+		volumes:  map[string]string{},
+	}
+	r.collect(root, services)
+
+	for i := 0; i < len(services.Content); i += 2 {
+		keyNode, svc := services.Content[i], services.Content[i+1]
+		name := keyNode.Value
+		keyNode.Value = r.services[name]
+		if svc.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("compose: service %q is not a mapping", keyNode.Value)
+		}
+		if err := r.rewriteService(svc, name); err != nil {
+			return nil, fmt.Errorf("compose: service %q: %w", keyNode.Value, err)
+		}
+	}
+	r.rewriteTopLevel(mapValue(root, "networks"), r.networks)
+	r.rewriteTopLevel(mapValue(root, "volumes"), r.volumes)
+
+	if s.Persistent {
+		r.attachConfigVolume(root, services.Content[1])
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("compose: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("compose: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ConfigVolumeName returns the Docker volume name used for a user's
+// persistent /config data. It is keyed on the user ID, since different
+// usernames such as "bob@x" and "bob_x" sanitize to the same name.
+func ConfigVolumeName(s Session) string {
+	name := "webtop-" + sanitize(s.UserID)
+	if s.AppName != "" {
+		name += "-" + sanitize(s.AppName)
+	}
+	return name + "-config"
+}
+
+type rewriter struct {
+	session Session
+	suffix  string
+
+	// old name -> new name, for everything that gets renamed
+	services map[string]string
+	networks map[string]string
+	volumes  map[string]string
+}
+
+// collect works out the new names up front so references can be rewritten
+// regardless of declaration order.
+func (r *rewriter) collect(root, services *yaml.Node) {
+	for i := 0; i < len(services.Content); i += 2 {
+		name := services.Content[i].Value
+		r.services[name] = name + r.suffix
+	}
+	for kind, names := range map[string]map[string]string{"networks": r.networks, "volumes": r.volumes} {
+		top := mapValue(root, kind)
+		if top == nil || top.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i < len(top.Content); i += 2 {
+			name := top.Content[i].Value
+			if name == "default" || isExternal(top.Content[i+1]) {
+				continue
+			}
+			names[name] = name + r.suffix
+		}
+	}
+}
+
+// rewriteService rewrites the service first called name.
+func (r *rewriter) rewriteService(svc *yaml.Node, name string) error {
+	r.setEnvironment(svc)
+	r.setLabel(svc, LabelSession, r.session.ID)
+
+	if n := mapValue(svc, "container_name"); n != nil && n.Kind == yaml.ScalarNode {
+		n.Value += r.suffix
+	}
+
+	// depends_on, links and volumes_from refer to other services.
+	if n := mapValue(svc, "depends_on"); n != nil {
+		renameKeysOrItems(n, r.services)
+	}
+	if n := mapValue(svc, "links"); n != nil && n.Kind == yaml.SequenceNode {
+		for _, item := range n.Content {
+			name, alias, hasAlias := strings.Cut(item.Value, ":")
+			if newName, ok := r.services[name]; ok {
+				if !hasAlias {
+					// Keep the original hostname reachable from the linking service.
+					alias = name
+				}
+				item.Value = newName + ":" + alias
+			}
+		}
+	}
+	if n := mapValue(svc, "volumes_from"); n != nil && n.Kind == yaml.SequenceNode {
+		for _, item := range n.Content {
+			name, mode, hasMode := strings.Cut(item.Value, ":")
+			if newName, ok := r.services[name]; ok {
+				item.Value = newName
+				if hasMode {
+					item.Value += ":" + mode
+				}
+			}
+		}
+	}
+	for _, key := range []string{"network_mode", "pid", "ipc"} {
+		if n := mapValue(svc, key); n != nil && strings.HasPrefix(n.Value, "service:") {
+			if newName, ok := r.services[strings.TrimPrefix(n.Value, "service:")]; ok {
+				n.Value = "service:" + newName
+			}
+		}
+	}
+	if n := mapValue(svc, "extends"); n != nil && n.Kind == yaml.MappingNode && mapValue(n, "file") == nil {
+		if ref := mapValue(n, "service"); ref != nil {
+			if newName, ok := r.services[ref.Value]; ok {
+				ref.Value = newName
+			}
+		}
+	}
+
+	if n := mapValue(svc, "networks"); n != nil {
+		renameKeysOrItems(n, r.networks)
+	}
+	r.addAlias(svc, name)
+	if n := mapValue(svc, "volumes"); n != nil && n.Kind == yaml.SequenceNode {
+		for _, item := range n.Content {
+			r.rewriteMount(item)
+		}
+	}
+	if n := mapValue(svc, "ports"); n != nil && n.Kind == yaml.SequenceNode {
+		for _, item := range n.Content {
+			dropHostPort(item)
+		}
+	}
+	return nil
+}
+
+// addAlias keeps the service reachable under its original name, which
+// siblings may use as a hostname, on every network of the session it
+// joins, including the default one. External networks are shared with
+// other sessions, where the alias would be ambiguous, and services with a
+// network_mode join no networks.
+func (r *rewriter) addAlias(svc *yaml.Node, name string) {
+	if mapValue(svc, "network_mode") != nil {
+		return
+	}
+	networks := mapValue(svc, "networks")
+	switch {
+	case networks == nil || (networks.Kind != yaml.SequenceNode && networks.Kind != yaml.MappingNode):
+		networks = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMapValue(networks, "default", nil)
+		setMapValue(svc, "networks", networks)
+	case networks.Kind == yaml.SequenceNode:
+		// Only the long syntax can carry aliases.
+		items := networks.Content
+		*networks = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, item := range items {
+			setMapValue(networks, item.Value, nil)
+		}
+	}
+	for i := 1; i < len(networks.Content); i += 2 {
+		if !r.ownNetwork(networks.Content[i-1].Value) {
+			continue
+		}
+		n := networks.Content[i]
+		if n == nil || n.Kind != yaml.MappingNode {
+			n = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			networks.Content[i] = n
+		}
+		aliases := mapValue(n, "aliases")
+		if aliases == nil || aliases.Kind != yaml.SequenceNode {
+			aliases = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			setMapValue(n, "aliases", aliases)
+		}
+		known := false
+		for _, a := range aliases.Content {
+			known = known || a.Value == name
+		}
+		if !known {
+			aliases.Content = append(aliases.Content, scalar(name))
+		}
+	}
+}
+
+// ownNetwork reports whether the (renamed) network belongs to the session.
+func (r *rewriter) ownNetwork(name string) bool {
+	if name == "default" {
+		return true
+	}
+	for _, n := range r.networks {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteMount renames the source of a named-volume mount, in either the
+// short "source:target[:mode]" or the long mapping syntax.
+func (r *rewriter) rewriteMount(item *yaml.Node) {
+	switch item.Kind {
+	case yaml.ScalarNode:
+		source, rest, ok := strings.Cut(item.Value, ":")
+		if !ok {
+			return
+		}
+		if newName, ok := r.volumes[source]; ok {
+			item.Value = newName + ":" + rest
+		}
+	case yaml.MappingNode:
+		if t := mapValue(item, "type"); t != nil && t.Value != "volume" {
+			return
+		}
+		if source := mapValue(item, "source"); source != nil {
+			if newName, ok := r.volumes[source.Value]; ok {
+				source.Value = newName
+			}
+		}
+	}
+}
+
+// rewriteTopLevel renames keys in the top-level networks or volumes section.
+// Explicit name: overrides are suffixed too, since they are global on the
+// Docker host.
+func (r *rewriter) rewriteTopLevel(top *yaml.Node, names map[string]string) {
+	if top == nil || top.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i < len(top.Content); i += 2 {
+		newName, ok := names[top.Content[i].Value]
+		if !ok {
+			continue
+		}
+		top.Content[i].Value = newName
+		if n := mapValue(top.Content[i+1], "name"); n != nil && n.Kind == yaml.ScalarNode {
+			n.Value += r.suffix
+		}
+	}
+}
+
+// attachConfigVolume mounts the user's persistent volume at /config in svc,
+// replacing any existing /config mount, and declares it at the top level.
+func (r *rewriter) attachConfigVolume(root, svc *yaml.Node) {
+	mount := configVolumeKey + ":" + ConfigMountPath
+
+	mounts := mapValue(svc, "volumes")
+	if mounts == nil || mounts.Kind != yaml.SequenceNode {
+		mounts = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		setMapValue(svc, "volumes", mounts)
+	}
+	replaced := false
+	for i, item := range mounts.Content {
+		if mountTarget(item) == ConfigMountPath {
+			mounts.Content[i] = scalar(mount)
+			replaced = true
+		}
+	}
+	if !replaced {
+		mounts.Content = append(mounts.Content, scalar(mount))
+	}
+
+	top := mapValue(root, "volumes")
+	if top == nil || top.Kind != yaml.MappingNode {
+		top = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMapValue(root, "volumes", top)
+	}
+	decl := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setMapValue(decl, "name", scalar(ConfigVolumeName(r.session)))
+	setMapValue(top, configVolumeKey, decl)
+}
+
+// setEnvironment adds SESSION_ID, USER_ID and USERNAME to the service's
+// environment, overriding any values already present.
+func (r *rewriter) setEnvironment(svc *yaml.Node) {
+	vars := [][2]string{
+		{"SESSION_ID", r.session.ID},
+		{"USER_ID", r.session.UserID},
+		{"USERNAME", r.session.Username},
+	}
+	env := ensureMapOrList(svc, "environment")
+	for _, kv := range vars {
+		setEntry(env, kv[0], kv[1])
+	}
+}
+
+func (r *rewriter) setLabel(svc *yaml.Node, key, value string) {
+	setEntry(ensureMapOrList(svc, "labels"), key, value)
+}
+
+// ensureMapOrList returns the environment/labels node of a service, creating
+// an empty mapping if it is missing.
+func ensureMapOrList(svc *yaml.Node, key string) *yaml.Node {
+	n := mapValue(svc, key)
+	if n == nil || (n.Kind != yaml.MappingNode && n.Kind != yaml.SequenceNode) {
+		n = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		setMapValue(svc, key, n)
+	}
+	return n
+}
+
+// setEntry sets key=value in a compose "dictionary or list of KEY=VALUE"
+// field, keeping the syntax the file already uses.
+func setEntry(n *yaml.Node, key, value string) {
+	if n.Kind == yaml.MappingNode {
+		setMapValue(n, key, scalar(value))
+		return
+	}
+	entry := key + "=" + value
+	for _, item := range n.Content {
+		if k, _, _ := strings.Cut(item.Value, "="); k == key {
+			item.Value = entry
+			item.Style = 0
+			return
+		}
+	}
+	n.Content = append(n.Content, scalar(entry))
+}
+
+// renameKeysOrItems renames references held either as mapping keys or as a
+// list of names (the two forms accepted by depends_on and networks).
+func renameKeysOrItems(n *yaml.Node, names map[string]string) {
+	switch n.Kind {
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			if newName, ok := names[item.Value]; ok {
+				item.Value = newName
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(n.Content); i += 2 {
+			if newName, ok := names[n.Content[i].Value]; ok {
+				n.Content[i].Value = newName
+			}
+		}
+	}
+}
+
+// dropHostPort removes the host port from a port mapping so Docker picks a
+// free one: "8080:3000" becomes "3000", "127.0.0.1:8080:3000" becomes
+// "127.0.0.1::3000".
+func dropHostPort(item *yaml.Node) {
+	switch item.Kind {
+	case yaml.ScalarNode:
+		parts := strings.Split(item.Value, ":")
+		switch len(parts) {
+		case 2:
+			item.Value = parts[1]
+		case 3:
+			item.Value = parts[0] + "::" + parts[2]
+		default:
+			return
+		}
+		item.Tag = "!!str"
+		item.Style = yaml.DoubleQuotedStyle
+	case yaml.MappingNode:
+		removeMapValue(item, "published")
+	}
+}
+
+func mountTarget(item *yaml.Node) string {
+	switch item.Kind {
+	case yaml.ScalarNode:
+		parts := strings.Split(item.Value, ":")
+		if len(parts) >= 2 {
+			return parts[1]
+		}
+		return parts[0]
+	case yaml.MappingNode:
+		if t := mapValue(item, "target"); t != nil {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+func isExternal(decl *yaml.Node) bool {
+	if decl == nil || decl.Kind != yaml.MappingNode {
+		return false
+	}
+	ext := mapValue(decl, "external")
+	if ext == nil {
+		return false
+	}
+	// external: true, or the legacy external: {name: ...} form.
+	return ext.Kind == yaml.MappingNode || ext.Value == "true"
+}
+
+func mapValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMapValue(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, scalar(key), value)
+}
+
+func removeMapValue(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func scalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// shortID returns the first eight hex digits of a UUID, enough to keep names
+// unique on a single host while staying readable.
+func shortID(id string) string {
+	id = strings.ReplaceAll(id, "-", "")
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return strings.ToLower(id)
+}
+
+// sanitize makes s safe for use in a Docker volume name.
+func sanitize(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(s) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+			b.WriteRune(c)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
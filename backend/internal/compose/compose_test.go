@@ -0,0 +1,203 @@
+package compose
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testSession is the session every golden file is rewritten for; files
+// named persistent*.in.yml are rewritten for a persistent one.
+var testSession = Session{
+	ID:       "3F2504E0-4F89-11D3-9A0C-0305E82C3301",
+	UserID:   "7c9e6679-7425-40de-944b-e07fc1f90ae7",
+	Username: "Alice Smith",
+	AppName:  "Webtop",
+}
+
+// TestRewriteGolden rewrites each testdata/*.in.yml and compares the result
+// with the matching .golden.yml. Run with -update after an intended change
+// and review the diff.
+func TestRewriteGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.in.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no golden files in testdata")
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".in.yml")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := testSession
+			s.Persistent = strings.HasPrefix(name, "persistent")
+
+			got, err := Rewrite(src, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", name+".golden.yml")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Rewrite(%s) differs from %s:\n%s", input, golden, got)
+			}
+
+			// The rewritten file must still be one the Docker driver can
+			// deploy, and rewriting must be deterministic.
+			if _, err := Parse(got, nil); err != nil {
+				t.Errorf("parsing the rewritten file: %v", err)
+			}
+			again, _ := Rewrite(src, s)
+			if !bytes.Equal(got, again) {
+				t.Error("Rewrite is not deterministic")
+			}
+		})
+	}
+}
+
+func TestRewriteNoServices(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"# just a comment\n",
+		"- a list\n",
+		"version: '3'\n",
+		"services:\n",
+		"services: {}\n",
+		"services: [web]\n",
+		"volumes:\n  data: {}\n",
+	} {
+		if _, err := Rewrite([]byte(src), testSession); err != ErrNoServices {
+			t.Errorf("Rewrite(%q) = %v, want ErrNoServices", src, err)
+		}
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+	for _, src := range []string{
+		"services:\n  web: nginx\n",
+		"services: [\n",
+	} {
+		if _, err := Rewrite([]byte(src), testSession); err == nil || err == ErrNoServices {
+			t.Errorf("Rewrite(%q) = %v, want a syntax error", src, err)
+		}
+	}
+}
+
+func TestConfigVolumeName(t *testing.T) {
+	tests := []struct {
+		session Session
+		want    string
+	}{
+		{Session{UserID: "7c9e6679-7425-40de-944b-e07fc1f90ae7"}, "webtop-7c9e6679-7425-40de-944b-e07fc1f90ae7-config"},
+		{Session{UserID: "7C9E6679", Username: "Alice Smith", AppName: "Webtop"}, "webtop-7c9e6679-webtop-config"},
+		{Session{UserID: "7c9e6679", AppName: "Ubuntu KDE"}, "webtop-7c9e6679-ubuntu_kde-config"},
+	}
+	for _, tt := range tests {
+		if got := ConfigVolumeName(tt.session); got != tt.want {
+			t.Errorf("ConfigVolumeName(%+v) = %q, want %q", tt.session, got, tt.want)
+		}
+	}
+
+	// Usernames that sanitize alike still get volumes of their own.
+	bob := Session{UserID: "1b4e28ba-2fa1-11d2-883f-0016d3cca427", Username: "bob@x", AppName: "Webtop"}
+	other := Session{UserID: "6fa459ea-ee8a-3ca4-894e-db77e160355e", Username: "bob_x", AppName: "Webtop"}
+	if ConfigVolumeName(bob) == ConfigVolumeName(other) {
+		t.Errorf("bob@x and bob_x share the volume %s", ConfigVolumeName(bob))
+	}
+}
+
+func TestRewriteSiblings(t *testing.T) {
+	src := `services:
+  app:
+    image: example/app
+    environment:
+      DB_HOST: db
+    networks: [back]
+  db:
+    image: postgres:16
+    networks:
+      back:
+      default:
+networks:
+  back: {}
+`
+	got, err := Rewrite([]byte(src), testSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Parse(got, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, svc := range p.Services {
+		if svc.Name == "app-3f2504e0" && !strings.Contains(strings.Join(svc.Environment, " "), "DB_HOST=db") {
+			t.Errorf("app environment = %q", svc.Environment)
+		}
+		if svc.Name != "db-3f2504e0" {
+			continue
+		}
+		// app finds db by the name it was written with on the network
+		// they share, and so does anything on the default network.
+		for _, network := range []string{"back-3f2504e0", "default"} {
+			aliases := svc.Networks[network].Aliases
+			if len(aliases) != 1 || aliases[0] != "db" {
+				t.Errorf("db aliases on %s = %q, want [db]", network, aliases)
+			}
+		}
+	}
+}
+
+func TestPrivileges(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"services:\n  web:\n    image: nginx\n    volumes:\n      - data:/data\n      - /cache\nvolumes:\n  data: {}\n", nil},
+		{"services:\n  web:\n    image: nginx\n    privileged: true\n", []string{"web: privileged"}},
+		{"services:\n  web:\n    image: nginx\n    privileged: false\n", nil},
+		{"services:\n  web:\n    image: nginx\n    cap_add: [SYS_ADMIN]\n", []string{"web: cap_add"}},
+		{"services:\n  web:\n    image: nginx\n    devices:\n      - /dev/dri:/dev/dri\n", []string{"web: devices"}},
+		{"services:\n  web:\n    image: nginx\n    network_mode: host\n    pid: host\n    ipc: host\n", []string{"web: network_mode: host", "web: pid: host", "web: ipc: host"}},
+		{"services:\n  web:\n    image: nginx\n    network_mode: ${NET:-host}\n", []string{"web: network_mode: host"}},
+		{"services:\n  web:\n    image: nginx\n    network_mode: bridge\n", nil},
+		{"services:\n  web:\n    image: nginx\n    volumes:\n      - /var/run/docker.sock:/var/run/docker.sock\n      - ./data:/data:ro\n", []string{"web: mounts /var/run/docker.sock from the host", "web: mounts ./data from the host"}},
+		{"services:\n  web:\n    image: nginx\n    volumes:\n      - type: bind\n        source: /etc\n        target: /host-etc\n", []string{"web: mounts /etc from the host"}},
+		{"services:\n  web:\n    image: nginx\n    volumes:\n      - root:/host\nvolumes:\n  root:\n    driver_opts:\n      type: none\n      o: bind\n      device: /\n", []string{"web: mounts root from the host"}},
+		{"services:\n  web:\n    image: nginx\n    volumes:\n      - data:/data\nvolumes:\n  data:\n    external: true\n", []string{"web: mounts the volume data from outside the project"}},
+		{"services:\n  web:\n    image: nginx\n    volumes:\n      - type: volume\n        source: data\n        target: /data\nvolumes:\n  data:\n    name: other_app_data\n", []string{"web: mounts the volume other_app_data from outside the project"}},
+		{"services:\n  web:\n    image: nginx\n    security_opt:\n      - seccomp:unconfined\n      - no-new-privileges:true\n", []string{"web: security_opt: seccomp:unconfined"}},
+		{"services:\n  web:\n    image: nginx\n    network_mode: container:db\n    pid: container:db\n    ipc: container:db\n", []string{"web: network_mode: container:db", "web: pid: container:db", "web: ipc: container:db"}},
+		{"services:\n  web:\n    image: nginx\n    cgroup_parent: system.slice\n", []string{"web: cgroup_parent: system.slice"}},
+	}
+	for _, tt := range tests {
+		got, err := Privileges([]byte(tt.src))
+		if err != nil {
+			t.Errorf("Privileges(%q): %v", tt.src, err)
+			continue
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("Privileges(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+
+	if _, err := Privileges([]byte("services: {}\n")); err != ErrNoServices {
+		t.Errorf("Privileges without services = %v, want ErrNoServices", err)
+	}
+}
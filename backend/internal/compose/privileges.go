@@ -0,0 +1,134 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// hostNamespaces are the service keys that can join a namespace of the
+// host.
+var hostNamespaces = []string{"network_mode", "pid", "ipc", "uts", "userns_mode", "cgroup"}
+
+// Privileges lists what a compose file asks for beyond an isolated
+// container: privileged mode, added capabilities, devices, security
+// options, a cgroup parent, host namespaces or another container's, mounts
+// of host paths and of volumes from outside the project, each as
+// "service: what". Variables are substituted with their defaults first, as
+// a launch without them would.
+func Privileges(src []byte) ([]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return nil, fmt.Errorf("compose: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, ErrNoServices
+	}
+	root := doc.Content[0]
+	if err := interpolate(root, nil); err != nil {
+		return nil, err
+	}
+	services := mapValue(root, "services")
+	if services == nil || services.Kind != yaml.MappingNode || len(services.Content) == 0 {
+		return nil, ErrNoServices
+	}
+
+	var found []string
+	// A local volume with the bind option mounts a host path as well. An
+	// external volume, or one with an explicit name, is not the project's
+	// own: it may hold another app's or user's data.
+	hostVolumes := map[string]bool{}
+	sharedVolumes := map[string]string{}
+	volumes := mapValue(root, "volumes")
+	if volumes != nil && volumes.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(volumes.Content); i += 2 {
+			key, volume := volumes.Content[i].Value, volumes.Content[i+1]
+			opts := mapValue(volume, "driver_opts")
+			if o := mapValue(opts, "o"); o != nil && hasOption(o.Value, "bind") {
+				hostVolumes[key] = true
+			}
+			if n := mapValue(volume, "name"); n != nil && n.Value != "" {
+				sharedVolumes[key] = n.Value
+			} else if e := mapValue(volume, "external"); e != nil && strings.EqualFold(e.Value, "true") {
+				sharedVolumes[key] = key
+			}
+		}
+	}
+
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name, svc := services.Content[i].Value, services.Content[i+1]
+		add := func(format string, args ...interface{}) {
+			found = append(found, name+": "+fmt.Sprintf(format, args...))
+		}
+		if v := mapValue(svc, "privileged"); v != nil && strings.EqualFold(v.Value, "true") {
+			add("privileged")
+		}
+		if v := mapValue(svc, "cap_add"); v != nil && len(v.Content) > 0 {
+			add("cap_add")
+		}
+		if v := mapValue(svc, "devices"); v != nil && len(v.Content) > 0 {
+			add("devices")
+		}
+		if v := mapValue(svc, "security_opt"); v != nil {
+			for _, opt := range v.Content {
+				// no-new-privileges only takes privileges away.
+				if o := strings.TrimSpace(opt.Value); o != "no-new-privileges" && o != "no-new-privileges:true" {
+					add("security_opt: %s", o)
+				}
+			}
+		}
+		if v := mapValue(svc, "cgroup_parent"); v != nil && v.Value != "" {
+			add("cgroup_parent: %s", v.Value)
+		}
+		for _, key := range hostNamespaces {
+			if v := mapValue(svc, key); v != nil && (v.Value == "host" || strings.HasPrefix(v.Value, "container:")) {
+				add("%s: %s", key, v.Value)
+			}
+		}
+		if mounts := mapValue(svc, "volumes"); mounts != nil {
+			for _, item := range mounts.Content {
+				typ, source := mountSource(item)
+				if typ == "bind" || hostVolumes[source] {
+					add("mounts %s from the host", source)
+				} else if shared, ok := sharedVolumes[source]; ok {
+					add("mounts the volume %s from outside the project", shared)
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+// mountSource returns the type and source of an entry of a service's
+// volumes. The short syntax gives the type only for host paths.
+func mountSource(item *yaml.Node) (typ, source string) {
+	switch item.Kind {
+	case yaml.ScalarNode:
+		parts := strings.Split(item.Value, ":")
+		if len(parts) < 2 {
+			return "", ""
+		}
+		source = parts[0]
+		if strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~") {
+			typ = "bind"
+		}
+	case yaml.MappingNode:
+		if t := mapValue(item, "type"); t != nil {
+			typ = t.Value
+		}
+		if s := mapValue(item, "source"); s != nil {
+			source = s.Value
+		}
+	}
+	return typ, source
+}
+
+func hasOption(opts, option string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if strings.TrimSpace(o) == option {
+			return true
+		}
+	}
+	return false
+}
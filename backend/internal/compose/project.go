@@ -0,0 +1,556 @@
+package compose
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Project is a parsed, normalised compose file: the subset of the compose
+// specification that the direct Docker driver knows how to run.
+type Project struct {
+	Services []Service
+	Networks map[string]Network
+	Volumes  map[string]Volume
+}
+
+// Service is one entry of the services section.
+type Service struct {
+	Name          string
+	Image         string
+	ContainerName string
+	Hostname      string
+	User          string
+	WorkingDir    string
+	Command       []string
+	Entrypoint    []string
+	Environment   []string // KEY=VALUE, sorted by key
+	Labels        map[string]string
+	Ports         []Port
+	Volumes       []Mount
+	Networks      map[string]ServiceNetwork
+	DependsOn     []string
+	Restart       string
+	ShmSize       int64
+	Privileged    bool
+	CapAdd        []string
+	SecurityOpt   []string
+	Devices       []string
+	ExtraHosts    []string
+}
+
+// ServiceNetwork is a service's attachment to a network.
+type ServiceNetwork struct {
+	Aliases []string
+}
+
+// Port is a published or exposed container port.
+type Port struct {
+	HostIP    string
+	Published string
+	Target    string
+	Protocol  string
+}
+
+// Mount is a volume or bind mount of a service.
+type Mount struct {
+	Type     string // "volume" or "bind"
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// Network is a top-level network declaration.
+type Network struct {
+	Name     string
+	Driver   string
+	External bool
+}
+
+// Volume is a top-level volume declaration.
+type Volume struct {
+	Name     string
+	Driver   string
+	External bool
+}
+
+// Parse reads a compose file, substituting ${VAR}, ${VAR:-default} and $VAR
+// references from env (falling back to an empty string).
+func Parse(src []byte, env map[string]string) (*Project, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return nil, fmt.Errorf("compose: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, ErrNoServices
+	}
+	if err := interpolate(doc.Content[0], env); err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		Services yaml.Node               `yaml:"services"`
+		Networks map[string]*rawResource `yaml:"networks"`
+		Volumes  map[string]*rawResource `yaml:"volumes"`
+	}
+	if err := doc.Content[0].Decode(&raw); err != nil {
+		return nil, fmt.Errorf("compose: %w", err)
+	}
+	if raw.Services.Kind != yaml.MappingNode || len(raw.Services.Content) == 0 {
+		return nil, ErrNoServices
+	}
+
+	p := &Project{Networks: map[string]Network{}, Volumes: map[string]Volume{}}
+	for key, res := range raw.Networks {
+		if res == nil {
+			res = &rawResource{}
+		}
+		p.Networks[key] = Network{Name: res.Name, Driver: res.Driver, External: bool(res.External)}
+	}
+	for key, res := range raw.Volumes {
+		if res == nil {
+			res = &rawResource{}
+		}
+		p.Volumes[key] = Volume{Name: res.Name, Driver: res.Driver, External: bool(res.External)}
+	}
+
+	for i := 0; i < len(raw.Services.Content); i += 2 {
+		name := raw.Services.Content[i].Value
+		var rs rawService
+		if err := raw.Services.Content[i+1].Decode(&rs); err != nil {
+			return nil, fmt.Errorf("compose: service %q: %w", name, err)
+		}
+		svc, err := rs.normalise(name)
+		if err != nil {
+			return nil, fmt.Errorf("compose: service %q: %w", name, err)
+		}
+		p.Services = append(p.Services, svc)
+	}
+	return p, nil
+}
+
+// StartOrder returns the services sorted so that every service comes after
+// the services it depends on. Declaration order is kept otherwise.
+func (p *Project) StartOrder() ([]Service, error) {
+	byName := map[string]Service{}
+	for _, s := range p.Services {
+		byName[s.Name] = s
+	}
+	var order []Service
+	state := map[string]int{} // 1 = visiting, 2 = done
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("compose: dependency cycle involving %q", name)
+		case 2:
+			return nil
+		}
+		svc, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("compose: unknown service %q in depends_on", name)
+		}
+		state[name] = 1
+		for _, dep := range svc.DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, svc)
+		return nil
+	}
+	for _, s := range p.Services {
+		if err := visit(s.Name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+var varPattern = regexp.MustCompile(`\$(\$|\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-?])([^}]*))?\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// interpolate substitutes variables in every scalar below n.
+func interpolate(n *yaml.Node, env map[string]string) error {
+	if n.Kind == yaml.ScalarNode {
+		if !strings.Contains(n.Value, "$") {
+			return nil
+		}
+		var err error
+		n.Value = varPattern.ReplaceAllStringFunc(n.Value, func(m string) string {
+			sub := varPattern.FindStringSubmatch(m)
+			if sub[1] == "$" {
+				return "$"
+			}
+			name := sub[2]
+			if name == "" {
+				name = sub[5]
+			}
+			value, set := env[name]
+			switch sub[3] {
+			case ":-":
+				if value == "" {
+					return sub[4]
+				}
+			case "-":
+				if !set {
+					return sub[4]
+				}
+			case ":?", "?":
+				if !set || (sub[3] == ":?" && value == "") {
+					err = fmt.Errorf("compose: required variable %s is missing a value: %s", name, sub[4])
+				}
+			}
+			return value
+		})
+		return err
+	}
+	for _, c := range n.Content {
+		if err := interpolate(c, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rawResource struct {
+	Name     string       `yaml:"name"`
+	Driver   string       `yaml:"driver"`
+	External externalFlag `yaml:"external"`
+}
+
+// externalFlag accepts both "external: true" and the legacy
+// "external: {name: ...}" form.
+type externalFlag bool
+
+func (e *externalFlag) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.MappingNode {
+		*e = true
+		return nil
+	}
+	var b bool
+	if err := n.Decode(&b); err != nil {
+		return err
+	}
+	*e = externalFlag(b)
+	return nil
+}
+
+type rawService struct {
+	Image         string      `yaml:"image"`
+	ContainerName string      `yaml:"container_name"`
+	Hostname      string      `yaml:"hostname"`
+	User          string      `yaml:"user"`
+	WorkingDir    string      `yaml:"working_dir"`
+	Command       shellWords  `yaml:"command"`
+	Entrypoint    shellWords  `yaml:"entrypoint"`
+	Environment   listOrMap   `yaml:"environment"`
+	Labels        listOrMap   `yaml:"labels"`
+	Ports         []yaml.Node `yaml:"ports"`
+	Volumes       []yaml.Node `yaml:"volumes"`
+	Networks      yaml.Node   `yaml:"networks"`
+	DependsOn     yaml.Node   `yaml:"depends_on"`
+	Restart       string      `yaml:"restart"`
+	ShmSize       string      `yaml:"shm_size"`
+	Privileged    bool        `yaml:"privileged"`
+	CapAdd        []string    `yaml:"cap_add"`
+	SecurityOpt   []string    `yaml:"security_opt"`
+	Devices       []string    `yaml:"devices"`
+	ExtraHosts    listOrMap   `yaml:"extra_hosts"`
+	Build         *yaml.Node  `yaml:"build"`
+}
+
+func (rs rawService) normalise(name string) (Service, error) {
+	if rs.Image == "" {
+		if rs.Build != nil {
+			return Service{}, fmt.Errorf("build is not supported, an image is required")
+		}
+		return Service{}, fmt.Errorf("image is required")
+	}
+	svc := Service{
+		Name:          name,
+		Image:         rs.Image,
+		ContainerName: rs.ContainerName,
+		Hostname:      rs.Hostname,
+		User:          rs.User,
+		WorkingDir:    rs.WorkingDir,
+		Command:       rs.Command,
+		Entrypoint:    rs.Entrypoint,
+		Labels:        map[string]string{},
+		Networks:      map[string]ServiceNetwork{},
+		Restart:       rs.Restart,
+		Privileged:    rs.Privileged,
+		CapAdd:        rs.CapAdd,
+		SecurityOpt:   rs.SecurityOpt,
+		Devices:       rs.Devices,
+	}
+
+	for k, v := range rs.Environment {
+		if v == nil {
+			// "- KEY" would pass the variable through from the host
+			// environment; the backend's own environment must not leak
+			// into session containers, so such entries are dropped.
+			continue
+		}
+		svc.Environment = append(svc.Environment, k+"="+*v)
+	}
+	sort.Strings(svc.Environment)
+	for k, v := range rs.Labels {
+		if v != nil {
+			svc.Labels[k] = *v
+		} else {
+			svc.Labels[k] = ""
+		}
+	}
+	for k, v := range rs.ExtraHosts {
+		if v != nil {
+			svc.ExtraHosts = append(svc.ExtraHosts, k+":"+*v)
+		}
+	}
+	sort.Strings(svc.ExtraHosts)
+
+	if rs.ShmSize != "" {
+		size, err := parseBytes(rs.ShmSize)
+		if err != nil {
+			return svc, fmt.Errorf("shm_size: %w", err)
+		}
+		svc.ShmSize = size
+	}
+
+	for i := range rs.Ports {
+		port, err := parsePort(&rs.Ports[i])
+		if err != nil {
+			return svc, err
+		}
+		svc.Ports = append(svc.Ports, port)
+	}
+	for i := range rs.Volumes {
+		m, err := parseMount(&rs.Volumes[i])
+		if err != nil {
+			return svc, err
+		}
+		svc.Volumes = append(svc.Volumes, m)
+	}
+
+	switch rs.Networks.Kind {
+	case yaml.SequenceNode:
+		for _, item := range rs.Networks.Content {
+			svc.Networks[item.Value] = ServiceNetwork{}
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(rs.Networks.Content); i += 2 {
+			var sn struct {
+				Aliases []string `yaml:"aliases"`
+			}
+			if err := rs.Networks.Content[i+1].Decode(&sn); err != nil {
+				return svc, fmt.Errorf("networks: %w", err)
+			}
+			svc.Networks[rs.Networks.Content[i].Value] = ServiceNetwork{Aliases: sn.Aliases}
+		}
+	}
+
+	switch rs.DependsOn.Kind {
+	case yaml.SequenceNode:
+		for _, item := range rs.DependsOn.Content {
+			svc.DependsOn = append(svc.DependsOn, item.Value)
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(rs.DependsOn.Content); i += 2 {
+			svc.DependsOn = append(svc.DependsOn, rs.DependsOn.Content[i].Value)
+		}
+	}
+	return svc, nil
+}
+
+// listOrMap decodes the compose "list of KEY=VALUE or mapping" syntax. A nil
+// value means the key was given without a value.
+type listOrMap map[string]*string
+
+func (l *listOrMap) UnmarshalYAML(n *yaml.Node) error {
+	out := listOrMap{}
+	switch n.Kind {
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			k, v, ok := strings.Cut(item.Value, "=")
+			if !ok {
+				// extra_hosts uses "host:ip" in list form.
+				if hk, hv, isHost := strings.Cut(item.Value, ":"); isHost && !strings.Contains(hk, " ") {
+					out[hk] = &hv
+					continue
+				}
+				out[k] = nil
+				continue
+			}
+			value := v
+			out[k] = &value
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(n.Content); i += 2 {
+			v := n.Content[i+1]
+			if v.Tag == "!!null" {
+				out[n.Content[i].Value] = nil
+				continue
+			}
+			value := v.Value
+			out[n.Content[i].Value] = &value
+		}
+	default:
+		return fmt.Errorf("line %d: expected a list or mapping", n.Line)
+	}
+	*l = out
+	return nil
+}
+
+// shellWords decodes a command given either as a list or as a string, which
+// is split on whitespace honouring simple quotes.
+type shellWords []string
+
+func (s *shellWords) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.SequenceNode {
+		var list []string
+		if err := n.Decode(&list); err != nil {
+			return err
+		}
+		*s = list
+		return nil
+	}
+	var words []string
+	var cur strings.Builder
+	var quote rune
+	inWord := false
+	for _, c := range n.Value {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	*s = words
+	return nil
+}
+
+func parsePort(n *yaml.Node) (Port, error) {
+	if n.Kind == yaml.MappingNode {
+		var p struct {
+			Target    string `yaml:"target"`
+			Published string `yaml:"published"`
+			HostIP    string `yaml:"host_ip"`
+			Protocol  string `yaml:"protocol"`
+		}
+		if err := n.Decode(&p); err != nil {
+			return Port{}, fmt.Errorf("ports: %w", err)
+		}
+		port := Port{HostIP: p.HostIP, Published: p.Published, Target: p.Target, Protocol: p.Protocol}
+		if port.Protocol == "" {
+			port.Protocol = "tcp"
+		}
+		return port, nil
+	}
+
+	spec, proto, _ := strings.Cut(n.Value, "/")
+	if proto == "" {
+		proto = "tcp"
+	}
+	parts := strings.Split(spec, ":")
+	port := Port{Protocol: proto}
+	switch len(parts) {
+	case 1:
+		port.Target = parts[0]
+	case 2:
+		port.Published, port.Target = parts[0], parts[1]
+	case 3:
+		port.HostIP, port.Published, port.Target = parts[0], parts[1], parts[2]
+	default:
+		return Port{}, fmt.Errorf("ports: invalid port %q", n.Value)
+	}
+	if strings.Contains(port.Target, "-") {
+		return Port{}, fmt.Errorf("ports: port ranges are not supported (%q)", n.Value)
+	}
+	return port, nil
+}
+
+func parseMount(n *yaml.Node) (Mount, error) {
+	if n.Kind == yaml.MappingNode {
+		var m struct {
+			Type     string `yaml:"type"`
+			Source   string `yaml:"source"`
+			Target   string `yaml:"target"`
+			ReadOnly bool   `yaml:"read_only"`
+		}
+		if err := n.Decode(&m); err != nil {
+			return Mount{}, fmt.Errorf("volumes: %w", err)
+		}
+		if m.Type != "volume" && m.Type != "bind" {
+			return Mount{}, fmt.Errorf("volumes: unsupported mount type %q", m.Type)
+		}
+		return Mount{Type: m.Type, Source: m.Source, Target: m.Target, ReadOnly: m.ReadOnly}, nil
+	}
+
+	parts := strings.Split(n.Value, ":")
+	m := Mount{Type: "volume"}
+	switch len(parts) {
+	case 1:
+		// Anonymous volume.
+		m.Target = parts[0]
+	case 2, 3:
+		m.Source, m.Target = parts[0], parts[1]
+		if len(parts) == 3 {
+			for _, opt := range strings.Split(parts[2], ",") {
+				if opt == "ro" {
+					m.ReadOnly = true
+				}
+			}
+		}
+	default:
+		return Mount{}, fmt.Errorf("volumes: invalid mount %q", n.Value)
+	}
+	if strings.HasPrefix(m.Source, "/") || strings.HasPrefix(m.Source, ".") || strings.HasPrefix(m.Source, "~") {
+		m.Type = "bind"
+	}
+	return m, nil
+}
+
+// parseBytes parses sizes such as "2gb", "512m" or "1073741824".
+func parseBytes(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"gb", 1 << 30}, {"g", 1 << 30},
+		{"mb", 1 << 20}, {"m", 1 << 20},
+		{"kb", 1 << 10}, {"k", 1 << 10},
+		{"b", 1},
+	}
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, factor = strings.TrimSuffix(s, u.suffix), u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(factor)), nil
+}
@@ -0,0 +1,62 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"webtop-launcher/internal/config"
+)
+
+func TestEncryptionKey(t *testing.T) {
+	tests := []struct {
+		jwtSecret, encryptionKey string
+		wantErr                  string
+	}{
+		{"signing-key", "", "ENCRYPTION_KEY is required"},
+		{"", "", "ENCRYPTION_KEY is required"},
+		{"", "your-secret-key", "not the default or JWT_SECRET"},
+		{"signing-key", "your-secret-key", "not the default or JWT_SECRET"},
+		{"signing-key", "signing-key", "not the default or JWT_SECRET"},
+		{"signing-key", "encryption-key", ""},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", tt.jwtSecret)
+		t.Setenv("ENCRYPTION_KEY", tt.encryptionKey)
+		cfg, err := config.LoadConfig()
+		if tt.wantErr == "" {
+			if err != nil || cfg.EncryptionKey != tt.encryptionKey {
+				t.Errorf("JWT_SECRET=%q ENCRYPTION_KEY=%q: %v", tt.jwtSecret, tt.encryptionKey, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("JWT_SECRET=%q ENCRYPTION_KEY=%q: %v, want %q", tt.jwtSecret, tt.encryptionKey, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSessionOrigin(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "encryption-key")
+	tests := []struct {
+		value, want string
+		wantErr     bool
+	}{
+		{"", "", false},
+		{"https://Sessions.Example.com", "https://sessions.example.com", false},
+		{"http://localhost:8081/", "http://localhost:8081", false},
+		{"sessions.example.com", "", true},
+		{"ftp://sessions.example.com", "", true},
+		{"https://example.com/s/", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("SESSION_ORIGIN", tt.value)
+		cfg, err := config.LoadConfig()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("SESSION_ORIGIN=%q was accepted", tt.value)
+			}
+		} else if err != nil {
+			t.Errorf("SESSION_ORIGIN=%q: %v", tt.value, err)
+		} else if cfg.SessionOrigin != tt.want {
+			t.Errorf("SESSION_ORIGIN=%q gave %q, want %q", tt.value, cfg.SessionOrigin, tt.want)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<driver>/ as NNNN_name.up.sql and
+// NNNN_name.down.sql and are compiled into the binary. Each driver has its
+// own copy of every version, written in its dialect. Applied versions are
+// recorded in schema_migrations.
+//
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the Postgres advisory lock held while migrating, so
+// replicas starting at the same time apply each migration exactly once.
+const migrationLockKey = 7_311_204_901
+
+// Migration is one numbered schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is a migration and whether it has been applied.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// migrationDirs maps drivers to their directory under migrations/.
+var migrationDirs = map[string]string{
+	Postgres: "postgres",
+	SQLite:   "sqlite",
+}
+
+// Migrations returns the embedded migrations for driver in version order.
+func Migrations(driver string) ([]Migration, error) {
+	dir, ok := migrationDirs[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+	dir = path.Join("migrations", dir)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		base, direction := strings.TrimSuffix(name, ".sql"), ""
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			base, direction = strings.TrimSuffix(base, ".up"), "up"
+		case strings.HasSuffix(base, ".down"):
+			base, direction = strings.TrimSuffix(base, ".down"), "down"
+		default:
+			return nil, fmt.Errorf("migration %s: name must end in .up.sql or .down.sql", name)
+		}
+		num, label, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a positive version number", name)
+		}
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate applies every pending migration.
+func Migrate(db *sql.DB, driver string) error {
+	return withMigrationLock(db, driver, func(conn *sql.Conn) error {
+		migrations, err := Migrations(driver)
+		if err != nil {
+			return err
+		}
+		applied, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			log.Printf("Applying migration %d_%s", m.Version, m.Name)
+			err := runInTx(conn, m.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Rollback reverts the last steps applied migrations, newest first.
+func Rollback(db *sql.DB, driver string, steps int) error {
+	return withMigrationLock(db, driver, func(conn *sql.Conn) error {
+		migrations, err := Migrations(driver)
+		if err != nil {
+			return err
+		}
+		applied, err := appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be rolled back", m.Version, m.Name)
+			}
+			log.Printf("Rolling back migration %d_%s", m.Version, m.Name)
+			err := runInTx(conn, m.Down, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+			if err != nil {
+				return fmt.Errorf("rolling back %d_%s: %w", m.Version, m.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// MigrationStatus lists every known migration and when it was applied. It
+// only reads, so it neither waits for a running migration nor creates
+// schema_migrations: without the table, no migration has been applied.
+func MigrationStatus(db *sql.DB, driver string) ([]MigrationState, error) {
+	migrations, err := Migrations(driver)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]time.Time{}
+	exists, err := migrationTableExists(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if applied, err = appliedVersions(db); err != nil {
+			return nil, err
+		}
+	}
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			state.AppliedAt = &at
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// migrationTableExists reports whether schema_migrations has been created.
+func migrationTableExists(db *sql.DB, driver string) (bool, error) {
+	query := "SELECT to_regclass('schema_migrations') IS NOT NULL"
+	if driver == SQLite {
+		query = "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'"
+	}
+	var exists bool
+	err := db.QueryRowContext(context.Background(), query).Scan(&exists)
+	return exists, err
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock, after making sure schema_migrations exists. SQLite has no
+// advisory locks and needs none: a database file has a single node writing
+// to it, and its transactions already serialise writers.
+func withMigrationLock(db *sql.DB, driver string, fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	appliedAt := "TIMESTAMP WITH TIME ZONE DEFAULT NOW()"
+	if driver == SQLite {
+		appliedAt = "TIMESTAMP DEFAULT CURRENT_TIMESTAMP"
+	} else {
+		// Advisory locks belong to the session, so lock and unlock on the
+		// same connection.
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		defer func() {
+			if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+				log.Printf("Releasing migration lock: %v", err)
+			}
+		}()
+	}
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at `+appliedAt+`
+	);`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// querier is a *sql.DB or a *sql.Conn.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func appliedVersions(q querier) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(context.Background(), "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runInTx runs a migration script and the statement that records it in one
+// transaction, so a failed migration leaves no trace.
+func runInTx(conn *sql.Conn, script, record string, args ...interface{}) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
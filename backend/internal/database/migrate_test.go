@@ -0,0 +1,51 @@
+package database_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"webtop-launcher/internal/database"
+)
+
+func TestMigrationStatus(t *testing.T) {
+	db, err := database.Connect(database.SQLite, filepath.Join(t.TempDir(), "webtop.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A fresh database has no schema_migrations, and status must not
+	// create it.
+	states, err := database.MigrationStatus(db, database.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) == 0 {
+		t.Fatal("no migrations")
+	}
+	for _, s := range states {
+		if s.AppliedAt != nil {
+			t.Errorf("migration %d applied on a fresh database", s.Version)
+		}
+	}
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_migrations'").Scan(&tables); err != nil || tables != 0 {
+		t.Errorf("MigrationStatus created schema_migrations (%d, %v)", tables, err)
+	}
+
+	if err := database.Migrate(db, database.SQLite); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Rollback(db, database.SQLite, 1); err != nil {
+		t.Fatal(err)
+	}
+	states, err = database.MigrationStatus(db, database.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range states {
+		if last := i == len(states)-1; (s.AppliedAt == nil) != last {
+			t.Errorf("migration %d: applied at %v after rolling back the last one", s.Version, s.AppliedAt)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS applications;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS settings;
@@ -0,0 +1,36 @@
+-- Initial schema. IF NOT EXISTS keeps this a no-op on installs created
+-- before migrations existed.
+CREATE TABLE IF NOT EXISTS users (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	username VARCHAR(255) UNIQUE NOT NULL,
+	password_hash VARCHAR(255) NOT NULL,
+	is_admin BOOLEAN DEFAULT FALSE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS applications (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	name VARCHAR(255) UNIQUE NOT NULL,
+	logo_url TEXT,
+	repository_url TEXT,
+	docker_compose TEXT NOT NULL,
+	is_enabled BOOLEAN DEFAULT TRUE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+	application_id UUID REFERENCES applications(id) ON DELETE CASCADE,
+	portainer_stack_id INT,
+	is_persistent BOOLEAN DEFAULT FALSE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS settings (
+	key VARCHAR(255) PRIMARY KEY,
+	value TEXT
+);
+
+INSERT INTO settings (key, value) VALUES ('portainer_url', ''), ('portainer_api_key', '')
+ON CONFLICT (key) DO NOTHING;
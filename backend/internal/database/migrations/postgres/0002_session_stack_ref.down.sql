@@ -0,0 +1,5 @@
+DELETE FROM settings WHERE key = 'orchestrator_driver';
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS orchestrator;
+ALTER TABLE sessions DROP COLUMN IF EXISTS stack_name;
+ALTER TABLE sessions DROP COLUMN IF EXISTS stack_id;
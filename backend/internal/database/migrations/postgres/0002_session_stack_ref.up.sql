@@ -0,0 +1,8 @@
+-- The orchestrator's reference to a session's stack. portainer_stack_id is
+-- kept for sessions started before drivers were pluggable.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS stack_id TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS stack_name TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS orchestrator VARCHAR(32) DEFAULT 'portainer';
+
+INSERT INTO settings (key, value) VALUES ('orchestrator_driver', 'portainer')
+ON CONFLICT (key) DO NOTHING;
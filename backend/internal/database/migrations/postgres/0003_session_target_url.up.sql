@@ -0,0 +1,2 @@
+-- The session container's web address, for external proxies.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS target_url TEXT;
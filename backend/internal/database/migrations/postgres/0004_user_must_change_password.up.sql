@@ -0,0 +1,2 @@
+-- Set when an admin resets a password; cleared when the user changes it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,4 @@
+DELETE FROM settings WHERE key IN ('lockout_threshold', 'lockout_duration_minutes');
+
+ALTER TABLE users DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE users DROP COLUMN IF EXISTS failed_logins;
@@ -0,0 +1,6 @@
+-- Consecutive failed logins, and the lockout they end in.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+
+INSERT INTO settings (key, value) VALUES ('lockout_threshold', '5'), ('lockout_duration_minutes', '15')
+ON CONFLICT (key) DO NOTHING;
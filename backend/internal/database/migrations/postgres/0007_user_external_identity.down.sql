@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS users_external_identity;
+
+ALTER TABLE users DROP COLUMN IF EXISTS external_id;
+ALTER TABLE users DROP COLUMN IF EXISTS auth_provider;
@@ -0,0 +1,6 @@
+-- Users provisioned by a single sign-on provider are linked to it by the
+-- provider's stable ID for them; local users have auth_provider 'local'.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_provider VARCHAR(32) NOT NULL DEFAULT 'local';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_external_identity ON users (auth_provider, external_id);
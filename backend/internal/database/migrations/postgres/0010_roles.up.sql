@@ -0,0 +1,58 @@
+-- Role-based access control. A user's permissions are the union of those
+-- of their roles. The built-in roles mirror rbac.Builtin; is_admin stays
+-- as the flag of users with the admin role.
+CREATE TABLE IF NOT EXISTS roles (
+	id UUID PRIMARY KEY,
+	name VARCHAR(64) UNIQUE NOT NULL,
+	description VARCHAR(255) NOT NULL DEFAULT '',
+	builtin BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+	permission VARCHAR(64) NOT NULL,
+	PRIMARY KEY (role_id, permission)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role_id UUID NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS user_roles_role_id ON user_roles (role_id);
+
+INSERT INTO roles (id, name, description, builtin) VALUES
+	('00000000-0000-4000-8000-000000000001', 'admin', 'Full access', TRUE),
+	('00000000-0000-4000-8000-000000000002', 'operator', 'Monitors and stops the sessions of all users', TRUE),
+	('00000000-0000-4000-8000-000000000003', 'catalog-editor', 'Edits the application catalogue', TRUE),
+	('00000000-0000-4000-8000-000000000004', 'user', 'Launches applications', TRUE);
+
+INSERT INTO role_permissions (role_id, permission) VALUES
+	('00000000-0000-4000-8000-000000000001', 'apps:read'),
+	('00000000-0000-4000-8000-000000000001', 'sessions:launch'),
+	('00000000-0000-4000-8000-000000000001', 'sessions:read_all'),
+	('00000000-0000-4000-8000-000000000001', 'sessions:stop_all'),
+	('00000000-0000-4000-8000-000000000001', 'sessions:connect_all'),
+	('00000000-0000-4000-8000-000000000001', 'apps:manage'),
+	('00000000-0000-4000-8000-000000000001', 'users:manage'),
+	('00000000-0000-4000-8000-000000000001', 'roles:manage'),
+	('00000000-0000-4000-8000-000000000001', 'portainer:manage'),
+	('00000000-0000-4000-8000-000000000001', 'security:manage'),
+	('00000000-0000-4000-8000-000000000002', 'apps:read'),
+	('00000000-0000-4000-8000-000000000002', 'sessions:launch'),
+	('00000000-0000-4000-8000-000000000002', 'sessions:read_all'),
+	('00000000-0000-4000-8000-000000000002', 'sessions:stop_all'),
+	('00000000-0000-4000-8000-000000000003', 'apps:read'),
+	('00000000-0000-4000-8000-000000000003', 'sessions:launch'),
+	('00000000-0000-4000-8000-000000000003', 'apps:manage'),
+	('00000000-0000-4000-8000-000000000004', 'apps:read'),
+	('00000000-0000-4000-8000-000000000004', 'sessions:launch');
+
+-- Existing users keep what they could do: everyone launches applications,
+-- and admins keep full access.
+INSERT INTO user_roles (user_id, role_id)
+	SELECT id, '00000000-0000-4000-8000-000000000004' FROM users;
+INSERT INTO user_roles (user_id, role_id)
+	SELECT id, '00000000-0000-4000-8000-000000000001' FROM users WHERE is_admin;
@@ -0,0 +1,5 @@
+DELETE FROM role_permissions WHERE permission = 'groups:manage';
+
+DROP TABLE IF EXISTS group_applications;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS user_groups;
@@ -0,0 +1,14 @@
+-- Personal access tokens, stored as SHA-256 hashes. scopes holds the
+-- permissions the token is limited to, separated by commas.
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id UUID PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name VARCHAR(64) NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	scopes TEXT NOT NULL,
+	expires_at TIMESTAMP WITH TIME ZONE,
+	last_used_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS api_tokens_user_id ON api_tokens (user_id);
@@ -0,0 +1,36 @@
+-- Initial schema. SQLite has no UUID type, so ids are TEXT generated by the
+-- application, and timestamps are stored in UTC by CURRENT_TIMESTAMP.
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	username VARCHAR(255) UNIQUE NOT NULL,
+	password_hash VARCHAR(255) NOT NULL,
+	is_admin BOOLEAN DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS applications (
+	id TEXT PRIMARY KEY,
+	name VARCHAR(255) UNIQUE NOT NULL,
+	logo_url TEXT,
+	repository_url TEXT,
+	docker_compose TEXT NOT NULL,
+	is_enabled BOOLEAN DEFAULT TRUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+	application_id TEXT REFERENCES applications(id) ON DELETE CASCADE,
+	portainer_stack_id INT,
+	is_persistent BOOLEAN DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS settings (
+	key VARCHAR(255) PRIMARY KEY,
+	value TEXT
+);
+
+INSERT INTO settings (key, value) VALUES ('portainer_url', ''), ('portainer_api_key', '')
+ON CONFLICT (key) DO NOTHING;
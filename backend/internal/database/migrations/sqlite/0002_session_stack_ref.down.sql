@@ -0,0 +1,5 @@
+DELETE FROM settings WHERE key = 'orchestrator_driver';
+
+ALTER TABLE sessions DROP COLUMN orchestrator;
+ALTER TABLE sessions DROP COLUMN stack_name;
+ALTER TABLE sessions DROP COLUMN stack_id;
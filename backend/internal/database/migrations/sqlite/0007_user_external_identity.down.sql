@@ -0,0 +1,4 @@
+DROP INDEX users_external_identity;
+
+ALTER TABLE users DROP COLUMN external_id;
+ALTER TABLE users DROP COLUMN auth_provider;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS recovery_codes;
+
+DELETE FROM settings WHERE key = 'mfa_required';
+
+ALTER TABLE users DROP COLUMN totp_last_step;
+ALTER TABLE users DROP COLUMN totp_enabled;
+ALTER TABLE users DROP COLUMN totp_secret;
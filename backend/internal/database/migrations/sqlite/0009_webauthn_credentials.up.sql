@@ -0,0 +1,15 @@
+-- WebAuthn credentials (passkeys and security keys). credential_id is the
+-- authenticator's ID, base64url-encoded; public_key is its COSE key.
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	credential_id TEXT UNIQUE NOT NULL,
+	public_key BLOB NOT NULL,
+	sign_count BIGINT NOT NULL DEFAULT 0,
+	name VARCHAR(255) NOT NULL,
+	transports TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id ON webauthn_credentials (user_id);
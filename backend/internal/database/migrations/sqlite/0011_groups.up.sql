@@ -0,0 +1,28 @@
+-- User groups and the applications restricted to them. An application in
+-- no group stays available to every user.
+CREATE TABLE IF NOT EXISTS user_groups (
+	id TEXT PRIMARY KEY,
+	name VARCHAR(64) UNIQUE NOT NULL,
+	description VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+	group_id TEXT NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS group_members_user_id ON group_members (user_id);
+
+CREATE TABLE IF NOT EXISTS group_applications (
+	group_id TEXT NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+	application_id TEXT NOT NULL REFERENCES applications(id) ON DELETE CASCADE,
+	PRIMARY KEY (group_id, application_id)
+);
+
+CREATE INDEX IF NOT EXISTS group_applications_application_id ON group_applications (application_id);
+
+-- The admin role has every permission, including the new one.
+INSERT INTO role_permissions (role_id, permission)
+	SELECT id, 'groups:manage' FROM roles WHERE name = 'admin';
@@ -0,0 +1,485 @@
+// Package docker is a minimal client for the Docker Engine HTTP API, used by
+// the backend to manage containers on the host directly (for example its own
+// Portainer instance). It talks to the daemon over the unix socket by default.
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultHost is the Docker socket used when DOCKER_HOST is not set.
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// Labels docker compose puts on the objects it creates. The direct Docker
+// driver sets the same ones so stacks look alike whichever tool made them.
+const (
+	LabelComposeProject = "com.docker.compose.project"
+	LabelComposeService = "com.docker.compose.service"
+	LabelComposeNetwork = "com.docker.compose.network"
+	LabelComposeVolume  = "com.docker.compose.volume"
+)
+
+// APIVersion is the Engine API version requested. 1.41 is supported by
+// Docker 20.10 and later.
+const APIVersion = "v1.41"
+
+// Sentinel errors matched by *APIError through errors.Is.
+var (
+	ErrNotFound = errors.New("docker: not found")
+	ErrConflict = errors.New("docker: conflict")
+)
+
+// APIError is returned when the daemon answers with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("docker: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("docker: %d %s", e.StatusCode, e.Message)
+}
+
+// Is lets errors.Is(err, ErrNotFound) work on an *APIError.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// Client talks to a single Docker daemon.
+type Client struct {
+	baseURL    string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for host, which may be a unix:// socket path,
+// or a tcp:// or http:// address.
+func NewClient(host string) (*Client, error) {
+	if host == "" {
+		host = DefaultHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("docker: invalid host %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &Client{baseURL: "http://docker", HTTPClient: &http.Client{Transport: transport}}, nil
+	case "tcp", "http":
+		return &Client{baseURL: "http://" + u.Host, HTTPClient: &http.Client{}}, nil
+	default:
+		return nil, fmt.Errorf("docker: unsupported host scheme %q", u.Scheme)
+	}
+}
+
+// Ping checks that the daemon is reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/_ping", nil, nil)
+}
+
+// ContainerState is the State section of a container inspect.
+type ContainerState struct {
+	Status     string  `json:"Status"`
+	Running    bool    `json:"Running"`
+	Restarting bool    `json:"Restarting"`
+	ExitCode   int     `json:"ExitCode"`
+	Error      string  `json:"Error"`
+	StartedAt  string  `json:"StartedAt"`
+	FinishedAt string  `json:"FinishedAt"`
+	Health     *Health `json:"Health,omitempty"`
+}
+
+// Health is the healthcheck result of a container that defines one.
+type Health struct {
+	Status string `json:"Status"`
+}
+
+// Health check states.
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// Container is the subset of GET /containers/{id}/json the backend uses.
+type Container struct {
+	ID              string          `json:"Id"`
+	Name            string          `json:"Name"`
+	Image           string          `json:"Image"`
+	State           ContainerState  `json:"State"`
+	Config          ContainerConfig `json:"Config"`
+	NetworkSettings NetworkSettings `json:"NetworkSettings"`
+}
+
+// NetworkSettings lists the networks a container is attached to.
+type NetworkSettings struct {
+	Networks map[string]EndpointSettings `json:"Networks"`
+}
+
+// EndpointSettings is a container's attachment to one network.
+type EndpointSettings struct {
+	IPAddress string `json:"IPAddress"`
+}
+
+// StartedTime parses State.StartedAt. The zero time is returned if the
+// container never started.
+func (s ContainerState) StartedTime() time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s.StartedAt)
+	if err != nil || t.Year() < 2000 {
+		return time.Time{}
+	}
+	return t
+}
+
+// PortBinding binds a container port to the host.
+type PortBinding struct {
+	HostIP   string `json:"HostIp,omitempty"`
+	HostPort string `json:"HostPort"`
+}
+
+// RestartPolicy controls whether the daemon restarts the container.
+type RestartPolicy struct {
+	Name string `json:"Name"`
+}
+
+// DeviceMapping exposes a host device inside the container.
+type DeviceMapping struct {
+	PathOnHost        string `json:"PathOnHost"`
+	PathInContainer   string `json:"PathInContainer"`
+	CgroupPermissions string `json:"CgroupPermissions"`
+}
+
+// HostConfig is the host-specific part of a container create request.
+type HostConfig struct {
+	Binds         []string                 `json:"Binds,omitempty"`
+	PortBindings  map[string][]PortBinding `json:"PortBindings,omitempty"`
+	RestartPolicy RestartPolicy            `json:"RestartPolicy,omitempty"`
+	NetworkMode   string                   `json:"NetworkMode,omitempty"`
+	ShmSize       int64                    `json:"ShmSize,omitempty"`
+	Privileged    bool                     `json:"Privileged,omitempty"`
+	CapAdd        []string                 `json:"CapAdd,omitempty"`
+	SecurityOpt   []string                 `json:"SecurityOpt,omitempty"`
+	Devices       []DeviceMapping          `json:"Devices,omitempty"`
+	ExtraHosts    []string                 `json:"ExtraHosts,omitempty"`
+}
+
+// NetworkingConfig selects the network a container is attached to at
+// creation time.
+type NetworkingConfig struct {
+	EndpointsConfig map[string]EndpointConfig `json:"EndpointsConfig"`
+}
+
+// EndpointConfig configures a container's attachment to a network.
+type EndpointConfig struct {
+	Aliases []string `json:"Aliases,omitempty"`
+}
+
+// ContainerConfig is the body of a container create request.
+type ContainerConfig struct {
+	Image            string              `json:"Image"`
+	Hostname         string              `json:"Hostname,omitempty"`
+	User             string              `json:"User,omitempty"`
+	WorkingDir       string              `json:"WorkingDir,omitempty"`
+	Env              []string            `json:"Env,omitempty"`
+	Cmd              []string            `json:"Cmd,omitempty"`
+	Entrypoint       []string            `json:"Entrypoint,omitempty"`
+	Labels           map[string]string   `json:"Labels,omitempty"`
+	ExposedPorts     map[string]struct{} `json:"ExposedPorts,omitempty"`
+	HostConfig       *HostConfig         `json:"HostConfig,omitempty"`
+	NetworkingConfig *NetworkingConfig   `json:"NetworkingConfig,omitempty"`
+}
+
+// InspectContainer returns a container by name or ID.
+func (c *Client) InspectContainer(ctx context.Context, name string) (*Container, error) {
+	var ctr Container
+	if err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/json", nil, &ctr); err != nil {
+		return nil, err
+	}
+	return &ctr, nil
+}
+
+// ContainerSummary is an entry of GET /containers/json.
+type ContainerSummary struct {
+	ID              string            `json:"Id"`
+	Names           []string          `json:"Names"`
+	Image           string            `json:"Image"`
+	State           string            `json:"State"`
+	Labels          map[string]string `json:"Labels"`
+	Ports           []Port            `json:"Ports"`
+	NetworkSettings NetworkSettings   `json:"NetworkSettings"`
+}
+
+// Port is a container port as listed by GET /containers/json.
+type Port struct {
+	IP          string `json:"IP,omitempty"`
+	PrivatePort int    `json:"PrivatePort"`
+	PublicPort  int    `json:"PublicPort,omitempty"`
+	Type        string `json:"Type"`
+}
+
+// ListContainers returns all containers (running or not) carrying the given
+// label, in "key" or "key=value" form. An empty label lists everything.
+func (c *Client) ListContainers(ctx context.Context, label string) ([]ContainerSummary, error) {
+	path := "/containers/json?all=true"
+	if label != "" {
+		filters, err := json.Marshal(map[string][]string{"label": {label}})
+		if err != nil {
+			return nil, err
+		}
+		path += "&filters=" + url.QueryEscape(string(filters))
+	}
+	containers := []ContainerSummary{}
+	if err := c.do(ctx, http.MethodGet, path, nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// CreateContainer creates (but does not start) a container and returns its ID.
+func (c *Client) CreateContainer(ctx context.Context, name string, cfg ContainerConfig) (string, error) {
+	var resp struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/create?name="+url.QueryEscape(name), cfg, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// StartContainer starts a created or stopped container.
+func (c *Client) StartContainer(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, nil)
+}
+
+// StopContainer stops a running container, killing it after timeout.
+func (c *Client) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	path := fmt.Sprintf("/containers/%s/stop?t=%d", url.PathEscape(id), int(timeout.Seconds()))
+	return c.do(ctx, http.MethodPost, path, nil, nil)
+}
+
+// RemoveContainer deletes a container, stopping it first if force is set.
+// Anonymous volumes are removed with it; named volumes are not.
+func (c *Client) RemoveContainer(ctx context.Context, id string, force bool) error {
+	path := fmt.Sprintf("/containers/%s?v=true&force=%t", url.PathEscape(id), force)
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// PullImage pulls an image reference such as "portainer/portainer-ce:latest"
+// and waits for the pull to finish.
+func (c *Client) PullImage(ctx context.Context, ref string) error {
+	image, tag := ref, "latest"
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		image, tag = ref[:i], ref[i+1:]
+	}
+	path := "/images/create?fromImage=" + url.QueryEscape(image) + "&tag=" + url.QueryEscape(tag)
+
+	resp, err := c.send(ctx, http.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The daemon streams JSON progress messages; failures after the
+	// headers are sent show up as an "error" field.
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("docker: pulling %s: %w", ref, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("docker: pulling %s: %s", ref, msg.Error)
+		}
+	}
+}
+
+// InspectImage reports whether an image reference is present locally. It
+// returns an error matching ErrNotFound if it is not.
+func (c *Client) InspectImage(ctx context.Context, ref string) error {
+	return c.do(ctx, http.MethodGet, "/images/"+ref+"/json", nil, nil)
+}
+
+// Network is a Docker network.
+type Network struct {
+	ID     string            `json:"Id"`
+	Name   string            `json:"Name"`
+	Driver string            `json:"Driver"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// CreateNetwork creates a network and returns its ID.
+func (c *Client) CreateNetwork(ctx context.Context, name, driver string, labels map[string]string) (string, error) {
+	if driver == "" {
+		driver = "bridge"
+	}
+	var resp struct {
+		ID string `json:"Id"`
+	}
+	body := map[string]interface{}{"Name": name, "Driver": driver, "Labels": labels, "CheckDuplicate": true}
+	if err := c.do(ctx, http.MethodPost, "/networks/create", body, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// ConnectNetwork attaches a container to an additional network.
+func (c *Client) ConnectNetwork(ctx context.Context, network, container string, aliases []string) error {
+	body := map[string]interface{}{
+		"Container":      container,
+		"EndpointConfig": EndpointConfig{Aliases: aliases},
+	}
+	return c.do(ctx, http.MethodPost, "/networks/"+url.PathEscape(network)+"/connect", body, nil)
+}
+
+// RemoveNetwork deletes a network.
+func (c *Client) RemoveNetwork(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/networks/"+url.PathEscape(id), nil, nil)
+}
+
+// ListNetworks returns networks carrying the given label ("key" or
+// "key=value").
+func (c *Client) ListNetworks(ctx context.Context, label string) ([]Network, error) {
+	path := "/networks"
+	if label != "" {
+		filters, err := json.Marshal(map[string][]string{"label": {label}})
+		if err != nil {
+			return nil, err
+		}
+		path += "?filters=" + url.QueryEscape(string(filters))
+	}
+	networks := []Network{}
+	if err := c.do(ctx, http.MethodGet, path, nil, &networks); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
+
+// Volume is a Docker named volume.
+type Volume struct {
+	Name   string            `json:"Name"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// CreateVolume creates a named volume. Creating a volume that already exists
+// is a no-op.
+func (c *Client) CreateVolume(ctx context.Context, name string, labels map[string]string) (*Volume, error) {
+	var v Volume
+	body := map[string]interface{}{"Name": name, "Labels": labels}
+	if err := c.do(ctx, http.MethodPost, "/volumes/create", body, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// RemoveVolume deletes a named volume.
+func (c *Client) RemoveVolume(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/volumes/"+url.PathEscape(name), nil, nil)
+}
+
+// ListVolumes returns volumes carrying the given label ("key" or
+// "key=value").
+func (c *Client) ListVolumes(ctx context.Context, label string) ([]Volume, error) {
+	path := "/volumes"
+	if label != "" {
+		filters, err := json.Marshal(map[string][]string{"label": {label}})
+		if err != nil {
+			return nil, err
+		}
+		path += "?filters=" + url.QueryEscape(string(filters))
+	}
+	var resp struct {
+		Volumes []Volume `json:"Volumes"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Volumes == nil {
+		resp.Volumes = []Volume{}
+	}
+	return resp.Volumes, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("docker: decoding %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// send performs the request and turns error statuses into *APIError. A 304
+// (container already started/stopped) is treated as success.
+func (c *Client) send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/"+APIVersion+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker: %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		var payload struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(raw, &payload) == nil {
+			apiErr.Message = payload.Message
+		} else {
+			apiErr.Message = strings.TrimSpace(string(raw))
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
@@ -0,0 +1,219 @@
+package docker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"webtop-launcher/internal/docker"
+	"webtop-launcher/internal/docker/dockertest"
+)
+
+func newServer(t *testing.T) *dockertest.Server {
+	t.Helper()
+	srv, err := dockertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNewClient(t *testing.T) {
+	for _, host := range []string{"", "unix:///var/run/docker.sock", "tcp://127.0.0.1:2375", "http://docker:2375"} {
+		if _, err := docker.NewClient(host); err != nil {
+			t.Errorf("NewClient(%q): %v", host, err)
+		}
+	}
+	if _, err := docker.NewClient("ssh://host"); err == nil {
+		t.Error("NewClient accepted an ssh:// host")
+	}
+}
+
+func TestImages(t *testing.T) {
+	srv := newServer(t)
+	ctx := context.Background()
+	client := srv.Client()
+
+	if err := client.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.InspectImage(ctx, "lscr.io/linuxserver/webtop:latest"); !errors.Is(err, docker.ErrNotFound) {
+		t.Errorf("InspectImage before pulling = %v, want ErrNotFound", err)
+	}
+	if err := client.PullImage(ctx, "lscr.io/linuxserver/webtop"); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.HasImage("lscr.io/linuxserver/webtop:latest") {
+		t.Error("PullImage without a tag did not pull latest")
+	}
+	if err := client.InspectImage(ctx, "lscr.io/linuxserver/webtop:latest"); err != nil {
+		t.Errorf("InspectImage after pulling: %v", err)
+	}
+}
+
+func TestContainers(t *testing.T) {
+	srv := newServer(t)
+	ctx := context.Background()
+	client := srv.Client()
+
+	cfg := docker.ContainerConfig{
+		Image:  "webtop:latest",
+		Labels: map[string]string{docker.LabelComposeProject: "webtop-1"},
+		HostConfig: &docker.HostConfig{
+			Binds: []string{"webtop-1_config:/config"},
+		},
+	}
+	if _, err := client.CreateContainer(ctx, "webtop-1-app-1", cfg); !errors.Is(err, docker.ErrNotFound) {
+		t.Errorf("CreateContainer without the image = %v, want ErrNotFound", err)
+	}
+	srv.AddImage("webtop:latest")
+	id, err := client.CreateContainer(ctx, "webtop-1-app-1", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateContainer(ctx, "webtop-1-app-1", cfg); !errors.Is(err, docker.ErrConflict) {
+		t.Errorf("CreateContainer with a taken name = %v, want ErrConflict", err)
+	}
+	if !srv.HasVolume("webtop-1_config") {
+		t.Error("the named volume of a bind was not created")
+	}
+
+	if err := client.StartContainer(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StartContainer(ctx, id); err != nil {
+		t.Errorf("starting a running container: %v", err)
+	}
+	ctr, err := client.InspectContainer(ctx, "webtop-1-app-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctr.ID != id || !ctr.State.Running || ctr.State.StartedTime().IsZero() {
+		t.Errorf("InspectContainer = %+v", ctr)
+	}
+
+	list, err := client.ListContainers(ctx, docker.LabelComposeProject+"=webtop-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != id || list[0].State != "running" {
+		t.Errorf("ListContainers = %+v", list)
+	}
+	if list, err = client.ListContainers(ctx, docker.LabelComposeProject+"=webtop-2"); err != nil || len(list) != 0 {
+		t.Errorf("ListContainers of another project = %+v, %v", list, err)
+	}
+
+	if err := client.RemoveContainer(ctx, id, false); !errors.Is(err, docker.ErrConflict) {
+		t.Errorf("removing a running container = %v, want ErrConflict", err)
+	}
+	if err := client.StopContainer(ctx, id, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveContainer(ctx, id, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.InspectContainer(ctx, id); !errors.Is(err, docker.ErrNotFound) {
+		t.Errorf("InspectContainer after removal = %v, want ErrNotFound", err)
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	srv := newServer(t)
+	ctx := context.Background()
+	client := srv.Client()
+	labels := map[string]string{docker.LabelComposeProject: "webtop-1"}
+
+	netID, err := client.CreateNetwork(ctx, "webtop-1_default", "", labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateNetwork(ctx, "webtop-1_default", "", labels); !errors.Is(err, docker.ErrConflict) {
+		t.Errorf("CreateNetwork with a taken name = %v, want ErrConflict", err)
+	}
+	networks, err := client.ListNetworks(ctx, docker.LabelComposeProject+"=webtop-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 1 || networks[0].ID != netID || networks[0].Driver != "bridge" {
+		t.Errorf("ListNetworks = %+v", networks)
+	}
+
+	srv.AddImage("webtop")
+	id, err := client.CreateContainer(ctx, "app", docker.ContainerConfig{Image: "webtop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ConnectNetwork(ctx, "webtop-1_default", id, []string{"app"}); err != nil {
+		t.Fatal(err)
+	}
+	ctr, _ := client.InspectContainer(ctx, id)
+	if ctr.NetworkSettings.Networks["webtop-1_default"].IPAddress == "" {
+		t.Errorf("the container has no address on the network: %+v", ctr.NetworkSettings)
+	}
+
+	if err := client.RemoveNetwork(ctx, netID); err == nil {
+		t.Error("RemoveNetwork removed a network in use")
+	}
+	if err := client.RemoveContainer(ctx, id, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveNetwork(ctx, netID); err != nil {
+		t.Fatal(err)
+	}
+	if srv.HasNetwork("webtop-1_default") {
+		t.Error("the network is still there")
+	}
+}
+
+func TestVolumes(t *testing.T) {
+	srv := newServer(t)
+	ctx := context.Background()
+	client := srv.Client()
+
+	v, err := client.CreateVolume(ctx, "webtop-1_config", map[string]string{docker.LabelComposeProject: "webtop-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "webtop-1_config" {
+		t.Errorf("CreateVolume = %+v", v)
+	}
+	if _, err := client.CreateVolume(ctx, "webtop-1_config", nil); err != nil {
+		t.Errorf("creating an existing volume: %v", err)
+	}
+	if _, err := client.CreateVolume(ctx, "other", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	volumes, err := client.ListVolumes(ctx, docker.LabelComposeProject+"=webtop-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 || volumes[0].Name != "webtop-1_config" {
+		t.Errorf("ListVolumes = %+v", volumes)
+	}
+
+	if err := client.RemoveVolume(ctx, "webtop-1_config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveVolume(ctx, "webtop-1_config"); !errors.Is(err, docker.ErrNotFound) {
+		t.Errorf("RemoveVolume twice = %v, want ErrNotFound", err)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	srv := newServer(t)
+
+	_, err := srv.Client().InspectContainer(context.Background(), "missing")
+	var apiErr *docker.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("InspectContainer = %v, want an *APIError", err)
+	}
+	if got, want := err.Error(), "docker: 404 No such container: missing"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, docker.ErrNotFound) || errors.Is(err, docker.ErrConflict) {
+		t.Errorf("errors.Is does not follow the status code of %v", err)
+	}
+}
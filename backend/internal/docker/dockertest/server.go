@@ -0,0 +1,463 @@
+// Package dockertest provides an in-memory fake Docker daemon listening on a
+// unix socket, so code using the docker client can be exercised without a
+// container runtime.
+package dockertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"webtop-launcher/internal/docker"
+)
+
+// Server is a fake Docker daemon. It keeps containers, volumes and pulled
+// images in memory.
+type Server struct {
+	// Host is the DOCKER_HOST style address of the fake (unix://...).
+	Host string
+
+	dir      string
+	listener net.Listener
+	srv      *http.Server
+
+	mu         sync.Mutex
+	containers map[string]*docker.Container
+	volumes    map[string]docker.Volume
+	networks   map[string]*docker.Network
+	images     map[string]bool
+	nextID     int
+
+	// OnStart, if set, is called with a container just after it is started
+	// and may adjust its state (for example to simulate a health check).
+	OnStart func(c *docker.Container)
+}
+
+// NewServer starts a fake daemon on a socket in a fresh temporary directory.
+// Call Close when done.
+func NewServer() (*Server, error) {
+	dir, err := os.MkdirTemp("", "dockertest")
+	if err != nil {
+		return nil, err
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	s := &Server{
+		Host:       "unix://" + socket,
+		dir:        dir,
+		listener:   l,
+		containers: map[string]*docker.Container{},
+		volumes:    map[string]docker.Volume{},
+		networks:   map[string]*docker.Network{},
+		images:     map[string]bool{},
+		nextID:     1,
+	}
+	s.srv = &http.Server{Handler: http.HandlerFunc(s.serve)}
+	go s.srv.Serve(l)
+	return s, nil
+}
+
+// Client returns a docker client connected to the fake.
+func (s *Server) Client() *docker.Client {
+	c, _ := docker.NewClient(s.Host)
+	return c
+}
+
+// Close stops the fake and removes its socket.
+func (s *Server) Close() {
+	s.srv.Close()
+	os.RemoveAll(s.dir)
+}
+
+// Container returns a copy of a container by name.
+func (s *Server) Container(name string) (docker.Container, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.find(name)
+	if c == nil {
+		return docker.Container{}, false
+	}
+	return *c, true
+}
+
+// SetState replaces the state of a container.
+func (s *Server) SetState(name string, state docker.ContainerState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.find(name); c != nil {
+		c.State = state
+	}
+}
+
+// HasVolume reports whether a named volume exists.
+func (s *Server) HasVolume(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.volumes[name]
+	return ok
+}
+
+// HasNetwork reports whether a network exists.
+func (s *Server) HasNetwork(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findNetwork(name) != nil
+}
+
+// ContainerCount returns the number of containers, running or not.
+func (s *Server) ContainerCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.containers)
+}
+
+// AddImage marks an image reference as already present.
+func (s *Server) AddImage(ref string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[normaliseRef(ref)] = true
+}
+
+// HasImage reports whether an image reference has been pulled.
+func (s *Server) HasImage(ref string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.images[normaliseRef(ref)]
+}
+
+func normaliseRef(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i <= strings.LastIndex(ref, "/") {
+		ref += ":latest"
+	}
+	return ref
+}
+
+func (s *Server) findNetwork(nameOrID string) *docker.Network {
+	for id, n := range s.networks {
+		if id == nameOrID || n.Name == nameOrID {
+			return n
+		}
+	}
+	return nil
+}
+
+func (s *Server) find(nameOrID string) *docker.Container {
+	nameOrID = strings.TrimPrefix(nameOrID, "/")
+	for id, c := range s.containers {
+		if id == nameOrID || strings.TrimPrefix(c.Name, "/") == nameOrID {
+			return c
+		}
+	}
+	return nil
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf(format, args...)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func labelsMatch(labels map[string]string, r *http.Request) bool {
+	raw := r.URL.Query().Get("filters")
+	if raw == "" {
+		return true
+	}
+	var filters map[string][]string
+	if err := json.Unmarshal([]byte(raw), &filters); err != nil {
+		return false
+	}
+	for _, f := range filters["label"] {
+		k, v, hasValue := strings.Cut(f, "=")
+		got, ok := labels[k]
+		if !ok || (hasValue && got != v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/v1.") {
+		if i := strings.Index(path[1:], "/"); i >= 0 {
+			path = path[i+1:]
+		}
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case path == "/_ping":
+		w.Write([]byte("OK"))
+
+	case path == "/images/create" && r.Method == http.MethodPost:
+		ref := r.URL.Query().Get("fromImage") + ":" + r.URL.Query().Get("tag")
+		s.images[ref] = true
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.Encode(map[string]string{"status": "Pulling from " + ref})
+		enc.Encode(map[string]string{"status": "Status: Downloaded newer image for " + ref})
+
+	case len(parts) >= 3 && parts[0] == "images" && parts[len(parts)-1] == "json" && r.Method == http.MethodGet:
+		ref := strings.Join(parts[1:len(parts)-1], "/")
+		if !s.images[normaliseRef(ref)] {
+			writeError(w, http.StatusNotFound, "No such image: %s", ref)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"Id": "sha256:" + ref})
+
+	case path == "/networks/create" && r.Method == http.MethodPost:
+		var n docker.Network
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+		if s.findNetwork(n.Name) != nil {
+			writeError(w, http.StatusConflict, "network with name %s already exists", n.Name)
+			return
+		}
+		n.ID = fmt.Sprintf("net%061x", s.nextID)
+		s.nextID++
+		s.networks[n.ID] = &n
+		writeJSON(w, http.StatusCreated, map[string]string{"Id": n.ID})
+
+	case path == "/networks" && r.Method == http.MethodGet:
+		ids := make([]string, 0, len(s.networks))
+		for id := range s.networks {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		list := []docker.Network{}
+		for _, id := range ids {
+			if labelsMatch(s.networks[id].Labels, r) {
+				list = append(list, *s.networks[id])
+			}
+		}
+		writeJSON(w, http.StatusOK, list)
+
+	case len(parts) == 3 && parts[0] == "networks" && parts[2] == "connect" && r.Method == http.MethodPost:
+		n := s.findNetwork(parts[1])
+		if n == nil {
+			writeError(w, http.StatusNotFound, "network %s not found", parts[1])
+			return
+		}
+		var req struct {
+			Container string `json:"Container"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		c := s.find(req.Container)
+		if c == nil {
+			writeError(w, http.StatusNotFound, "No such container: %s", req.Container)
+			return
+		}
+		s.attach(c, n.Name)
+		w.WriteHeader(http.StatusOK)
+
+	case len(parts) == 2 && parts[0] == "networks" && r.Method == http.MethodDelete:
+		n := s.findNetwork(parts[1])
+		if n == nil {
+			writeError(w, http.StatusNotFound, "network %s not found", parts[1])
+			return
+		}
+		for _, c := range s.containers {
+			if _, ok := c.NetworkSettings.Networks[n.Name]; ok {
+				writeError(w, http.StatusForbidden, "error while removing network: network %s has active endpoints", n.Name)
+				return
+			}
+		}
+		delete(s.networks, n.ID)
+		w.WriteHeader(http.StatusNoContent)
+
+	case path == "/volumes/create" && r.Method == http.MethodPost:
+		var v docker.Volume
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+		if existing, ok := s.volumes[v.Name]; ok {
+			writeJSON(w, http.StatusCreated, existing)
+			return
+		}
+		if v.Labels == nil {
+			v.Labels = map[string]string{}
+		}
+		s.volumes[v.Name] = v
+		writeJSON(w, http.StatusCreated, v)
+
+	case path == "/volumes" && r.Method == http.MethodGet:
+		names := make([]string, 0, len(s.volumes))
+		for name := range s.volumes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		volumes := []docker.Volume{}
+		for _, name := range names {
+			if labelsMatch(s.volumes[name].Labels, r) {
+				volumes = append(volumes, s.volumes[name])
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Volumes": volumes})
+
+	case len(parts) == 2 && parts[0] == "volumes" && r.Method == http.MethodDelete:
+		if _, ok := s.volumes[parts[1]]; !ok {
+			writeError(w, http.StatusNotFound, "get %s: no such volume", parts[1])
+			return
+		}
+		delete(s.volumes, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+
+	case path == "/containers/json" && r.Method == http.MethodGet:
+		ids := make([]string, 0, len(s.containers))
+		for id := range s.containers {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		list := []docker.ContainerSummary{}
+		for _, id := range ids {
+			c := s.containers[id]
+			if !labelsMatch(c.Config.Labels, r) {
+				continue
+			}
+			list = append(list, docker.ContainerSummary{
+				ID:              c.ID,
+				Names:           []string{c.Name},
+				Image:           c.Config.Image,
+				State:           c.State.Status,
+				Labels:          c.Config.Labels,
+				NetworkSettings: c.NetworkSettings,
+			})
+		}
+		writeJSON(w, http.StatusOK, list)
+
+	case path == "/containers/create" && r.Method == http.MethodPost:
+		s.createContainer(w, r)
+
+	case len(parts) >= 2 && parts[0] == "containers":
+		c := s.find(parts[1])
+		if c == nil {
+			writeError(w, http.StatusNotFound, "No such container: %s", parts[1])
+			return
+		}
+		switch {
+		case len(parts) == 3 && parts[2] == "json" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, c)
+		case len(parts) == 3 && parts[2] == "start" && r.Method == http.MethodPost:
+			if c.State.Running {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			c.State = docker.ContainerState{Status: "running", Running: true, StartedAt: time.Now().UTC().Format(time.RFC3339Nano)}
+			if len(c.NetworkSettings.Networks) == 0 {
+				s.attach(c, "bridge")
+			}
+			if s.OnStart != nil {
+				s.OnStart(c)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case len(parts) == 3 && parts[2] == "stop" && r.Method == http.MethodPost:
+			if !c.State.Running {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			c.State.Status, c.State.Running = "exited", false
+			c.State.FinishedAt = time.Now().UTC().Format(time.RFC3339Nano)
+			w.WriteHeader(http.StatusNoContent)
+		case len(parts) == 2 && r.Method == http.MethodDelete:
+			if c.State.Running && r.URL.Query().Get("force") != "true" {
+				writeError(w, http.StatusConflict, "You cannot remove a running container %s. Stop the container before attempting removal or force remove", c.ID)
+				return
+			}
+			delete(s.containers, c.ID)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusNotFound, "page not found")
+		}
+
+	default:
+		writeError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+func (s *Server) createContainer(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name != "" && s.find(name) != nil {
+		writeError(w, http.StatusConflict, "Conflict. The container name \"/%s\" is already in use", name)
+		return
+	}
+	var cfg docker.ContainerConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if !s.images[normaliseRef(cfg.Image)] {
+		writeError(w, http.StatusNotFound, "No such image: %s", cfg.Image)
+		return
+	}
+	// Named volumes referenced in binds are created implicitly, as the
+	// real daemon does.
+	if cfg.HostConfig != nil {
+		for _, bind := range cfg.HostConfig.Binds {
+			src := strings.SplitN(bind, ":", 2)[0]
+			if !strings.HasPrefix(src, "/") {
+				if _, ok := s.volumes[src]; !ok {
+					s.volumes[src] = docker.Volume{Name: src, Labels: map[string]string{}}
+				}
+			}
+		}
+	}
+
+	id := fmt.Sprintf("%064x", s.nextID)
+	s.nextID++
+	if name == "" {
+		name = "container" + id[56:]
+	}
+	c := &docker.Container{
+		ID:     id,
+		Name:   "/" + name,
+		Image:  "sha256:" + id,
+		State:  docker.ContainerState{Status: "created", StartedAt: "0001-01-01T00:00:00Z"},
+		Config: cfg,
+	}
+	if cfg.NetworkingConfig != nil {
+		for network := range cfg.NetworkingConfig.EndpointsConfig {
+			if s.findNetwork(network) == nil {
+				writeError(w, http.StatusNotFound, "network %s not found", network)
+				return
+			}
+			s.attach(c, network)
+		}
+	}
+	s.containers[id] = c
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"Id": id, "Warnings": []string{}})
+}
+
+// attach connects c to a network, handing out a fake address.
+func (s *Server) attach(c *docker.Container, network string) {
+	if c.NetworkSettings.Networks == nil {
+		c.NetworkSettings.Networks = map[string]docker.EndpointSettings{}
+	}
+	s.nextID++
+	c.NetworkSettings.Networks[network] = docker.EndpointSettings{
+		IPAddress: fmt.Sprintf("172.%d.%d.%d", 17+len(s.networks), (s.nextID/250)%250, s.nextID%250+2),
+	}
+}
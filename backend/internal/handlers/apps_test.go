@@ -0,0 +1,81 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"webtop-launcher/internal/models"
+)
+
+const privilegedCompose = `services:
+  webtop:
+    image: lscr.io/linuxserver/webtop:latest
+    privileged: true
+    volumes:
+      - /var/run/docker.sock:/var/run/docker.sock
+`
+
+func TestUpdateApp(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("admin", "admin")
+	ts.user("eddie", "catalog-editor")
+	app := ts.app("Webtop", true)
+	other := ts.app("Other", true)
+	eddie := ts.login("eddie")
+
+	update := func(token, id string, change func(*models.Application)) int {
+		t.Helper()
+		body := *app
+		change(&body)
+		return ts.do("PUT", "/api/admin/apps/"+id, token, body).Code
+	}
+	stored := func(id string) *models.Application {
+		t.Helper()
+		a, err := ts.Apps.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+
+	if code := update(eddie, app.ID, func(a *models.Application) { a.Name = "Webtop Ubuntu" }); code != http.StatusOK {
+		t.Fatalf("renaming: %d", code)
+	}
+	if stored(app.ID).Name != "Webtop Ubuntu" {
+		t.Error("the rename was not saved")
+	}
+
+	// The path, not the body, names the application.
+	if code := update(eddie, other.ID, func(a *models.Application) { a.Name = "Renamed" }); code != http.StatusOK {
+		t.Fatalf("updating another application: %d", code)
+	}
+	if stored(app.ID).Name != "Webtop Ubuntu" || stored(other.ID).Name != "Renamed" {
+		t.Error("the body's ID chose the application that was updated")
+	}
+	if code := update(eddie, "missing", func(a *models.Application) {}); code != http.StatusNotFound {
+		t.Errorf("updating a missing application: %d, want 404", code)
+	}
+
+	privileged := func(a *models.Application) { a.DockerCompose = privilegedCompose }
+	if code := update(eddie, app.ID, privileged); code != http.StatusForbidden {
+		t.Errorf("a catalog editor saving a privileged compose file: %d, want 403", code)
+	}
+	if stored(app.ID).DockerCompose != testCompose {
+		t.Error("the privileged compose file was saved")
+	}
+	if code := update(eddie, app.ID, func(a *models.Application) { a.DockerCompose = "services: [\n" }); code != http.StatusBadRequest {
+		t.Errorf("saving an invalid compose file: %d, want 400", code)
+	}
+
+	if code := update(ts.login("admin"), app.ID, privileged); code != http.StatusOK {
+		t.Fatalf("an admin saving a privileged compose file: %d", code)
+	}
+	// Editing the rest of the application leaves its compose file alone.
+	if code := update(eddie, app.ID, func(a *models.Application) {
+		a.DockerCompose = privilegedCompose
+		a.IsEnabled = false
+	}); code != http.StatusOK {
+		t.Errorf("disabling an application with a privileged compose file: %d", code)
+	}
+}
@@ -0,0 +1,246 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"webtop-launcher/internal/auth"
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/store"
+
+	"github.com/google/uuid"
+)
+
+// tokenCookie returns the access token cookie rec sets.
+func tokenCookie(t *testing.T, rec *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == middleware.TokenCookie {
+			return c
+		}
+	}
+	t.Fatalf("no %s cookie in %q", middleware.TokenCookie, rec.Header().Values("Set-Cookie"))
+	return nil
+}
+
+func TestCookieAuthentication(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("alice")
+	rec := ts.do("POST", "/api/auth/login", "", map[string]string{"username": "alice", "password": testPassword})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	cookie := tokenCookie(t, rec)
+	if !cookie.HttpOnly {
+		t.Error("the token cookie is readable by scripts")
+	}
+
+	send := func(method, path string, header http.Header) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.AddCookie(cookie)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		ts.Router().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send("GET", "/api/auth/me", nil); code != http.StatusOK {
+		t.Errorf("GET with the cookie: %d, want 200", code)
+	}
+	// Another site can make the browser send the cookie, but not a custom
+	// header.
+	if code := send("DELETE", "/api/tokens/missing", nil); code != http.StatusForbidden {
+		t.Errorf("DELETE with the cookie and no %s: %d, want 403", middleware.CSRFHeader, code)
+	}
+	if code := send("DELETE", "/api/tokens/missing", http.Header{middleware.CSRFHeader: {"XMLHttpRequest"}}); code != http.StatusNotFound {
+		t.Errorf("DELETE with the cookie and %s: %d, want 404", middleware.CSRFHeader, code)
+	}
+	if code := send("GET", "/api/auth/me", http.Header{"Authorization": {"Bearer invalid"}}); code != http.StatusUnauthorized {
+		t.Errorf("an invalid bearer token with a valid cookie: %d, want 401", code)
+	}
+}
+
+// A login waiting for a password change gets a restricted cookie, so the
+// web UI can finish it without handling the token.
+func TestRestrictedTokenCookie(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.user("bob")
+	if err := ts.Users.UpdatePassword(context.Background(), user.ID, user.PasswordHash, true); err != nil {
+		t.Fatal(err)
+	}
+	rec := ts.do("POST", "/api/auth/login", "", map[string]string{"username": "bob", "password": testPassword})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", rec.Code, rec.Body)
+	}
+	req := httptest.NewRequest("GET", "/api/auth/me", nil)
+	req.AddCookie(tokenCookie(t, rec))
+	me := httptest.NewRecorder()
+	ts.Router().ServeHTTP(me, req)
+	if me.Code != http.StatusForbidden {
+		t.Errorf("GET with the restricted cookie: %d, want 403", me.Code)
+	}
+}
+
+// The session proxy tells a restricted login what it is still missing,
+// like the API does.
+func TestProxyScopeErrors(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	bob := ts.user("bob")
+	ts.user("carol")
+	login := func(username string) *http.Cookie {
+		rec := ts.do("POST", "/api/auth/login", "", map[string]string{"username": username, "password": testPassword})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login %s: %d %s", username, rec.Code, rec.Body)
+		}
+		return tokenCookie(t, rec)
+	}
+
+	if err := ts.Users.UpdatePassword(ctx, bob.ID, bob.PasswordHash, true); err != nil {
+		t.Fatal(err)
+	}
+	passwordChange := login("bob")
+	if err := auth.SaveMFARequired(ctx, ts.Settings, true); err != nil {
+		t.Fatal(err)
+	}
+	mfaEnroll := login("carol")
+
+	for _, tt := range []struct {
+		cookie *http.Cookie
+		want   string
+	}{
+		{passwordChange, "Password change required"},
+		{mfaEnroll, "Two-factor enrollment required"},
+	} {
+		for _, path := range []string{"/s/" + uuid.New().String() + "/", "/api/auth/forward"} {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("X-Forwarded-Uri", "/s/"+uuid.New().String()+"/")
+			req.AddCookie(tt.cookie)
+			rec := httptest.NewRecorder()
+			ts.Router().ServeHTTP(rec, req)
+			if rec.Code != http.StatusForbidden || strings.TrimSpace(rec.Body.String()) != tt.want {
+				t.Errorf("%s: %d %q, want 403 %q", path, rec.Code, rec.Body, tt.want)
+			}
+		}
+	}
+}
+
+// agedTokens makes used refresh tokens look used age ago, past the grace
+// period for concurrent refreshes.
+type agedTokens struct {
+	store.TokenStore
+	age time.Duration
+}
+
+func (s agedTokens) GetByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	t, err := s.TokenStore.GetByHash(ctx, hash)
+	if err == nil && t.UsedAt != nil {
+		usedAt := t.UsedAt.Add(-s.age)
+		t.UsedAt = &usedAt
+	}
+	return t, err
+}
+
+type loginTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// loginTokens signs username in and returns both tokens.
+func (ts *testServer) loginTokens(username string) loginTokens {
+	ts.t.Helper()
+	rec := ts.do("POST", "/api/auth/login", "", map[string]string{"username": username, "password": testPassword})
+	if rec.Code != http.StatusOK {
+		ts.t.Fatalf("login %s: %d %s", username, rec.Code, rec.Body)
+	}
+	var tokens loginTokens
+	ts.decode(rec, &tokens)
+	return tokens
+}
+
+func (ts *testServer) refresh(refreshToken string) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	return ts.do("POST", "/api/auth/refresh", "", map[string]string{"refreshToken": refreshToken})
+}
+
+func TestRefreshReuse(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("alice")
+	first := ts.loginTokens("alice")
+	other := ts.loginTokens("alice")
+
+	rec := ts.refresh(first.RefreshToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh: %d %s", rec.Code, rec.Body)
+	}
+	var second loginTokens
+	ts.decode(rec, &second)
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("the refresh token was not rotated")
+	}
+
+	// Tabs refreshing at once present the old token again at once.
+	if rec := ts.refresh(first.RefreshToken); rec.Code != http.StatusConflict {
+		t.Errorf("reuse within the grace period: %d, want 409", rec.Code)
+	}
+	if rec := ts.do("GET", "/api/auth/me", second.Token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("the login after reuse within the grace period: %d", rec.Code)
+	}
+
+	ts.Tokens = agedTokens{ts.Tokens, time.Minute}
+	ts.handler = ts.Router()
+	if rec := ts.refresh(first.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("reuse after the grace period: %d, want 401", rec.Code)
+	}
+	// The whole family goes, including the token the thief may hold.
+	if rec := ts.refresh(second.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("the latest refresh token of a reused family: %d, want 401", rec.Code)
+	}
+	for _, token := range []string{first.Token, second.Token} {
+		if rec := ts.do("GET", "/api/auth/me", token, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("an access token of a reused family: %d, want 401", rec.Code)
+		}
+	}
+	if rec := ts.refresh(other.RefreshToken); rec.Code != http.StatusOK {
+		t.Errorf("another login of the user: %d, want 200", rec.Code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("alice")
+	first := ts.loginTokens("alice")
+	other := ts.loginTokens("alice")
+
+	rec := ts.do("POST", "/api/auth/logout", first.Token, nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("logout: %d %s", rec.Code, rec.Body)
+	}
+	if c := tokenCookie(t, rec); c.Value != "" {
+		t.Error("logout kept the token cookie")
+	}
+	if rec := ts.do("GET", "/api/auth/me", first.Token, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("the access token after logout: %d, want 401", rec.Code)
+	}
+	if rec := ts.refresh(first.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("the refresh token after logout: %d, want 401", rec.Code)
+	}
+	if rec := ts.do("GET", "/api/auth/me", other.Token, nil); rec.Code != http.StatusOK {
+		t.Errorf("another login after logout: %d, want 200", rec.Code)
+	}
+
+	// The refresh token alone is enough to end its login.
+	if rec := ts.do("POST", "/api/auth/logout", "", map[string]string{"refreshToken": other.RefreshToken}); rec.Code != http.StatusNoContent {
+		t.Fatalf("logout with the refresh token: %d", rec.Code)
+	}
+	if rec := ts.do("GET", "/api/auth/me", other.Token, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("the access token after logout with the refresh token: %d, want 401", rec.Code)
+	}
+}
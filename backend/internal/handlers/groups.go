@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/rbac"
+	"webtop-launcher/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) RegisterGroupRoutes(router *mux.Router) {
+	router.Handle("", s.allow(rbac.GroupsManage, s.GetGroups)).Methods("GET")
+	router.Handle("", s.allow(rbac.GroupsManage, s.CreateGroup)).Methods("POST")
+	router.Handle("/{id}", s.allow(rbac.GroupsManage, s.UpdateGroup)).Methods("PUT")
+	router.Handle("/{id}", s.allow(rbac.GroupsManage, s.DeleteGroup)).Methods("DELETE")
+	router.Handle("/{id}/members/{userId}", s.allow(rbac.GroupsManage, s.AddGroupMember)).Methods("PUT")
+	router.Handle("/{id}/members/{userId}", s.allow(rbac.GroupsManage, s.RemoveGroupMember)).Methods("DELETE")
+	router.Handle("/{id}/apps", s.allow(rbac.GroupsManage, s.SetGroupApps)).Methods("PUT")
+}
+
+func (s *Server) GetGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := s.Groups.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(groups)
+}
+
+// groupRequest is the body of CreateGroup and UpdateGroup.
+type groupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// decodeGroup reads and validates a groupRequest, answering 400 if it is
+// invalid.
+func decodeGroup(w http.ResponseWriter, r *http.Request) (*groupRequest, bool) {
+	var req groupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		http.Error(w, "Group name must be 1 to 64 characters", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(req.Description) > 255 {
+		http.Error(w, "Group description must be at most 255 characters", http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+// CreateGroup adds a group with no members and no applications.
+func (s *Server) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeGroup(w, r)
+	if !ok {
+		return
+	}
+	group := models.Group{Name: req.Name, Description: req.Description, UserIDs: []string{}, ApplicationIDs: []string{}}
+	err := s.Groups.Create(r.Context(), &group)
+	if err == store.ErrConflict {
+		http.Error(w, "A group with this name already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+// UpdateGroup renames a group.
+func (s *Server) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeGroup(w, r)
+	if !ok {
+		return
+	}
+	group := models.Group{ID: mux.Vars(r)["id"], Name: req.Name, Description: req.Description}
+	err := s.Groups.Update(r.Context(), &group)
+	switch err {
+	case nil:
+		s.writeGroup(w, r, group.ID)
+	case store.ErrNotFound:
+		http.Error(w, "Group not found", http.StatusNotFound)
+	case store.ErrConflict:
+		http.Error(w, "A group with this name already exists", http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DeleteGroup deletes a group. Its applications that are in no other group
+// become available to every user.
+func (s *Server) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	err := s.Groups.Delete(r.Context(), mux.Vars(r)["id"])
+	if err == store.ErrNotFound {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddGroupMember adds a user to a group. Adding a member twice is not an
+// error.
+func (s *Server) AddGroupMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := s.Groups.AddMember(r.Context(), vars["id"], vars["userId"])
+	if err == store.ErrNotFound {
+		http.Error(w, "Group or user not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeGroup(w, r, vars["id"])
+}
+
+func (s *Server) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := s.Groups.RemoveMember(r.Context(), vars["id"], vars["userId"])
+	if err == store.ErrNotFound {
+		http.Error(w, "Group member not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeGroup(w, r, vars["id"])
+}
+
+// SetGroupApps replaces the applications restricted to a group. An
+// application in at least one group can only be launched by the members of
+// its groups; one in none can be launched by every user.
+func (s *Server) SetGroupApps(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ApplicationIDs []string `json:"applicationIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if _, err := s.Groups.Get(r.Context(), id); err == store.ErrNotFound {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err := s.Groups.SetApplications(r.Context(), id, req.ApplicationIDs)
+	if err == store.ErrNotFound {
+		http.Error(w, "Application not found", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeGroup(w, r, id)
+}
+
+// writeGroup answers with the group id as it is now stored.
+func (s *Server) writeGroup(w http.ResponseWriter, r *http.Request, id string) {
+	group, err := s.Groups.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(group)
+}
@@ -0,0 +1,175 @@
+package handlers_test
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"webtop-launcher/internal/models"
+)
+
+// group creates a group and returns it.
+func (ts *testServer) group(token, name string) models.Group {
+	ts.t.Helper()
+	rec := ts.do("POST", "/api/admin/groups", token, map[string]string{"name": name})
+	if rec.Code != http.StatusCreated {
+		ts.t.Fatalf("creating group %s: %d %s", name, rec.Code, rec.Body)
+	}
+	var group models.Group
+	ts.decode(rec, &group)
+	return group
+}
+
+// availableApps returns the names of the apps the user of token may
+// launch, sorted.
+func (ts *testServer) availableApps(token string) string {
+	ts.t.Helper()
+	var apps []models.Application
+	ts.decode(ts.do("GET", "/api/apps", token, nil), &apps)
+	names := []string{}
+	for _, app := range apps {
+		names = append(names, app.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestGroups(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("admin", "admin")
+	ts.user("bob")
+	token := ts.login("admin")
+
+	staff := ts.group(token, "Staff")
+	if staff.ID == "" || len(staff.UserIDs) != 0 || len(staff.ApplicationIDs) != 0 {
+		t.Errorf("created group = %+v", staff)
+	}
+	for _, tt := range []struct {
+		name string
+		body map[string]string
+		want int
+	}{
+		{"an empty name", map[string]string{"name": "  "}, http.StatusBadRequest},
+		{"a long name", map[string]string{"name": strings.Repeat("x", 65)}, http.StatusBadRequest},
+		{"a long description", map[string]string{"name": "Ops", "description": strings.Repeat("x", 256)}, http.StatusBadRequest},
+		{"a taken name", map[string]string{"name": "Staff"}, http.StatusConflict},
+	} {
+		if rec := ts.do("POST", "/api/admin/groups", token, tt.body); rec.Code != tt.want {
+			t.Errorf("creating a group with %s: %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	ops := ts.group(token, "Ops")
+	rec := ts.do("PUT", "/api/admin/groups/"+staff.ID, token, map[string]string{"name": "Employees", "description": "Everyone"})
+	var renamed models.Group
+	ts.decode(rec, &renamed)
+	if renamed.Name != "Employees" || renamed.Description != "Everyone" {
+		t.Errorf("renamed group = %+v", renamed)
+	}
+	if rec := ts.do("PUT", "/api/admin/groups/"+ops.ID, token, map[string]string{"name": "Employees"}); rec.Code != http.StatusConflict {
+		t.Errorf("renaming to a taken name: %d, want 409", rec.Code)
+	}
+	if rec := ts.do("PUT", "/api/admin/groups/missing", token, map[string]string{"name": "Missing"}); rec.Code != http.StatusNotFound {
+		t.Errorf("renaming a missing group: %d, want 404", rec.Code)
+	}
+
+	var groups []models.Group
+	ts.decode(ts.do("GET", "/api/admin/groups", token, nil), &groups)
+	if len(groups) != 2 {
+		t.Errorf("listed %d groups, want 2", len(groups))
+	}
+	if rec := ts.do("GET", "/api/admin/groups", ts.login("bob"), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("listing groups without groups:manage: %d, want 403", rec.Code)
+	}
+
+	if rec := ts.do("DELETE", "/api/admin/groups/"+ops.ID, token, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("deleting a group: %d", rec.Code)
+	}
+	if rec := ts.do("DELETE", "/api/admin/groups/"+ops.ID, token, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting a deleted group: %d, want 404", rec.Code)
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("admin", "admin")
+	alice := ts.user("alice")
+	token := ts.login("admin")
+	staff := ts.group(token, "Staff")
+
+	path := "/api/admin/groups/" + staff.ID + "/members/" + alice.ID
+	for i := 0; i < 2; i++ {
+		rec := ts.do("PUT", path, token, nil)
+		var group models.Group
+		ts.decode(rec, &group)
+		if len(group.UserIDs) != 1 || group.UserIDs[0] != alice.ID {
+			t.Errorf("adding alice, attempt %d: members = %q", i+1, group.UserIDs)
+		}
+	}
+	if rec := ts.do("PUT", "/api/admin/groups/"+staff.ID+"/members/missing", token, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("adding a missing user: %d, want 404", rec.Code)
+	}
+	if rec := ts.do("PUT", "/api/admin/groups/missing/members/"+alice.ID, token, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("adding to a missing group: %d, want 404", rec.Code)
+	}
+
+	rec := ts.do("DELETE", path, token, nil)
+	var group models.Group
+	ts.decode(rec, &group)
+	if len(group.UserIDs) != 0 {
+		t.Errorf("members after removing alice = %q", group.UserIDs)
+	}
+	if rec := ts.do("DELETE", path, token, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("removing a user who is not a member: %d, want 404", rec.Code)
+	}
+}
+
+func TestGroupEntitlements(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("admin", "admin")
+	alice := ts.user("alice")
+	ts.user("bob")
+	token := ts.login("admin")
+	public := ts.app("Public", true)
+	restricted := ts.app("Restricted", true)
+	ts.app("Disabled", false)
+	staff := ts.group(token, "Staff")
+	aliceToken, bobToken := ts.login("alice"), ts.login("bob")
+
+	// Apps in no group are for everyone.
+	if got := ts.availableApps(bobToken); got != "Public,Restricted" {
+		t.Errorf("bob's apps before any restriction = %s", got)
+	}
+
+	if rec := ts.do("PUT", "/api/admin/groups/"+staff.ID+"/apps", token, map[string][]string{"applicationIds": {restricted.ID}}); rec.Code != http.StatusOK {
+		t.Fatalf("restricting an app to a group: %d %s", rec.Code, rec.Body)
+	}
+	ts.do("PUT", "/api/admin/groups/"+staff.ID+"/members/"+alice.ID, token, nil)
+	if got := ts.availableApps(aliceToken); got != "Public,Restricted" {
+		t.Errorf("a member's apps = %s, want Public,Restricted", got)
+	}
+	if got := ts.availableApps(bobToken); got != "Public" {
+		t.Errorf("a non-member's apps = %s, want Public", got)
+	}
+	if rec := ts.do("POST", "/api/sessions/launch", bobToken, map[string]interface{}{"applicationId": restricted.ID}); rec.Code != http.StatusForbidden {
+		t.Errorf("a non-member launching a restricted app: %d, want 403", rec.Code)
+	}
+	ts.launch(aliceToken, restricted.ID, false)
+	ts.launch(bobToken, public.ID, false)
+
+	if rec := ts.do("PUT", "/api/admin/groups/"+staff.ID+"/apps", token, map[string][]string{"applicationIds": {"missing"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("restricting a missing app: %d, want 400", rec.Code)
+	}
+	if rec := ts.do("PUT", "/api/admin/groups/missing/apps", token, map[string][]string{"applicationIds": {restricted.ID}}); rec.Code != http.StatusNotFound {
+		t.Errorf("restricting an app to a missing group: %d, want 404", rec.Code)
+	}
+
+	// Deleting the group leaves its app in no group, so for everyone.
+	if rec := ts.do("DELETE", "/api/admin/groups/"+staff.ID, token, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("deleting the group: %d", rec.Code)
+	}
+	if got := ts.availableApps(bobToken); got != "Public,Restricted" {
+		t.Errorf("bob's apps after deleting the group = %s", got)
+	}
+}
@@ -1,256 +1,1040 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
-	"webtop-launcher/internal/database"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"webtop-launcher/internal/compose"
+	"webtop-launcher/internal/docker"
 	"webtop-launcher/internal/middleware"
 	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/orchestrator"
+	"webtop-launcher/internal/portainer"
+	"webtop-launcher/internal/rbac"
+	"webtop-launcher/internal/security"
+	"webtop-launcher/internal/store"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func RegisterUserRoutes(router *mux.Router) {
-	router.HandleFunc("", GetUsers).Methods("GET")
-	router.HandleFunc("", CreateUser).Methods("POST")
-	router.HandleFunc("/{id}", UpdateUser).Methods("PUT")
-	router.HandleFunc("/{id}/reset-password", ResetPassword).Methods("POST")
-	router.HandleFunc("/{id}", DeleteUser).Methods("DELETE")
+func (s *Server) RegisterUserRoutes(router *mux.Router) {
+	router.Handle("", s.allow(rbac.UsersManage, s.GetUsers)).Methods("GET")
+	router.Handle("", s.allow(rbac.UsersManage, s.CreateUser)).Methods("POST")
+	router.Handle("/{id}", s.allow(rbac.UsersManage, s.UpdateUser)).Methods("PUT")
+	router.Handle("/{id}/reset-password", s.allow(rbac.UsersManage, s.ResetPassword)).Methods("POST")
+	router.Handle("/{id}/unlock", s.allow(rbac.UsersManage, s.UnlockUser)).Methods("POST")
+	router.Handle("/{id}/revoke-sessions", s.allow(rbac.UsersManage, s.RevokeUserSessions)).Methods("POST")
+	router.Handle("/{id}/reset-2fa", s.allow(rbac.UsersManage, s.ResetTwoFactor)).Methods("POST")
+	router.Handle("/{id}/roles", s.allow(rbac.RolesManage, s.SetUserRoles)).Methods("PUT")
+	router.Handle("/{id}/tokens", s.allow(rbac.UsersManage, s.GetUserTokens)).Methods("GET")
+	router.Handle("/{id}/tokens", s.allow(rbac.UsersManage, s.CreateUserToken)).Methods("POST")
+	router.Handle("/{id}/tokens/{tokenId}", s.allow(rbac.UsersManage, s.DeleteUserToken)).Methods("DELETE")
+	router.Handle("/{id}", s.allow(rbac.UsersManage, s.DeleteUser)).Methods("DELETE")
 }
 
-func GetUsers(w http.ResponseWriter, r *http.Request) {
-	rows, err := database.DB.Query("SELECT id, username, is_admin, created_at FROM users")
+// GetUsers lists the users with the IDs of their roles.
+func (s *Server) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.Users.List(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	assignments, err := s.Roles.ListAssignments(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := []userResponse{}
+	for _, u := range users {
+		resp = append(resp, userResponse{User: u, Roles: roleIDs(assignments, u.ID)})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
 
-	users := []models.User{}
-	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.ID, &user.Username, &user.IsAdmin, &user.CreatedAt); err != nil {
+// CreateUser adds a local user or, with serviceAccount set, a service
+// account: a user without a password who cannot sign in and only
+// authenticates with the API tokens issued to it.
+func (s *Server) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var user models.User
+	var creds struct {
+		Username       string `json:"username"`
+		Password       string `json:"password"`
+		IsAdmin        bool   `json:"isAdmin"`
+		ServiceAccount bool   `json:"serviceAccount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	creds.Username = strings.TrimSpace(creds.Username)
+	if creds.Username == "" {
+		http.Error(w, "Username must not be empty", http.StatusBadRequest)
+		return
+	}
+	if !creds.ServiceAccount {
+		if err := s.Passwords.Check(creds.Username, creds.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	// Only admins make admins.
+	if creds.IsAdmin && !s.mayGrant(w, r, rbac.All) {
+		return
+	}
+
+	user.Username = creds.Username
+	user.IsAdmin = creds.IsAdmin
+	if creds.ServiceAccount {
+		user.AuthProvider = models.AuthService
+	} else {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		users = append(users, user)
+		user.PasswordHash = string(hashedPassword)
 	}
-	json.NewEncoder(w).Encode(users)
+
+	err := s.Users.Create(r.Context(), &user)
+	if err == store.ErrConflict {
+		http.Error(w, "Username already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeUser(w, r, &user)
 }
 
-func CreateUser(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	var creds struct {
-		Username string `json:"username"`
+// UpdateUser changes a user's username and/or admin flag, which grants or
+// takes the admin role. Fields missing from the body are left as they are.
+func (s *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username *string `json:"username"`
+		IsAdmin  *bool   `json:"isAdmin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.Users.Get(r.Context(), mux.Vars(r)["id"])
+	if err == store.ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.Username != nil {
+		user.Username = strings.TrimSpace(*req.Username)
+		if user.Username == "" {
+			http.Error(w, "Username must not be empty", http.StatusBadRequest)
+			return
+		}
+	}
+	if !s.mayManage(w, r, user.ID) {
+		return
+	}
+	if req.IsAdmin != nil && *req.IsAdmin != user.IsAdmin {
+		if !s.mayGrant(w, r, rbac.All) {
+			return
+		}
+		user.IsAdmin = *req.IsAdmin
+	}
+
+	err = s.Users.Update(r.Context(), user)
+	switch err {
+	case nil:
+		s.writeUser(w, r, user)
+	case store.ErrNotFound:
+		http.Error(w, "User not found", http.StatusNotFound)
+	case store.ErrConflict:
+		http.Error(w, "Username already exists", http.StatusConflict)
+	case store.ErrLastAdmin:
+		http.Error(w, "Cannot demote the last admin", http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ResetPassword sets a user's password to the one in the body or, when the
+// body has none, to a generated one, and makes the user change it at next
+// login. A generated password is returned in the response and nowhere else.
+// The user's logins are revoked.
+func (s *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
 		Password string `json:"password"`
-		IsAdmin  bool   `json:"isAdmin"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+	// The body is optional.
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !s.mayManage(w, r, mux.Vars(r)["id"]) {
+		return
+	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(creds.Password), bcrypt.DefaultCost)
+	user, err := s.Users.Get(r.Context(), mux.Vars(r)["id"])
+	if err == store.ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if user.AuthProvider == models.AuthService {
+		http.Error(w, "Service accounts have no password", http.StatusBadRequest)
+		return
+	} else if user.AuthProvider != models.AuthLocal {
+		http.Error(w, "Password is managed by the identity provider", http.StatusBadRequest)
+		return
+	}
+
+	password, generated := req.Password, false
+	if password == "" {
+		if password, err = s.Passwords.Generate(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		generated = true
+	} else if err := s.Passwords.Check(user.Username, password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	user.ID = uuid.New().String()
-	user.Username = creds.Username
-	user.PasswordHash = string(hashedPassword)
-	user.IsAdmin = creds.IsAdmin
+	err = s.Users.UpdatePassword(r.Context(), user.ID, string(hash), true)
+	if err == store.ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.Tokens.RevokeUser(r.Context(), user.ID, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	_, err = database.DB.Exec("INSERT INTO users (id, username, password_hash, is_admin) VALUES ($1, $2, $3, $4)", user.ID, user.Username, user.PasswordHash, user.IsAdmin)
+	security.Log(security.Event{
+		Type:     security.PasswordReset,
+		Username: user.Username,
+		UserID:   user.ID,
+		ActorID:  middleware.UserID(r.Context()),
+		ClientIP: s.ClientIPs.ClientIP(r),
+	})
+
+	if !generated {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"password": password})
+}
+
+// UnlockUser lifts a lockout after too many failed logins and resets the
+// failure count.
+func (s *Server) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !s.mayManage(w, r, id) {
+		return
+	}
+	err := s.Users.ClearLoginFailures(r.Context(), id)
+	if err == store.ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user, err := s.Users.Get(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	security.Log(security.Event{
+		Type:     security.AccountUnlocked,
+		Username: user.Username,
+		UserID:   user.ID,
+		ActorID:  middleware.UserID(r.Context()),
+		ClientIP: s.ClientIPs.ClientIP(r),
+	})
 	json.NewEncoder(w).Encode(user)
 }
 
-func UpdateUser(w http.ResponseWriter, r *http.Request) {
-	// Implementation for updating a user
-	w.WriteHeader(http.StatusNotImplemented)
+// ResetTwoFactor removes a user's second factors, TOTP and passkeys, and
+// recovery codes, for a user who lost their device. If the policy requires
+// two-factor authentication they enroll again at their next login.
+func (s *Server) ResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if !s.mayManage(w, r, id) {
+		return
+	}
+	err := s.Users.DisableTOTP(r.Context(), id)
+	if err == store.ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	creds, err := s.Credentials.ListByUser(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, c := range creds {
+		if err := s.Credentials.Delete(r.Context(), id, c.ID); err != nil && err != store.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	user, err := s.Users.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	security.Log(security.Event{
+		Type:     security.MFAReset,
+		Username: user.Username,
+		UserID:   user.ID,
+		ActorID:  middleware.UserID(r.Context()),
+		ClientIP: s.ClientIPs.ClientIP(r),
+	})
+	json.NewEncoder(w).Encode(user)
 }
 
-func ResetPassword(w http.ResponseWriter, r *http.Request) {
-	// Implementation for resetting a password
-	w.WriteHeader(http.StatusNotImplemented)
+// RevokeUserSessions signs a user out everywhere: every refresh token is
+// revoked, and with them the access tokens issued alongside.
+func (s *Server) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	if !s.mayManage(w, r, mux.Vars(r)["id"]) {
+		return
+	}
+	user, err := s.Users.Get(r.Context(), mux.Vars(r)["id"])
+	if err == store.ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.Tokens.RevokeUser(r.Context(), user.ID, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	security.Log(security.Event{
+		Type:     security.SessionsRevoked,
+		Username: user.Username,
+		UserID:   user.ID,
+		ActorID:  middleware.UserID(r.Context()),
+		ClientIP: s.ClientIPs.ClientIP(r),
+	})
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func DeleteUser(w http.ResponseWriter, r *http.Request) {
+func (s *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
+	if !s.mayManage(w, r, id) {
+		return
+	}
 
-	_, err := database.DB.Exec("DELETE FROM users WHERE id = $1", id)
-	if err != nil {
+	err := s.Users.Delete(r.Context(), id)
+	if err == store.ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err == store.ErrLastAdmin {
+		http.Error(w, "Cannot delete the last admin", http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func RegisterAppRoutes(router *mux.Router) {
-	router.HandleFunc("", GetApps).Methods("GET")
-	router.HandleFunc("/scrape", ScrapeApps).Methods("POST")
-	router.HandleFunc("/{id}", UpdateApp).Methods("PUT")
+func (s *Server) RegisterAppRoutes(router *mux.Router) {
+	router.Handle("", s.allow(rbac.AppsManage, s.GetApps)).Methods("GET")
+	router.Handle("/scrape", s.allow(rbac.AppsManage, s.ScrapeApps)).Methods("POST")
+	router.Handle("/{id}", s.allow(rbac.AppsManage, s.UpdateApp)).Methods("PUT")
 }
 
-func GetApps(w http.ResponseWriter, r *http.Request) {
-	rows, err := database.DB.Query("SELECT id, name, logo_url, repository_url, docker_compose, is_enabled, created_at FROM applications")
+func (s *Server) GetApps(w http.ResponseWriter, r *http.Request) {
+	apps, err := s.Apps.List(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	json.NewEncoder(w).Encode(apps)
+}
 
-	apps := []models.Application{}
-	for rows.Next() {
-		var app models.Application
-		if err := rows.Scan(&app.ID, &app.Name, &app.LogoURL, &app.RepositoryURL, &app.DockerCompose, &app.IsEnabled, &app.CreatedAt); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		apps = append(apps, app)
+// GetAvailableApps lists the enabled applications the signed-in user is
+// entitled to launch.
+func (s *Server) GetAvailableApps(w http.ResponseWriter, r *http.Request) {
+	apps, err := s.Apps.ListEntitled(r.Context(), middleware.UserID(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	json.NewEncoder(w).Encode(apps)
 }
 
-func ScrapeApps(w http.ResponseWriter, r *http.Request) {
+func (s *Server) ScrapeApps(w http.ResponseWriter, r *http.Request) {
 	// This would trigger the Gemini service. For now, it's a placeholder.
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
-func UpdateApp(w http.ResponseWriter, r *http.Request) {
+// UpdateApp replaces the application named by the path. A compose file
+// that runs privileged, adds capabilities or devices, joins host
+// namespaces or mounts host paths gives root on the Docker host, so only
+// those who also manage Portainer may save one.
+func (s *Server) UpdateApp(w http.ResponseWriter, r *http.Request) {
 	var app models.Application
 	if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	app.ID = mux.Vars(r)["id"]
 
-	_, err := database.DB.Exec("UPDATE applications SET name = $1, logo_url = $2, repository_url = $3, docker_compose = $4, is_enabled = $5 WHERE id = $6",
-		app.Name, app.LogoURL, app.RepositoryURL, app.DockerCompose, app.IsEnabled, app.ID)
-	if err != nil {
+	current, err := s.Apps.Get(r.Context(), app.ID)
+	if err == store.ErrNotFound {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Other fields of an application an administrator set up stay
+	// editable.
+	if app.DockerCompose != current.DockerCompose {
+		privileges, err := compose.Privileges([]byte(app.DockerCompose))
+		if err != nil {
+			http.Error(w, "Invalid docker compose: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(privileges) > 0 {
+			allowed, err := s.Auth.Can(r.Context(), middleware.UserID(r.Context()), rbac.PortainerManage)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				http.Error(w, "Only Portainer managers may save a compose file with host access ("+strings.Join(privileges, "; ")+")", http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	err = s.Apps.Update(r.Context(), &app)
+	if err == store.ErrNotFound {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	} else if err == store.ErrConflict {
+		http.Error(w, "An application with this name already exists", http.StatusConflict)
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	json.NewEncoder(w).Encode(app)
 }
 
-func RegisterSessionRoutes(router *mux.Router, adminRouter *mux.Router) {
+func (s *Server) RegisterSessionRoutes(router *mux.Router, adminRouter *mux.Router) {
 	// Authenticated routes
-	router.Use(middleware.AuthMiddleware)
-	router.HandleFunc("", GetUserSessions).Methods("GET")
-	router.HandleFunc("/launch", LaunchSession).Methods("POST")
-	router.HandleFunc("/{id}/stop", StopSession).Methods("POST")
-
-	// Admin-only routes
-	adminRouter.HandleFunc("/sessions", GetAdminSessions).Methods("GET")
-}
-
-func GetUserSessions(w http.ResponseWriter, r *http.Request) {
-	log.Println("GetUserSessions handler hit")
-	userID := r.Context().Value("userID").(string)
-	// Join sessions and applications to get application name and logo
-	query := `
-	       SELECT s.id, s.user_id, s.application_id, s.portainer_stack_id, s.is_persistent, s.created_at,
-		      a.name, a.logo_url
-	       FROM sessions s
-	       JOIN applications a ON s.application_id = a.id
-	       WHERE s.user_id = $1
-       `
-	rows, err := database.DB.Query(query, userID)
+	router.Use(s.Auth.AuthMiddleware)
+	router.Handle("", s.allow(rbac.SessionsLaunch, s.GetUserSessions)).Methods("GET")
+	router.Handle("/launch", s.allow(rbac.SessionsLaunch, s.LaunchSession)).Methods("POST")
+	// Stopping the sessions of others also requires rbac.SessionsStopAll.
+	router.Handle("/{id}/stop", s.allow(rbac.SessionsLaunch, s.StopSession)).Methods("POST")
+
+	// Admin routes
+	adminRouter.Handle("/sessions", s.allow(rbac.SessionsReadAll, s.GetAdminSessions)).Methods("GET")
+}
+
+// sessionResponse is the session shape the frontend expects (see Session in types.ts).
+type sessionResponse struct {
+	ID               string `json:"id"`
+	UserID           string `json:"userId"`
+	ApplicationID    string `json:"applicationId"`
+	PortainerStackID int    `json:"portainerStackId"`
+	IsPersistent     bool   `json:"persistent"`
+	CreatedAt        string `json:"startTime"`
+	ApplicationName  string `json:"applicationName"`
+	ApplicationLogo  string `json:"applicationLogo"`
+}
+
+func newSessionResponse(sess models.Session, app models.Application) sessionResponse {
+	return sessionResponse{
+		ID:               sess.ID,
+		UserID:           sess.UserID,
+		ApplicationID:    sess.ApplicationID,
+		PortainerStackID: sess.PortainerStackID,
+		IsPersistent:     sess.IsPersistent,
+		CreatedAt:        sess.CreatedAt.Format(time.RFC3339),
+		ApplicationName:  app.Name,
+		ApplicationLogo:  app.LogoURL,
+	}
+}
+
+func (s *Server) GetUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r.Context())
+	sessions, err := s.Sessions.ListByUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apps, err := s.Apps.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	appsByID := map[string]models.Application{}
+	for _, app := range apps {
+		appsByID[app.ID] = app
+	}
+
+	resp := []sessionResponse{}
+	for _, sess := range sessions {
+		app, ok := appsByID[sess.ApplicationID]
+		if !ok {
+			continue
+		}
+		resp = append(resp, newSessionResponse(sess, app))
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) GetAdminSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.Sessions.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (s *Server) LaunchSession(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r.Context())
+	var req struct {
+		ApplicationID string `json:"applicationId"`
+		IsPersistent  bool   `json:"isPersistent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ApplicationID == "" {
+		http.Error(w, "applicationId is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.Users.Get(r.Context(), userID)
+	if err == store.ErrNotFound {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	username := user.Username
+
+	app, err := s.Apps.Get(r.Context(), req.ApplicationID)
+	if err == store.ErrNotFound {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !app.IsEnabled {
+		http.Error(w, "Application is disabled", http.StatusForbidden)
+		return
+	}
+	entitled, err := s.Apps.Entitled(r.Context(), userID, app.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
+	} else if !entitled {
+		http.Error(w, "You are not entitled to this application", http.StatusForbidden)
+		return
+	}
+
+	driver, err := orchestrator.ConfiguredDriver(r.Context(), s.Settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	orch, err := s.NewOrchestrator(r.Context(), driver)
+	if err != nil {
+		orchestratorError(w, err)
+		return
+	}
+
+	sessionID := uuid.New().String()
+	target := compose.Session{
+		ID:         sessionID,
+		UserID:     userID,
+		Username:   username,
+		AppName:    app.Name,
+		Persistent: req.IsPersistent,
+	}
+	stackFile, err := compose.Rewrite([]byte(app.DockerCompose), target)
+	if err != nil {
+		http.Error(w, "Invalid docker-compose definition: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	stack, err := orch.DeployStack(r.Context(), orchestrator.StackSpec{
+		Name:    stackName(username, sessionID),
+		Compose: string(stackFile),
+		Env: map[string]string{
+			"SESSION_ID": sessionID,
+			"USER_ID":    userID,
+			"USERNAME":   username,
+		},
+	})
+	if err != nil {
+		orchestratorError(w, err)
+		return
+	}
+
+	sess := models.Session{
+		ID:            sessionID,
+		UserID:        userID,
+		ApplicationID: app.ID,
+		StackID:       stack.ID,
+		StackName:     stack.Name,
+		Orchestrator:  driver,
+		IsPersistent:  req.IsPersistent,
+	}
+	// Sessions deployed through Portainer also keep the numeric stack ID in
+	// portainer_stack_id, which the frontend still displays.
+	if driver == orchestrator.DriverPortainer {
+		if id, err := strconv.Atoi(stack.ID); err == nil {
+			sess.PortainerStackID = id
+		}
+	}
+	// External proxies route straight to the container.
+	if u, err := stack.WebURL(); err == nil {
+		sess.TargetURL = u.String()
+	} else {
+		log.Printf("LaunchSession: no web address for stack %s: %v", stack.Name, err)
+	}
+
+	// The stack is running; record it. If that fails, tear the stack down
+	// again so nothing is left orphaned on either side.
+	if err := s.Sessions.Create(r.Context(), &sess); err != nil {
+		if rmErr := orch.RemoveStack(context.Background(), stack.Ref, keptVolumes(target)...); rmErr != nil {
+			log.Printf("LaunchSession: could not remove stack %s after failed insert: %v", stack.Name, rmErr)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.syncTraefik()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newSessionResponse(sess, *app))
+}
+
+// stackName builds a stack name from the username and session ID.
+// Compose project names may only contain lowercase letters, digits, dashes
+// and underscores, and must start with a letter or digit.
+func stackName(username, sessionID string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(username) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+			b.WriteRune(c)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	name := strings.TrimLeft(b.String(), "-_")
+	if name == "" {
+		name = "user"
+	}
+	return name + "-" + sessionID
+}
+
+// orchestratorError writes an HTTP error for a failed orchestrator call.
+func orchestratorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, portainer.ErrNotConfigured):
+		http.Error(w, "Portainer is not configured", http.StatusServiceUnavailable)
+	case errors.Is(err, portainer.ErrUnauthorized), errors.Is(err, portainer.ErrForbidden):
+		http.Error(w, "Portainer rejected the configured API key", http.StatusBadGateway)
+	case errors.Is(err, compose.ErrNoServices):
+		http.Error(w, "Invalid docker-compose definition: "+err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+func (s *Server) StopSession(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.UserID(r.Context())
+	sessionID := mux.Vars(r)["id"]
+
+	sess, err := s.Sessions.Get(r.Context(), sessionID)
+	if err == store.ErrNotFound {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	defer rows.Close()
 
-	type sessionResponse struct {
-		ID               string `json:"id"`
-		UserID           string `json:"userId"`
-		ApplicationID    string `json:"applicationId"`
-		PortainerStackID int    `json:"portainerStackId"`
-		IsPersistent     bool   `json:"persistent"`
-		CreatedAt        string `json:"startTime"`
-		ApplicationName  string `json:"applicationName"`
-		ApplicationLogo  string `json:"applicationLogo"`
+	if sess.UserID != userID {
+		allowed, err := s.Auth.Can(r.Context(), userID, rbac.SessionsStopAll)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "You are not allowed to stop this session", http.StatusForbidden)
+			return
+		}
 	}
 
-	sessions := []sessionResponse{}
-	for rows.Next() {
-		var s sessionResponse
-		var createdAtRaw interface{}
-		if err := rows.Scan(&s.ID, &s.UserID, &s.ApplicationID, &s.PortainerStackID, &s.IsPersistent, &createdAtRaw, &s.ApplicationName, &s.ApplicationLogo); err != nil {
+	// Sessions from before drivers were pluggable only have a Portainer
+	// stack ID; their stack name can be derived from the owner and session.
+	ref := orchestrator.Ref{ID: sess.StackID, Name: sess.StackName}
+	if ref.ID == "" && sess.PortainerStackID != 0 {
+		ref.ID = strconv.Itoa(sess.PortainerStackID)
+	}
+	if ref.Name == "" {
+		owner, err := s.Users.Get(r.Context(), sess.UserID)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		// Convert createdAt to string (ISO8601)
-		switch t := createdAtRaw.(type) {
-		case string:
-			s.CreatedAt = t
-		case []byte:
-			s.CreatedAt = string(t)
-		case nil:
-			s.CreatedAt = ""
-		default:
-			// Try to format as time.Time
-			if tm, ok := createdAtRaw.(interface{ String() string }); ok {
-				s.CreatedAt = tm.String()
-			} else {
-				s.CreatedAt = ""
-			}
+		ref.Name = stackName(owner.Username, sessionID)
+	}
+	target := compose.Session{ID: sessionID, UserID: sess.UserID, Persistent: sess.IsPersistent}
+	if sess.IsPersistent {
+		app, err := s.Apps.Get(r.Context(), sess.ApplicationID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		sessions = append(sessions, s)
+		target.AppName = app.Name
 	}
-	json.NewEncoder(w).Encode(sessions)
+	orch, err := s.NewOrchestrator(r.Context(), sess.Orchestrator)
+	if err != nil {
+		orchestratorError(w, err)
+		return
+	}
+	if err := orch.RemoveStack(r.Context(), ref, keptVolumes(target)...); err != nil {
+		orchestratorError(w, err)
+		return
+	}
+
+	if err := s.Sessions.Delete(r.Context(), sessionID); err != nil && err != store.ErrNotFound {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.syncTraefik()
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func GetAdminSessions(w http.ResponseWriter, r *http.Request) {
-	rows, err := database.DB.Query("SELECT id, user_id, application_id, portainer_stack_id, is_persistent, created_at FROM sessions")
+// keptVolumes returns the volumes to keep when the stack of a session is
+// removed: the user's /config volume, if the session is persistent.
+func keptVolumes(s compose.Session) []string {
+	if !s.Persistent {
+		return nil
+	}
+	return []string{compose.ConfigVolumeName(s)}
+}
+
+// syncTraefik rewrites the Traefik dynamic configuration file, if one is
+// configured, after a session starts or stops. Failures are logged: the
+// session itself is fine, and the next sync will catch up.
+func (s *Server) syncTraefik() {
+	if err := s.Traefik.Sync(context.Background()); err != nil {
+		log.Printf("traefik: writing %s: %v", s.Traefik.File, err)
+	}
+}
+
+func (s *Server) RegisterPortainerRoutes(router *mux.Router) {
+	router.Handle("", s.allow(rbac.PortainerManage, s.GetPortainerConfig)).Methods("GET")
+	router.Handle("", s.allow(rbac.PortainerManage, s.UpdatePortainerConfig)).Methods("PUT")
+	router.Handle("/deploy", s.allow(rbac.PortainerManage, s.DeployPortainer)).Methods("POST")
+	router.Handle("/status", s.allow(rbac.PortainerManage, s.GetPortainerStatus)).Methods("GET")
+}
+
+// portainerConfig mirrors PortainerConfig in types.ts.
+type portainerConfig struct {
+	URL    string `json:"url"`
+	APIKey string `json:"apiKey"`
+}
+
+// maskAPIKey hides all but the last four characters of a key.
+func maskAPIKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return strings.Repeat("*", len(key)-4) + key[len(key)-4:]
+}
+
+func (s *Server) loadPortainerConfig(ctx context.Context) (portainerConfig, error) {
+	values, err := s.Settings.Get(ctx, "portainer_url", "portainer_api_key")
+	if err != nil {
+		return portainerConfig{}, err
+	}
+	return portainerConfig{URL: values["portainer_url"], APIKey: values["portainer_api_key"]}, nil
+}
+
+func (s *Server) GetPortainerConfig(w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.loadPortainerConfig(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	cfg.APIKey = maskAPIKey(cfg.APIKey)
+	json.NewEncoder(w).Encode(cfg)
+}
 
-	sessions := []models.Session{}
-	for rows.Next() {
-		var session models.Session
-		if err := rows.Scan(&session.ID, &session.UserID, &session.ApplicationID, &session.PortainerStackID, &session.IsPersistent, &session.CreatedAt); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+// UpdatePortainerConfig saves a new Portainer URL and API key after checking
+// that the instance answers on /api/status and accepts the key. Sending back
+// the masked key (or an empty one) keeps the stored key, but only for the
+// stored URL: the key must not be sent to another instance.
+func (s *Server) UpdatePortainerConfig(w http.ResponseWriter, r *http.Request) {
+	var req portainerConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.URL = strings.TrimRight(strings.TrimSpace(req.URL), "/")
+	if req.URL == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+
+	current, err := s.loadPortainerConfig(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.APIKey == "" || req.APIKey == maskAPIKey(current.APIKey) {
+		if current.APIKey != "" && req.URL != current.URL {
+			http.Error(w, "Enter the API key again to change the URL", http.StatusBadRequest)
 			return
 		}
-		sessions = append(sessions, session)
+		req.APIKey = current.APIKey
 	}
-	json.NewEncoder(w).Encode(sessions)
+	if req.APIKey == "" {
+		http.Error(w, "apiKey is required", http.StatusBadRequest)
+		return
+	}
+
+	skipVerify, err := s.Settings.Get(r.Context(), "portainer_tls_skip_verify")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client := portainer.NewClient(req.URL, req.APIKey)
+	if skipVerify["portainer_tls_skip_verify"] == "true" {
+		client.InsecureSkipVerify()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if _, err := client.Status(ctx); err != nil {
+		http.Error(w, "Could not reach Portainer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := client.ListEndpoints(ctx); err != nil {
+		if errors.Is(err, portainer.ErrUnauthorized) || errors.Is(err, portainer.ErrForbidden) {
+			http.Error(w, "Portainer rejected the API key", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Could not reach Portainer: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Settings.Set(r.Context(), map[string]string{"portainer_url": req.URL, "portainer_api_key": req.APIKey}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(portainerConfig{URL: req.URL, APIKey: maskAPIKey(req.APIKey)})
 }
 
-func LaunchSession(w http.ResponseWriter, r *http.Request) {
-	// This is a complex operation involving Portainer. Placeholder for now.
-	w.WriteHeader(http.StatusNotImplemented)
+// newSelfHost returns the manager for the Portainer container the backend
+// deploys on its own Docker host.
+func (s *Server) newSelfHost() (*portainer.SelfHost, error) {
+	dockerClient, err := docker.NewClient(s.Config.DockerHost)
+	if err != nil {
+		return nil, err
+	}
+	return &portainer.SelfHost{
+		Docker:  dockerClient,
+		URL:     s.Config.PortainerSelfHostURL,
+		Timeout: 10 * time.Minute,
+	}, nil
 }
 
-func StopSession(w http.ResponseWriter, r *http.Request) {
-	// This is a complex operation involving Portainer. Placeholder for now.
-	w.WriteHeader(http.StatusNotImplemented)
+// portainerDeployment tracks the self-hosted Portainer deployment running
+// in the background, so GetPortainerStatus can report on it.
+type portainerDeployment struct {
+	mu      sync.Mutex
+	running bool
+	step    string
+	err     string
+	// adminPassword is that of a deployment that created a new admin,
+	// kept until GetPortainerStatus has shown it once.
+	adminPassword string
 }
 
-func RegisterPortainerRoutes(router *mux.Router) {
-	router.HandleFunc("/deploy", DeployPortainer).Methods("POST")
-	router.HandleFunc("/status", GetPortainerStatus).Methods("GET")
+// setStep records the step a running deployment is at.
+func (d *portainerDeployment) setStep(step string) {
+	d.mu.Lock()
+	d.step = step
+	d.mu.Unlock()
 }
 
-func DeployPortainer(w http.ResponseWriter, r *http.Request) {
-	// This is a complex operation involving Docker. Placeholder for now.
-	w.WriteHeader(http.StatusNotImplemented)
+// DeployPortainer starts (re)deploying the self-hosted Portainer container
+// through the Docker socket. The deployment runs in the background, on its
+// own timeout; GetPortainerStatus reports its progress.
+func (s *Server) DeployPortainer(w http.ResponseWriter, r *http.Request) {
+	selfHost, err := s.newSelfHost()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	current, err := s.loadPortainerConfig(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	selfHost.ExistingAPIKey = current.APIKey
+	selfHost.Progress = s.portainerDeploy.setStep
+
+	d := &s.portainerDeploy
+	d.mu.Lock()
+	if d.running {
+		d.mu.Unlock()
+		http.Error(w, "A Portainer deployment is already in progress", http.StatusConflict)
+		return
+	}
+	d.running, d.step, d.err, d.adminPassword = true, "Starting", "", ""
+	d.mu.Unlock()
+
+	go s.deployPortainer(selfHost)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Portainer deployment started",
+	})
 }
 
-func GetPortainerStatus(w http.ResponseWriter, r *http.Request) {
-	// This is a complex operation involving Docker. Placeholder for now.
-	w.WriteHeader(http.StatusNotImplemented)
+// deployPortainer runs a deployment started by DeployPortainer and stores
+// the resulting URL and API key. The admin password of a new instance is
+// stored encrypted, and shown once by GetPortainerStatus.
+func (s *Server) deployPortainer(selfHost *portainer.SelfHost) {
+	ctx := context.Background()
+	var adminPassword string
+	err := func() error {
+		result, err := selfHost.Deploy(ctx)
+		if err != nil {
+			return err
+		}
+		values := map[string]string{
+			"portainer_url":             result.URL,
+			"portainer_api_key":         result.APIKey,
+			"portainer_tls_skip_verify": "true",
+		}
+		if result.AdminPassword != "" {
+			encrypted, err := s.Secrets.Encrypt(result.AdminPassword)
+			if err != nil {
+				return err
+			}
+			values["portainer_admin_password"] = encrypted
+			adminPassword = result.AdminPassword
+		}
+		return s.Settings.Set(ctx, values)
+	}()
+
+	d := &s.portainerDeploy
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running, d.step = false, ""
+	if err != nil {
+		log.Printf("DeployPortainer: %v", err)
+		d.err = err.Error()
+		return
+	}
+	d.adminPassword = adminPassword
+}
+
+// portainerStatus mirrors PortainerStatus in types.ts.
+type portainerStatus struct {
+	portainer.ContainerStatus
+	// Message is the step of a running deployment or the error of a
+	// failed one.
+	Message       string `json:"message,omitempty"`
+	AdminPassword string `json:"adminPassword,omitempty"`
+}
+
+// GetPortainerStatus reports the state of the self-hosted Portainer
+// container and of its last deployment. The admin password created by a
+// deployment is included the first time the status is read after it.
+func (s *Server) GetPortainerStatus(w http.ResponseWriter, r *http.Request) {
+	d := &s.portainerDeploy
+	d.mu.Lock()
+	if d.running {
+		step := d.step
+		d.mu.Unlock()
+		json.NewEncoder(w).Encode(portainerStatus{
+			ContainerStatus: portainer.ContainerStatus{Status: portainer.StateDeploying},
+			Message:         step,
+		})
+		return
+	}
+	failure := d.err
+	d.mu.Unlock()
+
+	selfHost, err := s.newSelfHost()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	container, err := selfHost.Status(r.Context())
+	if err != nil {
+		http.Error(w, "Could not inspect Portainer container: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	status := portainerStatus{ContainerStatus: portainer.ContainerStatus{Status: portainer.StateStopped}}
+	if container != nil {
+		status.ContainerStatus = *container
+	}
+	if failure != "" {
+		status.Message = "Deployment failed: " + failure
+		if container == nil {
+			status.Status = portainer.StateError
+		}
+	}
+
+	d.mu.Lock()
+	status.AdminPassword, d.adminPassword = d.adminPassword, ""
+	d.mu.Unlock()
+	json.NewEncoder(w).Encode(status)
 }
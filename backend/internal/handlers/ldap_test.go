@@ -0,0 +1,164 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"webtop-launcher/internal/ldap/ldaptest"
+)
+
+const (
+	ldapServiceDN = "cn=launcher,dc=example,dc=org"
+	ldapAdminsDN  = "cn=admins,ou=groups,dc=example,dc=org"
+)
+
+// ldapUser is a directory user with testPassword.
+func ldapUser(uid string, groups ...string) ldaptest.Entry {
+	return ldaptest.Entry{
+		DN:         "uid=" + uid + ",ou=people,dc=example,dc=org",
+		Password:   testPassword,
+		Attributes: map[string][]string{"uid": {uid}, "memberOf": groups},
+	}
+}
+
+// withDirectory connects the server to dir.
+func (ts *testServer) withDirectory(dir *ldaptest.Directory) {
+	ts.LDAPDial = dir.Dial
+	ts.handler = ts.Router()
+}
+
+func ldapSettings(url, bindDN, bindPassword string) map[string]interface{} {
+	return map[string]interface{}{
+		"url":          url,
+		"bindDn":       bindDN,
+		"bindPassword": bindPassword,
+		"baseDn":       "ou=people,dc=example,dc=org",
+		"adminGroup":   ldapAdminsDN,
+	}
+}
+
+func TestUpdateLDAPConfig(t *testing.T) {
+	ts := newTestServer(t)
+	ts.withDirectory(&ldaptest.Directory{Entries: []ldaptest.Entry{
+		{DN: ldapServiceDN, Password: "service-secret"},
+		// Binding as this account with the stored password would work.
+		{DN: "cn=other,dc=example,dc=org", Password: "service-secret"},
+	}})
+	ts.user("admin", "admin")
+	token := ts.login("admin")
+	update := func(settings map[string]interface{}) int {
+		t.Helper()
+		return ts.do("PUT", "/api/admin/security/ldap", token, settings).Code
+	}
+	stored := func() map[string]string {
+		t.Helper()
+		values, err := ts.Settings.Get(context.Background(), "ldap_url", "ldap_bind_dn", "ldap_bind_password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return values
+	}
+
+	if code := update(ldapSettings("ldaps://ldap.example.org", ldapServiceDN, "wrong")); code != http.StatusBadRequest {
+		t.Errorf("saving a bind password the directory refuses: %d, want 400", code)
+	}
+	if code := update(ldapSettings("ldaps://ldap.example.org", ldapServiceDN, "service-secret")); code != http.StatusOK {
+		t.Fatalf("saving the config: %d", code)
+	}
+	var cfg struct {
+		BindPassword string `json:"bindPassword"`
+	}
+	ts.decode(ts.do("GET", "/api/admin/security/ldap", token, nil), &cfg)
+	if cfg.BindPassword == "service-secret" {
+		t.Fatal("GET returns the bind password unmasked")
+	}
+	// The masked password keeps the stored one for the same directory and
+	// account.
+	if code := update(ldapSettings("ldaps://ldap.example.org", ldapServiceDN, cfg.BindPassword)); code != http.StatusOK {
+		t.Errorf("saving with the masked password: %d", code)
+	}
+
+	// It is not sent anywhere else, or less safely.
+	for _, tt := range []struct {
+		name     string
+		settings map[string]interface{}
+	}{
+		{"another host", ldapSettings("ldaps://ldap.attacker.test", ldapServiceDN, cfg.BindPassword)},
+		{"another port", ldapSettings("ldaps://ldap.example.org:1636", ldapServiceDN, "")},
+		{"plain LDAP", ldapSettings("ldap://ldap.example.org", ldapServiceDN, cfg.BindPassword)},
+		{"another bind DN", ldapSettings("ldaps://ldap.example.org", "cn=other,dc=example,dc=org", cfg.BindPassword)},
+		{"no certificate check", func() map[string]interface{} {
+			s := ldapSettings("ldaps://ldap.example.org", ldapServiceDN, cfg.BindPassword)
+			s["insecureSkipVerify"] = true
+			return s
+		}()},
+	} {
+		if code := update(tt.settings); code != http.StatusBadRequest {
+			t.Errorf("%s with the kept password: %d, want 400", tt.name, code)
+		}
+	}
+	if values := stored(); values["ldap_url"] != "ldaps://ldap.example.org" || values["ldap_bind_dn"] != ldapServiceDN || values["ldap_bind_password"] != "service-secret" {
+		t.Errorf("stored config = %q after refused updates", values)
+	}
+
+	if code := update(ldapSettings("ldaps://ldap2.example.org", "cn=other,dc=example,dc=org", "service-secret")); code != http.StatusOK {
+		t.Errorf("changing the URL and bind DN with the password entered again: %d", code)
+	}
+	if values := stored(); values["ldap_url"] != "ldaps://ldap2.example.org" || values["ldap_bind_dn"] != "cn=other,dc=example,dc=org" {
+		t.Errorf("stored config = %q, want the new one", values)
+	}
+}
+
+func TestLDAPLogin(t *testing.T) {
+	ts := newTestServer(t)
+	dir := &ldaptest.Directory{Entries: []ldaptest.Entry{
+		{DN: ldapServiceDN, Password: "service-secret"},
+		ldapUser("carol", ldapAdminsDN),
+		ldapUser("dave"),
+	}}
+	ts.withDirectory(dir)
+	ts.user("admin", "admin")
+	token := ts.login("admin")
+	if rec := ts.do("PUT", "/api/admin/security/ldap", token, ldapSettings("ldaps://ldap.example.org", ldapServiceDN, "service-secret")); rec.Code != http.StatusOK {
+		t.Fatalf("configuring LDAP: %d %s", rec.Code, rec.Body)
+	}
+	if rec := ts.do("PUT", "/api/admin/security/authenticators", token, map[string][]string{"chain": {"local", "ldap"}}); rec.Code != http.StatusOK {
+		t.Fatalf("enabling LDAP: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := ts.do("POST", "/api/auth/login", "", map[string]string{"username": "dave", "password": "wrong"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a wrong directory password: %d, want 401", rec.Code)
+	}
+	if rec := ts.do("POST", "/api/auth/login", "", map[string]string{"username": "erin", "password": testPassword}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a user in neither source: %d, want 401", rec.Code)
+	}
+
+	// The admin group decides who gets the admin role.
+	isAdmin := func(username string) bool {
+		t.Helper()
+		var me struct {
+			IsAdmin bool `json:"isAdmin"`
+		}
+		ts.decode(ts.do("GET", "/api/auth/me", ts.login(username), nil), &me)
+		return me.IsAdmin
+	}
+	if !isAdmin("carol") {
+		t.Error("a member of the admin group is not an admin")
+	}
+	if rec := ts.do("GET", "/api/admin/users", ts.login("carol"), nil); rec.Code != http.StatusOK {
+		t.Errorf("a member of the admin group managing users: %d", rec.Code)
+	}
+	if isAdmin("dave") {
+		t.Error("a user outside the admin group is an admin")
+	}
+	if rec := ts.do("GET", "/api/admin/users", ts.login("dave"), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("a user outside the admin group managing users: %d, want 403", rec.Code)
+	}
+
+	// Leaving the group takes the role away at the next login.
+	dir.Entries[1].Attributes["memberOf"] = nil
+	if isAdmin("carol") {
+		t.Error("a user who left the admin group is still an admin")
+	}
+}
@@ -0,0 +1,202 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"webtop-launcher/internal/totp"
+	"webtop-launcher/internal/webauthn/webauthntest"
+)
+
+type mfaLogin struct {
+	Token                 string   `json:"token"`
+	MFARequired           bool     `json:"mfaRequired"`
+	MFAEnrollmentRequired bool     `json:"mfaEnrollmentRequired"`
+	Methods               []string `json:"methods"`
+	RecoveryCodes         []string `json:"recoveryCodes"`
+}
+
+// enrollTOTP enables TOTP for the user of token and returns the secret, the
+// step of the code that confirmed it and the confirmation's response.
+func (ts *testServer) enrollTOTP(token string) (string, int64, mfaLogin) {
+	ts.t.Helper()
+	rec := ts.do("POST", "/api/auth/2fa/totp/enroll", token, nil)
+	if rec.Code != http.StatusOK {
+		ts.t.Fatalf("2fa/totp/enroll: %d %s", rec.Code, rec.Body)
+	}
+	var enroll struct {
+		Secret string `json:"secret"`
+	}
+	ts.decode(rec, &enroll)
+	step := totp.Step(time.Now())
+	rec = ts.do("POST", "/api/auth/2fa/totp/confirm", token, map[string]string{"code": totpCode(ts.t, enroll.Secret, step)})
+	if rec.Code != http.StatusOK {
+		ts.t.Fatalf("2fa/totp/confirm: %d %s", rec.Code, rec.Body)
+	}
+	var confirmed mfaLogin
+	ts.decode(rec, &confirmed)
+	return enroll.Secret, step, confirmed
+}
+
+// challenge signs username in with the password and returns the second
+// factor challenge.
+func (ts *testServer) challenge(username string) mfaLogin {
+	ts.t.Helper()
+	var login mfaLogin
+	ts.decode(ts.do("POST", "/api/auth/login", "", map[string]string{"username": username, "password": testPassword}), &login)
+	if !login.MFARequired {
+		ts.t.Fatalf("login %s = %+v, want a second factor challenge", username, login)
+	}
+	return login
+}
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPEnrollAndVerify(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("alice")
+	token := ts.login("alice")
+
+	rec := ts.do("POST", "/api/auth/2fa/totp/enroll", token, nil)
+	var enroll struct {
+		Secret string `json:"secret"`
+		URL    string `json:"url"`
+	}
+	ts.decode(rec, &enroll)
+	if rec := ts.do("POST", "/api/auth/2fa/totp/confirm", token, map[string]string{"code": "000000"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("confirming with a wrong code: %d, want 400", rec.Code)
+	}
+	if rec := ts.do("POST", "/api/auth/login", "", map[string]string{"username": "alice", "password": testPassword}); rec.Code != http.StatusOK {
+		t.Fatalf("an unconfirmed enrollment changed the login: %d", rec.Code)
+	}
+
+	secret, step, confirmed := ts.enrollTOTP(token)
+	if len(confirmed.RecoveryCodes) != 10 || confirmed.Token != "" {
+		t.Errorf("confirming = %+v, want 10 recovery codes and no token", confirmed)
+	}
+	if rec := ts.do("POST", "/api/auth/2fa/totp/enroll", token, nil); rec.Code != http.StatusConflict {
+		t.Errorf("enrolling again: %d, want 409", rec.Code)
+	}
+
+	challenge := ts.challenge("alice")
+	if len(challenge.Methods) != 1 || challenge.Methods[0] != "totp" {
+		t.Errorf("methods = %q, want totp", challenge.Methods)
+	}
+	if rec := ts.do("GET", "/api/auth/me", challenge.Token, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("the challenge token was accepted: %d", rec.Code)
+	}
+	if rec := ts.do("POST", "/api/auth/2fa/verify", challenge.Token, map[string]string{"code": "000000"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a wrong code: %d, want 401", rec.Code)
+	}
+	// The code that confirmed the enrollment was used up.
+	if rec := ts.do("POST", "/api/auth/2fa/verify", challenge.Token, map[string]string{"code": totpCode(t, secret, step)}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a used code: %d, want 401", rec.Code)
+	}
+	rec = ts.do("POST", "/api/auth/2fa/verify", challenge.Token, map[string]string{"code": totpCode(t, secret, step+1)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("2fa/verify: %d %s", rec.Code, rec.Body)
+	}
+	var verified mfaLogin
+	ts.decode(rec, &verified)
+	if rec := ts.do("GET", "/api/auth/me", verified.Token, nil); rec.Code != http.StatusOK {
+		t.Errorf("the verified login does not authenticate: %d", rec.Code)
+	}
+	if rec := ts.do("POST", "/api/auth/2fa/verify", ts.challenge("alice").Token, map[string]string{"code": totpCode(t, secret, step+1)}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a replayed code: %d, want 401", rec.Code)
+	}
+}
+
+func TestMFAEnrollmentRequired(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("admin", "admin")
+	ts.user("bob")
+	if rec := ts.do("PUT", "/api/admin/security/mfa", ts.login("admin"), map[string]bool{"required": true}); rec.Code != http.StatusOK {
+		t.Fatalf("requiring two-factor authentication: %d %s", rec.Code, rec.Body)
+	}
+
+	var login mfaLogin
+	ts.decode(ts.do("POST", "/api/auth/login", "", map[string]string{"username": "bob", "password": testPassword}), &login)
+	if !login.MFAEnrollmentRequired {
+		t.Fatalf("login without a second factor = %+v, want enrollment", login)
+	}
+	if rec := ts.do("GET", "/api/auth/me", login.Token, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("the enrollment token was accepted: %d", rec.Code)
+	}
+	_, _, confirmed := ts.enrollTOTP(login.Token)
+	if len(confirmed.RecoveryCodes) != 10 || confirmed.Token == "" {
+		t.Fatalf("confirming = %+v, want recovery codes and a token", confirmed)
+	}
+	if rec := ts.do("GET", "/api/auth/me", confirmed.Token, nil); rec.Code != http.StatusOK {
+		t.Errorf("the token after enrolling does not authenticate: %d", rec.Code)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("alice")
+	_, _, confirmed := ts.enrollTOTP(ts.login("alice"))
+	codes := confirmed.RecoveryCodes
+
+	rec := ts.do("POST", "/api/auth/2fa/verify", ts.challenge("alice").Token, map[string]string{"code": codes[0]})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("verifying with a recovery code: %d %s", rec.Code, rec.Body)
+	}
+	var verified mfaLogin
+	ts.decode(rec, &verified)
+	token := verified.Token
+	var status struct {
+		RecoveryCodesLeft int `json:"recoveryCodesLeft"`
+	}
+	ts.decode(ts.do("GET", "/api/auth/2fa", token, nil), &status)
+	if status.RecoveryCodesLeft != 9 {
+		t.Errorf("recovery codes left = %d, want 9", status.RecoveryCodesLeft)
+	}
+	if rec := ts.do("POST", "/api/auth/2fa/verify", ts.challenge("alice").Token, map[string]string{"code": codes[0]}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a used recovery code: %d, want 401", rec.Code)
+	}
+
+	// New codes replace all the old ones.
+	rec = ts.do("POST", "/api/auth/2fa/recovery-codes", token, map[string]string{"code": codes[1]})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("2fa/recovery-codes: %d %s", rec.Code, rec.Body)
+	}
+	var replaced mfaLogin
+	ts.decode(rec, &replaced)
+	if len(replaced.RecoveryCodes) != 10 {
+		t.Fatalf("new recovery codes = %q", replaced.RecoveryCodes)
+	}
+	if rec := ts.do("POST", "/api/auth/2fa/verify", ts.challenge("alice").Token, map[string]string{"code": codes[2]}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a replaced recovery code: %d, want 401", rec.Code)
+	}
+	if rec := ts.do("POST", "/api/auth/2fa/verify", ts.challenge("alice").Token, map[string]string{"code": replaced.RecoveryCodes[0]}); rec.Code != http.StatusOK {
+		t.Errorf("a new recovery code: %d", rec.Code)
+	}
+}
+
+func TestRecoveryCodesNeedASecondFactor(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("alice")
+	ts.user("bob")
+
+	if rec := ts.do("POST", "/api/auth/2fa/recovery-codes", ts.login("bob"), map[string]string{"code": "123456"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("without a second factor: %d, want 400", rec.Code)
+	}
+
+	// A passkey is a second factor, but not one a code can prove.
+	token := ts.login("alice")
+	ts.registerPasskey(token, webauthntest.New(passkeyOrigin))
+	if rec := ts.do("POST", "/api/auth/2fa/recovery-codes", token, map[string]string{"code": "123456"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a TOTP code without TOTP: %d, want 401", rec.Code)
+	}
+	if rec := ts.do("POST", "/api/auth/2fa/totp/disable", token, map[string]string{"code": "123456"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("disabling TOTP that is not enabled: %d, want 400", rec.Code)
+	}
+}
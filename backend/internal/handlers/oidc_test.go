@@ -0,0 +1,284 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"webtop-launcher/internal/config"
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/oidc/oidctest"
+)
+
+const oidcCallback = "http://launcher.test/api/auth/oidc/callback"
+
+// ssoFlow is one single sign-on attempt, from /oidc/login to the
+// provider's redirect back to /oidc/callback.
+type ssoFlow struct {
+	ts       *testServer
+	issuer   *oidctest.Server
+	authURL  *url.URL
+	state    *http.Cookie
+	callback *url.URL
+}
+
+func newSSOServer(t *testing.T) (*testServer, *oidctest.Server) {
+	issuer := oidctest.NewServer()
+	t.Cleanup(issuer.Close)
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.OIDCIssuerURL = issuer.URL
+		cfg.OIDCClientID = oidctest.DefaultClientID
+		cfg.OIDCClientSecret = oidctest.DefaultClientSecret
+		cfg.OIDCRedirectURL = oidcCallback
+		cfg.OIDCAdminGroup = "launcher-admins"
+	})
+	return ts, issuer
+}
+
+// startSSO calls /oidc/login and returns where it sends the browser.
+func startSSO(t *testing.T, ts *testServer, issuer *oidctest.Server) *ssoFlow {
+	t.Helper()
+	rec := ts.do("GET", "/api/auth/oidc/login", "", nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("oidc/login: %d %s", rec.Code, rec.Body)
+	}
+	authURL, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := &ssoFlow{ts: ts, issuer: issuer, authURL: authURL}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "webtop_oidc" {
+			flow.state = c
+		}
+	}
+	if flow.state == nil {
+		t.Fatal("oidc/login set no state cookie")
+	}
+	return flow
+}
+
+// authorize signs in at the provider, with the authorization request
+// changed by edit, and records where the provider sends the browser back.
+func (f *ssoFlow) authorize(t *testing.T, edit func(q url.Values)) {
+	t.Helper()
+	u := *f.authURL
+	q := u.Query()
+	if edit != nil {
+		edit(q)
+	}
+	u.RawQuery = q.Encode()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: %d", resp.StatusCode)
+	}
+	if f.callback, err = url.Parse(resp.Header.Get("Location")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// finish follows the redirect to /oidc/callback with the state cookie and
+// returns the response.
+func (f *ssoFlow) finish(t *testing.T) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", f.callback.RequestURI(), nil)
+	req.AddCookie(f.state)
+	rec := httptest.NewRecorder()
+	f.ts.Router().ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("oidc/callback: %d %s", rec.Code, rec.Body)
+	}
+	return rec
+}
+
+// ssoError returns the sso_error the callback sent the browser back with.
+func ssoError(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	u, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("sso_error")
+}
+
+// signIn runs a whole single sign-on and returns the user it signed in.
+func signIn(t *testing.T, ts *testServer, issuer *oidctest.Server, subject string, claims map[string]interface{}) *models.User {
+	t.Helper()
+	issuer.SignIn(subject, claims)
+	flow := startSSO(t, ts, issuer)
+	flow.authorize(t, nil)
+	rec := flow.finish(t)
+	if e := ssoError(t, rec); e != "" {
+		t.Fatalf("sign-in failed: %s", e)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/?sso=1" {
+		t.Errorf("callback redirects to %s", loc)
+	}
+	claims2, _, err := ts.Auth.Authenticate(context.Background(), tokenCookie(t, rec).Value)
+	if err != nil {
+		t.Fatalf("the login cookie does not authenticate: %v", err)
+	}
+	user, err := ts.Users.Get(context.Background(), claims2.Subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestOIDCLoginSendsPKCE(t *testing.T) {
+	ts, issuer := newSSOServer(t)
+	flow := startSSO(t, ts, issuer)
+	q := flow.authURL.Query()
+	if !strings.HasPrefix(flow.authURL.String(), issuer.URL+"/authorize") || q.Get("redirect_uri") != oidcCallback {
+		t.Errorf("oidc/login redirects to %s", flow.authURL)
+	}
+	if q.Get("state") == "" || q.Get("nonce") == "" {
+		t.Errorf("no state or nonce in %s", flow.authURL)
+	}
+
+	// The state cookie is signed, not encrypted: its verifier must be the
+	// one the challenge was derived from.
+	parts := strings.Split(flow.state.Value, ".")
+	if len(parts) != 3 {
+		t.Fatalf("state cookie %q is not a JWT", flow.state.Value)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state struct {
+		State, Nonce, Verifier string
+	}
+	if err := json.Unmarshal(payload, &state); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(state.Verifier))
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Errorf("code_challenge = %q (%s), want the S256 of the verifier", q.Get("code_challenge"), q.Get("code_challenge_method"))
+	}
+	if state.State != q.Get("state") || state.Nonce != q.Get("nonce") {
+		t.Errorf("state cookie %+v does not match %s", state, flow.authURL)
+	}
+}
+
+func TestOIDCProvisioning(t *testing.T) {
+	ts, issuer := newSSOServer(t)
+	alice := signIn(t, ts, issuer, "alice-subject", map[string]interface{}{"preferred_username": "Alice"})
+	if alice.Username != "alice" || alice.AuthProvider != models.AuthOIDC || alice.ExternalID != "alice-subject" || alice.IsAdmin {
+		t.Errorf("provisioned user = %+v", alice)
+	}
+
+	// The subject, not the username, identifies the user next time.
+	again := signIn(t, ts, issuer, "alice-subject", map[string]interface{}{"preferred_username": "alice.renamed"})
+	if again.ID != alice.ID {
+		t.Error("a second sign-in provisioned another user")
+	}
+	if users, _ := ts.Users.List(context.Background()); len(users) != 1 {
+		t.Errorf("%d users, want 1", len(users))
+	}
+}
+
+func TestOIDCAdminGroup(t *testing.T) {
+	ts, issuer := newSSOServer(t)
+	ts.user("root", "admin")
+
+	bob := signIn(t, ts, issuer, "bob-subject", map[string]interface{}{
+		"preferred_username": "bob",
+		"groups":             []interface{}{"staff", "launcher-admins"},
+	})
+	if !bob.IsAdmin {
+		t.Error("a member of the admin group is not an admin")
+	}
+	perms, _ := ts.Roles.Permissions(context.Background(), bob.ID)
+	if len(perms) < 2 {
+		t.Errorf("admin permissions = %q", perms)
+	}
+
+	// Leaving the group demotes the user at their next sign-in.
+	bob = signIn(t, ts, issuer, "bob-subject", map[string]interface{}{
+		"preferred_username": "bob",
+		"groups":             []interface{}{"staff"},
+	})
+	if bob.IsAdmin {
+		t.Error("a user who left the admin group is still an admin")
+	}
+}
+
+func TestOIDCUsernameTaken(t *testing.T) {
+	ts, issuer := newSSOServer(t)
+	local := ts.user("alice")
+
+	issuer.SignIn("alice-subject", map[string]interface{}{"preferred_username": "alice"})
+	flow := startSSO(t, ts, issuer)
+	flow.authorize(t, nil)
+	rec := flow.finish(t)
+	if e := ssoError(t, rec); !strings.Contains(e, "alice") {
+		t.Errorf("sso_error = %q, want the username clash", e)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == middleware.TokenCookie && c.Value != "" {
+			t.Error("a clashing sign-in was logged in")
+		}
+	}
+	user, err := ts.Users.GetByUsername(context.Background(), "alice")
+	if err != nil || user.ID != local.ID || user.AuthProvider != models.AuthLocal {
+		t.Errorf("the local user was taken over: %+v, %v", user, err)
+	}
+}
+
+func TestOIDCStateMismatch(t *testing.T) {
+	ts, issuer := newSSOServer(t)
+
+	tests := []struct {
+		name  string
+		setup func(f *ssoFlow)
+	}{
+		{"another state", func(f *ssoFlow) {
+			q := f.callback.Query()
+			q.Set("state", "forged")
+			f.callback.RawQuery = q.Encode()
+		}},
+		{"no cookie", func(f *ssoFlow) { f.state = &http.Cookie{Name: "unrelated", Value: "x"} }},
+		{"cookie of another attempt", func(f *ssoFlow) { f.state = startSSO(t, ts, issuer).state }},
+		{"tampered cookie", func(f *ssoFlow) { f.state.Value += "x" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow := startSSO(t, ts, issuer)
+			flow.authorize(t, nil)
+			tt.setup(flow)
+			if e := ssoError(t, flow.finish(t)); !strings.Contains(e, "Sign-in expired") {
+				t.Errorf("sso_error = %q, want a state mismatch", e)
+			}
+		})
+	}
+	if users, _ := ts.Users.List(context.Background()); len(users) != 0 {
+		t.Errorf("a failed sign-in provisioned %d users", len(users))
+	}
+}
+
+func TestOIDCNonceMismatch(t *testing.T) {
+	ts, issuer := newSSOServer(t)
+	flow := startSSO(t, ts, issuer)
+	// The ID token comes back for another nonce, as it would when replayed
+	// from another sign-in.
+	flow.authorize(t, func(q url.Values) { q.Set("nonce", "replayed") })
+	if e := ssoError(t, flow.finish(t)); e != "Sign-in with the identity provider failed" {
+		t.Errorf("sso_error = %q, want the exchange to fail", e)
+	}
+	if users, _ := ts.Users.List(context.Background()); len(users) != 0 {
+		t.Errorf("a failed sign-in provisioned %d users", len(users))
+	}
+}
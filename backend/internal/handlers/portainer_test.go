@@ -0,0 +1,181 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"webtop-launcher/internal/config"
+	"webtop-launcher/internal/docker"
+	"webtop-launcher/internal/docker/dockertest"
+	"webtop-launcher/internal/portainer"
+	"webtop-launcher/internal/portainer/portainertest"
+	"webtop-launcher/internal/secrets"
+)
+
+type portainerStatus struct {
+	Status        string `json:"status"`
+	Message       string `json:"message"`
+	AdminPassword string `json:"adminPassword"`
+}
+
+func TestDeployPortainer(t *testing.T) {
+	dockerSrv, err := dockertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dockerSrv.Close()
+	portainerSrv := portainertest.NewServer()
+	defer portainerSrv.Close()
+
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.DockerHost = dockerSrv.Host
+		cfg.PortainerSelfHostURL = portainerSrv.URL
+	})
+	ts.user("admin", "admin")
+	ts.user("editor", "catalog-editor")
+	token := ts.login("admin")
+
+	if rec := ts.do("POST", "/api/admin/portainer/deploy", ts.login("editor"), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("deploy without portainer:manage: %d, want 403", rec.Code)
+	}
+
+	var status portainerStatus
+	rec := ts.do("GET", "/api/admin/portainer/status", token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: %d %s", rec.Code, rec.Body)
+	}
+	if ts.decode(rec, &status); status.Status != portainer.StateStopped {
+		t.Errorf("status before deploying = %+v, want stopped", status)
+	}
+
+	if rec := ts.do("POST", "/api/admin/portainer/deploy", token, nil); rec.Code != http.StatusAccepted {
+		t.Fatalf("deploy: %d %s", rec.Code, rec.Body)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status = portainerStatus{}
+		ts.decode(ts.do("GET", "/api/admin/portainer/status", token, nil), &status)
+		if status.Status != portainer.StateDeploying {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the deployment did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Status != portainer.StateRunning || status.Message != "" || status.AdminPassword == "" {
+		t.Fatalf("status after deploying = %+v", status)
+	}
+
+	// The admin password is shown once, and stored encrypted.
+	var again portainerStatus
+	if ts.decode(ts.do("GET", "/api/admin/portainer/status", token, nil), &again); again.AdminPassword != "" {
+		t.Error("the admin password was shown twice")
+	}
+	values, err := ts.Settings.Get(context.Background(), "portainer_url", "portainer_api_key", "portainer_admin_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["portainer_url"] != portainerSrv.URL || !portainerSrv.ValidKey(values["portainer_api_key"]) {
+		t.Errorf("stored config = %q", values)
+	}
+	if values["portainer_admin_password"] == status.AdminPassword {
+		t.Error("the admin password is stored in clear")
+	}
+	box, _ := secrets.NewBox(ts.Config.EncryptionKey)
+	if password, err := box.Decrypt(values["portainer_admin_password"]); err != nil || password != status.AdminPassword {
+		t.Errorf("stored admin password decrypts to %q, %v", password, err)
+	}
+}
+
+func TestDeployPortainerFailure(t *testing.T) {
+	dockerSrv, err := dockertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dockerSrv.Close()
+
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.DockerHost = dockerSrv.Host
+		cfg.PortainerSelfHostURL = "http://127.0.0.1:1"
+	})
+	ts.user("admin", "admin")
+	token := ts.login("admin")
+	dockerSrv.OnStart = func(c *docker.Container) {
+		c.State = docker.ContainerState{Status: "exited", ExitCode: 1, Error: "port is already allocated"}
+	}
+
+	if rec := ts.do("POST", "/api/admin/portainer/deploy", token, nil); rec.Code != http.StatusAccepted {
+		t.Fatalf("deploy: %d %s", rec.Code, rec.Body)
+	}
+	var status portainerStatus
+	deadline := time.Now().Add(5 * time.Second)
+	for status.Status == "" || status.Status == portainer.StateDeploying {
+		if time.Now().After(deadline) {
+			t.Fatal("the deployment did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+		ts.decode(ts.do("GET", "/api/admin/portainer/status", token, nil), &status)
+	}
+	if status.Status != portainer.StateError || status.Message == "" {
+		t.Errorf("status after a failed deployment = %+v", status)
+	}
+}
+
+func TestUpdatePortainerConfig(t *testing.T) {
+	first := portainertest.NewServer()
+	defer first.Close()
+	second := portainertest.NewServer()
+	defer second.Close()
+
+	ts := newTestServer(t)
+	ts.user("admin", "admin")
+	token := ts.login("admin")
+	update := func(url, key string) int {
+		t.Helper()
+		return ts.do("PUT", "/api/admin/portainer", token, map[string]string{"url": url, "apiKey": key}).Code
+	}
+	stored := func() map[string]string {
+		t.Helper()
+		values, err := ts.Settings.Get(context.Background(), "portainer_url", "portainer_api_key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return values
+	}
+
+	if code := update(first.URL, portainertest.DefaultAPIKey); code != http.StatusOK {
+		t.Fatalf("saving the config: %d", code)
+	}
+	var cfg struct {
+		URL    string `json:"url"`
+		APIKey string `json:"apiKey"`
+	}
+	ts.decode(ts.do("GET", "/api/admin/portainer", token, nil), &cfg)
+	if cfg.URL != first.URL || cfg.APIKey == portainertest.DefaultAPIKey {
+		t.Fatalf("GET config = %+v, want the key masked", cfg)
+	}
+	// The masked key keeps the stored one for the same instance.
+	if code := update(first.URL, cfg.APIKey); code != http.StatusOK {
+		t.Errorf("saving with the masked key: %d", code)
+	}
+
+	// It is not sent to another instance.
+	for _, key := range []string{cfg.APIKey, ""} {
+		if code := update(second.URL, key); code != http.StatusBadRequest {
+			t.Errorf("changing the URL with the key %q: %d, want 400", key, code)
+		}
+	}
+	if values := stored(); values["portainer_url"] != first.URL || values["portainer_api_key"] != portainertest.DefaultAPIKey {
+		t.Errorf("stored config = %q after refused updates", values)
+	}
+
+	if code := update(second.URL, portainertest.DefaultAPIKey); code != http.StatusOK {
+		t.Errorf("changing the URL with the key entered again: %d", code)
+	}
+	if values := stored(); values["portainer_url"] != second.URL {
+		t.Errorf("stored URL = %q, want the new one", values["portainer_url"])
+	}
+}
@@ -0,0 +1,197 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"webtop-launcher/internal/config"
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/proxy"
+
+	"github.com/google/uuid"
+)
+
+const sessionOrigin = "http://sessions.test"
+
+// serve sends req through the router.
+func (ts *testServer) serve(req *http.Request) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// forward asks /api/auth/forward whether cookie may open the session URI.
+func (ts *testServer) forward(uri string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	req := httptest.NewRequest("GET", "/api/auth/forward", nil)
+	req.Header.Set("X-Forwarded-Uri", uri)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	return ts.serve(req)
+}
+
+// connect returns the URL the launcher opens for a session.
+func (ts *testServer) connect(token, sessionID string) string {
+	ts.t.Helper()
+	rec := ts.do("POST", "/api/sessions/"+sessionID+"/connect", token, nil)
+	if rec.Code != http.StatusOK {
+		ts.t.Fatalf("connect: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		URL string `json:"url"`
+	}
+	ts.decode(rec, &resp)
+	return resp.URL
+}
+
+func TestSessionOrigin(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) { cfg.SessionOrigin = sessionOrigin })
+	ts.user("alice")
+	ts.user("bob")
+	app := ts.app("Webtop", true)
+	alice := ts.login("alice")
+	sess := ts.launch(alice, app.ID, false)
+	path := proxy.Prefix + sess.ID + "/"
+
+	// Each origin serves its own half only.
+	if rec := ts.do("GET", path, alice, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("a session on the launcher's origin: %d, want 404", rec.Code)
+	}
+	req := httptest.NewRequest("GET", sessionOrigin+"/api/auth/me", nil)
+	req.Header.Set("Authorization", "Bearer "+alice)
+	if rec := ts.serve(req); rec.Code != http.StatusNotFound {
+		t.Errorf("the API on the session origin: %d, want 404", rec.Code)
+	}
+
+	if rec := ts.do("POST", "/api/sessions/"+sess.ID+"/connect", ts.login("bob"), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("connecting to another user's session: %d, want 403", rec.Code)
+	}
+	ticketURL := ts.connect(alice, sess.ID)
+	if !strings.HasPrefix(ticketURL, sessionOrigin+path+"?"+proxy.TicketParam+"=") {
+		t.Fatalf("connect URL = %q", ticketURL)
+	}
+
+	rec := ts.serve(httptest.NewRequest("GET", ticketURL, nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != path {
+		t.Fatalf("redeeming the ticket: %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == middleware.SessionCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Path != path || !cookie.HttpOnly {
+		t.Fatalf("session cookie = %+v", cookie)
+	}
+	if rec := ts.serve(httptest.NewRequest("GET", ticketURL, nil)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("redeeming a ticket twice: %d, want 401", rec.Code)
+	}
+
+	rec = ts.forward(path, cookie)
+	if rec.Code != http.StatusOK || rec.Header().Get(proxy.HeaderUser) != "alice" {
+		t.Errorf("forward auth with the session cookie: %d %q", rec.Code, rec.Header().Get(proxy.HeaderUser))
+	}
+	// The cookie opens that session and nothing else.
+	if rec := ts.forward(proxy.Prefix+uuid.New().String()+"/", cookie); rec.Code != http.StatusForbidden {
+		t.Errorf("the session cookie for another session: %d, want 403", rec.Code)
+	}
+	req = httptest.NewRequest("GET", "/api/auth/me", nil)
+	req.AddCookie(&http.Cookie{Name: middleware.TokenCookie, Value: cookie.Value})
+	if rec := ts.serve(req); rec.Code != http.StatusForbidden {
+		t.Errorf("the session token on the API: %d, want 403", rec.Code)
+	}
+	// The launcher's cookie is not accepted on the session origin.
+	if rec := ts.forward(path, &http.Cookie{Name: middleware.TokenCookie, Value: alice}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("forward auth with the launcher's cookie: %d, want 401", rec.Code)
+	}
+
+	// Logging out ends the session cookie with the login.
+	if rec := ts.do("POST", "/api/auth/logout", alice, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("logout: %d", rec.Code)
+	}
+	if rec := ts.forward(path, cookie); rec.Code != http.StatusUnauthorized {
+		t.Errorf("the session cookie after logout: %d, want 401", rec.Code)
+	}
+}
+
+func TestConnectSameOrigin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("alice")
+	app := ts.app("Webtop", true)
+	alice := ts.login("alice")
+	sess := ts.launch(alice, app.ID, false)
+
+	if got := ts.connect(alice, sess.ID); got != proxy.Prefix+sess.ID+"/" {
+		t.Errorf("connect URL = %q, want the path on the launcher's origin", got)
+	}
+	req := httptest.NewRequest("GET", sessionOrigin+"/api/auth/me", nil)
+	req.Header.Set("Authorization", "Bearer "+alice)
+	if rec := ts.serve(req); rec.Code != http.StatusOK {
+		t.Errorf("the API on any host without a session origin: %d", rec.Code)
+	}
+}
+
+func TestForwardAuth(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.user("alice")
+	ts.user("bob")
+	ts.user("admin", "admin")
+	app := ts.app("Webtop", true)
+	aliceToken := ts.login("alice")
+	sess := ts.launch(aliceToken, app.ID, false)
+	uri := proxy.Prefix + sess.ID + "/vnc.html?autoconnect=1"
+	cookie := func(token string) *http.Cookie {
+		return &http.Cookie{Name: middleware.TokenCookie, Value: token}
+	}
+
+	if rec := ts.forward(uri, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a cookie: %d, want 401", rec.Code)
+	}
+	if rec := ts.forward(uri, cookie("not-a-token")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("with an invalid token: %d, want 401", rec.Code)
+	}
+	bob := cookie(ts.login("bob"))
+	if rec := ts.forward(uri, bob); rec.Code != http.StatusForbidden {
+		t.Errorf("another user's session: %d, want 403", rec.Code)
+	}
+	// A missing session looks like a foreign one.
+	if rec := ts.forward(proxy.Prefix+uuid.New().String()+"/", cookie(aliceToken)); rec.Code != http.StatusForbidden {
+		t.Errorf("a missing session: %d, want 403", rec.Code)
+	}
+	if rec := ts.forward("/api/auth/me", cookie(aliceToken)); rec.Code != http.StatusForbidden {
+		t.Errorf("a URI that is not a session's: %d, want 403", rec.Code)
+	}
+
+	rec := ts.forward(uri, cookie(aliceToken))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("the owner: %d %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get(proxy.HeaderUser) != "alice" || rec.Header().Get(proxy.HeaderUserID) != alice.ID || rec.Header().Get(proxy.HeaderSession) != sess.ID {
+		t.Errorf("identity headers = %q", rec.Header())
+	}
+	rec = ts.forward(uri, cookie(ts.login("admin")))
+	if rec.Code != http.StatusOK || rec.Header().Get(proxy.HeaderUser) != "admin" {
+		t.Errorf("a user who may connect to any session: %d %q", rec.Code, rec.Header().Get(proxy.HeaderUser))
+	}
+
+	// API tokens of the owner need the sessions:launch scope.
+	for _, tt := range []struct {
+		scope string
+		want  int
+	}{
+		{"apps:read", http.StatusForbidden},
+		{"sessions:launch", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/api/auth/forward", nil)
+		req.Header.Set("X-Forwarded-Uri", uri)
+		req.Header.Set("Authorization", "Bearer "+ts.apiToken(aliceToken, tt.scope))
+		if rec := ts.serve(req); rec.Code != tt.want {
+			t.Errorf("an API token with %s: %d, want %d", tt.scope, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/rbac"
+	"webtop-launcher/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) RegisterRoleRoutes(router *mux.Router) {
+	router.Handle("", s.allow(rbac.RolesManage, s.GetRoles)).Methods("GET")
+	router.Handle("", s.allow(rbac.RolesManage, s.CreateRole)).Methods("POST")
+	router.Handle("/{id}", s.allow(rbac.RolesManage, s.UpdateRole)).Methods("PUT")
+	router.Handle("/{id}", s.allow(rbac.RolesManage, s.DeleteRole)).Methods("DELETE")
+}
+
+// GetPermissions lists the permissions roles can grant.
+func (s *Server) GetPermissions(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(rbac.All)
+}
+
+func (s *Server) GetRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := s.Roles.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(roles)
+}
+
+// roleRequest is the body of CreateRole and UpdateRole.
+type roleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// decodeRole reads and validates a roleRequest, answering 400 if it is
+// invalid.
+func decodeRole(w http.ResponseWriter, r *http.Request) (*roleRequest, bool) {
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		http.Error(w, "Role name must be 1 to 64 characters", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(req.Description) > 255 {
+		http.Error(w, "Role description must be at most 255 characters", http.StatusBadRequest)
+		return nil, false
+	}
+	if req.Permissions == nil {
+		req.Permissions = []string{}
+	}
+	for _, perm := range req.Permissions {
+		if !rbac.Valid(perm) {
+			http.Error(w, "Unknown permission "+perm, http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	return &req, true
+}
+
+// CreateRole adds a custom role.
+func (s *Server) CreateRole(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRole(w, r)
+	if !ok || !s.mayGrant(w, r, req.Permissions) {
+		return
+	}
+	role := models.Role{Name: req.Name, Description: req.Description, Permissions: req.Permissions}
+	err := s.Roles.Create(r.Context(), &role)
+	if err == store.ErrConflict {
+		http.Error(w, "A role with this name already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+// UpdateRole renames a custom role and replaces its permissions. The users
+// who have it gain and lose permissions with their next request.
+func (s *Server) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeRole(w, r)
+	if !ok {
+		return
+	}
+	role, ok := s.customRole(w, r)
+	if !ok || !s.mayGrant(w, r, append(req.Permissions, role.Permissions...)) {
+		return
+	}
+	role.Name, role.Description, role.Permissions = req.Name, req.Description, req.Permissions
+	err := s.Roles.Update(r.Context(), role)
+	switch err {
+	case nil:
+		json.NewEncoder(w).Encode(role)
+	case store.ErrNotFound:
+		http.Error(w, "Role not found", http.StatusNotFound)
+	case store.ErrConflict:
+		http.Error(w, "A role with this name already exists", http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DeleteRole deletes a custom role, taking it from its users.
+func (s *Server) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	role, ok := s.customRole(w, r)
+	if !ok || !s.mayGrant(w, r, role.Permissions) {
+		return
+	}
+	err := s.Roles.Delete(r.Context(), role.ID)
+	if err == store.ErrNotFound {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// customRole loads the role named by the URL, answering 404 if there is
+// none and 403 if it is built in.
+func (s *Server) customRole(w http.ResponseWriter, r *http.Request) (*models.Role, bool) {
+	role, err := s.Roles.Get(r.Context(), mux.Vars(r)["id"])
+	if err == store.ErrNotFound {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if role.Builtin {
+		http.Error(w, "Built-in roles cannot be changed", http.StatusForbidden)
+		return nil, false
+	}
+	return role, true
+}
+
+// SetUserRoles replaces the roles of a user. The admin role sets is_admin
+// with it.
+func (s *Server) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if !s.mayManage(w, r, id) {
+		return
+	}
+	var granted []string
+	for _, roleID := range req.Roles {
+		role, err := s.Roles.Get(r.Context(), roleID)
+		if err == store.ErrNotFound {
+			http.Error(w, "Role not found", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		granted = append(granted, role.Permissions...)
+	}
+	if !s.mayGrant(w, r, granted) {
+		return
+	}
+
+	err := s.Roles.SetUserRoles(r.Context(), id, req.Roles)
+	switch err {
+	case nil:
+	case store.ErrNotFound:
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	case store.ErrLastAdmin:
+		http.Error(w, "Cannot demote the last admin", http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user, err := s.Users.Get(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeUser(w, r, user)
+}
+
+// userResponse is a user with the IDs of their roles.
+type userResponse struct {
+	models.User
+	Roles []string `json:"roles"`
+}
+
+// writeUser answers with user and their roles.
+func (s *Server) writeUser(w http.ResponseWriter, r *http.Request, user *models.User) {
+	assignments, err := s.Roles.ListAssignments(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(userResponse{User: *user, Roles: roleIDs(assignments, user.ID)})
+}
+
+func roleIDs(assignments map[string][]string, userID string) []string {
+	if ids, ok := assignments[userID]; ok {
+		return ids
+	}
+	return []string{}
+}
+
+// mayGrant answers 403 unless the signed-in user has every one of perms,
+// so that no one hands out permissions they lack.
+func (s *Server) mayGrant(w http.ResponseWriter, r *http.Request, perms []string) bool {
+	have, err := s.Auth.Permissions(r.Context(), middleware.UserID(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	held := map[string]bool{}
+	for _, p := range have {
+		held[p] = true
+	}
+	for _, p := range perms {
+		if !held[p] {
+			http.Error(w, "You cannot grant or manage permissions you do not have", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// mayManage answers 403 unless the signed-in user has every permission of
+// the user id, so that managing users cannot take over a more privileged
+// account.
+func (s *Server) mayManage(w http.ResponseWriter, r *http.Request, id string) bool {
+	perms, err := s.Roles.Permissions(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return s.mayGrant(w, r, perms)
+}
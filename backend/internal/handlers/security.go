@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"webtop-launcher/internal/auth"
+	"webtop-launcher/internal/ldap"
+	"webtop-launcher/internal/rbac"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) RegisterSecurityRoutes(router *mux.Router) {
+	router.Handle("/lockout", s.allow(rbac.SecurityManage, s.GetLockoutPolicy)).Methods("GET")
+	router.Handle("/lockout", s.allow(rbac.SecurityManage, s.UpdateLockoutPolicy)).Methods("PUT")
+	router.Handle("/authenticators", s.allow(rbac.SecurityManage, s.GetAuthChain)).Methods("GET")
+	router.Handle("/authenticators", s.allow(rbac.SecurityManage, s.UpdateAuthChain)).Methods("PUT")
+	router.Handle("/ldap", s.allow(rbac.SecurityManage, s.GetLDAPConfig)).Methods("GET")
+	router.Handle("/ldap", s.allow(rbac.SecurityManage, s.UpdateLDAPConfig)).Methods("PUT")
+	router.Handle("/mfa", s.allow(rbac.SecurityManage, s.GetMFAPolicy)).Methods("GET")
+	router.Handle("/mfa", s.allow(rbac.SecurityManage, s.UpdateMFAPolicy)).Methods("PUT")
+}
+
+// lockoutPolicy is the JSON form of auth.LockoutPolicy.
+type lockoutPolicy struct {
+	Threshold       int `json:"threshold"`
+	DurationMinutes int `json:"durationMinutes"`
+}
+
+func (s *Server) GetLockoutPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, err := auth.LoadLockoutPolicy(r.Context(), s.Settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(lockoutPolicy{
+		Threshold:       policy.Threshold,
+		DurationMinutes: int(policy.Duration / time.Minute),
+	})
+}
+
+// UpdateLockoutPolicy sets how many consecutive failed logins lock an
+// account (0 disables lockout) and for how long.
+func (s *Server) UpdateLockoutPolicy(w http.ResponseWriter, r *http.Request) {
+	var req lockoutPolicy
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy := auth.LockoutPolicy{
+		Threshold: req.Threshold,
+		Duration:  time.Duration(req.DurationMinutes) * time.Minute,
+	}
+	if err := auth.SaveLockoutPolicy(r.Context(), s.Settings, policy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(req)
+}
+
+// mfaPolicy is the JSON form of the two-factor policy.
+type mfaPolicy struct {
+	Required bool `json:"required"`
+}
+
+func (s *Server) GetMFAPolicy(w http.ResponseWriter, r *http.Request) {
+	required, err := auth.LoadMFARequired(r.Context(), s.Settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(mfaPolicy{Required: required})
+}
+
+// UpdateMFAPolicy sets whether users who sign in with a password must use
+// two-factor authentication. Those who have not enrolled yet are asked to
+// at their next login.
+func (s *Server) UpdateMFAPolicy(w http.ResponseWriter, r *http.Request) {
+	var req mfaPolicy
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := auth.SaveMFARequired(r.Context(), s.Settings, req.Required); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(req)
+}
+
+// authChain is the JSON form of the authenticator chain.
+type authChain struct {
+	Chain []string `json:"chain"`
+}
+
+func (s *Server) GetAuthChain(w http.ResponseWriter, r *http.Request) {
+	chain, err := auth.LoadAuthChain(r.Context(), s.Settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(authChain{Chain: chain})
+}
+
+// UpdateAuthChain sets which authenticators check passwords at login, in
+// the order they are asked.
+func (s *Server) UpdateAuthChain(w http.ResponseWriter, r *http.Request) {
+	var req authChain
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := auth.SaveAuthChain(r.Context(), s.Settings, req.Chain); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(req)
+}
+
+// ldapConfig is the JSON form of ldap.Config.
+type ldapConfig struct {
+	URL                string `json:"url"`
+	StartTLS           bool   `json:"startTls"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	BindDN             string `json:"bindDn"`
+	BindPassword       string `json:"bindPassword"`
+	BaseDN             string `json:"baseDn"`
+	UserFilter         string `json:"userFilter"`
+	UsernameAttribute  string `json:"usernameAttribute"`
+	GroupBaseDN        string `json:"groupBaseDn"`
+	GroupFilter        string `json:"groupFilter"`
+	AdminGroup         string `json:"adminGroup"`
+}
+
+func (s *Server) GetLDAPConfig(w http.ResponseWriter, r *http.Request) {
+	cfg, err := auth.LoadLDAPConfig(r.Context(), s.Settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(ldapConfig{
+		URL:                cfg.URL,
+		StartTLS:           cfg.StartTLS,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		BindDN:             cfg.BindDN,
+		BindPassword:       maskAPIKey(cfg.BindPassword),
+		BaseDN:             cfg.BaseDN,
+		UserFilter:         cfg.UserFilter,
+		UsernameAttribute:  cfg.UsernameAttribute,
+		GroupBaseDN:        cfg.GroupBaseDN,
+		GroupFilter:        cfg.GroupFilter,
+		AdminGroup:         cfg.AdminGroup,
+	})
+}
+
+// UpdateLDAPConfig saves the LDAP directory after checking that the service
+// account can bind. Sending back the masked bind password (or an empty one)
+// keeps the stored password, unless the URL or bind DN changes or TLS is
+// weakened.
+func (s *Server) UpdateLDAPConfig(w http.ResponseWriter, r *http.Request) {
+	var req ldapConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	current, err := auth.LoadLDAPConfig(r.Context(), s.Settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.BindPassword == "" || req.BindPassword == maskAPIKey(current.BindPassword) {
+		// The stored password only goes where it went before, the way it
+		// went before.
+		if current.BindPassword != "" && (req.URL != current.URL || req.BindDN != current.BindDN ||
+			(current.StartTLS && !req.StartTLS) || (req.InsecureSkipVerify && !current.InsecureSkipVerify)) {
+			http.Error(w, "Enter the bind password again to change the URL, bind DN or TLS settings", http.StatusBadRequest)
+			return
+		}
+		req.BindPassword = current.BindPassword
+	}
+
+	cfg := &ldap.Config{
+		URL:                req.URL,
+		StartTLS:           req.StartTLS,
+		InsecureSkipVerify: req.InsecureSkipVerify,
+		BindDN:             req.BindDN,
+		BindPassword:       req.BindPassword,
+		BaseDN:             req.BaseDN,
+		UserFilter:         req.UserFilter,
+		UsernameAttribute:  req.UsernameAttribute,
+		GroupBaseDN:        req.GroupBaseDN,
+		GroupFilter:        req.GroupFilter,
+		AdminGroup:         req.AdminGroup,
+		Dial:               s.LDAPDial,
+	}
+	if err := cfg.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ldap.Check(cfg); err != nil {
+		http.Error(w, "Could not reach the directory: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := auth.SaveLDAPConfig(r.Context(), s.Settings, cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.BindPassword = maskAPIKey(req.BindPassword)
+	json.NewEncoder(w).Encode(req)
+}
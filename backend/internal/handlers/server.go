@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"webtop-launcher/internal/auth"
+	"webtop-launcher/internal/config"
+	"webtop-launcher/internal/ldap"
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/oidc"
+	"webtop-launcher/internal/orchestrator"
+	"webtop-launcher/internal/password"
+	"webtop-launcher/internal/proxy"
+	"webtop-launcher/internal/rbac"
+	"webtop-launcher/internal/secrets"
+	"webtop-launcher/internal/security"
+	"webtop-launcher/internal/store"
+	"webtop-launcher/internal/traefik"
+	"webtop-launcher/internal/webauthn"
+
+	"github.com/gorilla/mux"
+)
+
+// Server holds everything the HTTP API depends on. main builds it from the
+// configuration and the SQL stores; tests can build one from
+// store.NewMemory and orchestratortest.Fake and drive Router with httptest.
+type Server struct {
+	*store.Stores
+	Config    *config.Config
+	Auth      *middleware.Auth
+	Passwords *password.Policy
+	// Throttle and ClientIPs back the login brute-force protection.
+	Throttle  *security.Throttle
+	ClientIPs *security.ClientIPResolver
+	// Ceremonies makes each WebAuthn ceremony state single-use, and
+	// Tickets each ticket to a session.
+	Ceremonies *security.OneTime
+	Tickets    *security.OneTime
+	// OIDC is the single sign-on provider, nil unless configured.
+	OIDC *oidc.Provider
+	// Secrets encrypts the TOTP secrets.
+	Secrets *secrets.Box
+	// LDAPDial connects to the LDAP directory; nil dials it over the
+	// network.
+	LDAPDial ldap.Dialer
+	// NewOrchestrator builds the orchestrator driver used for session
+	// stacks.
+	NewOrchestrator func(ctx context.Context, driver string) (orchestrator.Orchestrator, error)
+	Traefik         *traefik.Provider
+
+	// portainerDeploy is the self-hosted Portainer deployment running in
+	// the background, or the outcome of the last one.
+	portainerDeploy portainerDeployment
+}
+
+// NewServer returns a Server with the default orchestrator drivers, the
+// configured password policy and, if configured, single sign-on.
+func NewServer(cfg *config.Config, stores *store.Stores) (*Server, error) {
+	passwords, err := password.NewPolicy(cfg.PasswordMinLength, cfg.PasswordRequire, cfg.PasswordBlocklistFile)
+	if err != nil {
+		return nil, err
+	}
+	clientIPs, err := security.NewClientIPResolver(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	if cfg.EncryptionKey == cfg.JWTSecret {
+		return nil, errors.New("ENCRYPTION_KEY must differ from JWT_SECRET")
+	}
+	box, err := secrets.NewBox(cfg.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("ENCRYPTION_KEY: %w", err)
+	}
+	traefikProvider, err := traefik.NewProvider(stores.Sessions, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var sso *oidc.Provider
+	if cfg.OIDCIssuerURL != "" {
+		sso, err = oidc.NewProvider(oidc.Config{
+			IssuerURL:     cfg.OIDCIssuerURL,
+			ClientID:      cfg.OIDCClientID,
+			ClientSecret:  cfg.OIDCClientSecret,
+			RedirectURL:   cfg.OIDCRedirectURL,
+			Scopes:        cfg.OIDCScopes,
+			UsernameClaim: cfg.OIDCUsernameClaim,
+			GroupsClaim:   cfg.OIDCGroupsClaim,
+			AdminGroup:    cfg.OIDCAdminGroup,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	s := &Server{
+		Stores:     stores,
+		Config:     cfg,
+		Auth:       middleware.NewAuth([]byte(cfg.JWTSecret), stores.Users, stores.Tokens, stores.Roles, stores.APITokens),
+		Passwords:  passwords,
+		Throttle:   security.NewThrottle(),
+		ClientIPs:  clientIPs,
+		Ceremonies: security.NewOneTime(),
+		Tickets:    security.NewOneTime(),
+		OIDC:       sso,
+		Secrets:    box,
+		Traefik:    traefikProvider,
+	}
+	s.NewOrchestrator = func(ctx context.Context, driver string) (orchestrator.Orchestrator, error) {
+		return orchestrator.New(ctx, s.Settings, driver, s.Config.DockerHost)
+	}
+	return s, nil
+}
+
+// Router returns the complete HTTP API.
+func (s *Server) Router() http.Handler {
+	r := mux.NewRouter().StrictSlash(true)
+
+	// Simple CORS for demo/homelab usage
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	// Session reverse proxy: /s/{sessionId}/ is forwarded to the session's
+	// container, for its owner or users allowed to connect to any session.
+	// With a session origin, that origin serves nothing else, and the
+	// launcher's origin no sessions.
+	sessionProxy := &proxy.Proxy{
+		Sessions: s.Sessions,
+		Users:    s.Users,
+		Auth:     s.Auth,
+		NewOrchestrator: func(ctx context.Context, driver string) (orchestrator.Orchestrator, error) {
+			return s.NewOrchestrator(ctx, driver)
+		},
+		Origin:  s.Config.SessionOrigin,
+		Tickets: s.Tickets,
+	}
+	r.PathPrefix(proxy.Prefix).MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+		return sessionProxy.Origin == "" || sessionProxy.OnOrigin(r)
+	}).Handler(sessionProxy)
+
+	// API routes
+	api := r.PathPrefix("/api").MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+		return !sessionProxy.OnOrigin(r)
+	}).Subrouter()
+
+	// Authentication routes
+	authRouter := api.PathPrefix("/auth").Subrouter()
+	authHandler := &auth.Handler{
+		Users:       s.Users,
+		Settings:    s.Settings,
+		Tokens:      s.Tokens,
+		Auth:        s.Auth,
+		Passwords:   s.Passwords,
+		Throttle:    s.Throttle,
+		ClientIPs:   s.ClientIPs,
+		OIDC:        s.OIDC,
+		Secrets:     s.Secrets,
+		Credentials: s.Credentials,
+		WebAuthn: webauthn.Config{
+			RPID:    s.Config.WebAuthnRPID,
+			RPName:  s.Config.WebAuthnRPName,
+			Origins: s.Config.WebAuthnOrigins,
+		},
+		Ceremonies: s.Ceremonies,
+		LDAPDial:   s.LDAPDial,
+	}
+	authHandler.RegisterAuthRoutes(authRouter)
+
+	// Admin routes: a valid token, and the permission each route requires
+	adminRouter := api.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(s.Auth.AuthMiddleware)
+
+	// User management routes
+	userRouter := adminRouter.PathPrefix("/users").Subrouter()
+	s.RegisterUserRoutes(userRouter)
+
+	// Application management routes
+	appRouter := adminRouter.PathPrefix("/apps").Subrouter()
+	s.RegisterAppRoutes(appRouter)
+
+	// Roles and the permissions they grant
+	roleRouter := adminRouter.PathPrefix("/roles").Subrouter()
+	s.RegisterRoleRoutes(roleRouter)
+	adminRouter.Handle("/permissions", s.allow(rbac.RolesManage, s.GetPermissions)).Methods("GET")
+
+	// User groups and the applications they are entitled to
+	groupRouter := adminRouter.PathPrefix("/groups").Subrouter()
+	s.RegisterGroupRoutes(groupRouter)
+
+	// Login protection settings
+	securityRouter := adminRouter.PathPrefix("/security").Subrouter()
+	s.RegisterSecurityRoutes(securityRouter)
+
+	// Portainer management routes
+	portainerRouter := adminRouter.PathPrefix("/portainer").Subrouter()
+	s.RegisterPortainerRoutes(portainerRouter)
+
+	// Session management routes (some are protected, some are not)
+	sessionRouter := api.PathPrefix("/sessions").Subrouter()
+	s.RegisterSessionRoutes(sessionRouter, adminRouter)
+
+	// The signed-in user's API tokens
+	tokenRouter := api.PathPrefix("/tokens").Subrouter()
+	s.RegisterTokenRoutes(tokenRouter)
+
+	// Public-facing application list route: the apps the user may launch
+	appsListRouter := api.PathPrefix("/apps").Subrouter()
+	appsListRouter.Use(s.Auth.AuthMiddleware)
+	appsListRouter.Handle("", s.allow(rbac.AppsRead, s.GetAvailableApps)).Methods("GET")
+
+	// Traefik dynamic configuration for the HTTP provider.
+	api.Handle("/traefik/config", s.Traefik).Methods("GET")
+
+	// The URL, and on a session origin the ticket, to open a session with.
+	// Not for API tokens: the session cookie ends with the login.
+	sessionRouter.Handle("/{id}/connect", s.Auth.LoginMiddleware(s.allow(rbac.SessionsLaunch, sessionProxy.Connect))).Methods("POST")
+
+	// The same check for proxies in front of the sessions (nginx
+	// auth_request, Traefik ForwardAuth). Any method, as proxies forward the
+	// original one.
+	authRouter.HandleFunc("/forward", sessionProxy.ForwardAuth)
+
+	return r
+}
+
+// allow serves h to users whose roles grant perm. Routes using it must be
+// behind AuthMiddleware.
+func (s *Server) allow(perm string, h http.HandlerFunc) http.Handler {
+	return s.Auth.Require(perm)(h)
+}
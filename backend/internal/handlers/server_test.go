@@ -0,0 +1,148 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"webtop-launcher/internal/config"
+	"webtop-launcher/internal/handlers"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/orchestrator"
+	"webtop-launcher/internal/orchestrator/orchestratortest"
+	"webtop-launcher/internal/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct-horse-battery"
+
+// testServer is a Server on the memory stores and a fake orchestrator,
+// driven through its Router.
+type testServer struct {
+	*handlers.Server
+	t            *testing.T
+	handler      http.Handler
+	Orchestrator *orchestratortest.Fake
+}
+
+func newTestServer(t *testing.T, configure ...func(*config.Config)) *testServer {
+	t.Helper()
+	cfg := &config.Config{
+		JWTSecret:         "test-signing-key-test-signing-key",
+		EncryptionKey:     "test-encryption-key-test-encryption",
+		PasswordMinLength: 8,
+	}
+	for _, f := range configure {
+		f(cfg)
+	}
+	s, err := handlers.NewServer(cfg, store.NewMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := orchestratortest.New()
+	s.NewOrchestrator = func(ctx context.Context, driver string) (orchestrator.Orchestrator, error) {
+		return fake, nil
+	}
+	return &testServer{Server: s, t: t, handler: s.Router(), Orchestrator: fake}
+}
+
+// user creates a local user with testPassword and the given roles, on top
+// of the user role every user gets.
+func (ts *testServer) user(username string, roles ...string) *models.User {
+	ts.t.Helper()
+	ctx := context.Background()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	user := &models.User{Username: username, PasswordHash: string(hash)}
+	for _, role := range roles {
+		if role == "admin" {
+			user.IsAdmin = true
+		}
+	}
+	if err := ts.Users.Create(ctx, user); err != nil {
+		ts.t.Fatal(err)
+	}
+	if len(roles) > 0 {
+		all, err := ts.Roles.List(ctx)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		ids := []string{}
+		for _, role := range all {
+			if role.Name == "user" {
+				ids = append(ids, role.ID)
+			}
+			for _, name := range roles {
+				if role.Name == name {
+					ids = append(ids, role.ID)
+				}
+			}
+		}
+		if err := ts.Roles.SetUserRoles(ctx, user.ID, ids); err != nil {
+			ts.t.Fatal(err)
+		}
+	}
+	return user
+}
+
+// login signs username in with testPassword and returns the access token.
+func (ts *testServer) login(username string) string {
+	ts.t.Helper()
+	rec := ts.do("POST", "/api/auth/login", "", map[string]string{"username": username, "password": testPassword})
+	if rec.Code != http.StatusOK {
+		ts.t.Fatalf("login %s: %d %s", username, rec.Code, rec.Body)
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	ts.decode(rec, &resp)
+	return resp.Token
+}
+
+// apiToken issues an API token with scopes to the user of token.
+func (ts *testServer) apiToken(token string, scopes ...string) string {
+	ts.t.Helper()
+	rec := ts.do("POST", "/api/tokens", token, map[string]interface{}{"name": "test", "scopes": scopes})
+	if rec.Code != http.StatusCreated {
+		ts.t.Fatalf("creating an API token: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	ts.decode(rec, &resp)
+	return resp.Token
+}
+
+// do sends a request with an optional bearer token and JSON body.
+func (ts *testServer) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	ts.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			ts.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func (ts *testServer) decode(rec *httptest.ResponseRecorder, v interface{}) {
+	ts.t.Helper()
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		ts.t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+}
@@ -0,0 +1,236 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"webtop-launcher/internal/models"
+)
+
+const testCompose = `services:
+  webtop:
+    image: lscr.io/linuxserver/webtop:latest
+    volumes:
+      - data:/config
+volumes:
+  data: {}
+`
+
+// app adds an application to the catalogue.
+func (ts *testServer) app(name string, enabled bool) *models.Application {
+	ts.t.Helper()
+	app := &models.Application{Name: name, DockerCompose: testCompose, IsEnabled: enabled}
+	if err := ts.Apps.Create(context.Background(), app); err != nil {
+		ts.t.Fatal(err)
+	}
+	return app
+}
+
+type session struct {
+	ID            string `json:"id"`
+	UserID        string `json:"userId"`
+	ApplicationID string `json:"applicationId"`
+	Persistent    bool   `json:"persistent"`
+}
+
+// launch starts a session of app and returns it.
+func (ts *testServer) launch(token, appID string, persistent bool) session {
+	ts.t.Helper()
+	rec := ts.do("POST", "/api/sessions/launch", token, map[string]interface{}{"applicationId": appID, "isPersistent": persistent})
+	if rec.Code != http.StatusCreated {
+		ts.t.Fatalf("launch: %d %s", rec.Code, rec.Body)
+	}
+	var sess session
+	ts.decode(rec, &sess)
+	return sess
+}
+
+func TestLaunchAndStopSession(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.user("alice")
+	app := ts.app("Webtop", true)
+	token := ts.login("alice")
+
+	sess := ts.launch(token, app.ID, false)
+	if sess.ID == "" || sess.UserID != alice.ID || sess.ApplicationID != app.ID {
+		t.Errorf("launched session = %+v", sess)
+	}
+	spec, ok := ts.Orchestrator.Spec("alice-" + sess.ID)
+	if !ok {
+		t.Fatal("no stack was deployed for the session")
+	}
+	if spec.Env["SESSION_ID"] != sess.ID || spec.Env["USERNAME"] != "alice" {
+		t.Errorf("stack env = %q", spec.Env)
+	}
+	stored, err := ts.Sessions.Get(context.Background(), sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.TargetURL != "http://10.0.0.2:3000" {
+		t.Errorf("TargetURL = %q", stored.TargetURL)
+	}
+
+	var sessions []session
+	ts.decode(ts.do("GET", "/api/sessions", token, nil), &sessions)
+	if len(sessions) != 1 || sessions[0].ID != sess.ID {
+		t.Errorf("GET /api/sessions = %+v", sessions)
+	}
+
+	if rec := ts.do("POST", "/api/sessions/"+sess.ID+"/stop", token, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("stop: %d %s", rec.Code, rec.Body)
+	}
+	if ts.Orchestrator.StackCount() != 0 || ts.Orchestrator.VolumeCount() != 0 {
+		t.Error("stopping a session left its stack or volumes behind")
+	}
+	if _, err := ts.Sessions.Get(context.Background(), sess.ID); err == nil {
+		t.Error("the stopped session is still recorded")
+	}
+	if rec := ts.do("POST", "/api/sessions/"+sess.ID+"/stop", token, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("stopping a stopped session: %d, want 404", rec.Code)
+	}
+}
+
+func TestStopPersistentSession(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.user("alice")
+	app := ts.app("Webtop", true)
+	token := ts.login("alice")
+
+	sess := ts.launch(token, app.ID, true)
+	if !sess.Persistent {
+		t.Errorf("launched session = %+v, want persistent", sess)
+	}
+	if rec := ts.do("POST", "/api/sessions/"+sess.ID+"/stop", token, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("stop: %d %s", rec.Code, rec.Body)
+	}
+	// Only the /config volume is kept; the session's own volumes would
+	// never be used again.
+	if ts.Orchestrator.StackCount() != 0 || ts.Orchestrator.VolumeCount() != 1 || !ts.Orchestrator.HasVolume("webtop-"+alice.ID+"-webtop-config") {
+		t.Error("stopping a persistent session must keep its /config volume only")
+	}
+}
+
+func TestLaunchSessionChecks(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	alice := ts.user("alice")
+	ts.user("bob")
+	token := ts.login("alice")
+	enabled := ts.app("Webtop", true)
+	disabled := ts.app("Disabled", false)
+	restricted := ts.app("Restricted", true)
+
+	group := &models.Group{Name: "Engineering"}
+	if err := ts.Groups.Create(ctx, group); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Groups.SetApplications(ctx, group.ID, []string{restricted.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		body  map[string]interface{}
+		want  int
+	}{
+		{"no token", "", map[string]interface{}{"applicationId": enabled.ID}, http.StatusUnauthorized},
+		{"no application", token, map[string]interface{}{}, http.StatusBadRequest},
+		{"unknown application", token, map[string]interface{}{"applicationId": "missing"}, http.StatusNotFound},
+		{"disabled application", token, map[string]interface{}{"applicationId": disabled.ID}, http.StatusForbidden},
+		{"not in the group", token, map[string]interface{}{"applicationId": restricted.ID}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if rec := ts.do("POST", "/api/sessions/launch", tt.token, tt.body); rec.Code != tt.want {
+			t.Errorf("%s: %d %s, want %d", tt.name, rec.Code, rec.Body, tt.want)
+		}
+	}
+	if ts.Orchestrator.StackCount() != 0 {
+		t.Error("a refused launch deployed a stack")
+	}
+
+	if err := ts.Groups.AddMember(ctx, group.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	ts.launch(token, restricted.ID, false)
+
+	// A failed deployment records no session.
+	ts.Orchestrator.DeployErr = errors.New("no capacity")
+	if rec := ts.do("POST", "/api/sessions/launch", ts.login("bob"), map[string]interface{}{"applicationId": enabled.ID}); rec.Code != http.StatusBadGateway {
+		t.Errorf("launch with a failing orchestrator: %d, want 502", rec.Code)
+	}
+	if all, _ := ts.Sessions.List(ctx); len(all) != 1 {
+		t.Errorf("%d sessions recorded, want only alice's", len(all))
+	}
+}
+
+func TestStopSessionOwnership(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("alice")
+	ts.user("bob")
+	ts.user("olivia", "operator")
+	app := ts.app("Webtop", true)
+	sess := ts.launch(ts.login("alice"), app.ID, false)
+
+	if rec := ts.do("POST", "/api/sessions/"+sess.ID+"/stop", ts.login("bob"), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("another user stopping the session: %d, want 403", rec.Code)
+	}
+	if ts.Orchestrator.StackCount() != 1 {
+		t.Fatal("a refused stop removed the stack")
+	}
+	var bobs []session
+	ts.decode(ts.do("GET", "/api/sessions", ts.login("bob"), nil), &bobs)
+	if len(bobs) != 0 {
+		t.Errorf("bob sees alice's sessions: %+v", bobs)
+	}
+
+	// Operators may stop anyone's session.
+	if rec := ts.do("POST", "/api/sessions/"+sess.ID+"/stop", ts.login("olivia"), nil); rec.Code != http.StatusNoContent {
+		t.Errorf("an operator stopping the session: %d %s, want 204", rec.Code, rec.Body)
+	}
+	if ts.Orchestrator.StackCount() != 0 {
+		t.Error("the operator's stop left the stack behind")
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("admin", "admin")
+	ts.user("olivia", "operator")
+	ts.user("eddie", "catalog-editor")
+	ts.user("alice")
+	tokens := map[string]string{}
+	for _, name := range []string{"admin", "olivia", "eddie", "alice"} {
+		tokens[name] = ts.login(name)
+	}
+
+	tests := []struct {
+		method, path string
+		allowed      []string
+	}{
+		{"GET", "/api/admin/users", []string{"admin"}},
+		{"GET", "/api/admin/roles", []string{"admin"}},
+		{"GET", "/api/admin/groups", []string{"admin"}},
+		{"GET", "/api/admin/sessions", []string{"admin", "olivia"}},
+		{"GET", "/api/admin/apps", []string{"admin", "eddie"}},
+		{"GET", "/api/admin/portainer", []string{"admin"}},
+	}
+	for _, tt := range tests {
+		if rec := ts.do(tt.method, tt.path, "", nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token: %d, want 401", tt.method, tt.path, rec.Code)
+		}
+		for name, token := range tokens {
+			want := http.StatusForbidden
+			for _, allowed := range tt.allowed {
+				if name == allowed {
+					want = http.StatusOK
+				}
+			}
+			if rec := ts.do(tt.method, tt.path, token, nil); rec.Code != want {
+				t.Errorf("%s %s as %s: %d, want %d", tt.method, tt.path, name, rec.Code, want)
+			}
+		}
+	}
+}
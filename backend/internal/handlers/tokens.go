@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/rbac"
+	"webtop-launcher/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+// RegisterTokenRoutes registers the routes where users manage their own
+// API tokens. An API token cannot be used to manage API tokens.
+func (s *Server) RegisterTokenRoutes(router *mux.Router) {
+	router.Use(s.Auth.LoginMiddleware)
+	router.HandleFunc("", s.GetTokens).Methods("GET")
+	router.HandleFunc("", s.CreateToken).Methods("POST")
+	router.HandleFunc("/{id}", s.DeleteToken).Methods("DELETE")
+}
+
+func (s *Server) GetTokens(w http.ResponseWriter, r *http.Request) {
+	s.listTokens(w, r, middleware.UserID(r.Context()))
+}
+
+// CreateToken issues an API token to the signed-in user. It is returned in
+// the response and nowhere else.
+func (s *Server) CreateToken(w http.ResponseWriter, r *http.Request) {
+	s.createToken(w, r, middleware.UserID(r.Context()))
+}
+
+func (s *Server) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	s.deleteToken(w, r, middleware.UserID(r.Context()), mux.Vars(r)["id"])
+}
+
+// GetUserTokens lists the API tokens of a user.
+func (s *Server) GetUserTokens(w http.ResponseWriter, r *http.Request) {
+	s.listTokens(w, r, mux.Vars(r)["id"])
+}
+
+// CreateUserToken issues an API token to a service account. Users create
+// their own.
+func (s *Server) CreateUserToken(w http.ResponseWriter, r *http.Request) {
+	if !noAPIToken(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if !s.mayManage(w, r, id) {
+		return
+	}
+	user, err := s.Users.Get(r.Context(), id)
+	if err == store.ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user.AuthProvider != models.AuthService {
+		http.Error(w, "API tokens can only be issued to service accounts", http.StatusBadRequest)
+		return
+	}
+	s.createToken(w, r, id)
+}
+
+// DeleteUserToken revokes an API token of a user.
+func (s *Server) DeleteUserToken(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if !noAPIToken(w, r) || !s.mayManage(w, r, vars["id"]) {
+		return
+	}
+	s.deleteToken(w, r, vars["id"], vars["tokenId"])
+}
+
+func (s *Server) listTokens(w http.ResponseWriter, r *http.Request, userID string) {
+	tokens, err := s.APITokens.ListByUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// createToken issues an API token to the user userID. Its scopes must be
+// permissions the signed-in user has; it is only ever granted those its
+// user's roles grant too.
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request, userID string) {
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		// ExpiresAt is optional; without it the token does not expire.
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		http.Error(w, "Token name must be 1 to 64 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "A token needs at least one scope", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !rbac.Valid(scope) {
+			http.Error(w, "Unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "Expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+	if !s.mayGrant(w, r, req.Scopes) {
+		return
+	}
+
+	tokenString, hash := middleware.NewAPIToken()
+	token := models.APIToken{
+		UserID:    userID,
+		Name:      req.Name,
+		TokenHash: hash,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	err := s.APITokens.Create(r.Context(), &token)
+	if err == store.ErrNotFound {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		models.APIToken
+		Token string `json:"token"`
+	}{token, tokenString})
+}
+
+func (s *Server) deleteToken(w http.ResponseWriter, r *http.Request, userID, id string) {
+	err := s.APITokens.Delete(r.Context(), userID, id)
+	if err == store.ErrNotFound {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// noAPIToken answers 403 if the request was authenticated with an API
+// token, so that tokens cannot mint or revoke others.
+func noAPIToken(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := middleware.APITokenFromContext(r.Context()); ok {
+		http.Error(w, "API tokens are not accepted here", http.StatusForbidden)
+		return false
+	}
+	return true
+}
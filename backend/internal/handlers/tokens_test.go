@@ -0,0 +1,133 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+)
+
+func TestAPITokenScopes(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("admin", "admin")
+	ts.user("bob")
+	token := ts.apiToken(ts.login("admin"), "apps:read")
+
+	if rec := ts.do("GET", "/api/apps", token, nil); rec.Code != http.StatusOK {
+		t.Errorf("a token within its scopes: %d", rec.Code)
+	}
+	if rec := ts.do("GET", "/api/admin/users", token, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("a token outside its scopes: %d, want 403", rec.Code)
+	}
+	// Tokens do not mint tokens, even with the scopes.
+	if rec := ts.do("POST", "/api/tokens", token, map[string]interface{}{"name": "more", "scopes": []string{"apps:read"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("an API token creating another: %d, want 403", rec.Code)
+	}
+
+	// A user can only grant what their roles grant them.
+	bobToken := ts.login("bob")
+	if rec := ts.do("POST", "/api/tokens", bobToken, map[string]interface{}{"name": "admin", "scopes": []string{"users:manage"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("granting a scope the user lacks: %d, want 403", rec.Code)
+	}
+	if rec := ts.do("POST", "/api/tokens", bobToken, map[string]interface{}{"name": "mixed", "scopes": []string{"apps:read", "users:manage"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("granting a held and a lacking scope: %d, want 403", rec.Code)
+	}
+	for _, tt := range []struct {
+		name string
+		body map[string]interface{}
+	}{
+		{"no scopes", map[string]interface{}{"name": "none", "scopes": []string{}}},
+		{"an unknown scope", map[string]interface{}{"name": "unknown", "scopes": []string{"everything"}}},
+		{"no name", map[string]interface{}{"name": " ", "scopes": []string{"apps:read"}}},
+		{"a past expiry", map[string]interface{}{"name": "old", "scopes": []string{"apps:read"}, "expiresAt": time.Now().Add(-time.Hour)}},
+	} {
+		if rec := ts.do("POST", "/api/tokens", bobToken, tt.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("a token with %s: %d, want 400", tt.name, rec.Code)
+		}
+	}
+}
+
+func TestAPITokenRoleChange(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("admin", "admin")
+	carol := ts.user("carol", "admin")
+	token := ts.apiToken(ts.login("carol"), "users:manage")
+	if rec := ts.do("GET", "/api/admin/users", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("a token within its scopes: %d", rec.Code)
+	}
+
+	// The token only keeps the scopes its user's roles still grant.
+	if rec := ts.do("PUT", "/api/admin/users/"+carol.ID+"/roles", ts.login("admin"), map[string][]string{"roleIds": {ts.roleID("user")}}); rec.Code != http.StatusOK {
+		t.Fatalf("demoting carol: %d %s", rec.Code, rec.Body)
+	}
+	if rec := ts.do("GET", "/api/admin/users", token, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("a scope the user lost: %d, want 403", rec.Code)
+	}
+}
+
+func TestAPITokenExpiryAndRevocation(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.user("alice")
+	login := ts.login("alice")
+
+	plain, hash := middleware.NewAPIToken()
+	expired := time.Now().Add(-time.Minute)
+	if err := ts.APITokens.Create(context.Background(), &models.APIToken{
+		UserID: alice.ID, Name: "expired", TokenHash: hash, Scopes: []string{"apps:read"}, ExpiresAt: &expired,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := ts.do("GET", "/api/apps", plain, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("an expired token: %d, want 401", rec.Code)
+	}
+
+	rec := ts.do("POST", "/api/tokens", login, map[string]interface{}{"name": "ci", "scopes": []string{"apps:read"}})
+	var created struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	ts.decode(rec, &created)
+	if rec := ts.do("GET", "/api/apps", created.Token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("a new token: %d", rec.Code)
+	}
+	if rec := ts.do("DELETE", "/api/tokens/"+created.ID, login, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("revoking the token: %d", rec.Code)
+	}
+	if rec := ts.do("GET", "/api/apps", created.Token, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a revoked token: %d, want 401", rec.Code)
+	}
+	if rec := ts.do("DELETE", "/api/tokens/"+created.ID, login, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("revoking it again: %d, want 404", rec.Code)
+	}
+}
+
+func TestAPITokenShownOnce(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.user("alice")
+	login := ts.login("alice")
+	token := ts.apiToken(login, "apps:read")
+	if token == "" {
+		t.Fatal("the created token was not returned")
+	}
+
+	rec := ts.do("GET", "/api/tokens", login, nil)
+	if strings.Contains(rec.Body.String(), token) {
+		t.Error("listing tokens returns the token")
+	}
+	var listed []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0]["token"] != nil || listed[0]["tokenHash"] != nil {
+		t.Errorf("listed tokens = %v, want one without the token or its hash", listed)
+	}
+	stored, err := ts.APITokens.ListByUser(context.Background(), alice.ID)
+	if err != nil || len(stored) != 1 || stored[0].TokenHash == token || strings.Contains(stored[0].TokenHash, token) {
+		t.Errorf("stored tokens = %+v, %v, want only the hash", stored, err)
+	}
+}
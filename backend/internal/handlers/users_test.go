@@ -0,0 +1,119 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"webtop-launcher/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// roleID returns the ID of the built-in role name.
+func (ts *testServer) roleID(name string) string {
+	ts.t.Helper()
+	roles, err := ts.Roles.List(context.Background())
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			return role.ID
+		}
+	}
+	ts.t.Fatalf("no role %q", name)
+	return ""
+}
+
+func TestLastAdmin(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	root := ts.user("root", "admin")
+	token := ts.login("root")
+	stored := func() *models.User {
+		t.Helper()
+		u, err := ts.Users.Get(ctx, root.ID)
+		if err != nil {
+			t.Fatalf("the last admin is gone: %v", err)
+		}
+		return u
+	}
+
+	if rec := ts.do("PUT", "/api/admin/users/"+root.ID, token, map[string]interface{}{"username": "renamed", "isAdmin": false}); rec.Code != http.StatusConflict {
+		t.Errorf("demoting the last admin: %d, want 409", rec.Code)
+	}
+	if u := stored(); !u.IsAdmin || u.Username != "root" {
+		t.Errorf("after a refused demotion: %+v", u)
+	}
+	if rec := ts.do("PUT", "/api/admin/users/"+root.ID+"/roles", token, map[string]interface{}{"roles": []string{ts.roleID("user")}}); rec.Code != http.StatusConflict {
+		t.Errorf("taking the admin role from the last admin: %d, want 409", rec.Code)
+	}
+	if can, err := ts.Auth.Can(ctx, root.ID, "users:manage"); err != nil || !can {
+		t.Errorf("the last admin lost their permissions: %v, %v", can, err)
+	}
+	if rec := ts.do("DELETE", "/api/admin/users/"+root.ID, token, nil); rec.Code != http.StatusConflict {
+		t.Errorf("deleting the last admin: %d, want 409", rec.Code)
+	}
+	stored()
+
+	// With a second admin, either may go.
+	ts.user("backup", "admin")
+	if rec := ts.do("PUT", "/api/admin/users/"+root.ID, token, map[string]interface{}{"isAdmin": false}); rec.Code != http.StatusOK {
+		t.Errorf("demoting an admin who is not the last: %d %s", rec.Code, rec.Body)
+	}
+	if stored().IsAdmin {
+		t.Error("the demotion was not saved")
+	}
+}
+
+func TestLockoutAndUnlock(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	alice := ts.user("alice")
+	ts.user("bob")
+	ts.user("admin", "admin")
+	admin := ts.login("admin")
+	// Keep the throttle out of the way of the account lockout.
+	ts.Throttle.Free = 100
+
+	if rec := ts.do("PUT", "/api/admin/security/lockout", admin, map[string]int{"threshold": 3, "durationMinutes": 15}); rec.Code != http.StatusOK {
+		t.Fatalf("setting the lockout policy: %d %s", rec.Code, rec.Body)
+	}
+	wrong := map[string]string{"username": "alice", "password": "wrong-password"}
+	for i := 1; i <= 3; i++ {
+		if rec := ts.do("POST", "/api/auth/login", "", wrong); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d: %d", i, rec.Code)
+		}
+		u, err := ts.Users.Get(ctx, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked := u.LockedUntil != nil; locked != (i == 3) {
+			t.Errorf("after %d wrong passwords: locked %v", i, locked)
+		}
+	}
+	rec := ts.do("POST", "/api/auth/login", "", map[string]string{"username": "alice", "password": testPassword})
+	if rec.Code != http.StatusLocked || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("the right password on a locked account: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	if rec := ts.do("POST", "/api/admin/users/"+alice.ID+"/unlock", ts.login("bob"), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("unlocking without users:manage: %d, want 403", rec.Code)
+	}
+	if rec := ts.do("POST", "/api/admin/users/"+alice.ID+"/unlock", admin, nil); rec.Code != http.StatusOK {
+		t.Fatalf("unlocking: %d %s", rec.Code, rec.Body)
+	}
+	u, err := ts.Users.Get(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.LockedUntil != nil || u.FailedLogins != 0 {
+		t.Errorf("after unlocking: locked until %v, %d failed logins", u.LockedUntil, u.FailedLogins)
+	}
+	ts.login("alice")
+
+	if rec := ts.do("POST", "/api/admin/users/"+uuid.New().String()+"/unlock", admin, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unlocking a missing user: %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,269 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"webtop-launcher/internal/webauthn"
+	"webtop-launcher/internal/webauthn/webauthntest"
+)
+
+// httptest requests are for example.com, which is the relying party when
+// none is configured.
+const passkeyOrigin = "http://example.com"
+
+type creationCeremony struct {
+	PublicKey webauthn.CreationOptions `json:"publicKey"`
+	State     string                   `json:"state"`
+}
+
+type requestCeremony struct {
+	PublicKey webauthn.RequestOptions `json:"publicKey"`
+	State     string                  `json:"state"`
+}
+
+// stepUp confirms the password of the user of token and returns the
+// step-up token.
+func (ts *testServer) stepUp(token string) string {
+	ts.t.Helper()
+	rec := ts.do("POST", "/api/auth/step-up", token, map[string]string{"password": testPassword})
+	if rec.Code != http.StatusOK {
+		ts.t.Fatalf("step-up: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		StepUpToken string `json:"stepUpToken"`
+	}
+	ts.decode(rec, &resp)
+	return resp.StepUpToken
+}
+
+// registerPasskey registers a passkey of a for the user of token.
+func (ts *testServer) registerPasskey(token string, a *webauthntest.Authenticator) {
+	ts.t.Helper()
+	stepUp := ts.stepUp(token)
+	var begin creationCeremony
+	ts.decode(ts.do("POST", "/api/auth/webauthn/register/begin", token, map[string]string{"stepUpToken": stepUp}), &begin)
+	cred, err := a.Create(&begin.PublicKey)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	rec := ts.do("POST", "/api/auth/webauthn/register/finish", token, map[string]interface{}{
+		"state": begin.State, "name": "Laptop", "credential": cred, "stepUpToken": stepUp,
+	})
+	if rec.Code != http.StatusCreated {
+		ts.t.Fatalf("register/finish: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// assert begins a passkey ceremony at prefix and returns its state and
+// a's answer.
+func (ts *testServer) assert(prefix, token string, a *webauthntest.Authenticator) (string, *webauthn.AssertionResponse) {
+	ts.t.Helper()
+	rec := ts.do("POST", prefix+"/begin", token, nil)
+	if rec.Code != http.StatusOK {
+		ts.t.Fatalf("%s/begin: %d %s", prefix, rec.Code, rec.Body)
+	}
+	var begin requestCeremony
+	ts.decode(rec, &begin)
+	cred, err := a.Get(&begin.PublicKey)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return begin.State, cred
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.user("alice")
+	a := webauthntest.New(passkeyOrigin)
+	ts.registerPasskey(ts.login("alice"), a)
+	creds, err := ts.Credentials.ListByUser(context.Background(), alice.ID)
+	if err != nil || len(creds) != 1 || creds[0].Name != "Laptop" {
+		t.Fatalf("stored passkeys = %+v, %v", creds, err)
+	}
+
+	state, cred := ts.assert("/api/auth/webauthn/login", "", a)
+	rec := ts.do("POST", "/api/auth/webauthn/login/finish", "", map[string]interface{}{"state": state, "credential": cred})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login/finish: %d %s", rec.Code, rec.Body)
+	}
+	claims, _, err := ts.Auth.Authenticate(context.Background(), tokenCookie(t, rec).Value)
+	if err != nil || claims.Subject != alice.ID || claims.Scope != "" {
+		t.Errorf("passwordless login gave %+v, %v", claims, err)
+	}
+	creds, _ = ts.Credentials.ListByUser(context.Background(), alice.ID)
+	if creds[0].SignCount != 1 {
+		t.Errorf("stored counter = %d, want 1", creds[0].SignCount)
+	}
+
+	// The same state and assertion cannot sign in again, even from a synced
+	// passkey, whose counter cannot tell.
+	a.Synced = true
+	state, cred = ts.assert("/api/auth/webauthn/login", "", a)
+	if rec := ts.do("POST", "/api/auth/webauthn/login/finish", "", map[string]interface{}{"state": state, "credential": cred}); rec.Code != http.StatusOK {
+		t.Fatalf("login/finish with a synced passkey: %d %s", rec.Code, rec.Body)
+	}
+	rec = ts.do("POST", "/api/auth/webauthn/login/finish", "", map[string]interface{}{"state": state, "credential": cred})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed login: %d, want 401", rec.Code)
+	}
+}
+
+func TestPasskeySecondFactor(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("alice")
+	a := webauthntest.New(passkeyOrigin)
+	a.Synced = true
+	ts.registerPasskey(ts.login("alice"), a)
+
+	rec := ts.do("POST", "/api/auth/login", "", map[string]string{"username": "alice", "password": testPassword})
+	var challenge struct {
+		Token       string   `json:"token"`
+		MFARequired bool     `json:"mfaRequired"`
+		Methods     []string `json:"methods"`
+	}
+	ts.decode(rec, &challenge)
+	if !challenge.MFARequired || len(challenge.Methods) != 1 || challenge.Methods[0] != "passkey" {
+		t.Fatalf("login with a passkey = %+v, want a passkey challenge", challenge)
+	}
+	if rec := ts.do("GET", "/api/auth/me", challenge.Token, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("the challenge token was accepted: %d", rec.Code)
+	}
+
+	state, cred := ts.assert("/api/auth/2fa/webauthn", challenge.Token, a)
+	rec = ts.do("POST", "/api/auth/2fa/webauthn/finish", challenge.Token, map[string]interface{}{"state": state, "credential": cred})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("2fa/webauthn/finish: %d %s", rec.Code, rec.Body)
+	}
+	if rec := ts.do("GET", "/api/auth/me", tokenCookie(t, rec).Value, nil); rec.Code != http.StatusOK {
+		t.Errorf("the verified login does not authenticate: %d", rec.Code)
+	}
+
+	rec = ts.do("POST", "/api/auth/2fa/webauthn/finish", challenge.Token, map[string]interface{}{"state": state, "credential": cred})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed verification: %d, want 400", rec.Code)
+	}
+}
+
+func TestPasskeyRefused(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("alice")
+	a := webauthntest.New(passkeyOrigin)
+	ts.registerPasskey(ts.login("alice"), a)
+
+	tests := []struct {
+		name  string
+		setup func(a *webauthntest.Authenticator) *webauthntest.Authenticator
+	}{
+		{"wrong origin", func(a *webauthntest.Authenticator) *webauthntest.Authenticator {
+			a.Origin = "http://example.com.evil.test"
+			return a
+		}},
+		{"not verified", func(a *webauthntest.Authenticator) *webauthntest.Authenticator {
+			a.UserVerified = false
+			return a
+		}},
+		// A copy made before the last login reports an older counter.
+		{"counter regression", func(a *webauthntest.Authenticator) *webauthntest.Authenticator {
+			clone := a.Clone()
+			state, cred := ts.assert("/api/auth/webauthn/login", "", a)
+			if rec := ts.do("POST", "/api/auth/webauthn/login/finish", "", map[string]interface{}{"state": state, "credential": cred}); rec.Code != http.StatusOK {
+				t.Fatalf("login/finish: %d %s", rec.Code, rec.Body)
+			}
+			return clone
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.setup(a.Clone())
+			state, cred := ts.assert("/api/auth/webauthn/login", "", b)
+			rec := ts.do("POST", "/api/auth/webauthn/login/finish", "", map[string]interface{}{"state": state, "credential": cred})
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("login/finish: %d %s, want 401", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestPasskeyRegistrationStepUp(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Throttle.Free = 100
+	alice := ts.user("alice")
+	ts.user("bob")
+	token := ts.login("alice")
+	a := webauthntest.New(passkeyOrigin)
+	begin := func(body interface{}) int {
+		t.Helper()
+		return ts.do("POST", "/api/auth/webauthn/register/begin", token, body).Code
+	}
+
+	if code := begin(nil); code != http.StatusForbidden {
+		t.Errorf("begin without a step-up token: %d, want 403", code)
+	}
+	if code := begin(map[string]string{"stepUpToken": ts.stepUp(ts.login("bob"))}); code != http.StatusForbidden {
+		t.Errorf("begin with another user's step-up token: %d, want 403", code)
+	}
+	if code := begin(map[string]string{"stepUpToken": token}); code != http.StatusForbidden {
+		t.Errorf("begin with the access token as step-up token: %d, want 403", code)
+	}
+	if rec := ts.do("POST", "/api/auth/step-up", token, map[string]string{"password": "wrong"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("step-up with a wrong password: %d, want 401", rec.Code)
+	}
+	if rec := ts.do("POST", "/api/auth/step-up", token, map[string]string{"code": "123456"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("step-up with a code and no second factor: %d, want 400", rec.Code)
+	}
+
+	// Finishing needs the step-up token too, not just a begun ceremony.
+	var ceremony creationCeremony
+	ts.decode(ts.do("POST", "/api/auth/webauthn/register/begin", token, map[string]string{"stepUpToken": ts.stepUp(token)}), &ceremony)
+	cred, err := a.Create(&ceremony.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := ts.do("POST", "/api/auth/webauthn/register/finish", token, map[string]interface{}{
+		"state": ceremony.State, "credential": cred,
+	})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("finish without a step-up token: %d, want 403", rec.Code)
+	}
+	creds, _ := ts.Credentials.ListByUser(context.Background(), alice.ID)
+	if len(creds) != 0 {
+		t.Errorf("refused registrations stored %d passkeys", len(creds))
+	}
+
+	// A second factor steps up as well as the password.
+	secret, step, _ := ts.enrollTOTP(token)
+	rec = ts.do("POST", "/api/auth/step-up", token, map[string]string{"code": totpCode(t, secret, step+1)})
+	if rec.Code != http.StatusOK {
+		t.Errorf("step-up with a TOTP code: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPasskeyEnrollmentAtLogin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.user("admin", "admin")
+	ts.user("bob")
+	if rec := ts.do("PUT", "/api/admin/security/mfa", ts.login("admin"), map[string]bool{"required": true}); rec.Code != http.StatusOK {
+		t.Fatalf("requiring two-factor authentication: %d %s", rec.Code, rec.Body)
+	}
+	var login mfaLogin
+	ts.decode(ts.do("POST", "/api/auth/login", "", map[string]string{"username": "bob", "password": testPassword}), &login)
+
+	// The password was just given, so enrolling needs no step-up token.
+	var ceremony creationCeremony
+	ts.decode(ts.do("POST", "/api/auth/webauthn/register/begin", login.Token, nil), &ceremony)
+	cred, err := webauthntest.New(passkeyOrigin).Create(&ceremony.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := ts.do("POST", "/api/auth/webauthn/register/finish", login.Token, map[string]interface{}{
+		"state": ceremony.State, "credential": cred,
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("enrolling a passkey at login: %d %s", rec.Code, rec.Body)
+	}
+	if rec := ts.do("GET", "/api/auth/me", tokenCookie(t, rec).Value, nil); rec.Code != http.StatusOK {
+		t.Errorf("the token after enrolling does not authenticate: %d", rec.Code)
+	}
+}
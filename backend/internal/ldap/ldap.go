@@ -0,0 +1,276 @@
+// Package ldap authenticates users against an LDAP directory or Active
+// Directory: it finds the user with a service account, binds as them to
+// check the password and reads their groups.
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+var (
+	// ErrUserNotFound is returned when the search finds no such user.
+	ErrUserNotFound = errors.New("ldap: user not found")
+	// ErrInvalidCredentials is returned when the user's bind fails.
+	ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+)
+
+// Defaults for OpenLDAP-style directories. For Active Directory use e.g.
+// UserFilter "(&(objectClass=user)(sAMAccountName={username}))" and
+// UsernameAttribute "sAMAccountName".
+const (
+	DefaultUserFilter        = "(uid={username})"
+	DefaultUsernameAttribute = "uid"
+	DefaultTimeout           = 10 * time.Second
+)
+
+// Config describes the directory. In filters, {username} is replaced by the
+// escaped login name and {dn} by the user's escaped DN.
+type Config struct {
+	// URL is ldap://host[:port] or ldaps://host[:port].
+	URL string
+	// StartTLS upgrades an ldap:// connection before anything is sent.
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// BindDN and BindPassword are the service account that searches for
+	// users; empty for an anonymous search.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string
+	// UsernameAttribute holds the username the launcher uses.
+	UsernameAttribute string
+	// GroupFilter, if set, finds the user's groups under GroupBaseDN, e.g.
+	// "(member={dn})". Otherwise the user's memberOf attribute is used.
+	GroupBaseDN string
+	GroupFilter string
+	// Members of AdminGroup, a group DN, are admins and everybody else is
+	// not; without it the admin flag is managed in the launcher.
+	AdminGroup string
+	Timeout    time.Duration
+	// Dial connects to the directory; nil dials URL over the network.
+	Dial Dialer
+}
+
+// Conn is the part of a directory connection the package uses.
+type Conn interface {
+	StartTLS(config *tls.Config) error
+	Bind(username, password string) error
+	UnauthenticatedBind(username string) error
+	Search(req *goldap.SearchRequest) (*goldap.SearchResult, error)
+	Close() error
+}
+
+// Dialer opens a connection to the directory at url, using tlsConfig for
+// ldaps:// URLs.
+type Dialer func(url string, tlsConfig *tls.Config, timeout time.Duration) (Conn, error)
+
+// Identity is a user found in the directory.
+type Identity struct {
+	DN       string
+	Username string
+	Groups   []string
+	// IsAdmin is only meaningful when AdminKnown is set, i.e. when an
+	// admin group is configured.
+	IsAdmin    bool
+	AdminKnown bool
+}
+
+// Validate checks that the configuration is complete.
+func (c *Config) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return fmt.Errorf("url must be ldap://host[:port] or ldaps://host[:port]")
+	}
+	if c.StartTLS && u.Scheme == "ldaps" {
+		return errors.New("startTLS only applies to ldap:// URLs")
+	}
+	if c.BaseDN == "" {
+		return errors.New("baseDn is required")
+	}
+	if c.BindDN != "" && c.BindPassword == "" {
+		return errors.New("bindPassword is required with a bindDn")
+	}
+	for _, filter := range []string{c.filter(), c.GroupFilter} {
+		if filter == "" {
+			continue
+		}
+		if _, err := goldap.CompileFilter(expand(filter, "x", "x")); err != nil {
+			return fmt.Errorf("invalid filter %s: %v", filter, err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) filter() string {
+	if c.UserFilter == "" {
+		return DefaultUserFilter
+	}
+	return c.UserFilter
+}
+
+func (c *Config) usernameAttribute() string {
+	if c.UsernameAttribute == "" {
+		return DefaultUsernameAttribute
+	}
+	return c.UsernameAttribute
+}
+
+// expand fills in a filter template, escaping the values.
+func expand(filter, username, dn string) string {
+	return strings.NewReplacer(
+		"{username}", goldap.EscapeFilter(username),
+		"{dn}", goldap.EscapeFilter(dn),
+	).Replace(filter)
+}
+
+// connect dials the directory, upgrades to TLS if configured and binds as
+// the service account.
+func (c *Config) connect() (Conn, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: c.InsecureSkipVerify}
+	dial := c.Dial
+	if dial == nil {
+		dial = dialNetwork
+	}
+	conn, err := dial(c.URL, tlsConfig, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS: %w", err)
+		}
+	}
+	if err := c.bindService(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func dialNetwork(url string, tlsConfig *tls.Config, timeout time.Duration) (Conn, error) {
+	conn, err := goldap.DialURL(url,
+		goldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		goldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	return conn, nil
+}
+
+func (c *Config) bindService(conn Conn) error {
+	var err error
+	if c.BindDN != "" {
+		err = conn.Bind(c.BindDN, c.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return fmt.Errorf("service account bind: %w", err)
+	}
+	return nil
+}
+
+// Check connects and binds as the service account, to validate settings
+// before they are saved.
+func Check(c *Config) error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Authenticate finds username in the directory and checks password by
+// binding as them.
+func Authenticate(c *Config, username, password string) (*Identity, error) {
+	// An empty password would be an unauthenticated bind, which many
+	// servers accept for any DN.
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	attrs := []string{c.usernameAttribute(), "memberOf"}
+	res, err := conn.Search(goldap.NewSearchRequest(c.BaseDN,
+		goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 2, 0, false,
+		expand(c.filter(), username, ""), attrs, nil))
+	if err != nil && !goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("user search: %w", err)
+	}
+	if res == nil || len(res.Entries) == 0 {
+		return nil, ErrUserNotFound
+	}
+	if len(res.Entries) > 1 {
+		return nil, fmt.Errorf("user search: %q matches more than one entry", username)
+	}
+	entry := res.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("user bind: %w", err)
+	}
+
+	id := &Identity{
+		DN:       entry.DN,
+		Username: strings.ToLower(entry.GetAttributeValue(c.usernameAttribute())),
+		Groups:   entry.GetAttributeValues("memberOf"),
+	}
+	if id.Username == "" {
+		id.Username = strings.ToLower(username)
+	}
+	if c.GroupFilter != "" {
+		// Search as the service account again; the user may not be allowed
+		// to read groups.
+		if err := c.bindService(conn); err != nil {
+			return nil, err
+		}
+		base := c.GroupBaseDN
+		if base == "" {
+			base = c.BaseDN
+		}
+		groups, err := conn.Search(goldap.NewSearchRequest(base,
+			goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, 0, false,
+			expand(c.GroupFilter, username, entry.DN), []string{"1.1"}, nil))
+		if err != nil {
+			return nil, fmt.Errorf("group search: %w", err)
+		}
+		id.Groups = nil
+		for _, g := range groups.Entries {
+			id.Groups = append(id.Groups, g.DN)
+		}
+	}
+
+	if c.AdminGroup != "" {
+		id.AdminKnown = true
+		for _, g := range id.Groups {
+			if strings.EqualFold(g, c.AdminGroup) {
+				id.IsAdmin = true
+			}
+		}
+	}
+	return id, nil
+}
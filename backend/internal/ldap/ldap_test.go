@@ -0,0 +1,199 @@
+package ldap_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"webtop-launcher/internal/ldap"
+	"webtop-launcher/internal/ldap/ldaptest"
+)
+
+const (
+	serviceDN = "cn=launcher,ou=services,dc=example,dc=org"
+	aliceDN   = "uid=alice,ou=people,dc=example,dc=org"
+	bobDN     = "uid=bob,ou=people,dc=example,dc=org"
+	adminsDN  = "cn=admins,ou=groups,dc=example,dc=org"
+	staffDN   = "cn=staff,ou=groups,dc=example,dc=org"
+)
+
+func newDirectory() *ldaptest.Directory {
+	return &ldaptest.Directory{Entries: []ldaptest.Entry{
+		{DN: serviceDN, Password: "service-secret"},
+		{DN: aliceDN, Password: "alice-secret", Attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"Alice"},
+			"memberOf":    {adminsDN, staffDN},
+		}},
+		{DN: bobDN, Password: "bob-secret", Attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"bob"},
+			"memberOf":    {staffDN},
+		}},
+		{DN: adminsDN, Attributes: map[string][]string{"objectClass": {"groupOfNames"}, "member": {aliceDN}}},
+		{DN: staffDN, Attributes: map[string][]string{"objectClass": {"groupOfNames"}, "member": {aliceDN, bobDN}}},
+	}}
+}
+
+func newConfig(dir *ldaptest.Directory) *ldap.Config {
+	return &ldap.Config{
+		URL:          "ldaps://ldap.example.org",
+		BindDN:       serviceDN,
+		BindPassword: "service-secret",
+		BaseDN:       "ou=people,dc=example,dc=org",
+		Dial:         dir.Dial,
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	dir := newDirectory()
+	id, err := ldap.Authenticate(newConfig(dir), "alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ldap.Identity{DN: aliceDN, Username: "alice", Groups: []string{adminsDN, staffDN}}
+	if !reflect.DeepEqual(id, want) {
+		t.Errorf("identity = %+v, want %+v", id, want)
+	}
+	// The service account searches, then the user's bind checks the
+	// password.
+	if binds := dir.Binds(); !reflect.DeepEqual(binds, []string{serviceDN, aliceDN}) {
+		t.Errorf("binds = %q", binds)
+	}
+}
+
+func TestAuthenticateFailures(t *testing.T) {
+	tests := []struct {
+		name               string
+		username, password string
+		configure          func(*ldap.Config)
+		want               error
+	}{
+		{"bad password", "alice", "bob-secret", nil, ldap.ErrInvalidCredentials},
+		{"empty password", "alice", "", nil, ldap.ErrInvalidCredentials},
+		{"unknown user", "carol", "alice-secret", nil, ldap.ErrUserNotFound},
+		{"outside the base DN", "alice", "alice-secret", func(c *ldap.Config) { c.BaseDN = "ou=others,dc=example,dc=org" }, ldap.ErrUserNotFound},
+		{"filter injection", "*", "alice-secret", nil, ldap.ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		cfg := newConfig(newDirectory())
+		if tt.configure != nil {
+			tt.configure(cfg)
+		}
+		if _, err := ldap.Authenticate(cfg, tt.username, tt.password); err != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	cfg := newConfig(newDirectory())
+	cfg.BindPassword = "wrong"
+	if _, err := ldap.Authenticate(cfg, "alice", "alice-secret"); err == nil || errors.Is(err, ldap.ErrInvalidCredentials) {
+		t.Errorf("a wrong service account password: %v, want a configuration error", err)
+	}
+	cfg = newConfig(newDirectory())
+	cfg.UserFilter = "(objectClass=inetOrgPerson)"
+	if _, err := ldap.Authenticate(cfg, "alice", "alice-secret"); err == nil || err == ldap.ErrUserNotFound {
+		t.Errorf("a filter matching several users: %v, want an error", err)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	tests := []struct {
+		name      string
+		username  string
+		password  string
+		configure func(*ldap.Config)
+		want      *ldap.Identity
+	}{
+		{"memberOf without an admin group", "bob", "bob-secret", nil,
+			&ldap.Identity{DN: bobDN, Username: "bob", Groups: []string{staffDN}}},
+		{"member of the admin group", "alice", "alice-secret", func(c *ldap.Config) { c.AdminGroup = "CN=Admins,OU=Groups,DC=example,DC=org" },
+			&ldap.Identity{DN: aliceDN, Username: "alice", Groups: []string{adminsDN, staffDN}, IsAdmin: true, AdminKnown: true}},
+		{"not a member of the admin group", "bob", "bob-secret", func(c *ldap.Config) { c.AdminGroup = adminsDN },
+			&ldap.Identity{DN: bobDN, Username: "bob", Groups: []string{staffDN}, AdminKnown: true}},
+		{"group search", "bob", "bob-secret", func(c *ldap.Config) {
+			c.GroupBaseDN = "ou=groups,dc=example,dc=org"
+			c.GroupFilter = "(&(objectClass=groupOfNames)(member={dn}))"
+			c.AdminGroup = adminsDN
+		}, &ldap.Identity{DN: bobDN, Username: "bob", Groups: []string{staffDN}, AdminKnown: true}},
+		{"group search for an admin", "alice", "alice-secret", func(c *ldap.Config) {
+			c.BaseDN = "dc=example,dc=org"
+			c.GroupFilter = "(member={dn})"
+			c.AdminGroup = adminsDN
+		}, &ldap.Identity{DN: aliceDN, Username: "alice", Groups: []string{adminsDN, staffDN}, IsAdmin: true, AdminKnown: true}},
+	}
+	for _, tt := range tests {
+		cfg := newConfig(newDirectory())
+		if tt.configure != nil {
+			tt.configure(cfg)
+		}
+		id, err := ldap.Authenticate(cfg, tt.username, tt.password)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(id, tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, id, tt.want)
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir := newDirectory()
+	cfg := newConfig(dir)
+	if err := ldap.Check(cfg); err != nil {
+		t.Fatal(err)
+	}
+	dial, startTLS := dir.DialTLS()
+	if dial == nil || dial.ServerName != "ldap.example.org" || dial.InsecureSkipVerify || startTLS != nil {
+		t.Errorf("ldaps://: dialed with %+v, StartTLS %+v", dial, startTLS)
+	}
+
+	cfg.URL = "ldap://ldap.example.org:389"
+	cfg.StartTLS = true
+	cfg.InsecureSkipVerify = true
+	if err := ldap.Check(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, startTLS := dir.DialTLS(); startTLS == nil || startTLS.ServerName != "ldap.example.org" || !startTLS.InsecureSkipVerify {
+		t.Errorf("StartTLS with %+v", startTLS)
+	}
+
+	// Nothing is sent, the service password least of all, unless the
+	// upgrade works.
+	dir = newDirectory()
+	dir.StartTLSErr = errors.New("no TLS here")
+	cfg.Dial = dir.Dial
+	if err := ldap.Check(cfg); err == nil {
+		t.Error("a failed StartTLS was ignored")
+	}
+	if binds := dir.Binds(); len(binds) != 0 {
+		t.Errorf("bound as %q after a failed StartTLS", binds)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*ldap.Config)
+		wantErr   bool
+	}{
+		{"valid", func(c *ldap.Config) {}, false},
+		{"anonymous", func(c *ldap.Config) { c.BindDN, c.BindPassword = "", "" }, false},
+		{"http URL", func(c *ldap.Config) { c.URL = "http://ldap.example.org" }, true},
+		{"no host", func(c *ldap.Config) { c.URL = "ldap://" }, true},
+		{"StartTLS on ldaps", func(c *ldap.Config) { c.StartTLS = true }, true},
+		{"StartTLS on ldap", func(c *ldap.Config) { c.URL, c.StartTLS = "ldap://ldap.example.org", true }, false},
+		{"no base DN", func(c *ldap.Config) { c.BaseDN = "" }, true},
+		{"bind DN without a password", func(c *ldap.Config) { c.BindPassword = "" }, true},
+		{"bad user filter", func(c *ldap.Config) { c.UserFilter = "(uid={username}" }, true},
+		{"bad group filter", func(c *ldap.Config) { c.GroupFilter = "member={dn})" }, true},
+	}
+	for _, tt := range tests {
+		cfg := newConfig(newDirectory())
+		tt.configure(cfg)
+		if err := cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Package ldaptest provides an in-memory LDAP directory, so logins against
+// a directory can be exercised without a server. It understands the filters
+// the launcher uses: and, or, not, equality and presence.
+package ldaptest
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"webtop-launcher/internal/ldap"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// Entry is an object in the directory. Password, if set, is what a bind
+// as DN needs.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Directory is an in-memory directory. Set its fields before the first
+// Dial; Config.Dial = d.Dial connects a configuration to it.
+type Directory struct {
+	Entries []Entry
+	// StartTLSErr, if set, is what StartTLS returns.
+	StartTLSErr error
+
+	mu sync.Mutex
+	// dialTLS is the TLS configuration of the last dial, and startTLS the
+	// one of the last StartTLS, if any.
+	dialTLS, startTLS *tls.Config
+	binds             []string
+}
+
+// Dial connects to the directory. It is an ldap.Dialer.
+func (d *Directory) Dial(url string, tlsConfig *tls.Config, timeout time.Duration) (ldap.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dialTLS, d.startTLS = tlsConfig, nil
+	return &conn{dir: d}, nil
+}
+
+// DialTLS returns the TLS configuration the last connection was dialed
+// with, and the one it was upgraded with by StartTLS, or nil.
+func (d *Directory) DialTLS() (dial, startTLS *tls.Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dialTLS, d.startTLS
+}
+
+// Binds returns the DNs bound as so far, "" for anonymous binds.
+func (d *Directory) Binds() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.binds...)
+}
+
+type conn struct {
+	dir    *Directory
+	bound  bool
+	closed bool
+}
+
+var errClosed = errors.New("ldaptest: connection closed")
+
+func (c *conn) StartTLS(config *tls.Config) error {
+	c.dir.mu.Lock()
+	defer c.dir.mu.Unlock()
+	if c.dir.StartTLSErr != nil {
+		return c.dir.StartTLSErr
+	}
+	c.dir.startTLS = config
+	return nil
+}
+
+func (c *conn) Bind(dn, password string) error {
+	c.dir.mu.Lock()
+	defer c.dir.mu.Unlock()
+	if c.closed {
+		return errClosed
+	}
+	c.dir.binds = append(c.dir.binds, dn)
+	c.bound = false
+	for _, e := range c.dir.Entries {
+		if strings.EqualFold(e.DN, dn) && e.Password != "" && e.Password == password {
+			c.bound = true
+			return nil
+		}
+	}
+	return goldap.NewError(goldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (c *conn) UnauthenticatedBind(dn string) error {
+	c.dir.mu.Lock()
+	defer c.dir.mu.Unlock()
+	if c.closed {
+		return errClosed
+	}
+	c.dir.binds = append(c.dir.binds, dn)
+	c.bound = true
+	return nil
+}
+
+// Search returns the requested attributes of the entries at or below the
+// base DN that match the filter. It needs a successful bind first.
+func (c *conn) Search(req *goldap.SearchRequest) (*goldap.SearchResult, error) {
+	c.dir.mu.Lock()
+	defer c.dir.mu.Unlock()
+	if c.closed {
+		return nil, errClosed
+	}
+	if !c.bound {
+		return nil, goldap.NewError(goldap.LDAPResultInsufficientAccessRights, errors.New("bind first"))
+	}
+	filter, err := goldap.CompileFilter(req.Filter)
+	if err != nil {
+		return nil, goldap.NewError(goldap.ErrorFilterCompile, err)
+	}
+	res := &goldap.SearchResult{}
+	for _, e := range c.dir.Entries {
+		if !under(e.DN, req.BaseDN) || !match(filter, e.Attributes) {
+			continue
+		}
+		if req.SizeLimit > 0 && len(res.Entries) == req.SizeLimit {
+			return res, goldap.NewError(goldap.LDAPResultSizeLimitExceeded, errors.New("size limit exceeded"))
+		}
+		attrs := map[string][]string{}
+		for _, name := range req.Attributes {
+			if values := lookup(e.Attributes, name); values != nil {
+				attrs[name] = values
+			}
+		}
+		res.Entries = append(res.Entries, goldap.NewEntry(e.DN, attrs))
+	}
+	return res, nil
+}
+
+func (c *conn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func under(dn, base string) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	return dn == base || strings.HasSuffix(dn, ","+base)
+}
+
+func lookup(attrs map[string][]string, name string) []string {
+	for k, v := range attrs {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+func match(f *ber.Packet, attrs map[string][]string) bool {
+	switch f.Tag {
+	case goldap.FilterAnd:
+		for _, child := range f.Children {
+			if !match(child, attrs) {
+				return false
+			}
+		}
+		return true
+	case goldap.FilterOr:
+		for _, child := range f.Children {
+			if match(child, attrs) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterNot:
+		return !match(f.Children[0], attrs)
+	case goldap.FilterEqualityMatch:
+		want := f.Children[1].Data.String()
+		for _, v := range lookup(attrs, f.Children[0].Data.String()) {
+			if strings.EqualFold(v, want) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterPresent:
+		return lookup(attrs, f.Data.String()) != nil
+	}
+	return false
+}
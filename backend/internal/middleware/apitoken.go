@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/security"
+	"webtop-launcher/internal/store"
+)
+
+// APITokenPrefix starts every API token, which tells them apart from JWTs
+// and makes leaked ones easy to search for.
+const APITokenPrefix = "wtl_"
+
+// touchInterval is how stale the last use of an API token may get before
+// it is recorded again, so busy scripts do not write on every request.
+const touchInterval = time.Minute
+
+// NewAPIToken returns a new random API token and the hash to store.
+func NewAPIToken() (token, hash string) {
+	token = APITokenPrefix + security.RandomToken()
+	return token, security.HashToken(token)
+}
+
+// IsAPIToken reports whether tokenString is an API token rather than a JWT.
+func IsAPIToken(tokenString string) bool {
+	return strings.HasPrefix(tokenString, APITokenPrefix)
+}
+
+// AuthenticateAPIToken looks an API token and its user up, and records
+// that it was used. Unknown and expired tokens give ErrInvalidToken; other
+// errors come from the stores.
+func (a *Auth) AuthenticateAPIToken(ctx context.Context, tokenString string) (*models.APIToken, *models.User, error) {
+	token, err := a.APITokens.GetByHash(ctx, security.HashToken(tokenString))
+	if err == store.ErrNotFound {
+		return nil, nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if token.ExpiresAt != nil && !now.Before(*token.ExpiresAt) {
+		return nil, nil, ErrInvalidToken
+	}
+	user, err := a.Users.Get(ctx, token.UserID)
+	if err == store.ErrNotFound {
+		return nil, nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= touchInterval {
+		// Losing track of a use is no reason to fail the request.
+		if err := a.APITokens.Touch(ctx, token.ID, now); err != nil {
+			log.Printf("Could not record the use of API token %s: %v", token.ID, err)
+		}
+		token.LastUsedAt = &now
+	}
+	return token, user, nil
+}
@@ -2,46 +2,250 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
-	"webtop-launcher/internal/config"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/store"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-var jwtKey []byte
+// TokenCookie is the HttpOnly cookie the login handler sets alongside the
+// JSON token. The web UI authenticates with it alone and never sees the
+// token.
+const TokenCookie = "webtop_token"
 
-func init() {
-	cfg, err := config.LoadConfig()
+// SessionCookie authenticates the browser to one session on the session
+// origin. It holds a token restricted to ScopeSession, so it opens nothing
+// else.
+const SessionCookie = "webtop_session"
+
+// CSRFHeader must be sent with state-changing requests authenticated by
+// TokenCookie. Browsers only add custom headers to cross-origin requests
+// after a CORS preflight, which the credentialed request fails.
+const CSRFHeader = "X-Requested-With"
+
+// Scopes of restricted tokens, each only accepted by the routes that
+// finish one step of a login. ScopeMFA is the challenge issued once the
+// password of a user with two-factor authentication is accepted;
+// ScopeMFAEnroll is issued to users who must enroll first, and
+// ScopePasswordChange to users who must change their password.
+// ScopeSessionTicket and ScopeSession tokens only open the session named
+// by their audience: the ticket is traded once for a SessionCookie, which
+// holds the other.
+const (
+	ScopePasswordChange = "password_change"
+	ScopeMFA            = "mfa"
+	ScopeMFAEnroll      = "mfa_enroll"
+	ScopeSessionTicket  = "session_ticket"
+	ScopeSession        = "session"
+)
+
+// scopeErrors tell the client what a restricted token is still missing.
+var scopeErrors = map[string]string{
+	ScopePasswordChange: "Password change required",
+	ScopeMFA:            "Two-factor verification required",
+	ScopeMFAEnroll:      "Two-factor enrollment required",
+}
+
+// ScopeError is the message rejecting a token restricted to scope outside
+// the routes that accept it.
+func ScopeError(scope string) string {
+	if msg, ok := scopeErrors[scope]; ok {
+		return msg
+	}
+	return "Token not accepted here"
+}
+
+// Claims are the claims of the JWTs issued at login. A token with a Scope
+// is restricted to the routes that accept that scope. SessionID names the
+// refresh token family of the login the token belongs to; revoking the
+// family revokes the token.
+type Claims struct {
+	jwt.StandardClaims
+	Scope     string `json:"scope,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+}
+
+var (
+	// ErrInvalidToken is returned for tokens that do not verify, have
+	// expired or belong to a deleted user.
+	ErrInvalidToken = errors.New("Invalid token")
+	// ErrRevokedToken is returned for tokens whose login was revoked.
+	ErrRevokedToken = errors.New("Token has been revoked")
+)
+
+// Auth validates the JWTs issued at login and the API tokens, and provides
+// the authentication and permission middleware.
+type Auth struct {
+	Key       []byte
+	Users     store.UserStore
+	Tokens    store.TokenStore
+	Roles     store.RoleStore
+	APITokens store.APITokenStore
+}
+
+// NewAuth returns an Auth that verifies tokens signed with key or found in
+// apiTokens, and checks permissions against the users' roles.
+func NewAuth(key []byte, users store.UserStore, tokens store.TokenStore, roles store.RoleStore, apiTokens store.APITokenStore) *Auth {
+	return &Auth{Key: key, Users: users, Tokens: tokens, Roles: roles, APITokens: apiTokens}
+}
+
+// ParseToken validates a JWT issued by the login handler and returns its
+// claims.
+func (a *Auth) ParseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return a.Key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// Authenticate parses tokenString and checks that its user still exists
+// and that the login it belongs to has not been revoked. Restricted tokens
+// of a login step have no login to revoke and live only minutes. Errors
+// other than ErrInvalidToken and ErrRevokedToken come from the stores.
+func (a *Auth) Authenticate(ctx context.Context, tokenString string) (*Claims, *models.User, error) {
+	claims, err := a.ParseToken(tokenString)
 	if err != nil {
-		panic(err)
+		return nil, nil, ErrInvalidToken
 	}
-	jwtKey = []byte(cfg.JWTSecret)
+	user, err := a.Users.Get(ctx, claims.Subject)
+	if err == store.ErrNotFound {
+		return nil, nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if claims.Scope == "" || claims.SessionID != "" {
+		active, err := a.Tokens.FamilyActive(ctx, claims.SessionID, time.Now())
+		if err != nil {
+			return nil, nil, err
+		}
+		if !active {
+			return nil, nil, ErrRevokedToken
+		}
+	}
+	return claims, user, nil
 }
 
-func AuthMiddleware(next http.Handler) http.Handler {
+// SignToken issues a JWT for claims.
+func (a *Auth) SignToken(claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.Key)
+}
+
+// RequestToken returns the bearer token from the Authorization header,
+// falling back to the TokenCookie cookie.
+func RequestToken(r *http.Request) string {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	if cookie, err := r.Cookie(TokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// AuthMiddleware requires a valid, unrestricted token or an API token.
+func (a *Auth) AuthMiddleware(next http.Handler) http.Handler {
+	return a.authenticate(next, "", false, true)
+}
+
+// LoginMiddleware is AuthMiddleware refusing API tokens, for the routes
+// that change how a user signs in: an API token must not turn off a
+// second factor.
+func (a *Auth) LoginMiddleware(next http.Handler) http.Handler {
+	return a.authenticate(next, "", false, false)
+}
+
+// PasswordChangeMiddleware is LoginMiddleware that also accepts the
+// restricted token of a user who must change their password.
+func (a *Auth) PasswordChangeMiddleware(next http.Handler) http.Handler {
+	return a.authenticate(next, ScopePasswordChange, false, false)
+}
+
+// MFAEnrollMiddleware is LoginMiddleware that also accepts the restricted
+// token of a user who must enroll in two-factor authentication.
+func (a *Auth) MFAEnrollMiddleware(next http.Handler) http.Handler {
+	return a.authenticate(next, ScopeMFAEnroll, false, false)
+}
+
+// MFAChallengeMiddleware only accepts the challenge token of a login
+// waiting for its second factor.
+func (a *Auth) MFAChallengeMiddleware(next http.Handler) http.Handler {
+	return a.authenticate(next, ScopeMFA, true, false)
+}
+
+// authenticate accepts tokens restricted to scope and, unless only is
+// set, unrestricted ones, and API tokens if apiTokens is set.
+func (a *Auth) authenticate(next http.Handler, scope string, only, apiTokens bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+		tokenString := RequestToken(r)
+		if tokenString == "" {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Authorization") == "" && !safeMethod(r.Method) && r.Header.Get(CSRFHeader) == "" {
+			http.Error(w, CSRFHeader+" header required", http.StatusForbidden)
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := &jwt.StandardClaims{}
-
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
+		if IsAPIToken(tokenString) {
+			if !apiTokens {
+				http.Error(w, "API tokens are not accepted here", http.StatusForbidden)
+				return
+			}
+			token, user, err := a.AuthenticateAPIToken(r.Context(), tokenString)
+			if err == ErrInvalidToken {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithAPIToken(WithUser(r.Context(), user), token)))
+			return
+		}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+		claims, user, err := a.Authenticate(r.Context(), tokenString)
+		if err == ErrInvalidToken || err == ErrRevokedToken {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if only && claims.Scope != scope {
+			http.Error(w, "Two-factor challenge token required", http.StatusUnauthorized)
+			return
+		}
+		if claims.Scope != "" && claims.Scope != scope {
+			http.Error(w, ScopeError(claims.Scope), http.StatusForbidden)
 			return
 		}
 
-		// You can add the user ID to the request context here if needed
-		ctx := context.WithValue(r.Context(), "userID", claims.Subject)
+		ctx := WithTokenScope(WithUser(r.Context(), user), claims.Scope)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// safeMethod reports whether method does not change state, so a request
+// with it needs no CSRF protection.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
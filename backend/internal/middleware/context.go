@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+
+	"webtop-launcher/internal/models"
+)
+
+// contextKey is unexported so values set by this package cannot collide with
+// keys from other packages.
+type contextKey string
+
+const (
+	userIDKey   contextKey = "userID"
+	userKey     contextKey = "user"
+	scopeKey    contextKey = "scope"
+	apiTokenKey contextKey = "apiToken"
+)
+
+// UserID returns the ID of the authenticated user, as set by AuthMiddleware.
+func UserID(ctx context.Context) string {
+	id, _ := ctx.Value(userIDKey).(string)
+	return id
+}
+
+// UserFromContext returns the user loaded by AuthMiddleware.
+func UserFromContext(ctx context.Context) (*models.User, bool) {
+	user, ok := ctx.Value(userKey).(*models.User)
+	return user, ok
+}
+
+// WithUserID returns a copy of ctx carrying the authenticated user ID.
+func WithUserID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, userIDKey, id)
+}
+
+// WithUser returns a copy of ctx carrying the loaded user.
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	ctx = context.WithValue(ctx, userKey, user)
+	return WithUserID(ctx, user.ID)
+}
+
+// TokenScope returns the scope of the token the request was authenticated
+// with, empty for an unrestricted token.
+func TokenScope(ctx context.Context) string {
+	scope, _ := ctx.Value(scopeKey).(string)
+	return scope
+}
+
+// WithTokenScope returns a copy of ctx carrying the token's scope.
+func WithTokenScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey, scope)
+}
+
+// APITokenFromContext returns the API token the request was authenticated
+// with, if it was not a JWT.
+func APITokenFromContext(ctx context.Context) (*models.APIToken, bool) {
+	token, ok := ctx.Value(apiTokenKey).(*models.APIToken)
+	return token, ok
+}
+
+// WithAPIToken returns a copy of ctx carrying the API token.
+func WithAPIToken(ctx context.Context, token *models.APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey, token)
+}
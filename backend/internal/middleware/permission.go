@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// Require only lets through users whose roles grant perm, one of the
+// permissions of package rbac. It must run after AuthMiddleware, which
+// loads the user into the request context, and rejects the others with
+// 403.
+func (a *Auth) Require(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+
+			allowed, err := a.Can(r.Context(), user.ID, perm)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				http.Error(w, "Permission "+perm+" required", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Can reports whether the roles of the user grant perm, and so does the
+// scope of the API token the request was authenticated with, if any.
+func (a *Auth) Can(ctx context.Context, userID, perm string) (bool, error) {
+	perms, err := a.Permissions(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range perms {
+		if p == perm {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Permissions returns the permissions the roles of the user grant, sorted.
+// For a request of the user authenticated with an API token, only those
+// in the token's scopes count.
+func (a *Auth) Permissions(ctx context.Context, userID string) ([]string, error) {
+	perms, err := a.Roles.Permissions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	token, ok := APITokenFromContext(ctx)
+	if !ok || token.UserID != userID {
+		return perms, nil
+	}
+	scoped := []string{}
+	for _, p := range perms {
+		for _, s := range token.Scopes {
+			if p == s {
+				scoped = append(scoped, p)
+				break
+			}
+		}
+	}
+	return scoped, nil
+}
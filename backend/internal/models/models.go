@@ -4,12 +4,31 @@ import (
 	"time"
 )
 
+// Authentication providers a user can come from. Only local users have a
+// password; OIDC and LDAP users are linked to their provider by
+// ExternalID. Service accounts cannot sign in at all and only authenticate
+// with API tokens.
+const (
+	AuthLocal   = "local"
+	AuthOIDC    = "oidc"
+	AuthLDAP    = "ldap"
+	AuthService = "service"
+)
+
 type User struct {
-	ID           string    `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"`
-	IsAdmin      bool      `json:"isAdmin"`
-	CreatedAt    time.Time `json:"createdAt"`
+	ID                 string     `json:"id"`
+	Username           string     `json:"username"`
+	PasswordHash       string     `json:"-"`
+	IsAdmin            bool       `json:"isAdmin"`
+	MustChangePassword bool       `json:"mustChangePassword"`
+	FailedLogins       int        `json:"failedLogins"`
+	LockedUntil        *time.Time `json:"lockedUntil,omitempty"`
+	AuthProvider       string     `json:"authProvider"`
+	ExternalID         string     `json:"-"`
+	TOTPSecret         string     `json:"-"`
+	TOTPEnabled        bool       `json:"totpEnabled"`
+	TOTPLastStep       int64      `json:"-"`
+	CreatedAt          time.Time  `json:"createdAt"`
 }
 
 type Application struct {
@@ -27,10 +46,78 @@ type Session struct {
 	UserID           string    `json:"userId"`
 	ApplicationID    string    `json:"applicationId"`
 	PortainerStackID int       `json:"portainerStackId"`
+	StackID          string    `json:"stackId"`
+	StackName        string    `json:"stackName"`
+	Orchestrator     string    `json:"orchestrator"`
+	TargetURL        string    `json:"-"`
 	IsPersistent     bool      `json:"isPersistent"`
 	CreatedAt        time.Time `json:"createdAt"`
 }
 
+// RefreshToken is a stored refresh token. Only the SHA-256 hash of the
+// token itself is kept.
+type RefreshToken struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"userId"`
+	FamilyID  string     `json:"familyId"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// APIToken is a personal access token, a long-lived bearer token for
+// scripts. It grants those of its Scopes the roles of its user grant. Only
+// the SHA-256 hash of the token itself is kept.
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// WebAuthnCredential is a passkey or security key registered by a user.
+// CredentialID is the authenticator's ID for it and PublicKey its COSE
+// key.
+type WebAuthnCredential struct {
+	ID           string     `json:"id"`
+	UserID       string     `json:"userId"`
+	CredentialID []byte     `json:"-"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    uint32     `json:"-"`
+	Name         string     `json:"name"`
+	Transports   []string   `json:"transports"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// Group is a set of users. Applications restricted to groups can only be
+// launched by their members.
+type Group struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	UserIDs        []string  `json:"userIds"`
+	ApplicationIDs []string  `json:"applicationIds"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// Role bundles permissions (see package rbac). Built-in roles cannot be
+// changed.
+type Role struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	Builtin     bool      `json:"builtin"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
 type Settings struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
@@ -0,0 +1,192 @@
+// Package oidc signs users in through an OpenID Connect provider with the
+// authorization code flow and PKCE, and maps the ID token's claims to a
+// launcher identity.
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Default claims and scopes, as used by most providers (Keycloak,
+// Authentik, Authelia, Dex).
+const (
+	DefaultUsernameClaim = "preferred_username"
+	DefaultGroupsClaim   = "groups"
+)
+
+// DefaultScopes are requested when Config.Scopes is empty.
+var DefaultScopes = []string{gooidc.ScopeOpenID, "profile", "email"}
+
+// Config configures the connection to the provider.
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the launcher's /api/auth/oidc/callback as the browser
+	// reaches it, and must be registered with the provider.
+	RedirectURL string
+	Scopes      []string
+	// UsernameClaim names the claim used as the username of new users,
+	// falling back to email and then the subject.
+	UsernameClaim string
+	// GroupsClaim names the claim listing the user's groups. Members of
+	// AdminGroup are admins, and everybody else is not; without an
+	// AdminGroup the admin flag is managed in the launcher.
+	GroupsClaim string
+	AdminGroup  string
+}
+
+// Identity is what the provider asserts about a signed-in user.
+type Identity struct {
+	// Subject is the provider's stable ID for the user.
+	Subject  string
+	Username string
+	Groups   []string
+	// IsAdmin is only meaningful when AdminKnown is set, i.e. when an
+	// admin group is configured.
+	IsAdmin    bool
+	AdminKnown bool
+}
+
+// Provider is a configured OpenID Connect provider. Its discovery
+// document is fetched on first use and then cached, so the launcher starts
+// even while the provider is unreachable.
+type Provider struct {
+	cfg Config
+
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// NewProvider returns a Provider for cfg, filling in the defaults.
+func NewProvider(cfg Config) (*Provider, error) {
+	if cfg.IssuerURL == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("OIDC needs an issuer URL, a client ID and a redirect URL")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = DefaultScopes
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = DefaultUsernameClaim
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = DefaultGroupsClaim
+	}
+	return &Provider{cfg: cfg}, nil
+}
+
+// discover returns the OAuth2 configuration and ID token verifier,
+// fetching the provider's discovery document the first time.
+func (p *Provider) discover() (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth2 != nil {
+		return p.oauth2, p.verifier, nil
+	}
+	// The provider keeps using this context to fetch signing keys, so it
+	// must outlive the request.
+	provider, err := gooidc.NewProvider(context.Background(), p.cfg.IssuerURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("OIDC discovery: %w", err)
+	}
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.cfg.Scopes,
+	}
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.cfg.ClientID})
+	return p.oauth2, p.verifier, nil
+}
+
+// AuthCodeURL returns the provider URL to send the browser to. state and
+// nonce must be random and kept by the caller, as must verifier, which
+// only leaves the launcher as its S256 challenge.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	cfg, _, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	return cfg.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// Exchange redeems the authorization code from the callback, verifies the
+// ID token it yields and returns the identity it asserts.
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	cfg, idVerifier, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	token, err := cfg.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("OIDC code exchange: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("OIDC token response has no id_token")
+	}
+	idToken, err := idVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("OIDC ID token nonce does not match")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("OIDC ID token claims: %w", err)
+	}
+	return p.identity(idToken.Subject, claims), nil
+}
+
+// identity maps the ID token claims of subject to an Identity.
+func (p *Provider) identity(subject string, claims map[string]interface{}) *Identity {
+	id := &Identity{Subject: subject}
+	for _, claim := range []string{p.cfg.UsernameClaim, "email"} {
+		if v, ok := claims[claim].(string); ok && v != "" {
+			id.Username = v
+			break
+		}
+	}
+	if id.Username == "" {
+		id.Username = subject
+	}
+	id.Username = strings.ToLower(id.Username)
+
+	// Most providers send a list; some send one group as a string.
+	switch groups := claims[p.cfg.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	case string:
+		id.Groups = []string{groups}
+	}
+
+	if p.cfg.AdminGroup != "" {
+		id.AdminKnown = true
+		for _, g := range id.Groups {
+			if g == p.cfg.AdminGroup {
+				id.IsAdmin = true
+			}
+		}
+	}
+	return id
+}
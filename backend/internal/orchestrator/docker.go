@@ -0,0 +1,379 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"webtop-launcher/internal/compose"
+	"webtop-launcher/internal/docker"
+)
+
+// labelStack marks every object the Docker driver creates with the stack
+// (compose project) name it belongs to.
+const labelStack = "webtop.stack"
+
+// Docker runs stacks directly against the Docker Engine API, translating the
+// compose file into networks, volumes and containers itself. Stack IDs are
+// the compose project names.
+type Docker struct {
+	Client *docker.Client
+}
+
+func (d *Docker) DeployStack(ctx context.Context, spec StackSpec) (*Stack, error) {
+	project, err := compose.Parse([]byte(spec.Compose), spec.Env)
+	if err != nil {
+		return nil, err
+	}
+	services, err := project.StartOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := d.Client.ListContainers(ctx, labelStack+"="+spec.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, fmt.Errorf("orchestrator: stack %q already exists", spec.Name)
+	}
+
+	if err := d.deploy(ctx, spec.Name, project, services); err != nil {
+		// Leave nothing half-created behind, but keep the volumes named in
+		// the file: one of them may be a user's persistent volume.
+		var named []string
+		for _, v := range project.Volumes {
+			if v.Name != "" {
+				named = append(named, v.Name)
+			}
+		}
+		if rmErr := d.RemoveStack(context.Background(), Ref{ID: spec.Name, Name: spec.Name}, named...); rmErr != nil {
+			log.Printf("orchestrator: cleaning up failed stack %s: %v", spec.Name, rmErr)
+		}
+		return nil, err
+	}
+	return d.InspectStack(ctx, Ref{ID: spec.Name, Name: spec.Name})
+}
+
+func (d *Docker) deploy(ctx context.Context, name string, project *compose.Project, services []compose.Service) error {
+	labels := func(extra map[string]string) map[string]string {
+		l := map[string]string{labelStack: name, docker.LabelComposeProject: name}
+		for k, v := range extra {
+			l[k] = v
+		}
+		return l
+	}
+
+	// Networks: compose gives every project a "default" network, used by
+	// services that do not list any.
+	networkNames := map[string]string{}
+	needDefault := false
+	for _, svc := range services {
+		if _, ok := svc.Networks["default"]; ok || len(svc.Networks) == 0 {
+			needDefault = true
+		}
+	}
+	keys := sortedKeys(project.Networks)
+	if _, declared := project.Networks["default"]; needDefault && !declared {
+		keys = append(keys, "default")
+	}
+	for _, key := range keys {
+		n := project.Networks[key]
+		dockerName := n.Name
+		if dockerName == "" {
+			dockerName = name + "_" + key
+		}
+		networkNames[key] = dockerName
+		if n.External {
+			continue
+		}
+		if _, err := d.Client.CreateNetwork(ctx, dockerName, n.Driver, labels(map[string]string{docker.LabelComposeNetwork: key})); err != nil && !errors.Is(err, docker.ErrConflict) {
+			return fmt.Errorf("creating network %s: %w", dockerName, err)
+		}
+	}
+
+	volumeNames := map[string]string{}
+	for _, key := range sortedKeys(project.Volumes) {
+		v := project.Volumes[key]
+		dockerName := v.Name
+		if dockerName == "" {
+			dockerName = name + "_" + key
+		}
+		volumeNames[key] = dockerName
+		if v.External {
+			continue
+		}
+		if _, err := d.Client.CreateVolume(ctx, dockerName, labels(map[string]string{docker.LabelComposeVolume: key})); err != nil {
+			return fmt.Errorf("creating volume %s: %w", dockerName, err)
+		}
+	}
+
+	for _, svc := range services {
+		if err := d.ensureImage(ctx, svc.Image); err != nil {
+			return err
+		}
+		cfg, err := containerConfig(svc, volumeNames)
+		if err != nil {
+			return fmt.Errorf("service %s: %w", svc.Name, err)
+		}
+		cfg.Labels = labels(svc.Labels)
+		cfg.Labels[docker.LabelComposeService] = svc.Name
+
+		attach := sortedKeys(svc.Networks)
+		if len(attach) == 0 {
+			attach = []string{"default"}
+		}
+		first := attach[0]
+		cfg.NetworkingConfig = &docker.NetworkingConfig{EndpointsConfig: map[string]docker.EndpointConfig{
+			networkNames[first]: {Aliases: append([]string{svc.Name}, svc.Networks[first].Aliases...)},
+		}}
+		cfg.HostConfig.NetworkMode = networkNames[first]
+
+		containerName := svc.ContainerName
+		if containerName == "" {
+			containerName = name + "-" + svc.Name + "-1"
+		}
+		id, err := d.Client.CreateContainer(ctx, containerName, cfg)
+		if err != nil {
+			return fmt.Errorf("creating container %s: %w", containerName, err)
+		}
+		for _, key := range attach[1:] {
+			aliases := append([]string{svc.Name}, svc.Networks[key].Aliases...)
+			if err := d.Client.ConnectNetwork(ctx, networkNames[key], id, aliases); err != nil {
+				return fmt.Errorf("connecting %s to %s: %w", containerName, networkNames[key], err)
+			}
+		}
+		if err := d.Client.StartContainer(ctx, id); err != nil {
+			return fmt.Errorf("starting container %s: %w", containerName, err)
+		}
+	}
+	return nil
+}
+
+func (d *Docker) ensureImage(ctx context.Context, ref string) error {
+	err := d.Client.InspectImage(ctx, ref)
+	if errors.Is(err, docker.ErrNotFound) {
+		return d.Client.PullImage(ctx, ref)
+	}
+	return err
+}
+
+// containerConfig translates a compose service into a container create
+// request.
+func containerConfig(svc compose.Service, volumeNames map[string]string) (docker.ContainerConfig, error) {
+	host := &docker.HostConfig{
+		ShmSize:     svc.ShmSize,
+		Privileged:  svc.Privileged,
+		CapAdd:      svc.CapAdd,
+		SecurityOpt: svc.SecurityOpt,
+		ExtraHosts:  svc.ExtraHosts,
+	}
+	if svc.Restart != "" && svc.Restart != "no" {
+		host.RestartPolicy = docker.RestartPolicy{Name: svc.Restart}
+	}
+
+	for _, m := range svc.Volumes {
+		source := m.Source
+		switch {
+		case m.Type == "bind":
+			if !path.IsAbs(source) {
+				return docker.ContainerConfig{}, fmt.Errorf("relative bind mount %q is not supported by the docker driver", source)
+			}
+		case source == "":
+			// Anonymous volume: let the daemon create one.
+		default:
+			name, ok := volumeNames[source]
+			if !ok {
+				return docker.ContainerConfig{}, fmt.Errorf("volume %q is not declared", source)
+			}
+			source = name
+		}
+		bind := m.Target
+		if source != "" {
+			bind = source + ":" + m.Target
+		}
+		if m.ReadOnly {
+			bind += ":ro"
+		}
+		host.Binds = append(host.Binds, bind)
+	}
+
+	exposed := map[string]struct{}{}
+	for _, p := range svc.Ports {
+		key := p.Target + "/" + p.Protocol
+		exposed[key] = struct{}{}
+		if host.PortBindings == nil {
+			host.PortBindings = map[string][]docker.PortBinding{}
+		}
+		// An empty HostPort asks Docker for an ephemeral one.
+		host.PortBindings[key] = append(host.PortBindings[key], docker.PortBinding{HostIP: p.HostIP, HostPort: p.Published})
+	}
+
+	for _, dev := range svc.Devices {
+		parts := strings.Split(dev, ":")
+		mapping := docker.DeviceMapping{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+		if len(parts) > 1 {
+			mapping.PathInContainer = parts[1]
+		}
+		if len(parts) > 2 {
+			mapping.CgroupPermissions = parts[2]
+		}
+		host.Devices = append(host.Devices, mapping)
+	}
+
+	cfg := docker.ContainerConfig{
+		Image:      svc.Image,
+		Hostname:   svc.Hostname,
+		User:       svc.User,
+		WorkingDir: svc.WorkingDir,
+		Env:        svc.Environment,
+		Cmd:        svc.Command,
+		Entrypoint: svc.Entrypoint,
+		HostConfig: host,
+	}
+	if len(exposed) > 0 {
+		cfg.ExposedPorts = exposed
+	}
+	return cfg, nil
+}
+
+func (d *Docker) InspectStack(ctx context.Context, ref Ref) (*Stack, error) {
+	name := stackName(ref)
+	summaries, err := d.Client.ListContainers(ctx, labelStack+"="+name)
+	if err != nil {
+		return nil, err
+	}
+	if len(summaries) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+	stack := &Stack{Ref: Ref{ID: name, Name: name}}
+	for _, s := range summaries {
+		c := containerFromSummary(s)
+		// The listing only reports published ports; fill in exposed
+		// ones from the container config.
+		if len(c.Ports) == 0 {
+			if full, err := d.Client.InspectContainer(ctx, s.ID); err == nil {
+				c.Ports = exposedPorts(full.Config.ExposedPorts)
+			}
+		}
+		stack.Containers = append(stack.Containers, c)
+	}
+	sort.Slice(stack.Containers, func(i, j int) bool { return stack.Containers[i].Name < stack.Containers[j].Name })
+	stack.Status = summarise(stack.Containers)
+	return stack, nil
+}
+
+func (d *Docker) RemoveStack(ctx context.Context, ref Ref, keep ...string) error {
+	name := stackName(ref)
+	label := labelStack + "=" + name
+
+	containers, err := d.Client.ListContainers(ctx, label)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if err := d.Client.StopContainer(ctx, c.ID, 10*time.Second); err != nil && !errors.Is(err, docker.ErrNotFound) {
+			log.Printf("orchestrator: stopping %s: %v", c.ID, err)
+		}
+		if err := d.Client.RemoveContainer(ctx, c.ID, true); err != nil && !errors.Is(err, docker.ErrNotFound) {
+			return err
+		}
+	}
+
+	networks, err := d.Client.ListNetworks(ctx, label)
+	if err != nil {
+		return err
+	}
+	for _, n := range networks {
+		if err := d.Client.RemoveNetwork(ctx, n.ID); err != nil && !errors.Is(err, docker.ErrNotFound) {
+			log.Printf("orchestrator: removing network %s: %v", n.Name, err)
+		}
+	}
+
+	volumes, err := d.Client.ListVolumes(ctx, label)
+	if err != nil {
+		return err
+	}
+	for _, v := range volumes {
+		if contains(keep, v.Name) {
+			continue
+		}
+		if err := d.Client.RemoveVolume(ctx, v.Name); err != nil && !errors.Is(err, docker.ErrNotFound) {
+			log.Printf("orchestrator: removing volume %s: %v", v.Name, err)
+		}
+	}
+	return nil
+}
+
+func (d *Docker) ListStacks(ctx context.Context) ([]Stack, error) {
+	summaries, err := d.Client.ListContainers(ctx, labelStack)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]*Stack{}
+	var names []string
+	for _, s := range summaries {
+		name := s.Labels[labelStack]
+		st, ok := byName[name]
+		if !ok {
+			st = &Stack{Ref: Ref{ID: name, Name: name}}
+			byName[name] = st
+			names = append(names, name)
+		}
+		st.Containers = append(st.Containers, containerFromSummary(s))
+	}
+	sort.Strings(names)
+	stacks := []Stack{}
+	for _, name := range names {
+		st := byName[name]
+		st.Status = summarise(st.Containers)
+		stacks = append(stacks, *st)
+	}
+	return stacks, nil
+}
+
+func stackName(ref Ref) string {
+	if ref.ID != "" {
+		return ref.ID
+	}
+	return ref.Name
+}
+
+func exposedPorts(exposed map[string]struct{}) []int {
+	var ports []int
+	for key := range exposed {
+		port, proto, _ := strings.Cut(key, "/")
+		if proto != "" && proto != "tcp" {
+			continue
+		}
+		if n, err := strconv.Atoi(port); err == nil {
+			ports = append(ports, n)
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
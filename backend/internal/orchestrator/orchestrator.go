@@ -0,0 +1,223 @@
+// Package orchestrator abstracts the container runtime that session stacks
+// are deployed to, so handlers do not depend on Portainer directly.
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	"webtop-launcher/internal/docker"
+	"webtop-launcher/internal/portainer"
+	"webtop-launcher/internal/store"
+)
+
+// Driver names, as stored in the orchestrator_driver setting and in the
+// orchestrator column of sessions.
+const (
+	DriverPortainer = "portainer"
+	DriverDocker    = "docker"
+)
+
+// Stack states.
+const (
+	StatusRunning  = "running"
+	StatusStopped  = "stopped"
+	StatusDegraded = "degraded"
+)
+
+// DefaultWebPort is the container port a session's UI is served on when a
+// container exposes it, or exposes no ports at all. linuxserver.io webtop
+// images serve plain HTTP on 3000 (and HTTPS on 3001).
+const DefaultWebPort = 3000
+
+var (
+	// ErrNotFound is returned (possibly wrapped) when a stack does not exist.
+	ErrNotFound = errors.New("orchestrator: stack not found")
+	// ErrNoWebPort is returned by Stack.WebURL when no container is running.
+	ErrNoWebPort = errors.New("orchestrator: stack has no running container")
+)
+
+// StackSpec describes a stack to deploy.
+type StackSpec struct {
+	// Name is the compose project name; it must be unique on the host.
+	Name    string
+	Compose string
+	// Env is made available for ${VAR} interpolation in the compose file.
+	Env map[string]string
+}
+
+// Ref identifies a deployed stack. ID is driver specific; Name is the
+// compose project name and is used when the ID is no longer resolvable.
+type Ref struct {
+	ID   string
+	Name string
+}
+
+// Stack is a deployed stack and its containers.
+type Stack struct {
+	Ref
+	Status     string
+	Containers []Container
+}
+
+// WebURL returns the address a session's UI is reached on: the first
+// running container that exposes DefaultWebPort, otherwise the first running
+// one with an address, on its lowest exposed port.
+func (s *Stack) WebURL() (*url.URL, error) {
+	var fallback *Container
+	for i := range s.Containers {
+		c := &s.Containers[i]
+		if c.State != "running" || c.Address == "" {
+			continue
+		}
+		for _, port := range c.Ports {
+			if port == DefaultWebPort {
+				return containerURL(c.Address, port), nil
+			}
+		}
+		if fallback == nil {
+			fallback = c
+		}
+	}
+	if fallback == nil {
+		return nil, ErrNoWebPort
+	}
+	port := DefaultWebPort
+	if len(fallback.Ports) > 0 {
+		port = fallback.Ports[0]
+		for _, p := range fallback.Ports[1:] {
+			if p < port {
+				port = p
+			}
+		}
+	}
+	return containerURL(fallback.Address, port), nil
+}
+
+func containerURL(address string, port int) *url.URL {
+	return &url.URL{Scheme: "http", Host: net.JoinHostPort(address, strconv.Itoa(port))}
+}
+
+// Container is one container of a stack.
+type Container struct {
+	Name    string
+	Service string
+	State   string
+	// Address is the container's IP on its first network, reachable from
+	// the Docker host.
+	Address string
+	// Ports are the container (private) ports it exposes.
+	Ports []int
+}
+
+// Orchestrator deploys and removes session stacks.
+type Orchestrator interface {
+	// DeployStack creates and starts a stack.
+	DeployStack(ctx context.Context, spec StackSpec) (*Stack, error)
+	// RemoveStack stops and deletes a stack and its named volumes, except
+	// the volumes named in keep. A stack that is already gone is not an
+	// error; its leftover volumes are still cleaned up.
+	RemoveStack(ctx context.Context, ref Ref, keep ...string) error
+	// InspectStack returns a stack and its containers, or ErrNotFound.
+	InspectStack(ctx context.Context, ref Ref) (*Stack, error)
+	// ListStacks returns the stacks created by the launcher.
+	ListStacks(ctx context.Context) ([]Stack, error)
+}
+
+// ConfiguredDriver returns the driver selected by the orchestrator_driver
+// setting, defaulting to Portainer.
+func ConfiguredDriver(ctx context.Context, settings store.SettingsStore) (string, error) {
+	values, err := settings.Get(ctx, "orchestrator_driver")
+	if err != nil {
+		return "", err
+	}
+	if values["orchestrator_driver"] == "" {
+		return DriverPortainer, nil
+	}
+	return values["orchestrator_driver"], nil
+}
+
+// New builds the named driver. The Portainer driver reads its URL and key
+// from settings; the Docker driver talks to dockerHost.
+func New(ctx context.Context, settings store.SettingsStore, driver, dockerHost string) (Orchestrator, error) {
+	switch driver {
+	case DriverPortainer, "":
+		client, err := portainer.NewClientFromSettings(ctx, settings)
+		if err != nil {
+			return nil, err
+		}
+		return &Portainer{Client: client}, nil
+	case DriverDocker:
+		client, err := docker.NewClient(dockerHost)
+		if err != nil {
+			return nil, err
+		}
+		return &Docker{Client: client}, nil
+	default:
+		return nil, fmt.Errorf("orchestrator: unknown driver %q", driver)
+	}
+}
+
+// summarise derives a stack status from its containers.
+func summarise(containers []Container) string {
+	running := 0
+	for _, c := range containers {
+		if c.State == "running" {
+			running++
+		}
+	}
+	switch {
+	case running == 0:
+		return StatusStopped
+	case running == len(containers):
+		return StatusRunning
+	default:
+		return StatusDegraded
+	}
+}
+
+// containerFromSummary converts a Docker container listing entry.
+func containerFromSummary(c docker.ContainerSummary) Container {
+	ctr := Container{
+		Service: c.Labels[docker.LabelComposeService],
+		State:   c.State,
+	}
+	if len(c.Names) > 0 {
+		ctr.Name = trimSlash(c.Names[0])
+	}
+	ctr.Address = firstAddress(c.NetworkSettings)
+	seen := map[int]bool{}
+	for _, p := range c.Ports {
+		if !seen[p.PrivatePort] {
+			seen[p.PrivatePort] = true
+			ctr.Ports = append(ctr.Ports, p.PrivatePort)
+		}
+	}
+	return ctr
+}
+
+// firstAddress returns the IP of the alphabetically first network, so the
+// result is stable when a container is on several networks.
+func firstAddress(ns docker.NetworkSettings) string {
+	best, addr := "", ""
+	for name, ep := range ns.Networks {
+		if ep.IPAddress == "" {
+			continue
+		}
+		if best == "" || name < best {
+			best, addr = name, ep.IPAddress
+		}
+	}
+	return addr
+}
+
+func trimSlash(name string) string {
+	if len(name) > 0 && name[0] == '/' {
+		return name[1:]
+	}
+	return name
+}
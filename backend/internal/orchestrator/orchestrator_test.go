@@ -0,0 +1,323 @@
+package orchestrator_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"webtop-launcher/internal/docker"
+	"webtop-launcher/internal/docker/dockertest"
+	"webtop-launcher/internal/orchestrator"
+	"webtop-launcher/internal/orchestrator/orchestratortest"
+	"webtop-launcher/internal/portainer"
+	"webtop-launcher/internal/portainer/portainertest"
+	"webtop-launcher/internal/store"
+)
+
+const testCompose = `services:
+  app:
+    image: lscr.io/linuxserver/webtop:latest
+    environment:
+      - TITLE=${TITLE}
+    volumes:
+      - config:/config
+volumes:
+  config: {}
+  scratch: {}
+`
+
+// driver is an Orchestrator under test and a view of the host it deploys
+// to.
+type driver struct {
+	orchestrator.Orchestrator
+	// deployed is called after a stack is deployed, for fakes that need
+	// help to look like a compose project.
+	deployed func(name string)
+	// hasVolume reports whether a volume is on the host.
+	hasVolume func(name string) bool
+}
+
+// testOrchestrator checks the behaviour every driver must share.
+func testOrchestrator(t *testing.T, d driver) {
+	ctx := context.Background()
+	spec := orchestrator.StackSpec{Name: "webtop-1", Compose: testCompose, Env: map[string]string{"TITLE": "Desktop"}}
+
+	stack, err := d.DeployStack(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.deployed != nil {
+		d.deployed(spec.Name)
+	}
+	if stack.Name != spec.Name || stack.ID == "" || stack.Status != orchestrator.StatusRunning {
+		t.Fatalf("DeployStack = %+v", stack)
+	}
+	u, err := stack.WebURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Port() != "3000" {
+		t.Errorf("WebURL = %s, want port 3000", u)
+	}
+	if _, err := d.DeployStack(ctx, spec); err == nil {
+		t.Error("DeployStack deployed a second stack with the same name")
+	}
+
+	for _, ref := range []orchestrator.Ref{stack.Ref, {Name: spec.Name}} {
+		got, err := d.InspectStack(ctx, ref)
+		if err != nil {
+			t.Fatalf("InspectStack(%+v): %v", ref, err)
+		}
+		if got.ID != stack.ID || got.Status != orchestrator.StatusRunning || len(got.Containers) == 0 {
+			t.Errorf("InspectStack(%+v) = %+v", ref, got)
+		}
+	}
+	if _, err := d.InspectStack(ctx, orchestrator.Ref{Name: "missing"}); !errors.Is(err, orchestrator.ErrNotFound) {
+		t.Errorf("InspectStack of a missing stack = %v, want ErrNotFound", err)
+	}
+
+	stacks, err := d.ListStacks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stacks) != 1 || stacks[0].ID != stack.ID || stacks[0].Name != spec.Name {
+		t.Errorf("ListStacks = %+v", stacks)
+	}
+
+	// A persistent session keeps the volume it asks for, and only that.
+	if err := d.RemoveStack(ctx, stack.Ref, "webtop-1_config"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.InspectStack(ctx, stack.Ref); !errors.Is(err, orchestrator.ErrNotFound) {
+		t.Errorf("InspectStack after RemoveStack = %v, want ErrNotFound", err)
+	}
+	if !d.hasVolume("webtop-1_config") {
+		t.Error("RemoveStack removed the volume it was asked to keep")
+	}
+	if d.hasVolume("webtop-1_scratch") {
+		t.Error("RemoveStack kept a volume it was not asked to keep")
+	}
+
+	// Removing a stack that is gone is not an error, and cleans up what
+	// it left behind.
+	if err := d.RemoveStack(ctx, stack.Ref); err != nil {
+		t.Fatalf("removing a removed stack: %v", err)
+	}
+	if d.hasVolume("webtop-1_config") {
+		t.Error("a volume was left after RemoveStack")
+	}
+	if stacks, err := d.ListStacks(ctx); err != nil || len(stacks) != 0 {
+		t.Errorf("ListStacks after RemoveStack = %+v, %v", stacks, err)
+	}
+}
+
+func TestFake(t *testing.T) {
+	fake := orchestratortest.New()
+	testOrchestrator(t, driver{
+		Orchestrator: fake,
+		hasVolume:    fake.HasVolume,
+	})
+
+	deployErr := errors.New("no capacity")
+	fake.DeployErr = deployErr
+	if _, err := fake.DeployStack(context.Background(), orchestrator.StackSpec{Name: "webtop-2"}); err != deployErr {
+		t.Errorf("DeployStack = %v, want DeployErr", err)
+	}
+	if fake.StackCount() != 0 {
+		t.Error("a failed DeployStack left a stack behind")
+	}
+}
+
+func TestDocker(t *testing.T) {
+	srv, err := dockertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	testOrchestrator(t, driver{
+		Orchestrator: &orchestrator.Docker{Client: srv.Client()},
+		hasVolume:    srv.HasVolume,
+	})
+	if srv.HasNetwork("webtop-1_default") {
+		t.Error("RemoveStack left the default network behind")
+	}
+}
+
+func TestDockerEnvironment(t *testing.T) {
+	srv, err := dockertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	d := &orchestrator.Docker{Client: srv.Client()}
+
+	_, err = d.DeployStack(context.Background(), orchestrator.StackSpec{Name: "webtop-1", Compose: testCompose, Env: map[string]string{"TITLE": "Desktop"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctr, ok := srv.Container("webtop-1-app-1")
+	if !ok {
+		t.Fatal("the container was not created")
+	}
+	if len(ctr.Config.Env) != 1 || ctr.Config.Env[0] != "TITLE=Desktop" {
+		t.Errorf("Env = %q, want the interpolated TITLE", ctr.Config.Env)
+	}
+	if ctr.Config.Labels[docker.LabelComposeProject] != "webtop-1" || ctr.Config.Labels[docker.LabelComposeService] != "app" {
+		t.Errorf("Labels = %q", ctr.Config.Labels)
+	}
+	if len(ctr.Config.HostConfig.Binds) != 1 || ctr.Config.HostConfig.Binds[0] != "webtop-1_config:/config" {
+		t.Errorf("Binds = %q", ctr.Config.HostConfig.Binds)
+	}
+}
+
+func TestDockerDeployFailure(t *testing.T) {
+	srv, err := dockertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	d := &orchestrator.Docker{Client: srv.Client()}
+
+	compose := `services:
+  app:
+    image: webtop
+    networks: [missing]
+    volumes:
+      - home:/config
+networks:
+  missing:
+    external: true
+volumes:
+  home:
+    name: webtop-alice-config
+  scratch: {}
+`
+	if _, err := d.DeployStack(context.Background(), orchestrator.StackSpec{Name: "webtop-1", Compose: compose}); err == nil {
+		t.Fatal("DeployStack succeeded on a missing external network")
+	}
+	if n := srv.ContainerCount(); n != 0 {
+		t.Errorf("%d containers left after a failed deploy", n)
+	}
+	if !srv.HasVolume("webtop-alice-config") || srv.HasVolume("webtop-1_scratch") {
+		t.Error("a failed deploy must keep only the volumes named in the file")
+	}
+}
+
+func TestPortainer(t *testing.T) {
+	srv := portainertest.NewServer()
+	defer srv.Close()
+
+	testOrchestrator(t, driver{
+		Orchestrator: &orchestrator.Portainer{Client: srv.Client()},
+		// Portainer's compose creates the volumes; the fake does not.
+		deployed: func(name string) {
+			for _, v := range []string{"_config", "_scratch"} {
+				srv.AddVolume(name+v, map[string]string{docker.LabelComposeProject: name})
+			}
+		},
+		hasVolume: srv.HasVolume,
+	})
+}
+
+func TestPortainerIgnoresUnmanagedStacks(t *testing.T) {
+	srv := portainertest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	if _, err := srv.Client().CreateStack(ctx, 1, portainer.CreateStackRequest{Name: "by-hand", StackFileContent: testCompose}); err != nil {
+		t.Fatal(err)
+	}
+	stacks, err := (&orchestrator.Portainer{Client: srv.Client()}).ListStacks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stacks) != 0 {
+		t.Errorf("ListStacks = %+v, want only the launcher's stacks", stacks)
+	}
+}
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	settings := store.NewMemory().Settings
+
+	driverName, err := orchestrator.ConfiguredDriver(ctx, settings)
+	if err != nil || driverName != orchestrator.DriverPortainer {
+		t.Errorf("ConfiguredDriver = %q, %v, want portainer by default", driverName, err)
+	}
+	if _, err := orchestrator.New(ctx, settings, orchestrator.DriverPortainer, ""); err == nil {
+		t.Error("New built a Portainer driver without a URL and key")
+	}
+	if o, err := orchestrator.New(ctx, settings, orchestrator.DriverDocker, "unix:///var/run/docker.sock"); err != nil {
+		t.Error(err)
+	} else if _, ok := o.(*orchestrator.Docker); !ok {
+		t.Errorf("New(docker) = %T", o)
+	}
+	if _, err := orchestrator.New(ctx, settings, "kubernetes", ""); err == nil {
+		t.Error("New accepted an unknown driver")
+	}
+
+	if err := settings.Set(ctx, map[string]string{"orchestrator_driver": orchestrator.DriverDocker}); err != nil {
+		t.Fatal(err)
+	}
+	if driverName, _ := orchestrator.ConfiguredDriver(ctx, settings); driverName != orchestrator.DriverDocker {
+		t.Errorf("ConfiguredDriver = %q, want docker", driverName)
+	}
+}
+
+func TestWebURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		containers []orchestrator.Container
+		want       string
+	}{
+		{"web port", []orchestrator.Container{
+			{State: "running", Address: "10.0.0.2", Ports: []int{8080}},
+			{State: "running", Address: "10.0.0.3", Ports: []int{3001, 3000}},
+		}, "http://10.0.0.3:3000"},
+		{"lowest port", []orchestrator.Container{{State: "running", Address: "10.0.0.2", Ports: []int{8080, 6901}}}, "http://10.0.0.2:6901"},
+		{"no ports", []orchestrator.Container{{State: "running", Address: "10.0.0.2"}}, "http://10.0.0.2:3000"},
+		{"skips stopped", []orchestrator.Container{
+			{State: "exited", Address: "10.0.0.2", Ports: []int{3000}},
+			{State: "running", Address: "10.0.0.3", Ports: []int{8080}},
+		}, "http://10.0.0.3:8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stack := orchestrator.Stack{Containers: tt.containers}
+			u, err := stack.WebURL()
+			if err != nil || u.String() != tt.want {
+				t.Errorf("WebURL = %v, %v, want %s", u, err, tt.want)
+			}
+		})
+	}
+
+	stack := orchestrator.Stack{Containers: []orchestrator.Container{{State: "exited", Address: "10.0.0.2"}}}
+	if _, err := stack.WebURL(); err != orchestrator.ErrNoWebPort {
+		t.Errorf("WebURL without a running container = %v, want ErrNoWebPort", err)
+	}
+}
+
+func TestDockerDefaultNetwork(t *testing.T) {
+	srv, err := dockertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	d := &orchestrator.Docker{Client: srv.Client()}
+
+	// Rewritten files name the default network to give it aliases.
+	compose := `services:
+  app:
+    image: webtop
+    networks:
+      default:
+        aliases: [desktop]
+`
+	if _, err := d.DeployStack(context.Background(), orchestrator.StackSpec{Name: "webtop-1", Compose: compose}); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.HasNetwork("webtop-1_default") {
+		t.Error("the default network was not created")
+	}
+}
@@ -0,0 +1,186 @@
+// Package orchestratortest provides an in-memory Orchestrator for handler
+// tests that should not need a container runtime.
+package orchestratortest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"webtop-launcher/internal/compose"
+	"webtop-launcher/internal/orchestrator"
+)
+
+// Fake is an in-memory orchestrator.Orchestrator. Every deployed stack gets
+// a single running container listening on port 3000.
+type Fake struct {
+	mu      sync.Mutex
+	stacks  map[string]*fakeStack
+	volumes map[string]string // volume -> owning stack name
+	nextID  int
+
+	// DeployErr, when set, is returned by the next DeployStack call.
+	DeployErr error
+	// RemoveErr, when set, is returned by the next RemoveStack call.
+	RemoveErr error
+}
+
+type fakeStack struct {
+	orchestrator.Stack
+	Spec orchestrator.StackSpec
+}
+
+var _ orchestrator.Orchestrator = (*Fake)(nil)
+
+// New returns an empty fake.
+func New() *Fake {
+	return &Fake{stacks: map[string]*fakeStack{}, volumes: map[string]string{}, nextID: 1}
+}
+
+func (f *Fake) DeployStack(ctx context.Context, spec orchestrator.StackSpec) (*orchestrator.Stack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.DeployErr; err != nil {
+		f.DeployErr = nil
+		return nil, err
+	}
+	for _, st := range f.stacks {
+		if st.Name == spec.Name {
+			return nil, fmt.Errorf("orchestratortest: stack %q already exists", spec.Name)
+		}
+	}
+	project, err := compose.Parse([]byte(spec.Compose), spec.Env)
+	if err != nil {
+		return nil, err
+	}
+	id := strconv.Itoa(f.nextID)
+	st := &fakeStack{
+		Stack: orchestrator.Stack{
+			Ref:    orchestrator.Ref{ID: id, Name: spec.Name},
+			Status: orchestrator.StatusRunning,
+			Containers: []orchestrator.Container{{
+				Name:    spec.Name + "-app-1",
+				Service: "app",
+				State:   "running",
+				Address: fmt.Sprintf("10.0.0.%d", f.nextID+1),
+				Ports:   []int{3000},
+			}},
+		},
+		Spec: spec,
+	}
+	f.nextID++
+	f.stacks[id] = st
+	// Like compose, a volume named in the file belongs to the stack that
+	// created it first.
+	for key, v := range project.Volumes {
+		name := v.Name
+		if name == "" {
+			name = spec.Name + "_" + key
+		}
+		if _, ok := f.volumes[name]; !ok && !v.External {
+			f.volumes[name] = spec.Name
+		}
+	}
+	out := st.Stack
+	return &out, nil
+}
+
+func (f *Fake) RemoveStack(ctx context.Context, ref orchestrator.Ref, keep ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.RemoveErr; err != nil {
+		f.RemoveErr = nil
+		return err
+	}
+	name := ref.Name
+	if st := f.find(ref); st != nil {
+		name = st.Name
+		delete(f.stacks, st.ID)
+	}
+	for v, owner := range f.volumes {
+		if owner == name && !contains(keep, v) {
+			delete(f.volumes, v)
+		}
+	}
+	return nil
+}
+
+func (f *Fake) InspectStack(ctx context.Context, ref orchestrator.Ref) (*orchestrator.Stack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st := f.find(ref)
+	if st == nil {
+		return nil, fmt.Errorf("%w: %q", orchestrator.ErrNotFound, ref.Name)
+	}
+	out := st.Stack
+	return &out, nil
+}
+
+func (f *Fake) ListStacks(ctx context.Context) ([]orchestrator.Stack, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stacks := []orchestrator.Stack{}
+	for _, st := range f.stacks {
+		stacks = append(stacks, st.Stack)
+	}
+	sort.Slice(stacks, func(i, j int) bool { return stacks[i].Name < stacks[j].Name })
+	return stacks, nil
+}
+
+// Spec returns the spec a stack was deployed with.
+func (f *Fake) Spec(name string) (orchestrator.StackSpec, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, st := range f.stacks {
+		if st.Name == name {
+			return st.Spec, true
+		}
+	}
+	return orchestrator.StackSpec{}, false
+}
+
+// StackCount returns the number of deployed stacks.
+func (f *Fake) StackCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.stacks)
+}
+
+// VolumeCount returns the number of volumes still present, including those
+// kept for persistent sessions.
+func (f *Fake) VolumeCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.volumes)
+}
+
+func (f *Fake) find(ref orchestrator.Ref) *fakeStack {
+	if st, ok := f.stacks[ref.ID]; ok {
+		return st
+	}
+	for _, st := range f.stacks {
+		if ref.Name != "" && st.Name == ref.Name {
+			return st
+		}
+	}
+	return nil
+}
+
+// HasVolume reports whether a volume is still present.
+func (f *Fake) HasVolume(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.volumes[name]
+	return ok
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"webtop-launcher/internal/portainer"
+)
+
+// envManaged is set on every stack the launcher deploys through Portainer,
+// so ListStacks can tell them apart from stacks created by hand.
+const envManaged = "WEBTOP_LAUNCHER"
+
+// Portainer deploys stacks through the Portainer API. Stack IDs are
+// Portainer's numeric stack IDs.
+type Portainer struct {
+	Client *portainer.Client
+}
+
+func (p *Portainer) DeployStack(ctx context.Context, spec StackSpec) (*Stack, error) {
+	endpoint, err := p.Client.DefaultEndpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	env := []portainer.EnvVar{{Name: envManaged, Value: "1"}}
+	keys := make([]string, 0, len(spec.Env))
+	for k := range spec.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, portainer.EnvVar{Name: k, Value: spec.Env[k]})
+	}
+
+	st, err := p.Client.CreateStack(ctx, endpoint.ID, portainer.CreateStackRequest{
+		Name:             spec.Name,
+		StackFileContent: spec.Compose,
+		Env:              env,
+	})
+	if err != nil {
+		return nil, err
+	}
+	stack, err := p.describe(ctx, st)
+	if err != nil {
+		// The stack exists now; failing here would orphan it.
+		log.Printf("orchestrator: inspecting new stack %s: %v", st.Name, err)
+		return &Stack{Ref: Ref{ID: strconv.Itoa(st.ID), Name: st.Name}, Status: StatusRunning}, nil
+	}
+	return stack, nil
+}
+
+func (p *Portainer) InspectStack(ctx context.Context, ref Ref) (*Stack, error) {
+	st, err := p.lookup(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return p.describe(ctx, st)
+}
+
+func (p *Portainer) RemoveStack(ctx context.Context, ref Ref, keep ...string) error {
+	name := ref.Name
+	var endpointID int
+	st, err := p.lookup(ctx, ref)
+	switch {
+	case err == nil:
+		name, endpointID = st.Name, st.EndpointID
+		if err := p.Client.DeleteStack(ctx, st.ID, st.EndpointID); err != nil && !errors.Is(err, portainer.ErrNotFound) {
+			return err
+		}
+	case errors.Is(err, ErrNotFound):
+		log.Printf("orchestrator: portainer stack %s (%s) is already gone", ref.ID, ref.Name)
+		endpoint, err := p.Client.DefaultEndpoint(ctx)
+		if err != nil {
+			return err
+		}
+		endpointID = endpoint.ID
+	default:
+		return err
+	}
+
+	if name == "" {
+		return nil
+	}
+	volumes, err := p.Client.ListProjectVolumes(ctx, endpointID, name)
+	if err != nil {
+		return err
+	}
+	for _, v := range volumes {
+		if contains(keep, v.Name) {
+			continue
+		}
+		if err := p.Client.RemoveVolume(ctx, endpointID, v.Name); err != nil && !errors.Is(err, portainer.ErrNotFound) {
+			log.Printf("orchestrator: could not remove volume %s: %v", v.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *Portainer) ListStacks(ctx context.Context) ([]Stack, error) {
+	all, err := p.Client.ListStacks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stacks := []Stack{}
+	for _, st := range all {
+		managed := false
+		for _, e := range st.Env {
+			if e.Name == envManaged {
+				managed = true
+			}
+		}
+		if !managed {
+			continue
+		}
+		status := StatusStopped
+		if st.Status == portainer.StackStatusActive {
+			status = StatusRunning
+		}
+		stacks = append(stacks, Stack{Ref: Ref{ID: strconv.Itoa(st.ID), Name: st.Name}, Status: status})
+	}
+	return stacks, nil
+}
+
+// lookup finds a stack by ID, falling back to its name.
+func (p *Portainer) lookup(ctx context.Context, ref Ref) (*portainer.Stack, error) {
+	if id, err := strconv.Atoi(ref.ID); err == nil {
+		st, err := p.Client.GetStack(ctx, id)
+		if errors.Is(err, portainer.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+		return st, err
+	}
+	all, err := p.Client.ListStacks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range all {
+		if all[i].Name == ref.Name {
+			return &all[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNotFound, ref.Name)
+}
+
+func (p *Portainer) describe(ctx context.Context, st *portainer.Stack) (*Stack, error) {
+	stack := &Stack{Ref: Ref{ID: strconv.Itoa(st.ID), Name: st.Name}}
+	containers, err := p.Client.ListProjectContainers(ctx, st.EndpointID, st.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range containers {
+		stack.Containers = append(stack.Containers, containerFromSummary(c))
+	}
+	stack.Status = summarise(stack.Containers)
+	return stack, nil
+}
@@ -0,0 +1,159 @@
+// Package password holds the policy every new password is checked against:
+// a minimum length, required character classes and a list of common or
+// breached passwords.
+package password
+
+import (
+	"bufio"
+	"crypto/rand"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+//go:embed common.txt
+var commonPasswords string
+
+// Character classes a policy can require.
+const (
+	Lower  = "lower"
+	Upper  = "upper"
+	Digit  = "digit"
+	Symbol = "symbol"
+)
+
+// DefaultMinLength is used when no minimum length is configured.
+const DefaultMinLength = 10
+
+// Policy decides whether a password is acceptable.
+type Policy struct {
+	MinLength int
+	// Require lists the character classes a password must contain.
+	Require []string
+	// blocked holds the lower-cased common passwords.
+	blocked map[string]struct{}
+}
+
+// NewPolicy returns a policy with the built-in common password list plus
+// the one in blocklistFile, if not empty. The file has one password per line.
+func NewPolicy(minLength int, require []string, blocklistFile string) (*Policy, error) {
+	if minLength <= 0 {
+		minLength = DefaultMinLength
+	}
+	for _, class := range require {
+		switch class {
+		case Lower, Upper, Digit, Symbol:
+		default:
+			return nil, fmt.Errorf("unknown password character class %q", class)
+		}
+	}
+	p := &Policy{MinLength: minLength, Require: require, blocked: map[string]struct{}{}}
+	p.block(strings.NewReader(commonPasswords))
+	if blocklistFile != "" {
+		f, err := os.Open(blocklistFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := p.block(f); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", blocklistFile, err)
+		}
+	}
+	return p, nil
+}
+
+func (p *Policy) block(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.blocked[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// PolicyError lists every rule a password breaks.
+type PolicyError struct {
+	Problems []string
+}
+
+func (e *PolicyError) Error() string {
+	return "Password " + strings.Join(e.Problems, ", ")
+}
+
+// Check returns a *PolicyError if password is not acceptable for username.
+func (p *Policy) Check(username, password string) error {
+	var problems []string
+	if n := len([]rune(password)); n < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	for _, class := range p.Require {
+		if !containsClass(password, class) {
+			problems = append(problems, "must contain "+describeClass(class))
+		}
+	}
+	lower := strings.ToLower(password)
+	if _, ok := p.blocked[lower]; ok {
+		problems = append(problems, "is too common")
+	} else if len(username) >= 3 && strings.Contains(lower, strings.ToLower(username)) {
+		problems = append(problems, "must not contain the username")
+	}
+	if problems != nil {
+		return &PolicyError{Problems: problems}
+	}
+	return nil
+}
+
+func containsClass(password, class string) bool {
+	for _, r := range password {
+		switch {
+		case class == Lower && unicode.IsLower(r),
+			class == Upper && unicode.IsUpper(r),
+			class == Digit && unicode.IsDigit(r),
+			class == Symbol && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r):
+			return true
+		}
+	}
+	return false
+}
+
+func describeClass(class string) string {
+	switch class {
+	case Lower:
+		return "a lowercase letter"
+	case Upper:
+		return "an uppercase letter"
+	case Digit:
+		return "a digit"
+	default:
+		return "a symbol"
+	}
+}
+
+// Generate returns a random password that satisfies the policy.
+func (p *Policy) Generate() (string, error) {
+	// 3 random bytes encode to 4 characters of URL-safe base64, which has
+	// both cases, digits and the symbols - and _.
+	n := 15
+	if p.MinLength > 20 {
+		n = (p.MinLength + 3) / 4 * 3
+	}
+	b := make([]byte, n)
+	for i := 0; i < 100; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		password := base64.RawURLEncoding.EncodeToString(b)
+		if p.Check("", password) == nil {
+			return password, nil
+		}
+	}
+	return "", errors.New("could not generate a password that satisfies the policy")
+}
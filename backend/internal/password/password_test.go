@@ -0,0 +1,109 @@
+package password_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"webtop-launcher/internal/password"
+)
+
+func TestCheck(t *testing.T) {
+	all := []string{password.Lower, password.Upper, password.Digit, password.Symbol}
+	tests := []struct {
+		name      string
+		minLength int
+		require   []string
+		username  string
+		password  string
+		want      []string
+	}{
+		{"default length", 0, nil, "alice", "abcdefghi", []string{"must be at least 10 characters long"}},
+		{"exactly the minimum", 0, nil, "alice", "abcdefghij", nil},
+		{"length in characters", 4, nil, "alice", "äöüß", nil},
+		{"configured length", 16, nil, "alice", "correct-horse-b", []string{"must be at least 16 characters long"}},
+		{"lower", 1, []string{password.Lower}, "alice", "CORRECT HORSE", []string{"must contain a lowercase letter"}},
+		{"upper", 1, []string{password.Upper}, "alice", "correct horse", []string{"must contain an uppercase letter"}},
+		{"digit", 1, []string{password.Digit}, "alice", "correct horse", []string{"must contain a digit"}},
+		{"symbol", 1, []string{password.Symbol}, "alice", "correcthorse9", []string{"must contain a symbol"}},
+		{"space is no symbol", 1, []string{password.Symbol}, "alice", "correct horse", []string{"must contain a symbol"}},
+		{"every class", 1, all, "alice", "Correct-horse9", nil},
+		{"every problem", 12, all, "alice", "", []string{
+			"must be at least 12 characters long",
+			"must contain a lowercase letter",
+			"must contain an uppercase letter",
+			"must contain a digit",
+			"must contain a symbol",
+		}},
+		{"common", 1, nil, "alice", "password123", []string{"is too common"}},
+		{"common in any case", 1, nil, "alice", "PassWord123", []string{"is too common"}},
+		{"common and short", 0, nil, "alice", "dragon", []string{"must be at least 10 characters long", "is too common"}},
+		{"username", 1, nil, "alice", "my-alice-password", []string{"must not contain the username"}},
+		{"username in any case", 1, nil, "Alice", "my-ALICE-password", []string{"must not contain the username"}},
+		{"short username", 1, nil, "al", "always-almost", nil},
+		{"no username", 1, nil, "", "correct horse", nil},
+	}
+	for _, tt := range tests {
+		p, err := password.NewPolicy(tt.minLength, tt.require, "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		err = p.Check(tt.username, tt.password)
+		var got []string
+		if err != nil {
+			perr, ok := err.(*password.PolicyError)
+			if !ok {
+				t.Errorf("%s: %T, want *PolicyError", tt.name, err)
+				continue
+			}
+			got = perr.Problems
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %q", tt.name, got)
+		}
+	}
+}
+
+func TestBlocklistFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(file, []byte("# ours\n\n  Webtop-Launcher-2024  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := password.NewPolicy(0, nil, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Check("alice", "webtop-launcher-2024"); err == nil {
+		t.Error("a password from the blocklist file was accepted")
+	}
+	if err := p.Check("alice", "password123"); err == nil {
+		t.Error("the built-in list is not used with a blocklist file")
+	}
+	if err := p.Check("alice", "# ours and more"); err != nil {
+		t.Errorf("a comment line was blocked: %v", err)
+	}
+
+	if _, err := password.NewPolicy(0, nil, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("a missing blocklist file was accepted")
+	}
+	if _, err := password.NewPolicy(0, []string{"emoji"}, ""); err == nil {
+		t.Error("an unknown character class was accepted")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, minLength := range []int{0, 20, 33} {
+		p, err := password.NewPolicy(minLength, []string{password.Lower, password.Upper, password.Digit, password.Symbol}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pw, err := p.Generate()
+		if err != nil {
+			t.Fatalf("min length %d: %v", minLength, err)
+		}
+		if err := p.Check("", pw); err != nil {
+			t.Errorf("min length %d: generated %q: %v", minLength, pw, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package portainer
+
+import (
+	"context"
+	"net/http"
+)
+
+// Endpoint is a Portainer environment (a Docker host or Swarm cluster).
+type Endpoint struct {
+	ID     int    `json:"Id"`
+	Name   string `json:"Name"`
+	Type   int    `json:"Type"`
+	URL    string `json:"URL"`
+	Status int    `json:"Status"`
+}
+
+// Endpoint status values as reported by Portainer.
+const (
+	EndpointStatusUp   = 1
+	EndpointStatusDown = 2
+)
+
+// ListEndpoints returns every environment visible to the API key.
+func (c *Client) ListEndpoints(ctx context.Context) ([]Endpoint, error) {
+	endpoints := []Endpoint{}
+	if err := c.do(ctx, http.MethodGet, "/api/endpoints", nil, &endpoints); err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// DefaultEndpoint returns the first environment that is up, falling back to
+// the first one listed. Single-host installs only ever have one.
+func (c *Client) DefaultEndpoint(ctx context.Context) (*Endpoint, error) {
+	endpoints, err := c.ListEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Message: "no endpoints configured"}
+	}
+	for i := range endpoints {
+		if endpoints[i].Status == EndpointStatusUp {
+			return &endpoints[i], nil
+		}
+	}
+	return &endpoints[0], nil
+}
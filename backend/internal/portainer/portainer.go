@@ -1,9 +1,180 @@
+// Package portainer is a small typed client for the parts of the Portainer-CE
+// API that the launcher relies on: endpoints, stacks and instance status.
 package portainer
 
-// This package will contain the logic for interacting with the Portainer API.
-// It will include functions for:
-// - Creating a Portainer client
-// - Creating a stack
-// - Deleting a stack
-// - Getting the status of a stack
-// - Deploying the Portainer container itself
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"webtop-launcher/internal/store"
+)
+
+// Sentinel errors that callers can test for with errors.Is. An *APIError
+// returned by the client matches the sentinel for its status code.
+var (
+	ErrNotFound      = errors.New("portainer: not found")
+	ErrUnauthorized  = errors.New("portainer: unauthorized")
+	ErrForbidden     = errors.New("portainer: forbidden")
+	ErrConflict      = errors.New("portainer: conflict")
+	ErrNotConfigured = errors.New("portainer: url or api key not configured")
+)
+
+// APIError is returned when Portainer answers with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Details    string
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Details != "" && e.Details != msg {
+		return fmt.Sprintf("portainer: %d %s: %s", e.StatusCode, msg, e.Details)
+	}
+	return fmt.Sprintf("portainer: %d %s", e.StatusCode, msg)
+}
+
+// Is lets errors.Is(err, ErrNotFound) and friends work on an *APIError.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// Client talks to a single Portainer instance. Requests are authenticated
+// with APIKey, or with a user JWT in Token when no key is set (only needed
+// while bootstrapping a fresh instance).
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the Portainer instance at baseURL.
+func NewClient(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// InsecureSkipVerify disables TLS certificate checks. Self-hosted Portainer
+// instances serve a self-signed certificate on :9443 by default.
+func (c *Client) InsecureSkipVerify() *Client {
+	c.HTTPClient.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	return c
+}
+
+// NewClientFromSettings builds a client from the portainer_url,
+// portainer_api_key and portainer_tls_skip_verify settings.
+func NewClientFromSettings(ctx context.Context, settings store.SettingsStore) (*Client, error) {
+	values, err := settings.Get(ctx, "portainer_url", "portainer_api_key", "portainer_tls_skip_verify")
+	if err != nil {
+		return nil, err
+	}
+	if values["portainer_url"] == "" || values["portainer_api_key"] == "" {
+		return nil, ErrNotConfigured
+	}
+	c := NewClient(values["portainer_url"], values["portainer_api_key"])
+	if values["portainer_tls_skip_verify"] == "true" {
+		c.InsecureSkipVerify()
+	}
+	return c, nil
+}
+
+// Status is the payload of GET /api/status.
+type Status struct {
+	Version    string `json:"Version"`
+	InstanceID string `json:"InstanceID"`
+}
+
+// Status returns the version of the Portainer instance. It is a cheap way to
+// check that the URL is reachable.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var s Status
+	if err := c.do(ctx, http.MethodGet, "/api/status", nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// do sends a JSON request and decodes a JSON response into out (if non-nil).
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	} else if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("portainer: %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		var payload struct {
+			Message string `json:"message"`
+			Details string `json:"details"`
+		}
+		if json.Unmarshal(raw, &payload) == nil {
+			apiErr.Message = payload.Message
+			apiErr.Details = payload.Details
+		} else {
+			apiErr.Message = strings.TrimSpace(string(raw))
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("portainer: decoding %s %s: %w", method, path, err)
+	}
+	return nil
+}
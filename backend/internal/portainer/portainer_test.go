@@ -0,0 +1,260 @@
+package portainer_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"webtop-launcher/internal/docker"
+	"webtop-launcher/internal/portainer"
+	"webtop-launcher/internal/portainer/portainertest"
+)
+
+func TestStatus(t *testing.T) {
+	srv := portainertest.NewServer()
+	defer srv.Close()
+
+	status, err := srv.Client().Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != srv.Version {
+		t.Errorf("Version = %q, want %q", status.Version, srv.Version)
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	srv := portainertest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	endpoints, err := srv.Client().ListEndpoints(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(endpoints) != 1 || endpoints[0].ID != 1 || endpoints[0].Name != "local" {
+		t.Fatalf("ListEndpoints = %+v", endpoints)
+	}
+
+	srv.Endpoints = []portainer.Endpoint{
+		{ID: 3, Name: "down", Status: portainer.EndpointStatusDown},
+		{ID: 4, Name: "up", Status: portainer.EndpointStatusUp},
+	}
+	endpoint, err := srv.Client().DefaultEndpoint(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.ID != 4 {
+		t.Errorf("DefaultEndpoint = %d, want the endpoint that is up", endpoint.ID)
+	}
+
+	srv.Endpoints = []portainer.Endpoint{{ID: 3, Name: "down", Status: portainer.EndpointStatusDown}}
+	if endpoint, err = srv.Client().DefaultEndpoint(ctx); err != nil || endpoint.ID != 3 {
+		t.Errorf("DefaultEndpoint = %+v, %v, want the first endpoint", endpoint, err)
+	}
+
+	srv.Endpoints = nil
+	if _, err := srv.Client().DefaultEndpoint(ctx); !errors.Is(err, portainer.ErrNotFound) {
+		t.Errorf("DefaultEndpoint without endpoints = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStacks(t *testing.T) {
+	srv := portainertest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	client := srv.Client()
+
+	req := portainer.CreateStackRequest{
+		Name:             "webtop-1",
+		StackFileContent: "services:\n  app:\n    image: webtop\n",
+		Env:              []portainer.EnvVar{{Name: "PUID", Value: "1000"}},
+	}
+	stack, err := client.CreateStack(ctx, 1, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stack.ID == 0 || stack.Name != req.Name || stack.EndpointID != 1 || stack.Type != portainer.StackTypeCompose {
+		t.Fatalf("CreateStack = %+v", stack)
+	}
+	if _, content, _ := srv.Stack(stack.ID); content != req.StackFileContent {
+		t.Errorf("stack content = %q, want %q", content, req.StackFileContent)
+	}
+
+	if _, err := client.CreateStack(ctx, 1, req); !errors.Is(err, portainer.ErrConflict) {
+		t.Errorf("CreateStack with a taken name = %v, want ErrConflict", err)
+	}
+	if _, err := client.CreateStack(ctx, 9, portainer.CreateStackRequest{Name: "other", StackFileContent: req.StackFileContent}); !errors.Is(err, portainer.ErrNotFound) {
+		t.Errorf("CreateStack on an unknown endpoint = %v, want ErrNotFound", err)
+	}
+
+	got, err := client.GetStack(ctx, stack.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != req.Name || len(got.Env) != 1 || got.Env[0] != req.Env[0] {
+		t.Errorf("GetStack = %+v", got)
+	}
+	stacks, err := client.ListStacks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stacks) != 1 || stacks[0].ID != stack.ID {
+		t.Errorf("ListStacks = %+v", stacks)
+	}
+
+	containers, err := client.ListProjectContainers(ctx, 1, req.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) != 1 || containers[0].Labels[docker.LabelComposeProject] != req.Name {
+		t.Errorf("ListProjectContainers = %+v", containers)
+	}
+	if containers, err = client.ListProjectContainers(ctx, 1, "other"); err != nil || len(containers) != 0 {
+		t.Errorf("ListProjectContainers of another project = %+v, %v", containers, err)
+	}
+
+	if err := client.DeleteStack(ctx, stack.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetStack(ctx, stack.ID); !errors.Is(err, portainer.ErrNotFound) {
+		t.Errorf("GetStack after DeleteStack = %v, want ErrNotFound", err)
+	}
+	if err := client.DeleteStack(ctx, stack.ID, 1); !errors.Is(err, portainer.ErrNotFound) {
+		t.Errorf("DeleteStack twice = %v, want ErrNotFound", err)
+	}
+}
+
+func TestVolumes(t *testing.T) {
+	srv := portainertest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	client := srv.Client()
+
+	srv.AddVolume("webtop-1_config", map[string]string{docker.LabelComposeProject: "webtop-1"})
+	srv.AddVolume("webtop-2_config", map[string]string{docker.LabelComposeProject: "webtop-2"})
+
+	volumes, err := client.ListProjectVolumes(ctx, 1, "webtop-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 || volumes[0].Name != "webtop-1_config" {
+		t.Fatalf("ListProjectVolumes = %+v", volumes)
+	}
+
+	if err := client.RemoveVolume(ctx, 1, "webtop-1_config"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.HasVolume("webtop-1_config") || !srv.HasVolume("webtop-2_config") {
+		t.Error("RemoveVolume removed the wrong volumes")
+	}
+	if err := client.RemoveVolume(ctx, 1, "webtop-1_config"); !errors.Is(err, portainer.ErrNotFound) {
+		t.Errorf("RemoveVolume twice = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBootstrapUser(t *testing.T) {
+	srv := portainertest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	client := portainer.NewClient(srv.URL, "")
+
+	if _, err := client.InitAdmin(ctx, "admin", "short"); err == nil {
+		t.Error("InitAdmin accepted a short password")
+	}
+	user, err := client.InitAdmin(ctx, "admin", "correct-horse-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "admin" || user.ID == 0 {
+		t.Fatalf("InitAdmin = %+v", user)
+	}
+	if _, err := client.InitAdmin(ctx, "admin", "correct-horse-battery"); !errors.Is(err, portainer.ErrConflict) {
+		t.Errorf("InitAdmin twice = %v, want ErrConflict", err)
+	}
+
+	if _, err := client.Authenticate(ctx, "admin", "wrong-password"); err == nil {
+		t.Error("Authenticate accepted a wrong password")
+	}
+	if _, err := client.CreateAPIKey(ctx, user.ID, "correct-horse-battery", "launcher"); !errors.Is(err, portainer.ErrUnauthorized) {
+		t.Errorf("CreateAPIKey without a token = %v, want ErrUnauthorized", err)
+	}
+
+	client.Token, err = client.Authenticate(ctx, "admin", "correct-horse-battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateAPIKey(ctx, user.ID, "wrong-password", "launcher"); !errors.Is(err, portainer.ErrForbidden) {
+		t.Errorf("CreateAPIKey with a wrong password = %v, want ErrForbidden", err)
+	}
+	key, err := client.CreateAPIKey(ctx, user.ID, "correct-horse-battery", "launcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !srv.ValidKey(key) {
+		t.Fatalf("key %q is not accepted by the server", key)
+	}
+	if _, err := portainer.NewClient(srv.URL, key).ListEndpoints(ctx); err != nil {
+		t.Errorf("ListEndpoints with the new key: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := portainertest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	_, err := portainer.NewClient(srv.URL, "wrong").ListStacks(ctx)
+	var apiErr *portainer.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("ListStacks with a wrong key = %v, want an *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "Unauthorized" {
+		t.Errorf("APIError = %+v", apiErr)
+	}
+	if !errors.Is(err, portainer.ErrUnauthorized) || errors.Is(err, portainer.ErrForbidden) {
+		t.Errorf("errors.Is does not follow the status code of %v", err)
+	}
+
+	srv.FailCreate = http.StatusInternalServerError
+	_, err = srv.Client().CreateStack(ctx, 1, portainer.CreateStackRequest{Name: "webtop-1", StackFileContent: "services: {}"})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("CreateStack = %v, want a 500 *APIError", err)
+	}
+	if got, want := err.Error(), "portainer: 500 Failed to deploy a stack"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if srv.StackCount() != 0 {
+		t.Error("a failed CreateStack left a stack behind")
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"message and details", http.StatusBadRequest, `{"message":"Invalid request","details":"name is empty"}`, "portainer: 400 Invalid request: name is empty"},
+		{"same message and details", http.StatusNotFound, `{"message":"Not found","details":"Not found"}`, "portainer: 404 Not found"},
+		{"plain text", http.StatusBadGateway, "upstream unavailable\n", "portainer: 502 upstream unavailable"},
+		{"empty body", http.StatusServiceUnavailable, "", "portainer: 503 Service Unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			_, err := portainer.NewClient(srv.URL, "key").Status(context.Background())
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Status() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
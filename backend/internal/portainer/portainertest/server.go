@@ -0,0 +1,434 @@
+// Package portainertest provides an in-memory fake of the Portainer API,
+// served over httptest, so code using the portainer client can be exercised
+// without a real Portainer instance.
+package portainertest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"webtop-launcher/internal/docker"
+	"webtop-launcher/internal/portainer"
+)
+
+// DefaultAPIKey is the key accepted by a Server unless APIKey is changed.
+const DefaultAPIKey = "ptr_test"
+
+// Server is a fake Portainer instance. Its exported fields may be adjusted
+// between requests; access is guarded by an internal mutex.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	APIKey    string
+	Version   string
+	Endpoints []portainer.Endpoint
+	stacks    map[int]*fakeStack
+	volumes   map[string]map[string]string
+	nextID    int
+
+	// Bootstrap state: the admin created through /api/users/admin/init,
+	// JWTs issued by /api/auth and keys created through the tokens API.
+	admin    *portainer.User
+	password string
+	jwts     map[string]bool
+	keys     map[string]bool
+
+	// FailCreate, when set, makes the next stack creation fail with this
+	// status code.
+	FailCreate int
+}
+
+type fakeStack struct {
+	portainer.Stack
+	Content string
+}
+
+// NewServer starts a fake Portainer with a single local endpoint (ID 1).
+// Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		APIKey:  DefaultAPIKey,
+		Version: "2.19.4",
+		Endpoints: []portainer.Endpoint{
+			{ID: 1, Name: "local", Type: 1, URL: "unix:///var/run/docker.sock", Status: portainer.EndpointStatusUp},
+		},
+		stacks:  map[int]*fakeStack{},
+		volumes: map[string]map[string]string{},
+		nextID:  1,
+		jwts:    map[string]bool{},
+		keys:    map[string]bool{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Client returns a portainer client pointed at the fake with a valid key.
+func (s *Server) Client() *portainer.Client {
+	return portainer.NewClient(s.URL, s.APIKey)
+}
+
+// Stack returns a copy of a stack and its compose content.
+func (s *Server) Stack(id int) (portainer.Stack, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.stacks[id]
+	if !ok {
+		return portainer.Stack{}, "", false
+	}
+	return st.Stack, st.Content, true
+}
+
+// StackCount returns the number of stacks currently deployed.
+func (s *Server) StackCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.stacks)
+}
+
+// AddVolume registers a named volume with the given labels on the fake
+// Docker host.
+func (s *Server) AddVolume(name string, labels map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	s.volumes[name] = labels
+}
+
+// HasVolume reports whether a named volume exists.
+func (s *Server) HasVolume(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.volumes[name]
+	return ok
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message, "details": message})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// /api/status is public in Portainer.
+	if r.URL.Path == "/api/status" && r.Method == http.MethodGet {
+		writeJSON(w, portainer.Status{Version: s.Version, InstanceID: "fake"})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/users/admin/init":
+		s.initAdmin(w, r)
+		return
+	case "/api/auth":
+		s.authenticate(w, r)
+		return
+	}
+
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 4 && parts[1] == "users" && parts[3] == "tokens" && r.Method == http.MethodPost:
+		s.createToken(w, r, parts[2])
+	case r.URL.Path == "/api/endpoints" && r.Method == http.MethodGet:
+		writeJSON(w, s.Endpoints)
+
+	case r.URL.Path == "/api/stacks" && r.Method == http.MethodGet:
+		stacks := []portainer.Stack{}
+		for id := 1; id < s.nextID; id++ {
+			if st, ok := s.stacks[id]; ok {
+				stacks = append(stacks, st.Stack)
+			}
+		}
+		writeJSON(w, stacks)
+
+	case r.URL.Path == "/api/stacks/create/standalone/string" && r.Method == http.MethodPost:
+		s.createStack(w, r)
+
+	case len(parts) == 3 && parts[0] == "api" && parts[1] == "stacks":
+		id, err := strconv.Atoi(parts[2])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid stack identifier route variable")
+			return
+		}
+		st, ok := s.stacks[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Unable to find a stack with the specified identifier inside the database")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, st.Stack)
+		case http.MethodDelete:
+			delete(s.stacks, id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+
+	case len(parts) == 6 && parts[1] == "endpoints" && parts[3] == "docker" && parts[4] == "containers" && parts[5] == "json" && r.Method == http.MethodGet:
+		s.listContainers(w, r)
+
+	case len(parts) == 5 && parts[1] == "endpoints" && parts[3] == "docker" && parts[4] == "volumes" && r.Method == http.MethodGet:
+		s.listVolumes(w, r)
+
+	case len(parts) == 6 && parts[1] == "endpoints" && parts[3] == "docker" && parts[4] == "volumes" && r.Method == http.MethodDelete:
+		if _, ok := s.volumes[parts[5]]; !ok {
+			writeError(w, http.StatusNotFound, "get "+parts[5]+": no such volume")
+			return
+		}
+		delete(s.volumes, parts[5])
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) createStack(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := strconv.Atoi(r.URL.Query().Get("endpointId"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid query parameter: endpointId")
+		return
+	}
+	found := false
+	for _, e := range s.Endpoints {
+		if e.ID == endpointID {
+			found = true
+		}
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "Unable to find an environment with the specified identifier inside the database")
+		return
+	}
+
+	if s.FailCreate != 0 {
+		status := s.FailCreate
+		s.FailCreate = 0
+		writeError(w, status, "Failed to deploy a stack")
+		return
+	}
+
+	var req portainer.CreateStackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Name == "" || req.StackFileContent == "" {
+		writeError(w, http.StatusBadRequest, "Invalid stack name or content")
+		return
+	}
+	for _, st := range s.stacks {
+		if st.Name == req.Name {
+			writeError(w, http.StatusConflict, "A stack with the name '"+req.Name+"' already exists")
+			return
+		}
+	}
+
+	st := &fakeStack{
+		Stack: portainer.Stack{
+			ID:           s.nextID,
+			Name:         req.Name,
+			Type:         portainer.StackTypeCompose,
+			EndpointID:   endpointID,
+			Status:       portainer.StackStatusActive,
+			Env:          req.Env,
+			CreationDate: time.Now().Unix(),
+		},
+		Content: req.StackFileContent,
+	}
+	s.stacks[st.ID] = st
+	s.nextID++
+	writeJSON(w, st.Stack)
+}
+
+// listContainers reports a single running container per stack, labelled the
+// way docker compose labels it, honouring label=k=v filters.
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request) {
+	var filters map[string][]string
+	if raw := r.URL.Query().Get("filters"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &filters); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid filters")
+			return
+		}
+	}
+	containers := []docker.ContainerSummary{}
+	for id := 1; id < s.nextID; id++ {
+		st, ok := s.stacks[id]
+		if !ok {
+			continue
+		}
+		labels := map[string]string{
+			docker.LabelComposeProject: st.Name,
+			docker.LabelComposeService: "app",
+		}
+		match := true
+		for _, f := range filters["label"] {
+			k, v, hasValue := strings.Cut(f, "=")
+			got, ok := labels[k]
+			if !ok || (hasValue && got != v) {
+				match = false
+			}
+		}
+		if !match {
+			continue
+		}
+		containers = append(containers, docker.ContainerSummary{
+			ID:     strconv.Itoa(st.ID),
+			Names:  []string{"/" + st.Name + "-app-1"},
+			State:  "running",
+			Labels: labels,
+			Ports:  []docker.Port{{PrivatePort: 3000, Type: "tcp"}},
+			NetworkSettings: docker.NetworkSettings{Networks: map[string]docker.EndpointSettings{
+				st.Name + "_default": {IPAddress: "172.30.0." + strconv.Itoa(st.ID+1)},
+			}},
+		})
+	}
+	writeJSON(w, containers)
+}
+
+// listVolumes implements the Docker volume list call, honouring label=k=v
+// filters.
+func (s *Server) listVolumes(w http.ResponseWriter, r *http.Request) {
+	var filters map[string][]string
+	if raw := r.URL.Query().Get("filters"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &filters); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid filters")
+			return
+		}
+	}
+	names := make([]string, 0, len(s.volumes))
+	for name := range s.volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	volumes := []portainer.Volume{}
+	for _, name := range names {
+		labels := s.volumes[name]
+		match := true
+		for _, f := range filters["label"] {
+			k, v, hasValue := strings.Cut(f, "=")
+			got, ok := labels[k]
+			if !ok || (hasValue && got != v) {
+				match = false
+			}
+		}
+		if match {
+			volumes = append(volumes, portainer.Volume{Name: name, Labels: labels})
+		}
+	}
+	writeJSON(w, map[string]interface{}{"Volumes": volumes})
+}
+
+// AdminInitialized reports whether /api/users/admin/init has been called.
+func (s *Server) AdminInitialized() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.admin != nil
+}
+
+// ValidKey reports whether key is accepted by the fake.
+func (s *Server) ValidKey(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return key == s.APIKey || s.keys[key]
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key == s.APIKey || s.keys[key]
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return s.jwts[strings.TrimPrefix(auth, "Bearer ")]
+	}
+	return false
+}
+
+type credentials struct {
+	Username string `json:"Username"`
+	Password string `json:"Password"`
+}
+
+func (s *Server) initAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.admin != nil {
+		writeError(w, http.StatusConflict, "Unable to create administrator user")
+		return
+	}
+	var creds credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil || creds.Username == "" {
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if len(creds.Password) < 12 {
+		writeError(w, http.StatusBadRequest, "Password does not meet the requirements")
+		return
+	}
+	s.admin = &portainer.User{ID: 1, Username: creds.Username, Role: 1}
+	s.password = creds.Password
+	writeJSON(w, s.admin)
+}
+
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) {
+	var creds credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if s.admin == nil || creds.Username != s.admin.Username || creds.Password != s.password {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid credentials")
+		return
+	}
+	jwt := "jwt-" + strconv.Itoa(len(s.jwts)+1)
+	s.jwts[jwt] = true
+	writeJSON(w, map[string]string{"jwt": jwt})
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request, userID string) {
+	if s.admin == nil || userID != strconv.Itoa(s.admin.ID) {
+		writeError(w, http.StatusNotFound, "Unable to find a user with the specified identifier inside the database")
+		return
+	}
+	var req struct {
+		Password    string `json:"password"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Password != s.password {
+		writeError(w, http.StatusForbidden, "Current password doesn't match")
+		return
+	}
+	key := "ptr_fake" + strconv.Itoa(len(s.keys)+1)
+	s.keys[key] = true
+	writeJSON(w, map[string]interface{}{
+		"rawAPIKey": key,
+		"apiKey":    map[string]interface{}{"id": len(s.keys), "userId": s.admin.ID, "description": req.Description},
+	})
+}
@@ -0,0 +1,327 @@
+package portainer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"webtop-launcher/internal/docker"
+)
+
+// Names used for the Portainer instance the launcher manages itself.
+const (
+	SelfHostContainer = "webtop-launcher-portainer"
+	SelfHostVolume    = "webtop-launcher-portainer-data"
+	SelfHostImage     = "portainer/portainer-ce:latest"
+	SelfHostAdmin     = "admin"
+)
+
+// Self-hosted container states, matching PortainerStatus in types.ts.
+const (
+	StateRunning   = "running"
+	StateStopped   = "stopped"
+	StateError     = "error"
+	StateDeploying = "deploying"
+)
+
+// SelfHost deploys and inspects a Portainer-CE container on the local Docker
+// daemon.
+type SelfHost struct {
+	Docker *docker.Client
+	// URL is where the backend reaches the deployed instance, e.g.
+	// https://localhost:9443.
+	URL string
+
+	PollInterval time.Duration
+	Timeout      time.Duration
+
+	// ExistingAPIKey is the key currently stored in settings, if any. The
+	// data volume survives redeploys, so an instance that is already
+	// initialised keeps working with its old key.
+	ExistingAPIKey string
+
+	// NewClient builds the Portainer client used for bootstrapping. It
+	// defaults to a client that accepts Portainer's self-signed certificate.
+	NewClient func(url string) *Client
+
+	// Progress, if set, is called with a short description of each step
+	// of Deploy as it starts.
+	Progress func(step string)
+}
+
+// DeployResult is the configuration of a freshly deployed instance.
+type DeployResult struct {
+	URL           string
+	APIKey        string
+	AdminPassword string
+}
+
+// ContainerStatus describes the managed container for the status endpoint.
+type ContainerStatus struct {
+	Status  string `json:"status"`
+	Uptime  string `json:"uptime"`
+	Version string `json:"version"`
+}
+
+func (s *SelfHost) client() *Client {
+	if s.NewClient != nil {
+		return s.NewClient(s.URL)
+	}
+	return NewClient(s.URL, "").InsecureSkipVerify()
+}
+
+func (s *SelfHost) progress(step string) {
+	if s.Progress != nil {
+		s.Progress(step)
+	}
+}
+
+func (s *SelfHost) pollInterval() time.Duration {
+	if s.PollInterval > 0 {
+		return s.PollInterval
+	}
+	return 2 * time.Second
+}
+
+// Deploy replaces any existing managed container with a fresh Portainer-CE
+// instance, waits for it to come up, creates its admin user and an API key,
+// and returns the resulting configuration. The data volume is kept across
+// deploys so Portainer does not lose track of running session stacks.
+func (s *SelfHost) Deploy(ctx context.Context) (*DeployResult, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	s.progress("Removing any previous container")
+	existing, err := s.Docker.InspectContainer(ctx, SelfHostContainer)
+	switch {
+	case err == nil:
+		if err := s.Docker.RemoveContainer(ctx, existing.ID, true); err != nil {
+			return nil, fmt.Errorf("removing existing container: %w", err)
+		}
+	case !errors.Is(err, docker.ErrNotFound):
+		return nil, err
+	}
+
+	s.progress("Pulling " + SelfHostImage)
+	if err := s.Docker.PullImage(ctx, SelfHostImage); err != nil {
+		return nil, err
+	}
+	s.progress("Starting the container")
+	if _, err := s.Docker.CreateVolume(ctx, SelfHostVolume, map[string]string{"webtop.managed": "true"}); err != nil {
+		return nil, fmt.Errorf("creating volume: %w", err)
+	}
+
+	id, err := s.Docker.CreateContainer(ctx, SelfHostContainer, docker.ContainerConfig{
+		Image:  SelfHostImage,
+		Labels: map[string]string{"webtop.managed": "true"},
+		ExposedPorts: map[string]struct{}{
+			"8000/tcp": {},
+			"9443/tcp": {},
+		},
+		HostConfig: &docker.HostConfig{
+			Binds: []string{
+				SelfHostVolume + ":/data",
+				"/var/run/docker.sock:/var/run/docker.sock",
+			},
+			PortBindings: map[string][]docker.PortBinding{
+				"8000/tcp": {{HostPort: "8000"}},
+				"9443/tcp": {{HostPort: "9443"}},
+			},
+			RestartPolicy: docker.RestartPolicy{Name: "unless-stopped"},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating container: %w", err)
+	}
+	if err := s.Docker.StartContainer(ctx, id); err != nil {
+		return nil, fmt.Errorf("starting container: %w", err)
+	}
+
+	s.progress("Waiting for Portainer to come up")
+	if err := s.waitHealthy(ctx, id); err != nil {
+		return nil, err
+	}
+	client := s.client()
+	if err := s.waitAPI(ctx, client); err != nil {
+		return nil, err
+	}
+	s.progress("Creating the admin user and API key")
+	return s.bootstrap(ctx, client)
+}
+
+// waitHealthy polls the container until it is running and, if the image
+// defines a health check, reports healthy.
+func (s *SelfHost) waitHealthy(ctx context.Context, id string) error {
+	for {
+		ctr, err := s.Docker.InspectContainer(ctx, id)
+		if err != nil {
+			return err
+		}
+		switch {
+		case ctr.State.Status == "exited" || ctr.State.Status == "dead":
+			return fmt.Errorf("portainer container %s (exit code %d) %s", ctr.State.Status, ctr.State.ExitCode, ctr.State.Error)
+		case ctr.State.Health != nil && ctr.State.Health.Status == docker.HealthUnhealthy:
+			return errors.New("portainer container is unhealthy")
+		case ctr.State.Running && (ctr.State.Health == nil || ctr.State.Health.Status == docker.HealthHealthy):
+			return nil
+		}
+		if err := sleep(ctx, s.pollInterval()); err != nil {
+			return fmt.Errorf("waiting for portainer container: %w", err)
+		}
+	}
+}
+
+// waitAPI polls /api/status until Portainer answers.
+func (s *SelfHost) waitAPI(ctx context.Context, client *Client) error {
+	for {
+		_, lastErr := client.Status(ctx)
+		if lastErr == nil {
+			return nil
+		}
+		if err := sleep(ctx, s.pollInterval()); err != nil {
+			return fmt.Errorf("waiting for portainer api: %w (last error: %v)", err, lastErr)
+		}
+	}
+}
+
+// bootstrap creates the admin user and an API key for the launcher.
+func (s *SelfHost) bootstrap(ctx context.Context, client *Client) (*DeployResult, error) {
+	password, err := randomPassword()
+	if err != nil {
+		return nil, err
+	}
+	admin, err := client.InitAdmin(ctx, SelfHostAdmin, password)
+	if errors.Is(err, ErrConflict) && s.ExistingAPIKey != "" {
+		// Redeployed over existing data: keep using the stored key if the
+		// instance still accepts it.
+		existing := *client
+		existing.APIKey = s.ExistingAPIKey
+		if _, err := existing.ListEndpoints(ctx); err != nil {
+			return nil, fmt.Errorf("portainer is already initialised and rejects the stored api key: %w", err)
+		}
+		return &DeployResult{URL: s.URL, APIKey: s.ExistingAPIKey}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating portainer admin: %w", err)
+	}
+	token, err := client.Authenticate(ctx, SelfHostAdmin, password)
+	if err != nil {
+		return nil, fmt.Errorf("authenticating to portainer: %w", err)
+	}
+	client.Token = token
+	key, err := client.CreateAPIKey(ctx, admin.ID, password, "webtop-launcher")
+	if err != nil {
+		return nil, fmt.Errorf("creating portainer api key: %w", err)
+	}
+	return &DeployResult{URL: s.URL, APIKey: key, AdminPassword: password}, nil
+}
+
+// Status inspects the managed container. It returns nil (and no error) when
+// the container does not exist.
+func (s *SelfHost) Status(ctx context.Context) (*ContainerStatus, error) {
+	ctr, err := s.Docker.InspectContainer(ctx, SelfHostContainer)
+	if errors.Is(err, docker.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	status := &ContainerStatus{
+		Status:  containerState(ctr.State),
+		Version: imageTag(ctr.Config.Image),
+	}
+	if status.Status == StateRunning || status.Status == StateDeploying {
+		if started := ctr.State.StartedTime(); !started.IsZero() {
+			status.Uptime = humanizeDuration(time.Since(started))
+		}
+	}
+	// The tag is usually "latest"; ask the instance for its real version.
+	if status.Status == StateRunning && (status.Version == "" || status.Version == "latest") && s.URL != "" {
+		sctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+		if st, err := s.client().Status(sctx); err == nil && st.Version != "" {
+			status.Version = st.Version
+		}
+	}
+	return status, nil
+}
+
+// containerState maps a Docker container state to the frontend's enum.
+func containerState(st docker.ContainerState) string {
+	switch st.Status {
+	case "running":
+		if st.Health != nil {
+			switch st.Health.Status {
+			case docker.HealthStarting:
+				return StateDeploying
+			case docker.HealthUnhealthy:
+				return StateError
+			}
+		}
+		return StateRunning
+	case "created":
+		return StateDeploying
+	case "paused":
+		return StateStopped
+	case "exited":
+		if st.ExitCode == 0 {
+			return StateStopped
+		}
+		return StateError
+	default: // restarting, removing, dead
+		return StateError
+	}
+}
+
+func imageTag(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return ""
+}
+
+// humanizeDuration renders d as "5 minutes", "3 hours", "2 days".
+func humanizeDuration(d time.Duration) string {
+	unit := func(n int, name string) string {
+		if n == 1 {
+			return "1 " + name
+		}
+		return fmt.Sprintf("%d %ss", n, name)
+	}
+	switch {
+	case d < time.Minute:
+		return unit(int(d/time.Second), "second")
+	case d < time.Hour:
+		return unit(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return unit(int(d/time.Hour), "hour")
+	default:
+		return unit(int(d/(24*time.Hour)), "day")
+	}
+}
+
+func randomPassword() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package portainer_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"webtop-launcher/internal/docker"
+	"webtop-launcher/internal/docker/dockertest"
+	"webtop-launcher/internal/portainer"
+	"webtop-launcher/internal/portainer/portainertest"
+)
+
+// newSelfHost returns a SelfHost deploying on a fake Docker daemon and
+// bootstrapping a fake Portainer.
+func newSelfHost(t *testing.T) (*portainer.SelfHost, *dockertest.Server, *portainertest.Server) {
+	t.Helper()
+	dockerSrv, err := dockertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dockerSrv.Close)
+	portainerSrv := portainertest.NewServer()
+	t.Cleanup(portainerSrv.Close)
+
+	return &portainer.SelfHost{
+		Docker:       dockerSrv.Client(),
+		URL:          portainerSrv.URL,
+		PollInterval: time.Millisecond,
+		Timeout:      5 * time.Second,
+	}, dockerSrv, portainerSrv
+}
+
+func TestSelfHostDeploy(t *testing.T) {
+	selfHost, dockerSrv, portainerSrv := newSelfHost(t)
+	ctx := context.Background()
+	var steps []string
+	selfHost.Progress = func(step string) { steps = append(steps, step) }
+
+	result, err := selfHost.Deploy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.URL != portainerSrv.URL || result.AdminPassword == "" {
+		t.Errorf("Deploy = %+v", result)
+	}
+	if !portainerSrv.ValidKey(result.APIKey) {
+		t.Errorf("the API key %q is not accepted by Portainer", result.APIKey)
+	}
+	if len(steps) == 0 || !strings.HasPrefix(steps[len(steps)-1], "Creating the admin user") {
+		t.Errorf("progress steps = %q", steps)
+	}
+
+	ctr, ok := dockerSrv.Container(portainer.SelfHostContainer)
+	if !ok {
+		t.Fatal("the Portainer container was not created")
+	}
+	if !ctr.State.Running || ctr.Config.Image != portainer.SelfHostImage {
+		t.Errorf("container = %+v", ctr)
+	}
+	if !dockerSrv.HasVolume(portainer.SelfHostVolume) {
+		t.Error("the data volume was not created")
+	}
+
+	status, err := selfHost.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != portainer.StateRunning || status.Version != portainerSrv.Version {
+		t.Errorf("Status = %+v", status)
+	}
+}
+
+func TestSelfHostRedeploy(t *testing.T) {
+	selfHost, dockerSrv, _ := newSelfHost(t)
+	ctx := context.Background()
+
+	first, err := selfHost.Deploy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data volume survives, so the instance is already initialised.
+	selfHost.ExistingAPIKey = first.APIKey
+	second, err := selfHost.Deploy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.APIKey != first.APIKey || second.AdminPassword != "" {
+		t.Errorf("redeploy = %+v, want the stored key and no new admin", second)
+	}
+	if n := dockerSrv.ContainerCount(); n != 1 {
+		t.Errorf("%d containers after a redeploy, want the old one replaced", n)
+	}
+
+	selfHost.ExistingAPIKey = "ptr_revoked"
+	if _, err := selfHost.Deploy(ctx); err == nil || !strings.Contains(err.Error(), "rejects the stored api key") {
+		t.Errorf("redeploy with a rejected key = %v", err)
+	}
+}
+
+func TestSelfHostDeployFailure(t *testing.T) {
+	selfHost, dockerSrv, portainerSrv := newSelfHost(t)
+	dockerSrv.OnStart = func(c *docker.Container) {
+		c.State = docker.ContainerState{Status: "exited", ExitCode: 1, Error: "bind: address already in use"}
+	}
+
+	_, err := selfHost.Deploy(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "address already in use") {
+		t.Errorf("Deploy = %v, want the container error", err)
+	}
+	if portainerSrv.AdminInitialized() {
+		t.Error("Portainer was bootstrapped although its container exited")
+	}
+}
+
+func TestSelfHostStatus(t *testing.T) {
+	selfHost, dockerSrv, _ := newSelfHost(t)
+	ctx := context.Background()
+
+	status, err := selfHost.Status(ctx)
+	if err != nil || status != nil {
+		t.Fatalf("Status before deploying = %+v, %v, want nil", status, err)
+	}
+	if _, err := selfHost.Deploy(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		state docker.ContainerState
+		want  string
+	}{
+		{docker.ContainerState{Status: "running", Running: true, Health: &docker.Health{Status: docker.HealthStarting}}, portainer.StateDeploying},
+		{docker.ContainerState{Status: "running", Running: true, Health: &docker.Health{Status: docker.HealthUnhealthy}}, portainer.StateError},
+		{docker.ContainerState{Status: "exited"}, portainer.StateStopped},
+		{docker.ContainerState{Status: "exited", ExitCode: 137}, portainer.StateError},
+		{docker.ContainerState{Status: "restarting"}, portainer.StateError},
+	}
+	for _, tt := range tests {
+		dockerSrv.SetState(portainer.SelfHostContainer, tt.state)
+		status, err := selfHost.Status(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Status != tt.want {
+			t.Errorf("Status with %+v = %q, want %q", tt.state, status.Status, tt.want)
+		}
+	}
+}
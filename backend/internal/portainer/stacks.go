@@ -0,0 +1,130 @@
+package portainer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"webtop-launcher/internal/docker"
+)
+
+// Stack types as reported by Portainer.
+const (
+	StackTypeSwarm   = 1
+	StackTypeCompose = 2
+)
+
+// Stack status values as reported by Portainer.
+const (
+	StackStatusActive   = 1
+	StackStatusInactive = 2
+)
+
+// EnvVar is a stack-level environment variable.
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Stack is a docker-compose project managed by Portainer.
+type Stack struct {
+	ID           int      `json:"Id"`
+	Name         string   `json:"Name"`
+	Type         int      `json:"Type"`
+	EndpointID   int      `json:"EndpointId"`
+	Status       int      `json:"Status"`
+	Env          []EnvVar `json:"Env"`
+	CreationDate int64    `json:"CreationDate"`
+}
+
+// CreateStackRequest describes a standalone compose stack deployed from a
+// string rather than a git repository or uploaded file.
+type CreateStackRequest struct {
+	Name             string   `json:"name"`
+	StackFileContent string   `json:"stackFileContent"`
+	Env              []EnvVar `json:"env,omitempty"`
+}
+
+// CreateStack deploys a compose stack on the given endpoint and returns it
+// once Portainer has started it.
+func (c *Client) CreateStack(ctx context.Context, endpointID int, req CreateStackRequest) (*Stack, error) {
+	var stack Stack
+	path := fmt.Sprintf("/api/stacks/create/standalone/string?endpointId=%d", endpointID)
+	if err := c.do(ctx, http.MethodPost, path, req, &stack); err != nil {
+		return nil, err
+	}
+	return &stack, nil
+}
+
+// GetStack inspects a stack by ID. It returns an error matching ErrNotFound if
+// the stack does not exist.
+func (c *Client) GetStack(ctx context.Context, id int) (*Stack, error) {
+	var stack Stack
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/stacks/%d", id), nil, &stack); err != nil {
+		return nil, err
+	}
+	return &stack, nil
+}
+
+// ListStacks returns all stacks visible to the API key.
+func (c *Client) ListStacks(ctx context.Context) ([]Stack, error) {
+	stacks := []Stack{}
+	if err := c.do(ctx, http.MethodGet, "/api/stacks", nil, &stacks); err != nil {
+		return nil, err
+	}
+	return stacks, nil
+}
+
+// DeleteStack stops and removes a stack. Named volumes are left in place;
+// use RemoveVolume to delete them explicitly.
+func (c *Client) DeleteStack(ctx context.Context, id, endpointID int) error {
+	path := fmt.Sprintf("/api/stacks/%d?endpointId=%d", id, endpointID)
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// RemoveVolume deletes a Docker volume on the endpoint through Portainer's
+// Docker API proxy.
+func (c *Client) RemoveVolume(ctx context.Context, endpointID int, name string) error {
+	path := fmt.Sprintf("/api/endpoints/%d/docker/volumes/%s", endpointID, url.PathEscape(name))
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// Volume is the subset of a Docker volume that the launcher cares about.
+type Volume struct {
+	Name   string            `json:"Name"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// ListProjectVolumes returns the volumes docker compose created for the
+// given project (stack) name on the endpoint.
+func (c *Client) ListProjectVolumes(ctx context.Context, endpointID int, project string) ([]Volume, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {docker.LabelComposeProject + "=" + project}})
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Volumes []Volume `json:"Volumes"`
+	}
+	path := fmt.Sprintf("/api/endpoints/%d/docker/volumes?filters=%s", endpointID, url.QueryEscape(string(filters)))
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Volumes, nil
+}
+
+// ListProjectContainers returns the containers of a compose project (stack)
+// on the endpoint, running or not, through Portainer's Docker API proxy.
+func (c *Client) ListProjectContainers(ctx context.Context, endpointID int, project string) ([]docker.ContainerSummary, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {docker.LabelComposeProject + "=" + project}})
+	if err != nil {
+		return nil, err
+	}
+	containers := []docker.ContainerSummary{}
+	path := fmt.Sprintf("/api/endpoints/%d/docker/containers/json?all=true&filters=%s", endpointID, url.QueryEscape(string(filters)))
+	if err := c.do(ctx, http.MethodGet, path, nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
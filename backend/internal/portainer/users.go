@@ -0,0 +1,52 @@
+package portainer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// User is a Portainer user account.
+type User struct {
+	ID       int    `json:"Id"`
+	Username string `json:"Username"`
+	Role     int    `json:"Role"`
+}
+
+// InitAdmin creates the initial administrator of a fresh Portainer instance.
+// Portainer only accepts this call once, within a few minutes of first start.
+func (c *Client) InitAdmin(ctx context.Context, username, password string) (*User, error) {
+	var u User
+	body := map[string]string{"Username": username, "Password": password}
+	if err := c.do(ctx, http.MethodPost, "/api/users/admin/init", body, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Authenticate logs in with a username and password and returns a JWT
+// suitable for Client.Token.
+func (c *Client) Authenticate(ctx context.Context, username, password string) (string, error) {
+	var resp struct {
+		JWT string `json:"jwt"`
+	}
+	body := map[string]string{"Username": username, "Password": password}
+	if err := c.do(ctx, http.MethodPost, "/api/auth", body, &resp); err != nil {
+		return "", err
+	}
+	return resp.JWT, nil
+}
+
+// CreateAPIKey creates an access token for the user and returns the raw key.
+// The client must be authenticated as that user (see Authenticate), and
+// Portainer asks for the user's password again.
+func (c *Client) CreateAPIKey(ctx context.Context, userID int, password, description string) (string, error) {
+	var resp struct {
+		RawAPIKey string `json:"rawAPIKey"`
+	}
+	body := map[string]string{"password": password, "description": description}
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/users/%d/tokens", userID), body, &resp); err != nil {
+		return "", err
+	}
+	return resp.RawAPIKey, nil
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"webtop-launcher/internal/middleware"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// TicketParam is the query parameter of the session URL that carries a
+// connect ticket.
+const TicketParam = "webtop_ticket"
+
+// A ticket only has to survive the redirect to the session origin. The
+// session cookie lasts a working day, and ends earlier with the login it
+// was issued for.
+const (
+	ticketLifetime = time.Minute
+	cookieLifetime = 12 * time.Hour
+)
+
+type connectResponse struct {
+	URL string `json:"url"`
+}
+
+// Connect answers POST /api/sessions/{id}/connect with the URL the browser
+// should open for the session. With an Origin, the URL carries a ticket
+// that the session origin trades, once, for a SessionCookie. It must be
+// behind LoginMiddleware: the cookie is revoked with the login it was
+// issued for, which API tokens do not have.
+func (p *Proxy) Connect(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+	if !validSessionID(sessionID) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	a, status, err := p.authorize(r, sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	path := Prefix + sessionID + "/"
+	if p.Origin == "" {
+		json.NewEncoder(w).Encode(connectResponse{URL: path})
+		return
+	}
+	claims, err := p.Auth.ParseToken(middleware.RequestToken(r))
+	if err != nil {
+		http.Error(w, middleware.ErrInvalidToken.Error(), http.StatusUnauthorized)
+		return
+	}
+	ticket, err := p.sign(a.userID, claims.SessionID, sessionID, middleware.ScopeSessionTicket, time.Now().Add(ticketLifetime))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(connectResponse{URL: p.Origin + path + "?" + TicketParam + "=" + url.QueryEscape(ticket)})
+}
+
+// redeem trades the ticket in uri, the URL the browser asked for, for a
+// SessionCookie, and redirects to uri without the ticket.
+func (p *Proxy) redeem(w http.ResponseWriter, r *http.Request, sessionID string, uri *url.URL) {
+	query := uri.Query()
+	claims, user, err := p.Auth.Authenticate(r.Context(), query.Get(TicketParam))
+	if err == middleware.ErrInvalidToken || err == middleware.ErrRevokedToken {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if claims.Scope != middleware.ScopeSessionTicket || claims.Audience != sessionID || !p.Tickets.Use(claims.Id, time.Unix(claims.ExpiresAt, 0)) {
+		http.Error(w, "Invalid or used session ticket", http.StatusUnauthorized)
+		return
+	}
+
+	expires := time.Now().Add(cookieLifetime)
+	token, err := p.sign(user.ID, claims.SessionID, sessionID, middleware.ScopeSession, expires)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Lax, not Strict: the browser arrives from the launcher, another site
+	// when the session origin is on a domain of its own.
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    token,
+		Path:     Prefix + sessionID + "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(p.Origin, "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	query.Del(TicketParam)
+	uri.RawQuery = query.Encode()
+	http.Redirect(w, r, uri.RequestURI(), http.StatusFound)
+}
+
+// sign issues a token of scope for the session, in the refresh token
+// family of the login it comes from.
+func (p *Proxy) sign(userID, family, sessionID, scope string, expires time.Time) (string, error) {
+	return p.Auth.SignToken(&middleware.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Subject:   userID,
+			Audience:  sessionID,
+			ExpiresAt: expires.Unix(),
+		},
+		Scope:     scope,
+		SessionID: family,
+	})
+}
+
+// OnOrigin reports whether r came to the session origin. It is always
+// false without an Origin.
+func (p *Proxy) OnOrigin(r *http.Request) bool {
+	if p.Origin == "" {
+		return false
+	}
+	u, err := url.Parse(p.Origin)
+	return err == nil && strings.EqualFold(r.Host, u.Host)
+}
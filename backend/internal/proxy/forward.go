@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Headers set on a successful forward-auth response. Proxies can copy them
+// onto the request they pass to the session (Traefik's authResponseHeaders,
+// nginx's auth_request_set).
+const (
+	HeaderUser    = "X-Webtop-User"
+	HeaderUserID  = "X-Webtop-User-Id"
+	HeaderSession = "X-Webtop-Session"
+)
+
+// ForwardAuth answers nginx auth_request and Traefik ForwardAuth checks for
+// session URLs. The original URI comes from X-Forwarded-Uri (Traefik) or
+// X-Original-URI (the usual nginx setup). It answers 200 with the X-Webtop-*
+// headers when the token in the cookie or Authorization header belongs to
+// a user authorize lets in, 401 without a valid token and 403
+// otherwise. A URI with a connect ticket is answered with the redirect
+// that sets the session cookie.
+func (p *Proxy) ForwardAuth(w http.ResponseWriter, r *http.Request) {
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.Header.Get("X-Original-URI")
+	}
+	sessionID, ok := sessionFromURI(uri)
+	if !ok {
+		http.Error(w, "Not a session URL", http.StatusForbidden)
+		return
+	}
+	// Traefik hands the redirect and its cookie back to the browser.
+	if u, _ := url.ParseRequestURI(uri); p.Origin != "" && u.Query().Has(TicketParam) {
+		p.redeem(w, r, sessionID, u)
+		return
+	}
+
+	a, status, err := p.authorize(r, sessionID)
+	if err != nil {
+		// Do not reveal whether a session exists to someone who may not
+		// see it.
+		if status == http.StatusNotFound {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set(HeaderUser, a.username)
+	w.Header().Set(HeaderUserID, a.userID)
+	w.Header().Set(HeaderSession, sessionID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// sessionFromURI extracts the session ID from a /s/{sessionId}/... request
+// URI.
+func sessionFromURI(uri string) (string, bool) {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil || !strings.HasPrefix(u.Path, Prefix) {
+		return "", false
+	}
+	sessionID, _, _ := strings.Cut(strings.TrimPrefix(u.Path, Prefix), "/")
+	if !validSessionID(sessionID) {
+		return "", false
+	}
+	return sessionID, true
+}
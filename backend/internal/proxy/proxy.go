@@ -0,0 +1,290 @@
+// Package proxy serves running sessions under /s/{sessionId}/. It looks the
+// session's container up through the orchestrator and reverse-proxies HTTP
+// and WebSocket traffic to it, letting only the session owner or users
+// allowed to connect to any session through. Sessions are best served from
+// an origin of their own, so their scripts cannot call the launcher's API;
+// see Proxy.Origin and Connect.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"webtop-launcher/internal/middleware"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/orchestrator"
+	"webtop-launcher/internal/rbac"
+	"webtop-launcher/internal/security"
+	"webtop-launcher/internal/store"
+
+	"github.com/google/uuid"
+)
+
+// Prefix is the path under which sessions are served.
+const Prefix = "/s/"
+
+// Proxy is the http.Handler for Prefix.
+type Proxy struct {
+	Sessions store.SessionStore
+	Users    store.UserStore
+	Auth     *middleware.Auth
+	// NewOrchestrator builds the driver a session was deployed with.
+	NewOrchestrator func(ctx context.Context, driver string) (orchestrator.Orchestrator, error)
+	// CacheTTL is how long a resolved container address is reused before
+	// asking the orchestrator again. Zero means 30 seconds.
+	CacheTTL time.Duration
+	// Origin, if set, is the origin sessions are served at, as
+	// scheme://host. There the proxy only accepts a SessionCookie, or an
+	// Authorization header, and never the launcher's TokenCookie.
+	Origin string
+	// Tickets makes each connect ticket single-use.
+	Tickets *security.OneTime
+
+	mu      sync.Mutex
+	targets map[string]cachedTarget
+}
+
+type cachedTarget struct {
+	url     *url.URL
+	expires time.Time
+}
+
+// session is the part of a sessions row the proxy needs.
+type session struct {
+	ownerID string
+	driver  string
+	ref     orchestrator.Ref
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, Prefix)
+	sessionID, subPath, hasSlash := strings.Cut(rest, "/")
+	if !validSessionID(sessionID) {
+		http.NotFound(w, r)
+		return
+	}
+	if !hasSlash {
+		// Relative asset URLs in the session UI only resolve under a
+		// trailing slash.
+		target := Prefix + sessionID + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+		return
+	}
+	if p.Origin != "" && r.URL.Query().Has(TicketParam) {
+		u := *r.URL
+		p.redeem(w, r, sessionID, &u)
+		return
+	}
+
+	sess, status, err := p.authorize(r, sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	target, err := p.target(r.Context(), sessionID, &sess.session)
+	if errors.Is(err, orchestrator.ErrNotFound) || errors.Is(err, orchestrator.ErrNoWebPort) {
+		http.Error(w, "Session is not running", http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		log.Printf("proxy: resolving session %s: %v", sessionID, err)
+		http.Error(w, "Could not reach the session", http.StatusBadGateway)
+		return
+	}
+
+	prefix := Prefix + sessionID
+	rp := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.URL.Path = "/" + subPath
+			req.URL.RawPath = ""
+			req.Header.Set("X-Forwarded-Prefix", prefix)
+			req.Header.Set("X-Forwarded-Host", r.Host)
+			if r.TLS != nil {
+				req.Header.Set("X-Forwarded-Proto", "https")
+			} else if req.Header.Get("X-Forwarded-Proto") == "" {
+				req.Header.Set("X-Forwarded-Proto", "http")
+			}
+			// The session container has no business seeing the launcher's
+			// credentials.
+			req.Header.Del("Authorization")
+			stripCookie(req, middleware.TokenCookie)
+			stripCookie(req, middleware.SessionCookie)
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Printf("proxy: session %s: %v", sessionID, err)
+			p.forget(sessionID)
+			http.Error(w, "Could not reach the session", http.StatusBadGateway)
+		},
+	}
+	rp.ServeHTTP(w, r)
+}
+
+// access is an authorised request for a session.
+type access struct {
+	session
+	userID   string
+	username string
+}
+
+// authorize checks that the request carries a valid token for the session's
+// owner or for a user with rbac.SessionsConnectAll. An API token of the
+// owner also needs rbac.SessionsLaunch in its scopes. On failure it returns
+// the status to answer with.
+func (p *Proxy) authorize(r *http.Request, sessionID string) (*access, int, error) {
+	ctx, user, status, err := p.authenticate(r, sessionID)
+	if err != nil {
+		return nil, status, err
+	}
+
+	sess, err := p.lookup(ctx, sessionID)
+	if err == store.ErrNotFound {
+		return nil, http.StatusNotFound, errors.New("Session not found")
+	} else if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	var perm string
+	if _, viaAPIToken := middleware.APITokenFromContext(ctx); sess.ownerID != user.ID {
+		perm = rbac.SessionsConnectAll
+	} else if viaAPIToken {
+		perm = rbac.SessionsLaunch
+	}
+	if perm != "" {
+		allowed, err := p.Auth.Can(ctx, user.ID, perm)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if !allowed {
+			return nil, http.StatusForbidden, errors.New("You are not allowed to access this session")
+		}
+	}
+	return &access{session: *sess, userID: user.ID, username: user.Username}, http.StatusOK, nil
+}
+
+// authenticate checks the JWT or API token of the request and returns its
+// user, and the request context carrying the API token if there is one.
+// Tokens restricted to a session only open that one.
+func (p *Proxy) authenticate(r *http.Request, sessionID string) (context.Context, *models.User, int, error) {
+	token := middleware.RequestToken(r)
+	if p.Origin != "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if cookie, err := r.Cookie(middleware.SessionCookie); err == nil && token == "" {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return nil, nil, http.StatusUnauthorized, errors.New("Authentication required")
+	}
+	if middleware.IsAPIToken(token) {
+		apiToken, user, err := p.Auth.AuthenticateAPIToken(r.Context(), token)
+		if err == middleware.ErrInvalidToken {
+			return nil, nil, http.StatusUnauthorized, err
+		} else if err != nil {
+			return nil, nil, http.StatusInternalServerError, err
+		}
+		return middleware.WithAPIToken(r.Context(), apiToken), user, http.StatusOK, nil
+	}
+	claims, user, err := p.Auth.Authenticate(r.Context(), token)
+	if err == middleware.ErrInvalidToken || err == middleware.ErrRevokedToken {
+		return nil, nil, http.StatusUnauthorized, err
+	} else if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
+	if claims.Scope == middleware.ScopeSession && claims.Audience == sessionID {
+		return r.Context(), user, http.StatusOK, nil
+	}
+	if claims.Scope != "" {
+		return nil, nil, http.StatusForbidden, errors.New(middleware.ScopeError(claims.Scope))
+	}
+	return r.Context(), user, http.StatusOK, nil
+}
+
+// lookup loads the session's owner and stack reference. Sessions from
+// before drivers were pluggable only have portainer_stack_id.
+func (p *Proxy) lookup(ctx context.Context, sessionID string) (*session, error) {
+	s, err := p.Sessions.Get(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	sess := &session{
+		ownerID: s.UserID,
+		driver:  s.Orchestrator,
+		ref:     orchestrator.Ref{ID: s.StackID, Name: s.StackName},
+	}
+	if sess.ref.ID == "" && s.PortainerStackID != 0 {
+		sess.ref.ID = strconv.Itoa(s.PortainerStackID)
+	}
+	return sess, nil
+}
+
+// target returns the URL of the session's container, from the cache when
+// possible.
+func (p *Proxy) target(ctx context.Context, sessionID string, sess *session) (*url.URL, error) {
+	p.mu.Lock()
+	cached, ok := p.targets[sessionID]
+	p.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.url, nil
+	}
+
+	orch, err := p.NewOrchestrator(ctx, sess.driver)
+	if err != nil {
+		return nil, err
+	}
+	stack, err := orch.InspectStack(ctx, sess.ref)
+	if err != nil {
+		return nil, err
+	}
+	u, err := stack.WebURL()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := p.CacheTTL
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	p.mu.Lock()
+	if p.targets == nil {
+		p.targets = map[string]cachedTarget{}
+	}
+	p.targets[sessionID] = cachedTarget{url: u, expires: time.Now().Add(ttl)}
+	p.mu.Unlock()
+	return u, nil
+}
+
+func (p *Proxy) forget(sessionID string) {
+	p.mu.Lock()
+	delete(p.targets, sessionID)
+	p.mu.Unlock()
+}
+
+// validSessionID reports whether id looks like a session ID, so that
+// arbitrary strings never reach the uuid column.
+func validSessionID(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil && len(id) == 36
+}
+
+// stripCookie removes the named cookie from the request's Cookie headers.
+func stripCookie(req *http.Request, name string) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != name {
+			req.AddCookie(c)
+		}
+	}
+}
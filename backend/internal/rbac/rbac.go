@@ -0,0 +1,85 @@
+// Package rbac defines the permissions the API checks and the built-in
+// roles that bundle them. Roles are stored by the store package; a user's
+// permissions are the union of those of their roles.
+package rbac
+
+// Permissions, named resource:action. Each route of the API requires one.
+const (
+	// AppsRead lists the enabled applications to launch.
+	AppsRead = "apps:read"
+	// SessionsLaunch starts sessions and lists and stops one's own.
+	SessionsLaunch = "sessions:launch"
+	// SessionsReadAll lists the sessions of every user.
+	SessionsReadAll = "sessions:read_all"
+	// SessionsStopAll stops the sessions of every user.
+	SessionsStopAll = "sessions:stop_all"
+	// SessionsConnectAll opens the sessions of every user through the
+	// session proxy.
+	SessionsConnectAll = "sessions:connect_all"
+	// AppsManage edits the application catalogue. Compose files with
+	// access to the Docker host also need PortainerManage.
+	AppsManage = "apps:manage"
+	// UsersManage creates, edits and deletes users.
+	UsersManage = "users:manage"
+	// GroupsManage edits user groups, their members and the applications
+	// they are entitled to.
+	GroupsManage = "groups:manage"
+	// RolesManage edits roles and assigns them to users, including the
+	// admin role.
+	RolesManage = "roles:manage"
+	// PortainerManage configures and deploys Portainer.
+	PortainerManage = "portainer:manage"
+	// SecurityManage edits the login protection, authenticator and
+	// two-factor settings.
+	SecurityManage = "security:manage"
+)
+
+// All lists every permission, in the order the UI shows them.
+var All = []string{
+	AppsRead,
+	SessionsLaunch,
+	SessionsReadAll,
+	SessionsStopAll,
+	SessionsConnectAll,
+	AppsManage,
+	UsersManage,
+	GroupsManage,
+	RolesManage,
+	PortainerManage,
+	SecurityManage,
+}
+
+// Valid reports whether perm is a known permission.
+func Valid(perm string) bool {
+	for _, p := range All {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Names of the built-in roles. Admin is the role of users with is_admin
+// set, and every new user gets User.
+const (
+	Admin         = "admin"
+	Operator      = "operator"
+	CatalogEditor = "catalog-editor"
+	User          = "user"
+)
+
+// Role is the definition of a built-in role.
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// Builtin lists the built-in roles. They cannot be changed or deleted; the
+// SQL migration that creates the roles table inserts the same ones.
+var Builtin = []Role{
+	{Admin, "Full access", All},
+	{Operator, "Monitors and stops the sessions of all users", []string{AppsRead, SessionsLaunch, SessionsReadAll, SessionsStopAll}},
+	{CatalogEditor, "Edits the application catalogue", []string{AppsRead, SessionsLaunch, AppsManage}},
+	{User, "Launches applications", []string{AppsRead, SessionsLaunch}},
+}
@@ -0,0 +1,70 @@
+// Package secrets encrypts values the launcher has to store and read back,
+// such as TOTP secrets, with AES-256-GCM.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// prefix versions the format, so the key or cipher can change later.
+const prefix = "v1:"
+
+// ErrDecrypt is returned for values that were not encrypted with the key
+// or were tampered with.
+var ErrDecrypt = errors.New("secrets: cannot decrypt value")
+
+// Box encrypts and decrypts with one key.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// NewBox returns a Box for key, which may be any passphrase: the AES key
+// is its SHA-256.
+func NewBox(key string) (*Box, error) {
+	if key == "" {
+		return nil, errors.New("secrets: empty key")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Encrypt returns plaintext encrypted under a random nonce, as text.
+func (b *Box) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return prefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt.
+func (b *Box) Decrypt(ciphertext string) (string, error) {
+	if !strings.HasPrefix(ciphertext, prefix) {
+		return "", ErrDecrypt
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(ciphertext, prefix))
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	nonce, sealed := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plaintext), nil
+}
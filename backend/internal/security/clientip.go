@@ -0,0 +1,68 @@
+package security
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPResolver finds the address of the client behind trusted reverse
+// proxies.
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+// NewClientIPResolver trusts the forwarding headers of requests coming from
+// the given CIDRs. With none, every request's client is its peer address.
+func NewClientIPResolver(cidrs []string) (*ClientIPResolver, error) {
+	c := &ClientIPResolver{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		c.trusted = append(c.trusted, network)
+	}
+	return c, nil
+}
+
+func (c *ClientIPResolver) isTrusted(ip net.IP) bool {
+	for _, network := range c.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the request's client address. X-Real-IP and
+// X-Forwarded-For are only believed when the connection comes from a
+// trusted proxy; in X-Forwarded-For the rightmost untrusted address wins,
+// as everything left of it may have been made up by the client.
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if remote == nil || !c.isTrusted(remote) {
+		return host
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			if !c.isTrusted(ip) || i == 0 {
+				return ip.String()
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
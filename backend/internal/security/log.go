@@ -0,0 +1,79 @@
+// Package security holds the pieces of login protection that are not tied
+// to a handler: the security event log, client address resolution behind
+// proxies and the in-memory login throttle.
+package security
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	LoginSucceeded  = "login.succeeded"
+	LoginFailed     = "login.failed"
+	LoginThrottled  = "login.throttled"
+	LoginLocked     = "login.locked"
+	AccountLocked   = "account.locked"
+	AccountUnlocked = "account.unlocked"
+	PasswordChanged = "password.changed"
+	PasswordReset   = "password.reset"
+	Logout          = "logout"
+	TokenReused     = "token.reused"
+	SessionsRevoked = "sessions.revoked"
+	UserProvisioned = "user.provisioned"
+	MFAEnabled      = "mfa.enabled"
+	MFADisabled     = "mfa.disabled"
+	MFAFailed       = "mfa.failed"
+	MFAReset        = "mfa.reset"
+	RecoveryUsed    = "mfa.recovery_code_used"
+	PasskeyAdded    = "passkey.registered"
+	PasskeyRemoved  = "passkey.removed"
+)
+
+// Event is one entry of the security log. It never contains passwords or
+// hashes.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"event"`
+	Username string    `json:"username,omitempty"`
+	UserID   string    `json:"userId,omitempty"`
+	// ActorID is the admin acting on the user, if it is not the user.
+	ActorID  string `json:"actorId,omitempty"`
+	ClientIP string `json:"clientIp,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+var (
+	mu     sync.Mutex
+	output io.Writer = os.Stderr
+)
+
+// SetOutput sets where events are written, one JSON object per line. The
+// default is standard error.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = w
+}
+
+// Log writes e to the security log, stamping it with the current time.
+func Log(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Could not encode security event %s: %v", e.Type, err)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, err := output.Write(append(line, '\n')); err != nil {
+		log.Printf("Could not write security event %s: %v", e.Type, err)
+	}
+}
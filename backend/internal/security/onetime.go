@@ -0,0 +1,49 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+// OneTime remembers values that may be used once, such as the challenges
+// of WebAuthn ceremonies, until they expire and can no longer be used
+// anyway. Like Throttle it lives in memory, so it only stops a replay
+// against the replica that saw the first use.
+type OneTime struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+// NewOneTime returns an empty OneTime.
+func NewOneTime() *OneTime {
+	return &OneTime{expires: map[string]time.Time{}}
+}
+
+// Use records key, valid until expires, and reports whether it was not
+// used before. When maxEntries keys are still valid, it refuses new ones:
+// forgetting a valid key would let it be used again.
+func (o *OneTime) Use(key string, expires time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	if until, ok := o.expires[key]; ok && now.Before(until) {
+		return false
+	}
+	if len(o.expires) >= maxEntries {
+		o.prune(now)
+		if len(o.expires) >= maxEntries {
+			return false
+		}
+	}
+	o.expires[key] = expires
+	return true
+}
+
+// prune drops keys that have expired.
+func (o *OneTime) prune(now time.Time) {
+	for key, until := range o.expires {
+		if !now.Before(until) {
+			delete(o.expires, key)
+		}
+	}
+}
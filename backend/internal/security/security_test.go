@@ -0,0 +1,167 @@
+package security
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottleBackoff(t *testing.T) {
+	th := NewThrottle()
+	for _, tt := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{11, 128 * time.Second},
+		{12, 256 * time.Second},
+		{13, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	} {
+		if got := th.delay(tt.failures); got != tt.want {
+			t.Errorf("delay after %d failures = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestThrottleWait(t *testing.T) {
+	th := NewThrottle()
+	// The free failures do not make anyone wait.
+	for i := 0; i < th.Free; i++ {
+		th.Fail("user:alice")
+		if wait := th.Wait("user:alice"); wait != 0 {
+			t.Fatalf("wait after %d failures = %s", i+1, wait)
+		}
+	}
+	th.Fail("user:alice")
+	if wait := th.Wait("user:alice"); wait <= 0 || wait > th.Base {
+		t.Errorf("wait after the first failure beyond the free ones = %s", wait)
+	}
+	if wait := th.Wait("user:bob"); wait != 0 {
+		t.Errorf("another key waits %s", wait)
+	}
+
+	th.Reset("user:alice")
+	if wait := th.Wait("user:alice"); wait != 0 {
+		t.Errorf("wait after a reset = %s", wait)
+	}
+
+	// Failures older than Forget start over.
+	for i := 0; i <= th.Free; i++ {
+		th.Fail("ip:192.0.2.1")
+	}
+	th.entries["ip:192.0.2.1"].last = time.Now().Add(-th.Forget - time.Second)
+	th.Fail("ip:192.0.2.1")
+	if n := th.entries["ip:192.0.2.1"].failures; n != 1 {
+		t.Errorf("failures after Forget = %d, want 1", n)
+	}
+}
+
+func TestThrottleMaxEntries(t *testing.T) {
+	th := NewThrottle()
+	for i := 0; i < maxEntries+10; i++ {
+		th.Fail(fmt.Sprintf("ip:%d", i))
+		if len(th.entries) > maxEntries {
+			t.Fatalf("%d entries after %d keys", len(th.entries), i+1)
+		}
+		if i == 0 {
+			th.entries["ip:0"].last = time.Now().Add(-time.Minute)
+		}
+	}
+	if _, ok := th.entries["ip:0"]; ok {
+		t.Error("the oldest key was kept")
+	}
+	if _, ok := th.entries[fmt.Sprintf("ip:%d", maxEntries+9)]; !ok {
+		t.Error("the newest key was dropped")
+	}
+}
+
+func TestOneTime(t *testing.T) {
+	o := NewOneTime()
+	expires := time.Now().Add(time.Minute)
+	if !o.Use("a", expires) {
+		t.Fatal("first use refused")
+	}
+	if o.Use("a", expires) {
+		t.Error("second use accepted")
+	}
+	o.Use("b", time.Now().Add(-time.Second))
+	if !o.Use("b", expires) {
+		t.Error("an expired key is still refused")
+	}
+}
+
+func TestOneTimeMaxEntries(t *testing.T) {
+	o := NewOneTime()
+	expires := time.Now().Add(time.Minute)
+	for i := 0; i < maxEntries; i++ {
+		o.Use(fmt.Sprint(i), expires)
+	}
+	// Every key is still valid, so none can make room.
+	if o.Use("new", expires) {
+		t.Error("a key beyond maxEntries was accepted")
+	}
+	if len(o.expires) > maxEntries {
+		t.Errorf("%d keys, want at most %d", len(o.expires), maxEntries)
+	}
+	if o.Use("0", expires) {
+		t.Error("a used key was forgotten")
+	}
+
+	// Expired keys make room.
+	o.expires["0"] = time.Now().Add(-time.Second)
+	if !o.Use("new", expires) {
+		t.Error("no room after a key expired")
+	}
+	if len(o.expires) > maxEntries {
+		t.Errorf("%d keys, want at most %d", len(o.expires), maxEntries)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+	}{
+		{"no proxies trusted", nil, "10.0.0.2:1234", "198.51.100.7", "198.51.100.8", "10.0.0.2"},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "203.0.113.9:1234", "198.51.100.7", "198.51.100.8", "203.0.113.9"},
+		{"trusted peer", []string{"10.0.0.0/8"}, "10.0.0.2:1234", "198.51.100.7", "", "198.51.100.7"},
+		{"made-up hops", []string{"10.0.0.0/8"}, "10.0.0.2:1234", "192.0.2.66, 198.51.100.7", "", "198.51.100.7"},
+		{"trusted hops", []string{"10.0.0.0/8"}, "10.0.0.2:1234", "198.51.100.7, 10.0.0.3", "", "198.51.100.7"},
+		{"only trusted hops", []string{"10.0.0.0/8"}, "10.0.0.2:1234", "10.0.0.4, 10.0.0.3", "", "10.0.0.4"},
+		{"X-Real-IP", []string{"10.0.0.0/8"}, "10.0.0.2:1234", "", "198.51.100.8", "198.51.100.8"},
+		{"garbage", []string{"10.0.0.0/8"}, "10.0.0.2:1234", "not-an-ip", "", "10.0.0.2"},
+		{"single address", []string{"10.0.0.2/32"}, "10.0.0.3:1234", "198.51.100.7", "", "10.0.0.3"},
+		{"IPv6", []string{"fd00::/8"}, "[fd00::2]:1234", "2001:db8::7", "", "2001:db8::7"},
+	}
+	for _, tt := range tests {
+		c, err := NewClientIPResolver(tt.trusted)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if tt.realIP != "" {
+			req.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := c.ClientIP(req); got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := NewClientIPResolver([]string{"10.0.0.0"}); err == nil {
+		t.Error("an address without a prefix length was accepted")
+	}
+}
@@ -0,0 +1,122 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+// Throttle slows down repeated login failures for a key, a username or a
+// client address, with exponential backoff. It lives in memory, so counts
+// are per replica and start over when the backend restarts; the account
+// lockout stored with the user is what survives both.
+type Throttle struct {
+	// Free is the number of failures allowed before backoff starts.
+	Free int
+	// Base is the wait after the first failure beyond Free. Each further
+	// failure doubles it, up to Max.
+	Base, Max time.Duration
+	// Forget drops a key this long after its last failure.
+	Forget time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*throttleEntry
+}
+
+type throttleEntry struct {
+	failures int
+	last     time.Time
+}
+
+// maxEntries bounds the memory a flood of distinct keys can take.
+const maxEntries = 10000
+
+// NewThrottle returns a throttle that allows three failures, then waits 1s,
+// 2s, 4s, ... up to five minutes between attempts.
+func NewThrottle() *Throttle {
+	return &Throttle{
+		Free:    3,
+		Base:    time.Second,
+		Max:     5 * time.Minute,
+		Forget:  time.Hour,
+		entries: map[string]*throttleEntry{},
+	}
+}
+
+// Wait returns how long key has to wait before its next attempt, or zero.
+func (t *Throttle) Wait(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key]
+	if !ok {
+		return 0
+	}
+	wait := time.Until(e.last.Add(t.delay(e.failures)))
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Fail records a failed attempt for key.
+func (t *Throttle) Fail(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.last) > t.Forget {
+		if len(t.entries) >= maxEntries {
+			t.prune(now)
+			if len(t.entries) >= maxEntries {
+				t.evictOldest()
+			}
+		}
+		e = &throttleEntry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+}
+
+// Reset forgets the failures of key.
+func (t *Throttle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+func (t *Throttle) delay(failures int) time.Duration {
+	n := failures - t.Free
+	if n <= 0 {
+		return 0
+	}
+	d := t.Base
+	for i := 1; i < n && d < t.Max; i++ {
+		d *= 2
+	}
+	if d > t.Max {
+		d = t.Max
+	}
+	return d
+}
+
+// prune drops entries whose last failure is older than Forget.
+func (t *Throttle) prune(now time.Time) {
+	for key, e := range t.entries {
+		if now.Sub(e.last) > t.Forget {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// evictOldest drops the entry whose last failure is the oldest, to make
+// room when no entry is stale yet.
+func (t *Throttle) evictOldest() {
+	var oldest string
+	var last time.Time
+	for key, e := range t.entries {
+		if last.IsZero() || e.last.Before(last) {
+			oldest, last = key, e.last
+		}
+	}
+	delete(t.entries, oldest)
+}
@@ -0,0 +1,1075 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/rbac"
+
+	"github.com/google/uuid"
+)
+
+// NewMemory returns empty stores that keep everything in memory. They
+// behave like the SQL stores, including ErrNotFound, ErrConflict and
+// deleting a user's sessions with the user, and are meant for tests.
+func NewMemory() *Stores {
+	m := &memory{
+		users:     map[string]models.User{},
+		apps:      map[string]models.Application{},
+		sessions:  map[string]models.Session{},
+		settings:  map[string]string{},
+		tokens:    map[string]models.RefreshToken{},
+		recovery:  map[string]map[string]bool{},
+		creds:     map[string]models.WebAuthnCredential{},
+		roles:     map[string]models.Role{},
+		grants:    map[string]map[string]bool{},
+		groups:    map[string]models.Group{},
+		apiTokens: map[string]models.APIToken{},
+	}
+	for _, r := range rbac.Builtin {
+		id := uuid.New().String()
+		m.roles[id] = models.Role{
+			ID:          id,
+			Name:        r.Name,
+			Description: r.Description,
+			Permissions: append([]string{}, r.Permissions...),
+			Builtin:     true,
+			CreatedAt:   time.Now(),
+		}
+	}
+	return &Stores{
+		Users:       (*memUsers)(m),
+		Apps:        (*memApps)(m),
+		Sessions:    (*memSessions)(m),
+		Settings:    (*memSettings)(m),
+		Tokens:      (*memTokens)(m),
+		Credentials: (*memCredentials)(m),
+		Roles:       (*memRoles)(m),
+		Groups:      (*memGroups)(m),
+		APITokens:   (*memAPITokens)(m),
+	}
+}
+
+// memory holds the data of all the stores behind one lock, so cascading
+// deletes stay consistent.
+type memory struct {
+	mu       sync.Mutex
+	users    map[string]models.User
+	apps     map[string]models.Application
+	sessions map[string]models.Session
+	settings map[string]string
+	tokens   map[string]models.RefreshToken
+	// recovery maps user IDs to their recovery code hashes and whether
+	// each has been used.
+	recovery map[string]map[string]bool
+	creds    map[string]models.WebAuthnCredential
+	roles    map[string]models.Role
+	// grants maps user IDs to the IDs of their roles.
+	grants    map[string]map[string]bool
+	groups    map[string]models.Group
+	apiTokens map[string]models.APIToken
+}
+
+// setRole grants or takes the role named name from the user. The caller
+// holds the lock.
+func (m *memory) setRole(userID, name string, granted bool) {
+	for id, r := range m.roles {
+		if r.Name != name {
+			continue
+		}
+		if m.grants[userID] == nil {
+			m.grants[userID] = map[string]bool{}
+		}
+		if granted {
+			m.grants[userID][id] = true
+		} else {
+			delete(m.grants[userID], id)
+		}
+	}
+}
+
+type memUsers memory
+
+func (s *memUsers) List(ctx context.Context) ([]models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := []models.User{}
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (s *memUsers) Get(ctx context.Context, id string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+func (s *memUsers) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == username {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memUsers) GetByExternalID(ctx context.Context, provider, externalID string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.AuthProvider == provider && u.ExternalID == externalID && externalID != "" {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memUsers) Create(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user.AuthProvider == "" {
+		user.AuthProvider = models.AuthLocal
+	}
+	for _, u := range s.users {
+		if u.Username == user.Username || u.ID == user.ID ||
+			(user.ExternalID != "" && u.AuthProvider == user.AuthProvider && u.ExternalID == user.ExternalID) {
+			return ErrConflict
+		}
+	}
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	user.CreatedAt = time.Now()
+	s.users[user.ID] = *user
+	(*memory)(s).setRole(user.ID, rbac.User, true)
+	(*memory)(s).setRole(user.ID, rbac.Admin, user.IsAdmin)
+	return nil
+}
+
+// otherAdminExists reports whether a user other than id is an admin. The
+// caller holds the lock.
+func (s *memUsers) otherAdminExists(id string) bool {
+	for _, u := range s.users {
+		if u.IsAdmin && u.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memUsers) Update(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, other := range s.users {
+		if other.Username == user.Username && other.ID != user.ID {
+			return ErrConflict
+		}
+	}
+	if u.IsAdmin && !user.IsAdmin && !s.otherAdminExists(u.ID) {
+		return ErrLastAdmin
+	}
+	u.Username = user.Username
+	u.IsAdmin = user.IsAdmin
+	s.users[u.ID] = u
+	(*memory)(s).setRole(u.ID, rbac.Admin, u.IsAdmin)
+	return nil
+}
+
+func (s *memUsers) UpdatePassword(ctx context.Context, id, passwordHash string, mustChange bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.PasswordHash = passwordHash
+	u.MustChangePassword = mustChange
+	s.users[id] = u
+	return nil
+}
+
+func (s *memUsers) RecordLoginFailure(ctx context.Context, id string, threshold int, lockUntil time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return false, ErrNotFound
+	}
+	u.FailedLogins++
+	locked := u.FailedLogins >= threshold
+	if locked {
+		u.FailedLogins = 0
+		lockUntil = lockUntil.UTC()
+		u.LockedUntil = &lockUntil
+	}
+	s.users[id] = u
+	return locked, nil
+}
+
+func (s *memUsers) ClearLoginFailures(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.FailedLogins = 0
+	u.LockedUntil = nil
+	s.users[id] = u
+	return nil
+}
+
+func (s *memUsers) SetTOTPSecret(ctx context.Context, id, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep = secret, false, 0
+	s.users[id] = u
+	return nil
+}
+
+func (s *memUsers) EnableTOTP(ctx context.Context, id string, step int64, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok || u.TOTPSecret == "" {
+		return ErrNotFound
+	}
+	u.TOTPEnabled, u.TOTPLastStep = true, step
+	s.users[id] = u
+	s.replaceRecoveryCodes(id, recoveryCodeHashes)
+	return nil
+}
+
+func (s *memUsers) DisableTOTP(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep = "", false, 0
+	s.users[id] = u
+	delete(s.recovery, id)
+	return nil
+}
+
+func (s *memUsers) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if step <= u.TOTPLastStep {
+		return ErrConflict
+	}
+	u.TOTPLastStep = step
+	s.users[id] = u
+	return nil
+}
+
+func (s *memUsers) ReplaceRecoveryCodes(ctx context.Context, id string, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[id]; !ok {
+		return ErrNotFound
+	}
+	s.replaceRecoveryCodes(id, hashes)
+	return nil
+}
+
+// replaceRecoveryCodes stores new recovery codes for the user. The caller
+// holds the lock.
+func (s *memUsers) replaceRecoveryCodes(id string, hashes []string) {
+	codes := map[string]bool{}
+	for _, hash := range hashes {
+		codes[hash] = false
+	}
+	s.recovery[id] = codes
+}
+
+func (s *memUsers) UseRecoveryCode(ctx context.Context, id, hash string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	used, ok := s.recovery[id][hash]
+	if !ok || used {
+		return ErrNotFound
+	}
+	s.recovery[id][hash] = true
+	return nil
+}
+
+func (s *memUsers) CountRecoveryCodes(ctx context.Context, id string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, used := range s.recovery[id] {
+		if !used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memUsers) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if u.IsAdmin && !s.otherAdminExists(id) {
+		return ErrLastAdmin
+	}
+	delete(s.users, id)
+	for sid, sess := range s.sessions {
+		if sess.UserID == id {
+			delete(s.sessions, sid)
+		}
+	}
+	for tid, t := range s.tokens {
+		if t.UserID == id {
+			delete(s.tokens, tid)
+		}
+	}
+	delete(s.recovery, id)
+	delete(s.grants, id)
+	for tid, t := range s.apiTokens {
+		if t.UserID == id {
+			delete(s.apiTokens, tid)
+		}
+	}
+	for gid, g := range s.groups {
+		g.UserIDs = without(g.UserIDs, id)
+		s.groups[gid] = g
+	}
+	for cid, c := range s.creds {
+		if c.UserID == id {
+			delete(s.creds, cid)
+		}
+	}
+	return nil
+}
+
+type memApps memory
+
+func (s *memApps) List(ctx context.Context) ([]models.Application, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apps := []models.Application{}
+	for _, a := range s.apps {
+		apps = append(apps, a)
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+	return apps, nil
+}
+
+func (s *memApps) ListEntitled(ctx context.Context, userID string) ([]models.Application, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apps := []models.Application{}
+	for _, a := range s.apps {
+		if a.IsEnabled && s.entitled(userID, a.ID) {
+			apps = append(apps, a)
+		}
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+	return apps, nil
+}
+
+func (s *memApps) Entitled(ctx context.Context, userID, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.apps[id]; !ok {
+		return false, nil
+	}
+	return s.entitled(userID, id), nil
+}
+
+// entitled reports whether the application is in no group or in one the
+// user is a member of. The caller holds the lock.
+func (s *memApps) entitled(userID, appID string) bool {
+	restricted := false
+	for _, g := range s.groups {
+		if contains(g.ApplicationIDs, appID) {
+			if contains(g.UserIDs, userID) {
+				return true
+			}
+			restricted = true
+		}
+	}
+	return !restricted
+}
+
+func (s *memApps) Get(ctx context.Context, id string) (*models.Application, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.apps[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &a, nil
+}
+
+func (s *memApps) Create(ctx context.Context, app *models.Application) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range s.apps {
+		if a.Name == app.Name || a.ID == app.ID {
+			return ErrConflict
+		}
+	}
+	if app.ID == "" {
+		app.ID = uuid.New().String()
+	}
+	app.CreatedAt = time.Now()
+	s.apps[app.ID] = *app
+	return nil
+}
+
+func (s *memApps) Update(ctx context.Context, app *models.Application) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.apps[app.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, a := range s.apps {
+		if a.Name == app.Name && a.ID != app.ID {
+			return ErrConflict
+		}
+	}
+	updated := *app
+	updated.CreatedAt = current.CreatedAt
+	s.apps[app.ID] = updated
+	return nil
+}
+
+type memSessions memory
+
+func (s *memSessions) list(keep func(models.Session) bool) []models.Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := []models.Session{}
+	for _, sess := range s.sessions {
+		if keep(sess) {
+			sessions = append(sessions, sess)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
+
+func (s *memSessions) List(ctx context.Context) ([]models.Session, error) {
+	return s.list(func(models.Session) bool { return true }), nil
+}
+
+func (s *memSessions) ListByUser(ctx context.Context, userID string) ([]models.Session, error) {
+	return s.list(func(sess models.Session) bool { return sess.UserID == userID }), nil
+}
+
+func (s *memSessions) Get(ctx context.Context, id string) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &sess, nil
+}
+
+func (s *memSessions) Create(ctx context.Context, session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session.ID == "" {
+		session.ID = uuid.New().String()
+	}
+	if _, ok := s.sessions[session.ID]; ok {
+		return ErrConflict
+	}
+	session.CreatedAt = time.Now()
+	s.sessions[session.ID] = *session
+	return nil
+}
+
+func (s *memSessions) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+type memSettings memory
+
+func (s *memSettings) Get(ctx context.Context, keys ...string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := map[string]string{}
+	for _, k := range keys {
+		if v, ok := s.settings[k]; ok {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+func (s *memSettings) Set(ctx context.Context, values map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range values {
+		s.settings[k] = v
+	}
+	return nil
+}
+
+type memTokens memory
+
+func (s *memTokens) Create(ctx context.Context, token *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.create(token)
+}
+
+// create inserts token. The caller holds the lock.
+func (s *memTokens) create(token *models.RefreshToken) error {
+	if _, ok := s.users[token.UserID]; !ok {
+		return ErrNotFound
+	}
+	for _, t := range s.tokens {
+		if t.TokenHash == token.TokenHash || t.ID == token.ID {
+			return ErrConflict
+		}
+	}
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+	token.CreatedAt = time.Now()
+	s.tokens[token.ID] = *token
+	return nil
+}
+
+func (s *memTokens) GetByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.TokenHash == hash {
+			return &t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memTokens) Rotate(ctx context.Context, oldID string, next *models.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.tokens[oldID]
+	if !ok || old.UsedAt != nil || old.RevokedAt != nil {
+		return ErrConflict
+	}
+	if err := s.create(next); err != nil {
+		return err
+	}
+	now := time.Now()
+	old.UsedAt = &now
+	s.tokens[oldID] = old
+	return nil
+}
+
+func (s *memTokens) FamilyActive(ctx context.Context, familyID string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.FamilyID == familyID && t.RevokedAt == nil && t.ExpiresAt.After(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// revoke revokes the unrevoked tokens match selects.
+func (s *memTokens) revoke(now time.Time, match func(models.RefreshToken) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.tokens {
+		if t.RevokedAt == nil && match(t) {
+			t.RevokedAt = &now
+			s.tokens[id] = t
+		}
+	}
+}
+
+func (s *memTokens) RevokeFamily(ctx context.Context, familyID string, now time.Time) error {
+	s.revoke(now, func(t models.RefreshToken) bool { return t.FamilyID == familyID })
+	return nil
+}
+
+func (s *memTokens) RevokeUser(ctx context.Context, userID string, now time.Time) error {
+	s.revoke(now, func(t models.RefreshToken) bool { return t.UserID == userID })
+	return nil
+}
+
+func (s *memTokens) DeleteExpired(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.tokens {
+		if t.ExpiresAt.Before(before) {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+type memCredentials memory
+
+func (s *memCredentials) ListByUser(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds := []models.WebAuthnCredential{}
+	for _, c := range s.creds {
+		if c.UserID == userID {
+			creds = append(creds, c)
+		}
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].CreatedAt.Before(creds[j].CreatedAt) })
+	return creds, nil
+}
+
+func (s *memCredentials) GetByCredentialID(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.creds {
+		if bytes.Equal(c.CredentialID, credentialID) {
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memCredentials) Create(ctx context.Context, cred *models.WebAuthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[cred.UserID]; !ok {
+		return ErrNotFound
+	}
+	for _, c := range s.creds {
+		if bytes.Equal(c.CredentialID, cred.CredentialID) || c.ID == cred.ID {
+			return ErrConflict
+		}
+	}
+	if cred.ID == "" {
+		cred.ID = uuid.New().String()
+	}
+	cred.CreatedAt = time.Now()
+	s.creds[cred.ID] = *cred
+	return nil
+}
+
+func (s *memCredentials) UpdateSignCount(ctx context.Context, id string, signCount uint32, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.creds[id]
+	if !ok {
+		return ErrNotFound
+	}
+	c.SignCount = signCount
+	c.LastUsedAt = &usedAt
+	s.creds[id] = c
+	return nil
+}
+
+func (s *memCredentials) Delete(ctx context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.creds[id]; !ok || c.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.creds, id)
+	return nil
+}
+
+type memAPITokens memory
+
+// copyAPIToken returns t with its own copy of the scopes.
+func copyAPIToken(t models.APIToken) models.APIToken {
+	t.Scopes = append([]string{}, t.Scopes...)
+	return t
+}
+
+func (s *memAPITokens) Create(ctx context.Context, token *models.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[token.UserID]; !ok {
+		return ErrNotFound
+	}
+	for _, t := range s.apiTokens {
+		if t.TokenHash == token.TokenHash || t.ID == token.ID {
+			return ErrConflict
+		}
+	}
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+	token.CreatedAt = time.Now()
+	s.apiTokens[token.ID] = copyAPIToken(*token)
+	return nil
+}
+
+func (s *memAPITokens) ListByUser(ctx context.Context, userID string) ([]models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := []models.APIToken{}
+	for _, t := range s.apiTokens {
+		if t.UserID == userID {
+			tokens = append(tokens, copyAPIToken(t))
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (s *memAPITokens) GetByHash(ctx context.Context, hash string) (*models.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.apiTokens {
+		if t.TokenHash == hash {
+			t = copyAPIToken(t)
+			return &t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memAPITokens) Touch(ctx context.Context, id string, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.apiTokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	t.LastUsedAt = &usedAt
+	s.apiTokens[id] = t
+	return nil
+}
+
+func (s *memAPITokens) Delete(ctx context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.apiTokens[id]; !ok || t.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.apiTokens, id)
+	return nil
+}
+
+type memRoles memory
+
+func (s *memRoles) List(ctx context.Context) ([]models.Role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roles := []models.Role{}
+	for _, r := range s.roles {
+		roles = append(roles, copyRole(r))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+// copyRole returns r with its own sorted copy of the permissions.
+func copyRole(r models.Role) models.Role {
+	r.Permissions = append([]string{}, r.Permissions...)
+	sort.Strings(r.Permissions)
+	return r
+}
+
+func (s *memRoles) Get(ctx context.Context, id string) (*models.Role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.roles[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	r = copyRole(r)
+	return &r, nil
+}
+
+func (s *memRoles) Create(ctx context.Context, role *models.Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.roles {
+		if r.Name == role.Name || r.ID == role.ID {
+			return ErrConflict
+		}
+	}
+	if role.ID == "" {
+		role.ID = uuid.New().String()
+	}
+	role.CreatedAt = time.Now()
+	s.roles[role.ID] = copyRole(*role)
+	return nil
+}
+
+func (s *memRoles) Update(ctx context.Context, role *models.Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.roles[role.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, r := range s.roles {
+		if r.Name == role.Name && r.ID != role.ID {
+			return ErrConflict
+		}
+	}
+	current.Name = role.Name
+	current.Description = role.Description
+	current.Permissions = role.Permissions
+	s.roles[role.ID] = copyRole(current)
+	return nil
+}
+
+func (s *memRoles) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.roles[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.roles, id)
+	for _, roles := range s.grants {
+		delete(roles, id)
+	}
+	return nil
+}
+
+func (s *memRoles) ListAssignments(ctx context.Context) (map[string][]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assignments := map[string][]string{}
+	for userID, roles := range s.grants {
+		for id := range roles {
+			assignments[userID] = append(assignments[userID], id)
+		}
+		sort.Strings(assignments[userID])
+	}
+	return assignments, nil
+}
+
+func (s *memRoles) SetUserRoles(ctx context.Context, userID string, roleIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	roles := map[string]bool{}
+	admin := false
+	for _, id := range roleIDs {
+		r, ok := s.roles[id]
+		if !ok {
+			return ErrNotFound
+		}
+		roles[id] = true
+		admin = admin || r.Name == rbac.Admin
+	}
+	if u.IsAdmin && !admin && !(*memUsers)(s).otherAdminExists(userID) {
+		return ErrLastAdmin
+	}
+	u.IsAdmin = admin
+	s.users[userID] = u
+	s.grants[userID] = roles
+	return nil
+}
+
+func (s *memRoles) Permissions(ctx context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := map[string]bool{}
+	for id := range s.grants[userID] {
+		for _, perm := range s.roles[id].Permissions {
+			set[perm] = true
+		}
+	}
+	perms := []string{}
+	for perm := range set {
+		perms = append(perms, perm)
+	}
+	sort.Strings(perms)
+	return perms, nil
+}
+
+type memGroups memory
+
+func (s *memGroups) List(ctx context.Context) ([]models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := []models.Group{}
+	for _, g := range s.groups {
+		groups = append(groups, copyGroup(g))
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// copyGroup returns g with its own sorted copies of the member and
+// application IDs.
+func copyGroup(g models.Group) models.Group {
+	g.UserIDs = append([]string{}, g.UserIDs...)
+	g.ApplicationIDs = append([]string{}, g.ApplicationIDs...)
+	sort.Strings(g.UserIDs)
+	sort.Strings(g.ApplicationIDs)
+	return g
+}
+
+func (s *memGroups) Get(ctx context.Context, id string) (*models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	g = copyGroup(g)
+	return &g, nil
+}
+
+func (s *memGroups) Create(ctx context.Context, group *models.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, g := range s.groups {
+		if g.Name == group.Name || g.ID == group.ID {
+			return ErrConflict
+		}
+	}
+	if group.ID == "" {
+		group.ID = uuid.New().String()
+	}
+	group.CreatedAt = time.Now()
+	s.groups[group.ID] = models.Group{
+		ID:          group.ID,
+		Name:        group.Name,
+		Description: group.Description,
+		CreatedAt:   group.CreatedAt,
+	}
+	return nil
+}
+
+func (s *memGroups) Update(ctx context.Context, group *models.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.groups[group.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, g := range s.groups {
+		if g.Name == group.Name && g.ID != group.ID {
+			return ErrConflict
+		}
+	}
+	current.Name = group.Name
+	current.Description = group.Description
+	s.groups[group.ID] = current
+	return nil
+}
+
+func (s *memGroups) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.groups[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.groups, id)
+	return nil
+}
+
+func (s *memGroups) AddMember(ctx context.Context, groupID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[groupID]
+	if _, exists := s.users[userID]; !ok || !exists {
+		return ErrNotFound
+	}
+	if !contains(g.UserIDs, userID) {
+		g.UserIDs = append(g.UserIDs, userID)
+		s.groups[groupID] = g
+	}
+	return nil
+}
+
+func (s *memGroups) RemoveMember(ctx context.Context, groupID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[groupID]
+	if !ok || !contains(g.UserIDs, userID) {
+		return ErrNotFound
+	}
+	g.UserIDs = without(g.UserIDs, userID)
+	s.groups[groupID] = g
+	return nil
+}
+
+func (s *memGroups) SetApplications(ctx context.Context, groupID string, appIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[groupID]
+	if !ok {
+		return ErrNotFound
+	}
+	g.ApplicationIDs = []string{}
+	for _, id := range appIDs {
+		if _, ok := s.apps[id]; !ok {
+			return ErrNotFound
+		}
+		if !contains(g.ApplicationIDs, id) {
+			g.ApplicationIDs = append(g.ApplicationIDs, id)
+		}
+	}
+	s.groups[groupID] = g
+	return nil
+}
+
+func contains(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// without returns ids less id, in a new slice.
+func without(ids []string, id string) []string {
+	kept := []string{}
+	for _, i := range ids {
+		if i != id {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
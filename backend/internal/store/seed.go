@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+
+	"webtop-launcher/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Seed inserts the default users and example applications that are
+// missing. New admin and user accounts get the password "password" and must
+// change it at their first login; existing accounts and applications are
+// left alone, so seeding on every start never resets a password.
+func Seed(ctx context.Context, stores *Stores) error {
+	var hash []byte
+	for _, u := range []models.User{
+		{Username: "admin", IsAdmin: true},
+		{Username: "user"},
+	} {
+		_, err := stores.Users.GetByUsername(ctx, u.Username)
+		if err == nil {
+			continue
+		} else if err != ErrNotFound {
+			return err
+		}
+		if hash == nil {
+			// Generate bcrypt hashes at runtime to ensure compatibility with the bcrypt implementation
+			if hash, err = bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost); err != nil {
+				return err
+			}
+		}
+		u.PasswordHash = string(hash)
+		u.MustChangePassword = true
+		if err := stores.Users.Create(ctx, &u); err != nil && err != ErrConflict {
+			return err
+		}
+	}
+
+	for _, app := range []models.Application{
+		{Name: "VS Code", LogoURL: "https://cdn.icon-icons.com/icons2/2107/PNG/512/file_type_vscode_icon_130084.png", RepositoryURL: "https://github.com/linuxserver/docker-code-server", DockerCompose: "...", IsEnabled: true},
+		{Name: "Ubuntu Desktop", LogoURL: "https://cdn.icon-icons.com/icons2/1508/PNG/512/ubuntu_104494.png", RepositoryURL: "https://github.com/linuxserver/docker-webtop", DockerCompose: "...", IsEnabled: true},
+	} {
+		if err := stores.Apps.Create(ctx, &app); err != nil && err != ErrConflict {
+			return err
+		}
+	}
+	return nil
+}
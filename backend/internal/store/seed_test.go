@@ -0,0 +1,40 @@
+package store_test
+
+import (
+	"testing"
+
+	"webtop-launcher/internal/store"
+)
+
+func TestSeed(t *testing.T) {
+	s := store.NewMemory()
+	if err := store.Seed(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := s.Users.GetByUsername(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !admin.IsAdmin || !admin.MustChangePassword {
+		t.Errorf("seeded admin = %+v", admin)
+	}
+	if err := s.Users.UpdatePassword(ctx, admin.ID, "changed", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seeding again, as every start with SEED=true does, must not reset
+	// the password.
+	if err := store.Seed(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	admin, _ = s.Users.GetByUsername(ctx, "admin")
+	if admin.PasswordHash != "changed" || admin.MustChangePassword {
+		t.Errorf("reseeding reset the admin password: %+v", admin)
+	}
+	if users, _ := s.Users.List(ctx); len(users) != 2 {
+		t.Errorf("%d users after seeding twice, want 2", len(users))
+	}
+	if apps, _ := s.Apps.List(ctx); len(apps) != 2 {
+		t.Errorf("%d applications after seeding twice, want 2", len(apps))
+	}
+}
@@ -0,0 +1,1298 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/rbac"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// NewSQL returns stores backed by a Postgres or SQLite database whose schema
+// is managed by database.Migrate. The queries are written in the subset of
+// SQL both understand.
+func NewSQL(db *sql.DB) *Stores {
+	return &Stores{
+		Users:       &sqlUsers{db},
+		Apps:        &sqlApps{db},
+		Sessions:    &sqlSessions{db},
+		Settings:    &sqlSettings{db},
+		Tokens:      &sqlTokens{db},
+		Credentials: &sqlCredentials{db},
+		Roles:       &sqlRoles{db},
+		Groups:      &sqlGroups{db},
+		APITokens:   &sqlAPITokens{db},
+	}
+}
+
+// sqlError maps driver errors to the store's sentinels.
+func sqlError(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+		return ErrConflict
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+		return ErrConflict
+	}
+	return err
+}
+
+// checkAffected turns an UPDATE or DELETE that matched no row into
+// ErrNotFound.
+func checkAffected(res sql.Result, err error) error {
+	if err != nil {
+		return sqlError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type sqlUsers struct{ db *sql.DB }
+
+const userColumns = `id, username, password_hash, is_admin, must_change_password, failed_logins, locked_until,
+	auth_provider, external_id, COALESCE(totp_secret, ''), totp_enabled, totp_last_step, created_at`
+
+func scanUser(row rowScanner) (*models.User, error) {
+	var u models.User
+	var lockedUntil sql.NullTime
+	var externalID sql.NullString
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.IsAdmin, &u.MustChangePassword,
+		&u.FailedLogins, &lockedUntil, &u.AuthProvider, &externalID,
+		&u.TOTPSecret, &u.TOTPEnabled, &u.TOTPLastStep, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		u.LockedUntil = &lockedUntil.Time
+	}
+	u.ExternalID = externalID.String
+	return &u, nil
+}
+
+func (s *sqlUsers) List(ctx context.Context) ([]models.User, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlUsers) Get(ctx context.Context, id string) (*models.User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	u, err := scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id))
+	return u, sqlError(err)
+}
+
+func (s *sqlUsers) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1", username))
+	return u, sqlError(err)
+}
+
+func (s *sqlUsers) GetByExternalID(ctx context.Context, provider, externalID string) (*models.User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE auth_provider = $1 AND external_id = $2", provider, externalID))
+	return u, sqlError(err)
+}
+
+func (s *sqlUsers) Create(ctx context.Context, user *models.User) error {
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	if user.AuthProvider == "" {
+		user.AuthProvider = models.AuthLocal
+	}
+	// Local users have no external ID; NULLs do not collide in the unique
+	// index.
+	externalID := sql.NullString{String: user.ExternalID, Valid: user.ExternalID != ""}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO users (id, username, password_hash, is_admin, must_change_password, auth_provider, external_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`,
+		user.ID, user.Username, user.PasswordHash, user.IsAdmin, user.MustChangePassword,
+		user.AuthProvider, externalID).Scan(&user.CreatedAt)
+	if err != nil {
+		return sqlError(err)
+	}
+	if err := grantRole(ctx, tx, user.ID, rbac.User); err != nil {
+		return err
+	}
+	if user.IsAdmin {
+		if err := grantRole(ctx, tx, user.ID, rbac.Admin); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// grantRole gives the user the role named name, within tx. Roles are
+// looked up by name as their IDs differ between installations.
+func grantRole(ctx context.Context, tx *sql.Tx, userID, name string) error {
+	var roleID string
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM roles WHERE name = $1", name).Scan(&roleID); err != nil {
+		return sqlError(err)
+	}
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2) ON CONFLICT (user_id, role_id) DO NOTHING", userID, roleID)
+	return err
+}
+
+// revokeRole takes the role named name from the user, within tx.
+func revokeRole(ctx context.Context, tx *sql.Tx, userID, name string) error {
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM user_roles WHERE user_id = $1 AND role_id IN (SELECT id FROM roles WHERE name = $2)", userID, name)
+	return err
+}
+
+// otherAdminExists is true when a user other than $N is an admin. The last
+// admin check is part of the UPDATE or DELETE itself, so two admins demoting
+// each other cannot both succeed.
+func otherAdminExists(n int) string {
+	return "EXISTS (SELECT 1 FROM users other WHERE other.is_admin AND other.id <> $" + strconv.Itoa(n) + ")"
+}
+
+func (s *sqlUsers) Update(ctx context.Context, user *models.User) error {
+	if _, err := uuid.Parse(user.ID); err != nil {
+		return ErrNotFound
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = checkAffected(tx.ExecContext(ctx,
+		"UPDATE users SET username = $1, is_admin = $2 WHERE id = $3 AND ($2 OR NOT is_admin OR "+otherAdminExists(3)+")",
+		user.Username, user.IsAdmin, user.ID))
+	if err != nil {
+		// Release the connection, which SQLite's pool has only one of.
+		tx.Rollback()
+		return s.lastAdminError(ctx, user.ID, err)
+	}
+	if user.IsAdmin {
+		err = grantRole(ctx, tx, user.ID, rbac.Admin)
+	} else {
+		err = revokeRole(ctx, tx, user.ID, rbac.Admin)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlUsers) UpdatePassword(ctx context.Context, id, passwordHash string, mustChange bool) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx,
+		"UPDATE users SET password_hash = $1, must_change_password = $2 WHERE id = $3",
+		passwordHash, mustChange, id))
+}
+
+func (s *sqlUsers) RecordLoginFailure(ctx context.Context, id string, threshold int, lockUntil time.Time) (bool, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return false, ErrNotFound
+	}
+	// The right-hand sides all see the row before the update.
+	var failures int
+	err := s.db.QueryRowContext(ctx, `UPDATE users SET
+		failed_logins = CASE WHEN failed_logins + 1 >= $1 THEN 0 ELSE failed_logins + 1 END,
+		locked_until = CASE WHEN failed_logins + 1 >= $1 THEN $2 ELSE locked_until END
+		WHERE id = $3 RETURNING failed_logins`,
+		threshold, lockUntil.UTC(), id).Scan(&failures)
+	return failures == 0, sqlError(err)
+}
+
+func (s *sqlUsers) ClearLoginFailures(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx, "UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = $1", id))
+}
+
+func (s *sqlUsers) SetTOTPSecret(ctx context.Context, id, secret string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx,
+		"UPDATE users SET totp_secret = $1, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $2",
+		secret, id))
+}
+
+func (s *sqlUsers) EnableTOTP(ctx context.Context, id string, step int64, recoveryCodeHashes []string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = checkAffected(tx.ExecContext(ctx,
+		"UPDATE users SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2 AND totp_secret IS NOT NULL",
+		step, id))
+	if err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, id, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlUsers) DisableTOTP(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = checkAffected(tx.ExecContext(ctx,
+		"UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $1", id))
+	if err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, id, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlUsers) UseTOTPStep(ctx context.Context, id string, step int64) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	// Of two logins with the same code, only one can match.
+	err := checkAffected(s.db.ExecContext(ctx,
+		"UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1", step, id))
+	if err == ErrNotFound {
+		if _, err := s.Get(ctx, id); err != nil {
+			return err
+		}
+		return ErrConflict
+	}
+	return err
+}
+
+func (s *sqlUsers) ReplaceRecoveryCodes(ctx context.Context, id string, hashes []string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := replaceRecoveryCodes(ctx, tx, id, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceRecoveryCodes deletes the recovery codes of the user and inserts
+// hashes, within tx.
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (id, user_id, code_hash) VALUES ($1, $2, $3)",
+			uuid.New().String(), userID, hash)
+		if err != nil {
+			return sqlError(err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlUsers) UseRecoveryCode(ctx context.Context, id, hash string, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx,
+		"UPDATE recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL",
+		now.UTC(), id, hash))
+}
+
+func (s *sqlUsers) CountRecoveryCodes(ctx context.Context, id string) (int, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return 0, nil
+	}
+	var n int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", id).Scan(&n)
+	return n, err
+}
+
+func (s *sqlUsers) Delete(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	err := checkAffected(s.db.ExecContext(ctx,
+		"DELETE FROM users WHERE id = $1 AND (NOT is_admin OR "+otherAdminExists(1)+")", id))
+	return s.lastAdminError(ctx, id, err)
+}
+
+// lastAdminError tells apart the two reasons a guarded UPDATE or DELETE
+// matches no row: the user does not exist, or it is the last admin.
+func (s *sqlUsers) lastAdminError(ctx context.Context, id string, err error) error {
+	if err != ErrNotFound {
+		return err
+	}
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+	return ErrLastAdmin
+}
+
+type sqlApps struct{ db *sql.DB }
+
+const appColumns = "id, name, COALESCE(logo_url, ''), COALESCE(repository_url, ''), docker_compose, is_enabled, created_at"
+
+func scanApp(row rowScanner) (*models.Application, error) {
+	var a models.Application
+	if err := row.Scan(&a.ID, &a.Name, &a.LogoURL, &a.RepositoryURL, &a.DockerCompose, &a.IsEnabled, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *sqlApps) List(ctx context.Context) ([]models.Application, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+appColumns+" FROM applications ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	apps := []models.Application{}
+	for rows.Next() {
+		a, err := scanApp(rows)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, *a)
+	}
+	return apps, rows.Err()
+}
+
+// entitledApp is true for the applications a.id the user $1 is entitled
+// to.
+const entitledApp = `(NOT EXISTS (SELECT 1 FROM group_applications ga WHERE ga.application_id = a.id)
+	OR EXISTS (SELECT 1 FROM group_applications ga
+		JOIN group_members gm ON gm.group_id = ga.group_id
+		WHERE ga.application_id = a.id AND gm.user_id = $1))`
+
+func (s *sqlApps) ListEntitled(ctx context.Context, userID string) ([]models.Application, error) {
+	apps := []models.Application{}
+	if _, err := uuid.Parse(userID); err != nil {
+		return apps, nil
+	}
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+appColumns+" FROM applications a WHERE is_enabled AND "+entitledApp+" ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := scanApp(rows)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, *a)
+	}
+	return apps, rows.Err()
+}
+
+func (s *sqlApps) Entitled(ctx context.Context, userID, id string) (bool, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return false, nil
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return false, nil
+	}
+	var entitled bool
+	err := s.db.QueryRowContext(ctx,
+		"SELECT "+entitledApp+" FROM applications a WHERE a.id = $2", userID, id).Scan(&entitled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return entitled, err
+}
+
+func (s *sqlApps) Get(ctx context.Context, id string) (*models.Application, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	a, err := scanApp(s.db.QueryRowContext(ctx, "SELECT "+appColumns+" FROM applications WHERE id = $1", id))
+	return a, sqlError(err)
+}
+
+func (s *sqlApps) Create(ctx context.Context, app *models.Application) error {
+	if app.ID == "" {
+		app.ID = uuid.New().String()
+	}
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO applications (id, name, logo_url, repository_url, docker_compose, is_enabled)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`,
+		app.ID, app.Name, app.LogoURL, app.RepositoryURL, app.DockerCompose, app.IsEnabled).Scan(&app.CreatedAt)
+	return sqlError(err)
+}
+
+func (s *sqlApps) Update(ctx context.Context, app *models.Application) error {
+	if _, err := uuid.Parse(app.ID); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx,
+		"UPDATE applications SET name = $1, logo_url = $2, repository_url = $3, docker_compose = $4, is_enabled = $5 WHERE id = $6",
+		app.Name, app.LogoURL, app.RepositoryURL, app.DockerCompose, app.IsEnabled, app.ID))
+}
+
+type sqlSessions struct{ db *sql.DB }
+
+const sessionColumns = `id, user_id, application_id, COALESCE(portainer_stack_id, 0),
+	COALESCE(stack_id, ''), COALESCE(stack_name, ''), COALESCE(orchestrator, ''), COALESCE(target_url, ''),
+	is_persistent, created_at`
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	var s models.Session
+	err := row.Scan(&s.ID, &s.UserID, &s.ApplicationID, &s.PortainerStackID,
+		&s.StackID, &s.StackName, &s.Orchestrator, &s.TargetURL,
+		&s.IsPersistent, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *sqlSessions) query(ctx context.Context, query string, args ...interface{}) ([]models.Session, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []models.Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *sess)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *sqlSessions) List(ctx context.Context) ([]models.Session, error) {
+	return s.query(ctx, "SELECT "+sessionColumns+" FROM sessions ORDER BY created_at, id")
+}
+
+func (s *sqlSessions) ListByUser(ctx context.Context, userID string) ([]models.Session, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return []models.Session{}, nil
+	}
+	return s.query(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE user_id = $1 ORDER BY created_at, id", userID)
+}
+
+func (s *sqlSessions) Get(ctx context.Context, id string) (*models.Session, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	sess, err := scanSession(s.db.QueryRowContext(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE id = $1", id))
+	return sess, sqlError(err)
+}
+
+func (s *sqlSessions) Create(ctx context.Context, session *models.Session) error {
+	if session.ID == "" {
+		session.ID = uuid.New().String()
+	}
+	var portainerStackID sql.NullInt64
+	if session.PortainerStackID != 0 {
+		portainerStackID = sql.NullInt64{Int64: int64(session.PortainerStackID), Valid: true}
+	}
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO sessions (id, user_id, application_id, portainer_stack_id, stack_id, stack_name, orchestrator, target_url, is_persistent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING created_at`,
+		session.ID, session.UserID, session.ApplicationID, portainerStackID,
+		nullString(session.StackID), nullString(session.StackName), nullString(session.Orchestrator), nullString(session.TargetURL),
+		session.IsPersistent).Scan(&session.CreatedAt)
+	return sqlError(err)
+}
+
+func (s *sqlSessions) Delete(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = $1", id))
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+type sqlSettings struct{ db *sql.DB }
+
+func (s *sqlSettings) Get(ctx context.Context, keys ...string) (map[string]string, error) {
+	values := map[string]string{}
+	if len(keys) == 0 {
+		return values, nil
+	}
+	placeholders := make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, k := range keys {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+		args[i] = k
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT key, value FROM settings WHERE key IN ("+strings.Join(placeholders, ", ")+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var value sql.NullString
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[key] = value.String
+	}
+	return values, rows.Err()
+}
+
+func (s *sqlSettings) Set(ctx context.Context, values map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for key, value := range values {
+		_, err := tx.ExecContext(ctx, "INSERT INTO settings (key, value) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value", key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type sqlTokens struct{ db *sql.DB }
+
+const tokenColumns = "id, user_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at"
+
+func scanToken(row rowScanner) (*models.RefreshToken, error) {
+	var t models.RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash, &t.ExpiresAt, &usedAt, &revokedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+// createToken inserts token with db, which is the database or a
+// transaction.
+func createToken(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}, token *models.RefreshToken) error {
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+	err := db.QueryRowContext(ctx,
+		`INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING created_at`,
+		token.ID, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt.UTC()).Scan(&token.CreatedAt)
+	return sqlError(err)
+}
+
+func (s *sqlTokens) Create(ctx context.Context, token *models.RefreshToken) error {
+	return createToken(ctx, s.db, token)
+}
+
+func (s *sqlTokens) GetByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	t, err := scanToken(s.db.QueryRowContext(ctx, "SELECT "+tokenColumns+" FROM refresh_tokens WHERE token_hash = $1", hash))
+	return t, sqlError(err)
+}
+
+func (s *sqlTokens) Rotate(ctx context.Context, oldID string, next *models.RefreshToken) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Only one of two concurrent rotations of the same token can match.
+	res, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL AND revoked_at IS NULL",
+		time.Now().UTC(), oldID)
+	if err := checkAffected(res, err); err == ErrNotFound {
+		return ErrConflict
+	} else if err != nil {
+		return err
+	}
+	if err := createToken(ctx, tx, next); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlTokens) FamilyActive(ctx context.Context, familyID string, now time.Time) (bool, error) {
+	if _, err := uuid.Parse(familyID); err != nil {
+		return false, nil
+	}
+	var n int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM refresh_tokens WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > $2",
+		familyID, now.UTC()).Scan(&n)
+	return n > 0, err
+}
+
+func (s *sqlTokens) RevokeFamily(ctx context.Context, familyID string, now time.Time) error {
+	if _, err := uuid.Parse(familyID); err != nil {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL",
+		now.UTC(), familyID)
+	return err
+}
+
+func (s *sqlTokens) RevokeUser(ctx context.Context, userID string, now time.Time) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL",
+		now.UTC(), userID)
+	return err
+}
+
+func (s *sqlTokens) DeleteExpired(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at < $1", before.UTC())
+	return err
+}
+
+type sqlCredentials struct{ db *sql.DB }
+
+const credentialColumns = "id, user_id, credential_id, public_key, sign_count, name, transports, created_at, last_used_at"
+
+// Credential IDs are stored base64url-encoded so they can be compared and
+// indexed as text in both databases.
+func encodeCredentialID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func scanCredential(row rowScanner) (*models.WebAuthnCredential, error) {
+	var c models.WebAuthnCredential
+	var credentialID, transports string
+	var signCount int64
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&c.ID, &c.UserID, &credentialID, &c.PublicKey, &signCount, &c.Name, &transports, &c.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	if c.CredentialID, err = base64.RawURLEncoding.DecodeString(credentialID); err != nil {
+		return nil, err
+	}
+	c.SignCount = uint32(signCount)
+	c.Transports = []string{}
+	if transports != "" {
+		c.Transports = strings.Split(transports, ",")
+	}
+	if lastUsedAt.Valid {
+		c.LastUsedAt = &lastUsedAt.Time
+	}
+	return &c, nil
+}
+
+func (s *sqlCredentials) ListByUser(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	creds := []models.WebAuthnCredential{}
+	if _, err := uuid.Parse(userID); err != nil {
+		return creds, nil
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT "+credentialColumns+" FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, *c)
+	}
+	return creds, rows.Err()
+}
+
+func (s *sqlCredentials) GetByCredentialID(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	c, err := scanCredential(s.db.QueryRowContext(ctx,
+		"SELECT "+credentialColumns+" FROM webauthn_credentials WHERE credential_id = $1", encodeCredentialID(credentialID)))
+	return c, sqlError(err)
+}
+
+func (s *sqlCredentials) Create(ctx context.Context, cred *models.WebAuthnCredential) error {
+	if cred.ID == "" {
+		cred.ID = uuid.New().String()
+	}
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO webauthn_credentials (id, user_id, credential_id, public_key, sign_count, name, transports)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`,
+		cred.ID, cred.UserID, encodeCredentialID(cred.CredentialID), cred.PublicKey, int64(cred.SignCount),
+		cred.Name, strings.Join(cred.Transports, ",")).Scan(&cred.CreatedAt)
+	return sqlError(err)
+}
+
+func (s *sqlCredentials) UpdateSignCount(ctx context.Context, id string, signCount uint32, usedAt time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx,
+		"UPDATE webauthn_credentials SET sign_count = $1, last_used_at = $2 WHERE id = $3",
+		int64(signCount), usedAt.UTC(), id))
+}
+
+func (s *sqlCredentials) Delete(ctx context.Context, userID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx, "DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2", id, userID))
+}
+
+type sqlRoles struct{ db *sql.DB }
+
+const roleColumns = "id, name, description, builtin, created_at"
+
+func scanRole(row rowScanner) (*models.Role, error) {
+	var r models.Role
+	if err := row.Scan(&r.ID, &r.Name, &r.Description, &r.Builtin, &r.CreatedAt); err != nil {
+		return nil, err
+	}
+	r.Permissions = []string{}
+	return &r, nil
+}
+
+func (s *sqlRoles) List(ctx context.Context) ([]models.Role, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+roleColumns+" FROM roles ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []models.Role{}
+	byID := map[string]int{}
+	for rows.Next() {
+		r, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[r.ID] = len(roles)
+		roles = append(roles, *r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	perms, err := s.db.QueryContext(ctx, "SELECT role_id, permission FROM role_permissions ORDER BY permission")
+	if err != nil {
+		return nil, err
+	}
+	defer perms.Close()
+	for perms.Next() {
+		var roleID, perm string
+		if err := perms.Scan(&roleID, &perm); err != nil {
+			return nil, err
+		}
+		if i, ok := byID[roleID]; ok {
+			roles[i].Permissions = append(roles[i].Permissions, perm)
+		}
+	}
+	return roles, perms.Err()
+}
+
+func (s *sqlRoles) Get(ctx context.Context, id string) (*models.Role, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	r, err := scanRole(s.db.QueryRowContext(ctx, "SELECT "+roleColumns+" FROM roles WHERE id = $1", id))
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT permission FROM role_permissions WHERE role_id = $1 ORDER BY permission", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var perm string
+		if err := rows.Scan(&perm); err != nil {
+			return nil, err
+		}
+		r.Permissions = append(r.Permissions, perm)
+	}
+	return r, rows.Err()
+}
+
+func (s *sqlRoles) Create(ctx context.Context, role *models.Role) error {
+	if role.ID == "" {
+		role.ID = uuid.New().String()
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO roles (id, name, description, builtin) VALUES ($1, $2, $3, $4) RETURNING created_at",
+		role.ID, role.Name, role.Description, role.Builtin).Scan(&role.CreatedAt)
+	if err != nil {
+		return sqlError(err)
+	}
+	if err := insertPermissions(ctx, tx, role.ID, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlRoles) Update(ctx context.Context, role *models.Role) error {
+	if _, err := uuid.Parse(role.ID); err != nil {
+		return ErrNotFound
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = checkAffected(tx.ExecContext(ctx,
+		"UPDATE roles SET name = $1, description = $2 WHERE id = $3", role.Name, role.Description, role.ID))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role_id = $1", role.ID); err != nil {
+		return err
+	}
+	if err := insertPermissions(ctx, tx, role.ID, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertPermissions adds perms to the role, within tx.
+func insertPermissions(ctx context.Context, tx *sql.Tx, roleID string, perms []string) error {
+	for _, perm := range perms {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO role_permissions (role_id, permission) VALUES ($1, $2) ON CONFLICT (role_id, permission) DO NOTHING",
+			roleID, perm)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlRoles) Delete(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx, "DELETE FROM roles WHERE id = $1", id))
+}
+
+func (s *sqlRoles) ListAssignments(ctx context.Context) (map[string][]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT user_id, role_id FROM user_roles ORDER BY user_id, role_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	assignments := map[string][]string{}
+	for rows.Next() {
+		var userID, roleID string
+		if err := rows.Scan(&userID, &roleID); err != nil {
+			return nil, err
+		}
+		assignments[userID] = append(assignments[userID], roleID)
+	}
+	return assignments, rows.Err()
+}
+
+func (s *sqlRoles) SetUserRoles(ctx context.Context, userID string, roleIDs []string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrNotFound
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var wasAdmin bool
+	if err := tx.QueryRowContext(ctx, "SELECT is_admin FROM users WHERE id = $1", userID).Scan(&wasAdmin); err != nil {
+		return sqlError(err)
+	}
+	admin := false
+	for _, id := range roleIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return ErrNotFound
+		}
+		var name string
+		if err := tx.QueryRowContext(ctx, "SELECT name FROM roles WHERE id = $1", id).Scan(&name); err != nil {
+			return sqlError(err)
+		}
+		admin = admin || name == rbac.Admin
+	}
+	if admin != wasAdmin {
+		err := checkAffected(tx.ExecContext(ctx,
+			"UPDATE users SET is_admin = $1 WHERE id = $2 AND ($1 OR "+otherAdminExists(2)+")", admin, userID))
+		if err == ErrNotFound {
+			return ErrLastAdmin
+		} else if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, id := range roleIDs {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2) ON CONFLICT (user_id, role_id) DO NOTHING", userID, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlRoles) Permissions(ctx context.Context, userID string) ([]string, error) {
+	perms := []string{}
+	if _, err := uuid.Parse(userID); err != nil {
+		return perms, nil
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT DISTINCT rp.permission FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		WHERE ur.user_id = $1 ORDER BY rp.permission`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var perm string
+		if err := rows.Scan(&perm); err != nil {
+			return nil, err
+		}
+		perms = append(perms, perm)
+	}
+	return perms, rows.Err()
+}
+
+type sqlGroups struct{ db *sql.DB }
+
+const groupColumns = "id, name, description, created_at"
+
+func scanGroup(row rowScanner) (*models.Group, error) {
+	var g models.Group
+	if err := row.Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt); err != nil {
+		return nil, err
+	}
+	g.UserIDs = []string{}
+	g.ApplicationIDs = []string{}
+	return &g, nil
+}
+
+func (s *sqlGroups) List(ctx context.Context) ([]models.Group, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+groupColumns+" FROM user_groups ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := []models.Group{}
+	byID := map[string]int{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[g.ID] = len(groups)
+		groups = append(groups, *g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	err = s.links(ctx, "group_members", "user_id", "", func(groupID, userID string) {
+		if i, ok := byID[groupID]; ok {
+			groups[i].UserIDs = append(groups[i].UserIDs, userID)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.links(ctx, "group_applications", "application_id", "", func(groupID, appID string) {
+		if i, ok := byID[groupID]; ok {
+			groups[i].ApplicationIDs = append(groups[i].ApplicationIDs, appID)
+		}
+	})
+	return groups, err
+}
+
+// links calls fn with the rows of table, group_members or
+// group_applications, of the group groupID, or of every group if it is
+// empty. column names the member.
+func (s *sqlGroups) links(ctx context.Context, table, column, groupID string, fn func(groupID, id string)) error {
+	query := "SELECT group_id, " + column + " FROM " + table
+	var args []interface{}
+	if groupID != "" {
+		query += " WHERE group_id = $1"
+		args = append(args, groupID)
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY group_id, "+column, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g, id string
+		if err := rows.Scan(&g, &id); err != nil {
+			return err
+		}
+		fn(g, id)
+	}
+	return rows.Err()
+}
+
+func (s *sqlGroups) Get(ctx context.Context, id string) (*models.Group, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	g, err := scanGroup(s.db.QueryRowContext(ctx, "SELECT "+groupColumns+" FROM user_groups WHERE id = $1", id))
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	err = s.links(ctx, "group_members", "user_id", id, func(_, userID string) {
+		g.UserIDs = append(g.UserIDs, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.links(ctx, "group_applications", "application_id", id, func(_, appID string) {
+		g.ApplicationIDs = append(g.ApplicationIDs, appID)
+	})
+	return g, err
+}
+
+func (s *sqlGroups) Create(ctx context.Context, group *models.Group) error {
+	if group.ID == "" {
+		group.ID = uuid.New().String()
+	}
+	err := s.db.QueryRowContext(ctx,
+		"INSERT INTO user_groups (id, name, description) VALUES ($1, $2, $3) RETURNING created_at",
+		group.ID, group.Name, group.Description).Scan(&group.CreatedAt)
+	return sqlError(err)
+}
+
+func (s *sqlGroups) Update(ctx context.Context, group *models.Group) error {
+	if _, err := uuid.Parse(group.ID); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx,
+		"UPDATE user_groups SET name = $1, description = $2 WHERE id = $3", group.Name, group.Description, group.ID))
+}
+
+func (s *sqlGroups) Delete(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx, "DELETE FROM user_groups WHERE id = $1", id))
+}
+
+// exists returns ErrNotFound unless table has a row with the given id,
+// within tx.
+func exists(ctx context.Context, tx *sql.Tx, table, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	var one int
+	return sqlError(tx.QueryRowContext(ctx, "SELECT 1 FROM "+table+" WHERE id = $1", id).Scan(&one))
+}
+
+func (s *sqlGroups) AddMember(ctx context.Context, groupID, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := exists(ctx, tx, "user_groups", groupID); err != nil {
+		return err
+	}
+	if err := exists(ctx, tx, "users", userID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO group_members (group_id, user_id) VALUES ($1, $2) ON CONFLICT (group_id, user_id) DO NOTHING",
+		groupID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlGroups) RemoveMember(ctx context.Context, groupID, userID string) error {
+	if _, err := uuid.Parse(groupID); err != nil {
+		return ErrNotFound
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx,
+		"DELETE FROM group_members WHERE group_id = $1 AND user_id = $2", groupID, userID))
+}
+
+func (s *sqlGroups) SetApplications(ctx context.Context, groupID string, appIDs []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := exists(ctx, tx, "user_groups", groupID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM group_applications WHERE group_id = $1", groupID); err != nil {
+		return err
+	}
+	for _, id := range appIDs {
+		if err := exists(ctx, tx, "applications", id); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO group_applications (group_id, application_id) VALUES ($1, $2)
+			ON CONFLICT (group_id, application_id) DO NOTHING`, groupID, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type sqlAPITokens struct{ db *sql.DB }
+
+const apiTokenColumns = "id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at"
+
+func scanAPIToken(row rowScanner) (*models.APIToken, error) {
+	var t models.APIToken
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopes, &expiresAt, &lastUsedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = []string{}
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	return &t, nil
+}
+
+func (s *sqlAPITokens) Create(ctx context.Context, token *models.APIToken) error {
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+	var expiresAt interface{}
+	if token.ExpiresAt != nil {
+		expiresAt = token.ExpiresAt.UTC()
+	}
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO api_tokens (id, user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at`,
+		token.ID, token.UserID, token.Name, token.TokenHash, strings.Join(token.Scopes, ","), expiresAt).Scan(&token.CreatedAt)
+	return sqlError(err)
+}
+
+func (s *sqlAPITokens) ListByUser(ctx context.Context, userID string) ([]models.APIToken, error) {
+	tokens := []models.APIToken{}
+	if _, err := uuid.Parse(userID); err != nil {
+		return tokens, nil
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqlAPITokens) GetByHash(ctx context.Context, hash string) (*models.APIToken, error) {
+	t, err := scanAPIToken(s.db.QueryRowContext(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = $1", hash))
+	return t, sqlError(err)
+}
+
+func (s *sqlAPITokens) Touch(ctx context.Context, id string, usedAt time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = $1 WHERE id = $2", usedAt.UTC(), id))
+}
+
+func (s *sqlAPITokens) Delete(ctx context.Context, userID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrNotFound
+	}
+	return checkAffected(s.db.ExecContext(ctx, "DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", id, userID))
+}
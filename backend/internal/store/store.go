@@ -0,0 +1,213 @@
+// Package store is the persistence layer. Handlers depend on the interfaces
+// here; SQL (Postgres or SQLite) backs them in production and Memory in
+// tests.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"webtop-launcher/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist.
+	ErrNotFound = errors.New("store: not found")
+	// ErrConflict is returned when a row would violate a uniqueness
+	// constraint, e.g. a duplicate username.
+	ErrConflict = errors.New("store: already exists")
+	// ErrLastAdmin is returned instead of demoting or deleting the only
+	// remaining admin.
+	ErrLastAdmin = errors.New("store: last admin")
+)
+
+// UserStore persists users.
+type UserStore interface {
+	List(ctx context.Context) ([]models.User, error)
+	Get(ctx context.Context, id string) (*models.User, error)
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	// GetByExternalID returns the user linked to an external identity.
+	GetByExternalID(ctx context.Context, provider, externalID string) (*models.User, error)
+	// Create inserts user, assigning an ID if it has none, and sets
+	// CreatedAt. An empty AuthProvider means models.AuthLocal. The user
+	// gets the user role, and the admin role if IsAdmin is set.
+	Create(ctx context.Context, user *models.User) error
+	// Update saves the username and admin flag, granting or removing the
+	// admin role with it.
+	Update(ctx context.Context, user *models.User) error
+	// UpdatePassword sets the password hash and the must-change-password
+	// flag.
+	UpdatePassword(ctx context.Context, id, passwordHash string, mustChange bool) error
+	// RecordLoginFailure counts a failed login. The threshold-th
+	// consecutive failure locks the account until lockUntil and starts
+	// the count over; locked reports whether that happened.
+	RecordLoginFailure(ctx context.Context, id string, threshold int, lockUntil time.Time) (locked bool, err error)
+	// ClearLoginFailures resets the count and lifts any lockout.
+	ClearLoginFailures(ctx context.Context, id string) error
+	// SetTOTPSecret starts a TOTP enrollment with an encrypted secret,
+	// replacing any previous one; TOTP stays disabled until EnableTOTP.
+	SetTOTPSecret(ctx context.Context, id, secret string) error
+	// EnableTOTP confirms the enrollment, accepting step as the last used
+	// time step, and replaces the recovery codes.
+	EnableTOTP(ctx context.Context, id string, step int64, recoveryCodeHashes []string) error
+	// DisableTOTP removes the secret and the recovery codes.
+	DisableTOTP(ctx context.Context, id string) error
+	// UseTOTPStep records that a code for step was accepted. It returns
+	// ErrConflict unless step is later than the last one, so each code
+	// is accepted once.
+	UseTOTPStep(ctx context.Context, id string, step int64) error
+	// ReplaceRecoveryCodes deletes the user's recovery codes and stores
+	// new ones.
+	ReplaceRecoveryCodes(ctx context.Context, id string, hashes []string) error
+	// UseRecoveryCode marks an unused recovery code as used. It returns
+	// ErrNotFound if the user has no such unused code.
+	UseRecoveryCode(ctx context.Context, id, hash string, now time.Time) error
+	// CountRecoveryCodes returns how many unused recovery codes are left.
+	CountRecoveryCodes(ctx context.Context, id string) (int, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// AppStore persists the application catalogue.
+type AppStore interface {
+	List(ctx context.Context) ([]models.Application, error)
+	// ListEntitled returns the enabled applications the user is entitled
+	// to (see Entitled).
+	ListEntitled(ctx context.Context, userID string) ([]models.Application, error)
+	// Entitled reports whether the user may launch application id: it is
+	// restricted to a group the user is a member of, or to no group at
+	// all.
+	Entitled(ctx context.Context, userID, id string) (bool, error)
+	Get(ctx context.Context, id string) (*models.Application, error)
+	// Create inserts app, assigning an ID if it has none, and sets
+	// CreatedAt.
+	Create(ctx context.Context, app *models.Application) error
+	Update(ctx context.Context, app *models.Application) error
+}
+
+// SessionStore persists running sessions.
+type SessionStore interface {
+	List(ctx context.Context) ([]models.Session, error)
+	ListByUser(ctx context.Context, userID string) ([]models.Session, error)
+	Get(ctx context.Context, id string) (*models.Session, error)
+	// Create inserts session, assigning an ID if it has none, and sets
+	// CreatedAt.
+	Create(ctx context.Context, session *models.Session) error
+	Delete(ctx context.Context, id string) error
+}
+
+// TokenStore persists refresh tokens. The tokens rotated from one login
+// form a family; revoking a family ends that login everywhere.
+type TokenStore interface {
+	// Create inserts token, assigning an ID if it has none, and sets
+	// CreatedAt.
+	Create(ctx context.Context, token *models.RefreshToken) error
+	GetByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	// Rotate marks the token oldID as used and inserts next, atomically.
+	// It returns ErrConflict if oldID was already used or revoked.
+	Rotate(ctx context.Context, oldID string, next *models.RefreshToken) error
+	// FamilyActive reports whether the family has a token that is neither
+	// revoked nor expired at now.
+	FamilyActive(ctx context.Context, familyID string, now time.Time) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string, now time.Time) error
+	// RevokeUser revokes every family of the user.
+	RevokeUser(ctx context.Context, userID string, now time.Time) error
+	// DeleteExpired removes tokens that expired before the given time.
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+// CredentialStore persists WebAuthn credentials.
+type CredentialStore interface {
+	ListByUser(ctx context.Context, userID string) ([]models.WebAuthnCredential, error)
+	GetByCredentialID(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error)
+	// Create inserts cred, assigning an ID if it has none, and sets
+	// CreatedAt. It returns ErrConflict if the credential ID is already
+	// registered.
+	Create(ctx context.Context, cred *models.WebAuthnCredential) error
+	// UpdateSignCount records a login with the credential.
+	UpdateSignCount(ctx context.Context, id string, signCount uint32, usedAt time.Time) error
+	// Delete removes the user's credential id.
+	Delete(ctx context.Context, userID, id string) error
+}
+
+// GroupStore persists user groups, their members and the applications
+// restricted to them.
+type GroupStore interface {
+	List(ctx context.Context) ([]models.Group, error)
+	Get(ctx context.Context, id string) (*models.Group, error)
+	// Create inserts group, assigning an ID if it has none, and sets
+	// CreatedAt. Members and applications are set separately. It returns
+	// ErrConflict if the name is taken.
+	Create(ctx context.Context, group *models.Group) error
+	// Update saves the name and description.
+	Update(ctx context.Context, group *models.Group) error
+	Delete(ctx context.Context, id string) error
+	// AddMember adds the user to the group, if not already a member. It
+	// returns ErrNotFound if either does not exist.
+	AddMember(ctx context.Context, groupID, userID string) error
+	// RemoveMember returns ErrNotFound if the user is not a member.
+	RemoveMember(ctx context.Context, groupID, userID string) error
+	// SetApplications replaces the applications restricted to the group.
+	// It returns ErrNotFound if the group or one of the applications does
+	// not exist.
+	SetApplications(ctx context.Context, groupID string, appIDs []string) error
+}
+
+// APITokenStore persists personal access tokens.
+type APITokenStore interface {
+	// Create inserts token, assigning an ID if it has none, and sets
+	// CreatedAt.
+	Create(ctx context.Context, token *models.APIToken) error
+	ListByUser(ctx context.Context, userID string) ([]models.APIToken, error)
+	GetByHash(ctx context.Context, hash string) (*models.APIToken, error)
+	// Touch records that the token was used at usedAt.
+	Touch(ctx context.Context, id string, usedAt time.Time) error
+	// Delete returns ErrNotFound unless the token exists and belongs to
+	// userID.
+	Delete(ctx context.Context, userID, id string) error
+}
+
+// RoleStore persists roles and which users have them.
+type RoleStore interface {
+	List(ctx context.Context) ([]models.Role, error)
+	Get(ctx context.Context, id string) (*models.Role, error)
+	// Create inserts role, assigning an ID if it has none, and sets
+	// CreatedAt. It returns ErrConflict if the name is taken.
+	Create(ctx context.Context, role *models.Role) error
+	// Update saves the name, description and permissions.
+	Update(ctx context.Context, role *models.Role) error
+	// Delete removes the role from its users and deletes it.
+	Delete(ctx context.Context, id string) error
+	// ListAssignments returns the IDs of the roles of every user that has
+	// any, by user ID.
+	ListAssignments(ctx context.Context) (map[string][]string, error)
+	// SetUserRoles replaces the roles of the user. It returns ErrNotFound
+	// if the user or one of the roles does not exist, and sets is_admin
+	// along with the admin role, so it returns ErrLastAdmin instead of
+	// taking the role from the last admin.
+	SetUserRoles(ctx context.Context, userID string, roleIDs []string) error
+	// Permissions returns what the user's roles allow, sorted.
+	Permissions(ctx context.Context, userID string) ([]string, error)
+}
+
+// SettingsStore persists key/value settings.
+type SettingsStore interface {
+	// Get returns the values of the given keys. Keys that are not set are
+	// missing from the map.
+	Get(ctx context.Context, keys ...string) (map[string]string, error)
+	// Set upserts all values atomically.
+	Set(ctx context.Context, values map[string]string) error
+}
+
+// Stores bundles one implementation of each store.
+type Stores struct {
+	Users       UserStore
+	Apps        AppStore
+	Sessions    SessionStore
+	Settings    SettingsStore
+	Tokens      TokenStore
+	Credentials CredentialStore
+	Roles       RoleStore
+	Groups      GroupStore
+	APITokens   APITokenStore
+}
@@ -0,0 +1,761 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"webtop-launcher/internal/database"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/rbac"
+	"webtop-launcher/internal/store"
+
+	"github.com/google/uuid"
+)
+
+// Every implementation runs the same suite, so the memory stores the
+// handler tests use behave like the SQL ones in production.
+
+func TestMemory(t *testing.T) {
+	testStores(t, func(t *testing.T) *store.Stores { return store.NewMemory() })
+}
+
+func TestSQLite(t *testing.T) {
+	testStores(t, func(t *testing.T) *store.Stores {
+		return openSQL(t, database.SQLite, filepath.Join(t.TempDir(), "webtop.db"))
+	})
+}
+
+// TestPostgres runs against the database in TEST_DATABASE_URL, each test in
+// a schema of its own that is dropped afterwards.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	admin, err := database.Connect(database.Postgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	testStores(t, func(t *testing.T) *store.Stores {
+		schema := "webtop_test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+				t.Errorf("dropping %s: %v", schema, err)
+			}
+		})
+		return openSQL(t, database.Postgres, withSearchPath(t, dsn, schema))
+	})
+}
+
+// withSearchPath adds search_path to a URL or key=value connection string.
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func openSQL(t *testing.T, driver, dsn string) *store.Stores {
+	t.Helper()
+	db, err := database.Open(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return store.NewSQL(db)
+}
+
+func testStores(t *testing.T, newStores func(t *testing.T) *store.Stores) {
+	for _, tt := range []struct {
+		name string
+		test func(t *testing.T, s *store.Stores)
+	}{
+		{"Users", testUsers},
+		{"LastAdmin", testLastAdmin},
+		{"LoginFailures", testLoginFailures},
+		{"TOTP", testTOTP},
+		{"Apps", testApps},
+		{"Groups", testGroups},
+		{"Sessions", testSessions},
+		{"Tokens", testTokens},
+		{"Credentials", testCredentials},
+		{"Roles", testRoles},
+		{"Settings", testSettings},
+		{"APITokens", testAPITokens},
+		{"DeleteUser", testDeleteUser},
+	} {
+		t.Run(tt.name, func(t *testing.T) { tt.test(t, newStores(t)) })
+	}
+}
+
+var ctx = context.Background()
+
+func createUser(t *testing.T, s *store.Stores, username string, admin bool) *models.User {
+	t.Helper()
+	u := &models.User{Username: username, PasswordHash: "hash-" + username, IsAdmin: admin}
+	if err := s.Users.Create(ctx, u); err != nil {
+		t.Fatalf("creating %s: %v", username, err)
+	}
+	return u
+}
+
+func createApp(t *testing.T, s *store.Stores, name string) *models.Application {
+	t.Helper()
+	app := &models.Application{Name: name, DockerCompose: "services: {}", IsEnabled: true}
+	if err := s.Apps.Create(ctx, app); err != nil {
+		t.Fatalf("creating %s: %v", name, err)
+	}
+	return app
+}
+
+func roleID(t *testing.T, s *store.Stores, name string) string {
+	t.Helper()
+	roles, err := s.Roles.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range roles {
+		if r.Name == name {
+			return r.ID
+		}
+	}
+	t.Fatalf("no role %s", name)
+	return ""
+}
+
+func testUsers(t *testing.T, s *store.Stores) {
+	alice := createUser(t, s, "alice", false)
+	if alice.ID == "" || alice.CreatedAt.IsZero() {
+		t.Errorf("Create did not assign an ID and CreatedAt: %+v", alice)
+	}
+	got, err := s.Users.Get(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != "alice" || got.PasswordHash != "hash-alice" || got.AuthProvider != models.AuthLocal {
+		t.Errorf("Get = %+v", got)
+	}
+	if got, err := s.Users.GetByUsername(ctx, "alice"); err != nil || got.ID != alice.ID {
+		t.Errorf("GetByUsername = %+v, %v", got, err)
+	}
+	if _, err := s.Users.Get(ctx, uuid.New().String()); err != store.ErrNotFound {
+		t.Errorf("Get of a missing user = %v, want ErrNotFound", err)
+	}
+	if err := s.Users.Create(ctx, &models.User{Username: "alice"}); err != store.ErrConflict {
+		t.Errorf("Create with a taken username = %v, want ErrConflict", err)
+	}
+
+	sso := &models.User{Username: "bob", AuthProvider: models.AuthOIDC, ExternalID: "sub-1"}
+	if err := s.Users.Create(ctx, sso); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Users.GetByExternalID(ctx, models.AuthOIDC, "sub-1"); err != nil || got.ID != sso.ID {
+		t.Errorf("GetByExternalID = %+v, %v", got, err)
+	}
+	if _, err := s.Users.GetByExternalID(ctx, models.AuthLDAP, "sub-1"); err != store.ErrNotFound {
+		t.Errorf("GetByExternalID of another provider = %v, want ErrNotFound", err)
+	}
+
+	alice.Username = "bob"
+	if err := s.Users.Update(ctx, alice); err != store.ErrConflict {
+		t.Errorf("Update to a taken username = %v, want ErrConflict", err)
+	}
+	alice.Username = "alice2"
+	if err := s.Users.Update(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.UpdatePassword(ctx, alice.ID, "new-hash", true); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.Users.Get(ctx, alice.ID)
+	if got.Username != "alice2" || got.PasswordHash != "new-hash" || !got.MustChangePassword {
+		t.Errorf("after Update and UpdatePassword: %+v", got)
+	}
+
+	users, err := s.Users.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Errorf("List returned %d users, want 2", len(users))
+	}
+}
+
+func testLastAdmin(t *testing.T, s *store.Stores) {
+	root := createUser(t, s, "root", true)
+	if perms, _ := s.Roles.Permissions(ctx, root.ID); len(perms) != len(rbac.All) {
+		t.Errorf("an admin has permissions %q", perms)
+	}
+	root.IsAdmin = false
+	if err := s.Users.Update(ctx, root); err != store.ErrLastAdmin {
+		t.Errorf("demoting the last admin = %v, want ErrLastAdmin", err)
+	}
+	if err := s.Users.Delete(ctx, root.ID); err != store.ErrLastAdmin {
+		t.Errorf("deleting the last admin = %v, want ErrLastAdmin", err)
+	}
+	if err := s.Roles.SetUserRoles(ctx, root.ID, []string{roleID(t, s, rbac.User)}); err != store.ErrLastAdmin {
+		t.Errorf("taking the admin role from the last admin = %v, want ErrLastAdmin", err)
+	}
+
+	other := createUser(t, s, "other", false)
+	if err := s.Roles.SetUserRoles(ctx, other.ID, []string{roleID(t, s, rbac.Admin)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Users.Get(ctx, other.ID); !got.IsAdmin {
+		t.Error("SetUserRoles with the admin role did not set IsAdmin")
+	}
+	if err := s.Users.Update(ctx, root); err != nil {
+		t.Errorf("demoting an admin while another remains = %v", err)
+	}
+	if perms, _ := s.Roles.Permissions(ctx, root.ID); len(perms) == len(rbac.All) {
+		t.Error("the demoted admin kept the admin role")
+	}
+}
+
+func testLoginFailures(t *testing.T, s *store.Stores) {
+	u := createUser(t, s, "alice", false)
+	until := time.Now().Add(time.Minute).Truncate(time.Second)
+	for i := 1; i <= 3; i++ {
+		locked, err := s.Users.RecordLoginFailure(ctx, u.ID, 3, until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != (i == 3) {
+			t.Errorf("failure %d: locked = %v", i, locked)
+		}
+	}
+	got, _ := s.Users.Get(ctx, u.ID)
+	if got.LockedUntil == nil || !got.LockedUntil.Equal(until) || got.FailedLogins != 0 {
+		t.Errorf("after locking: LockedUntil = %v, FailedLogins = %d", got.LockedUntil, got.FailedLogins)
+	}
+	if _, err := s.Users.RecordLoginFailure(ctx, u.ID, 3, until); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.ClearLoginFailures(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.Users.Get(ctx, u.ID)
+	if got.LockedUntil != nil || got.FailedLogins != 0 {
+		t.Errorf("after ClearLoginFailures: LockedUntil = %v, FailedLogins = %d", got.LockedUntil, got.FailedLogins)
+	}
+}
+
+func testTOTP(t *testing.T, s *store.Stores) {
+	u := createUser(t, s, "alice", false)
+	if err := s.Users.SetTOTPSecret(ctx, u.ID, "encrypted"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Users.Get(ctx, u.ID); got.TOTPSecret != "encrypted" || got.TOTPEnabled {
+		t.Errorf("after SetTOTPSecret: %+v", got)
+	}
+	if err := s.Users.EnableTOTP(ctx, u.ID, 100, []string{"code-1", "code-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Users.Get(ctx, u.ID); !got.TOTPEnabled || got.TOTPLastStep != 100 {
+		t.Errorf("after EnableTOTP: %+v", got)
+	}
+
+	for _, step := range []int64{99, 100} {
+		if err := s.Users.UseTOTPStep(ctx, u.ID, step); err != store.ErrConflict {
+			t.Errorf("UseTOTPStep(%d) = %v, want ErrConflict", step, err)
+		}
+	}
+	if err := s.Users.UseTOTPStep(ctx, u.ID, 101); err != nil {
+		t.Errorf("UseTOTPStep(101) = %v", err)
+	}
+
+	if err := s.Users.UseRecoveryCode(ctx, u.ID, "code-1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.UseRecoveryCode(ctx, u.ID, "code-1", time.Now()); err != store.ErrNotFound {
+		t.Errorf("using a recovery code twice = %v, want ErrNotFound", err)
+	}
+	if n, _ := s.Users.CountRecoveryCodes(ctx, u.ID); n != 1 {
+		t.Errorf("CountRecoveryCodes = %d, want 1", n)
+	}
+	if err := s.Users.ReplaceRecoveryCodes(ctx, u.ID, []string{"code-3", "code-4", "code-5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.UseRecoveryCode(ctx, u.ID, "code-2", time.Now()); err != store.ErrNotFound {
+		t.Errorf("using a replaced recovery code = %v, want ErrNotFound", err)
+	}
+	if n, _ := s.Users.CountRecoveryCodes(ctx, u.ID); n != 3 {
+		t.Errorf("CountRecoveryCodes after ReplaceRecoveryCodes = %d, want 3", n)
+	}
+
+	if err := s.Users.DisableTOTP(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Users.Get(ctx, u.ID); got.TOTPEnabled || got.TOTPSecret != "" {
+		t.Errorf("after DisableTOTP: %+v", got)
+	}
+	if n, _ := s.Users.CountRecoveryCodes(ctx, u.ID); n != 0 {
+		t.Errorf("DisableTOTP left %d recovery codes", n)
+	}
+}
+
+func testApps(t *testing.T, s *store.Stores) {
+	app := createApp(t, s, "Webtop")
+	if app.ID == "" || app.CreatedAt.IsZero() {
+		t.Errorf("Create did not assign an ID and CreatedAt: %+v", app)
+	}
+	if err := s.Apps.Create(ctx, &models.Application{Name: "Webtop"}); err != store.ErrConflict {
+		t.Errorf("Create with a taken name = %v, want ErrConflict", err)
+	}
+	app.LogoURL = "https://example.com/logo.png"
+	app.IsEnabled = false
+	if err := s.Apps.Update(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Apps.Get(ctx, app.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LogoURL != app.LogoURL || got.IsEnabled || got.DockerCompose != "services: {}" {
+		t.Errorf("Get after Update = %+v", got)
+	}
+	if err := s.Apps.Update(ctx, &models.Application{ID: uuid.New().String(), Name: "Missing"}); err != store.ErrNotFound {
+		t.Errorf("Update of a missing application = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Apps.Get(ctx, uuid.New().String()); err != store.ErrNotFound {
+		t.Errorf("Get of a missing application = %v, want ErrNotFound", err)
+	}
+
+	createApp(t, s, "Another")
+	apps, err := s.Apps.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 {
+		t.Errorf("List returned %d applications, want 2", len(apps))
+	}
+}
+
+func testGroups(t *testing.T, s *store.Stores) {
+	alice := createUser(t, s, "alice", false)
+	bob := createUser(t, s, "bob", false)
+	open := createApp(t, s, "Open")
+	restricted := createApp(t, s, "Restricted")
+
+	group := &models.Group{Name: "Engineering", Description: "Builds things"}
+	if err := s.Groups.Create(ctx, group); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Groups.Create(ctx, &models.Group{Name: "Engineering"}); err != store.ErrConflict {
+		t.Errorf("Create with a taken name = %v, want ErrConflict", err)
+	}
+	if err := s.Groups.SetApplications(ctx, group.ID, []string{restricted.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Groups.SetApplications(ctx, group.ID, []string{uuid.New().String()}); err != store.ErrNotFound {
+		t.Errorf("SetApplications with a missing application = %v, want ErrNotFound", err)
+	}
+	if err := s.Groups.AddMember(ctx, group.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Groups.AddMember(ctx, group.ID, alice.ID); err != nil {
+		t.Errorf("adding a member twice = %v", err)
+	}
+	if err := s.Groups.AddMember(ctx, group.ID, uuid.New().String()); err != store.ErrNotFound {
+		t.Errorf("AddMember of a missing user = %v, want ErrNotFound", err)
+	}
+
+	got, err := s.Groups.Get(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Description != "Builds things" || len(got.UserIDs) != 1 || got.UserIDs[0] != alice.ID ||
+		len(got.ApplicationIDs) != 1 || got.ApplicationIDs[0] != restricted.ID {
+		t.Errorf("Get = %+v", got)
+	}
+
+	for _, tt := range []struct {
+		user *models.User
+		app  *models.Application
+		want bool
+	}{
+		{alice, open, true},
+		{alice, restricted, true},
+		{bob, open, true},
+		{bob, restricted, false},
+	} {
+		if ok, err := s.Apps.Entitled(ctx, tt.user.ID, tt.app.ID); err != nil || ok != tt.want {
+			t.Errorf("Entitled(%s, %s) = %v, %v, want %v", tt.user.Username, tt.app.Name, ok, err, tt.want)
+		}
+	}
+	if apps, _ := s.Apps.ListEntitled(ctx, bob.ID); len(apps) != 1 || apps[0].ID != open.ID {
+		t.Errorf("ListEntitled(bob) = %+v", apps)
+	}
+
+	if err := s.Groups.RemoveMember(ctx, group.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Groups.RemoveMember(ctx, group.ID, alice.ID); err != store.ErrNotFound {
+		t.Errorf("removing a non-member = %v, want ErrNotFound", err)
+	}
+	if ok, _ := s.Apps.Entitled(ctx, alice.ID, restricted.ID); ok {
+		t.Error("a removed member is still entitled")
+	}
+
+	if err := s.Groups.Delete(ctx, group.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Apps.Entitled(ctx, bob.ID, restricted.ID); !ok {
+		t.Error("an application of a deleted group is still restricted")
+	}
+	if groups, _ := s.Groups.List(ctx); len(groups) != 0 {
+		t.Errorf("List after Delete = %+v", groups)
+	}
+}
+
+func testSessions(t *testing.T, s *store.Stores) {
+	alice := createUser(t, s, "alice", false)
+	bob := createUser(t, s, "bob", false)
+	app := createApp(t, s, "Webtop")
+
+	sess := &models.Session{
+		UserID: alice.ID, ApplicationID: app.ID, StackID: "7", StackName: "alice-1",
+		Orchestrator: "docker", TargetURL: "http://10.0.0.2:3000", IsPersistent: true,
+	}
+	if err := s.Sessions.Create(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	if sess.ID == "" || sess.CreatedAt.IsZero() {
+		t.Errorf("Create did not assign an ID and CreatedAt: %+v", sess)
+	}
+	if err := s.Sessions.Create(ctx, &models.Session{UserID: bob.ID, ApplicationID: app.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Sessions.Get(ctx, sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.StackID != "7" || got.StackName != "alice-1" || got.Orchestrator != "docker" ||
+		got.TargetURL != sess.TargetURL || !got.IsPersistent {
+		t.Errorf("Get = %+v", got)
+	}
+	if mine, _ := s.Sessions.ListByUser(ctx, alice.ID); len(mine) != 1 || mine[0].ID != sess.ID {
+		t.Errorf("ListByUser = %+v", mine)
+	}
+	if all, _ := s.Sessions.List(ctx); len(all) != 2 {
+		t.Errorf("List returned %d sessions, want 2", len(all))
+	}
+
+	if err := s.Sessions.Delete(ctx, sess.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sessions.Delete(ctx, sess.ID); err != store.ErrNotFound {
+		t.Errorf("deleting a deleted session = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Sessions.Get(ctx, sess.ID); err != store.ErrNotFound {
+		t.Errorf("Get of a deleted session = %v, want ErrNotFound", err)
+	}
+}
+
+func testTokens(t *testing.T, s *store.Stores) {
+	alice := createUser(t, s, "alice", false)
+	now := time.Now()
+	first := &models.RefreshToken{UserID: alice.ID, FamilyID: uuid.New().String(), TokenHash: "hash-1", ExpiresAt: now.Add(time.Hour)}
+	if err := s.Tokens.Create(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Tokens.GetByHash(ctx, "hash-1"); err != nil || got.ID != first.ID || got.UsedAt != nil {
+		t.Errorf("GetByHash = %+v, %v", got, err)
+	}
+	if _, err := s.Tokens.GetByHash(ctx, "missing"); err != store.ErrNotFound {
+		t.Errorf("GetByHash of a missing token = %v, want ErrNotFound", err)
+	}
+
+	second := &models.RefreshToken{UserID: alice.ID, FamilyID: first.FamilyID, TokenHash: "hash-2", ExpiresAt: now.Add(time.Hour)}
+	if err := s.Tokens.Rotate(ctx, first.ID, second); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Tokens.GetByHash(ctx, "hash-1"); got.UsedAt == nil {
+		t.Error("Rotate did not mark the old token used")
+	}
+	third := &models.RefreshToken{UserID: alice.ID, FamilyID: first.FamilyID, TokenHash: "hash-3", ExpiresAt: now.Add(time.Hour)}
+	if err := s.Tokens.Rotate(ctx, first.ID, third); err != store.ErrConflict {
+		t.Errorf("rotating a used token = %v, want ErrConflict", err)
+	}
+	if _, err := s.Tokens.GetByHash(ctx, "hash-3"); err != store.ErrNotFound {
+		t.Error("a failed Rotate inserted the next token")
+	}
+
+	if active, _ := s.Tokens.FamilyActive(ctx, first.FamilyID, now); !active {
+		t.Error("FamilyActive = false for a fresh login")
+	}
+	if active, _ := s.Tokens.FamilyActive(ctx, first.FamilyID, now.Add(2*time.Hour)); active {
+		t.Error("FamilyActive = true once every token expired")
+	}
+	if err := s.Tokens.RevokeFamily(ctx, first.FamilyID, now); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := s.Tokens.FamilyActive(ctx, first.FamilyID, now); active {
+		t.Error("FamilyActive = true after RevokeFamily")
+	}
+	if err := s.Tokens.Rotate(ctx, second.ID, third); err != store.ErrConflict {
+		t.Errorf("rotating a revoked token = %v, want ErrConflict", err)
+	}
+
+	other := &models.RefreshToken{UserID: alice.ID, FamilyID: uuid.New().String(), TokenHash: "hash-4", ExpiresAt: now.Add(time.Hour)}
+	if err := s.Tokens.Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Tokens.RevokeUser(ctx, alice.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := s.Tokens.FamilyActive(ctx, other.FamilyID, now); active {
+		t.Error("FamilyActive = true after RevokeUser")
+	}
+
+	if err := s.Tokens.DeleteExpired(ctx, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Tokens.GetByHash(ctx, "hash-4"); err != store.ErrNotFound {
+		t.Errorf("GetByHash of an expired token after DeleteExpired = %v, want ErrNotFound", err)
+	}
+}
+
+func testCredentials(t *testing.T, s *store.Stores) {
+	alice := createUser(t, s, "alice", false)
+	bob := createUser(t, s, "bob", false)
+	cred := &models.WebAuthnCredential{
+		UserID: alice.ID, CredentialID: []byte{1, 2, 3}, PublicKey: []byte{4, 5, 6},
+		SignCount: 5, Name: "YubiKey", Transports: []string{"usb", "nfc"},
+	}
+	if err := s.Credentials.Create(ctx, cred); err != nil {
+		t.Fatal(err)
+	}
+	dup := &models.WebAuthnCredential{UserID: bob.ID, CredentialID: []byte{1, 2, 3}, PublicKey: []byte{7}}
+	if err := s.Credentials.Create(ctx, dup); err != store.ErrConflict {
+		t.Errorf("registering a credential ID twice = %v, want ErrConflict", err)
+	}
+
+	got, err := s.Credentials.GetByCredentialID(ctx, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != cred.ID || string(got.PublicKey) != string(cred.PublicKey) || got.SignCount != 5 ||
+		strings.Join(got.Transports, ",") != "usb,nfc" || got.LastUsedAt != nil {
+		t.Errorf("GetByCredentialID = %+v", got)
+	}
+	if _, err := s.Credentials.GetByCredentialID(ctx, []byte{9}); err != store.ErrNotFound {
+		t.Errorf("GetByCredentialID of a missing credential = %v, want ErrNotFound", err)
+	}
+
+	used := time.Now().Truncate(time.Second)
+	if err := s.Credentials.UpdateSignCount(ctx, cred.ID, 6, used); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.Credentials.ListByUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].SignCount != 6 || list[0].LastUsedAt == nil || !list[0].LastUsedAt.Equal(used) {
+		t.Errorf("ListByUser after UpdateSignCount = %+v", list)
+	}
+
+	if err := s.Credentials.Delete(ctx, bob.ID, cred.ID); err != store.ErrNotFound {
+		t.Errorf("deleting another user's credential = %v, want ErrNotFound", err)
+	}
+	if err := s.Credentials.Delete(ctx, alice.ID, cred.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := s.Credentials.ListByUser(ctx, alice.ID); len(list) != 0 {
+		t.Errorf("ListByUser after Delete = %+v", list)
+	}
+}
+
+func testRoles(t *testing.T, s *store.Stores) {
+	roles, err := s.Roles.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builtin := map[string]bool{}
+	for _, r := range roles {
+		if r.Builtin {
+			builtin[r.Name] = true
+		}
+	}
+	for _, r := range rbac.Builtin {
+		if !builtin[r.Name] {
+			t.Errorf("built-in role %s is missing", r.Name)
+		}
+	}
+
+	role := &models.Role{Name: "auditor", Description: "Reads sessions", Permissions: []string{rbac.SessionsReadAll}}
+	if err := s.Roles.Create(ctx, role); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Roles.Create(ctx, &models.Role{Name: "auditor"}); err != store.ErrConflict {
+		t.Errorf("Create with a taken name = %v, want ErrConflict", err)
+	}
+	role.Permissions = []string{rbac.SessionsReadAll, rbac.AppsRead}
+	if err := s.Roles.Update(ctx, role); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Roles.Get(ctx, role.ID); err != nil || len(got.Permissions) != 2 || got.Builtin {
+		t.Errorf("Get after Update = %+v, %v", got, err)
+	}
+
+	alice := createUser(t, s, "alice", false)
+	if perms, _ := s.Roles.Permissions(ctx, alice.ID); strings.Join(perms, ",") != strings.Join(sortedPermissions(rbac.AppsRead, rbac.SessionsLaunch), ",") {
+		t.Errorf("Permissions of a new user = %q, want the user role's", perms)
+	}
+	if err := s.Roles.SetUserRoles(ctx, alice.ID, []string{role.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Roles.SetUserRoles(ctx, alice.ID, []string{uuid.New().String()}); err != store.ErrNotFound {
+		t.Errorf("SetUserRoles with a missing role = %v, want ErrNotFound", err)
+	}
+	if perms, _ := s.Roles.Permissions(ctx, alice.ID); strings.Join(perms, ",") != strings.Join(sortedPermissions(rbac.AppsRead, rbac.SessionsReadAll), ",") {
+		t.Errorf("Permissions = %q", perms)
+	}
+	assignments, err := s.Roles.ListAssignments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := assignments[alice.ID]; len(ids) != 1 || ids[0] != role.ID {
+		t.Errorf("ListAssignments[alice] = %q", ids)
+	}
+
+	if err := s.Roles.Delete(ctx, role.ID); err != nil {
+		t.Fatal(err)
+	}
+	if perms, _ := s.Roles.Permissions(ctx, alice.ID); len(perms) != 0 {
+		t.Errorf("Permissions after deleting the only role = %q", perms)
+	}
+	if _, err := s.Roles.Get(ctx, role.ID); err != store.ErrNotFound {
+		t.Errorf("Get of a deleted role = %v, want ErrNotFound", err)
+	}
+}
+
+// sortedPermissions returns perms in the order Permissions returns them.
+func sortedPermissions(perms ...string) []string {
+	sort.Strings(perms)
+	return perms
+}
+
+func testSettings(t *testing.T, s *store.Stores) {
+	if got, err := s.Settings.Get(ctx, "a", "b"); err != nil || len(got) != 0 {
+		t.Errorf("Get of unset keys = %q, %v", got, err)
+	}
+	if err := s.Settings.Set(ctx, map[string]string{"a": "1", "b": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Settings.Set(ctx, map[string]string{"b": "3"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Settings.Get(ctx, "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "map[a:1 b:3]" {
+		t.Errorf("Get = %q", got)
+	}
+}
+
+func testAPITokens(t *testing.T, s *store.Stores) {
+	alice := createUser(t, s, "alice", false)
+	bob := createUser(t, s, "bob", false)
+	expires := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	token := &models.APIToken{UserID: alice.ID, Name: "ci", TokenHash: "hash-1", Scopes: []string{rbac.SessionsLaunch}, ExpiresAt: &expires}
+	if err := s.APITokens.Create(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.APITokens.GetByHash(ctx, "hash-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != token.ID || got.Name != "ci" || len(got.Scopes) != 1 || got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires) {
+		t.Errorf("GetByHash = %+v", got)
+	}
+	used := time.Now().Truncate(time.Second)
+	if err := s.APITokens.Touch(ctx, token.ID, used); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.APITokens.ListByUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].LastUsedAt == nil || !list[0].LastUsedAt.Equal(used) {
+		t.Errorf("ListByUser after Touch = %+v", list)
+	}
+	if err := s.APITokens.Delete(ctx, bob.ID, token.ID); err != store.ErrNotFound {
+		t.Errorf("deleting another user's token = %v, want ErrNotFound", err)
+	}
+	if err := s.APITokens.Delete(ctx, alice.ID, token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.APITokens.GetByHash(ctx, "hash-1"); err != store.ErrNotFound {
+		t.Errorf("GetByHash of a deleted token = %v, want ErrNotFound", err)
+	}
+}
+
+// Deleting a user deletes everything that belongs to them.
+func testDeleteUser(t *testing.T, s *store.Stores) {
+	alice := createUser(t, s, "alice", false)
+	app := createApp(t, s, "Webtop")
+	sess := &models.Session{UserID: alice.ID, ApplicationID: app.ID}
+	if err := s.Sessions.Create(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Tokens.Create(ctx, &models.RefreshToken{UserID: alice.ID, FamilyID: uuid.New().String(), TokenHash: "refresh", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.APITokens.Create(ctx, &models.APIToken{UserID: alice.ID, Name: "ci", TokenHash: "api"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Credentials.Create(ctx, &models.WebAuthnCredential{UserID: alice.ID, CredentialID: []byte{1}, PublicKey: []byte{2}}); err != nil {
+		t.Fatal(err)
+	}
+	group := &models.Group{Name: "Engineering"}
+	if err := s.Groups.Create(ctx, group); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Groups.AddMember(ctx, group.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Users.Delete(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.Delete(ctx, alice.ID); err != store.ErrNotFound {
+		t.Errorf("deleting a deleted user = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Sessions.Get(ctx, sess.ID); err != store.ErrNotFound {
+		t.Errorf("the user's session survived: %v", err)
+	}
+	if _, err := s.Tokens.GetByHash(ctx, "refresh"); err != store.ErrNotFound {
+		t.Errorf("the user's refresh token survived: %v", err)
+	}
+	if _, err := s.APITokens.GetByHash(ctx, "api"); err != store.ErrNotFound {
+		t.Errorf("the user's API token survived: %v", err)
+	}
+	if _, err := s.Credentials.GetByCredentialID(ctx, []byte{1}); err != store.ErrNotFound {
+		t.Errorf("the user's passkey survived: %v", err)
+	}
+	if got, _ := s.Groups.Get(ctx, group.ID); len(got.UserIDs) != 0 {
+		t.Errorf("the user is still a member: %+v", got)
+	}
+}
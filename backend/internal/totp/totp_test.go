@@ -0,0 +1,88 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"webtop-launcher/internal/totp"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, ASCII
+// "12345678901234567890".
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// TestRFC6238 checks the SHA-1 test vectors of RFC 6238, appendix B. The
+// RFC lists eight digits; six-digit codes are their last six.
+func TestRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		got, err := totp.Code(rfcSecret, totp.Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.want[len(tt.want)-totp.Digits:]; got != want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totp.Step(now)
+	code := func(s int64) string {
+		c, err := totp.Code(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	for _, offset := range []int64{-1, 0, 1} {
+		if got, ok := totp.Validate(rfcSecret, code(step+offset), now); !ok || got != step+offset {
+			t.Errorf("code %d steps off: %d, %v", offset, got, ok)
+		}
+	}
+	for _, offset := range []int64{-2, 2} {
+		if _, ok := totp.Validate(rfcSecret, code(step+offset), now); ok {
+			t.Errorf("code %d steps off was accepted", offset)
+		}
+	}
+	c := code(step)
+	if _, ok := totp.Validate(strings.ToLower(rfcSecret), c[:3]+" "+c[3:], now); !ok {
+		t.Error("a code with a space or a lowercase secret was refused")
+	}
+	for _, bad := range []string{"", c[:5], c + "0", "abcdef"} {
+		if _, ok := totp.Validate(rfcSecret, bad, now); ok {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+	if _, ok := totp.Validate("not base32!", c, now); ok {
+		t.Error("an invalid secret accepted a code")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := totp.GenerateSecret()
+	if a == b || len(a) != 32 {
+		t.Errorf("secrets %q and %q, want two different 160-bit ones", a, b)
+	}
+	if _, err := totp.Code(a, 0); err != nil {
+		t.Errorf("a generated secret does not decode: %v", err)
+	}
+}
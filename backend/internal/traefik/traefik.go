@@ -0,0 +1,276 @@
+// Package traefik generates Traefik dynamic configuration for running
+// sessions: one router and one service per row of the sessions table. It is
+// served to Traefik's HTTP provider and can also be written to a file for
+// the file provider.
+package traefik
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"webtop-launcher/internal/config"
+	"webtop-launcher/internal/proxy"
+	"webtop-launcher/internal/store"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Middleware names. StripPrefixMiddleware removes /s/{sessionId} before a
+// request reaches the session container; ForwardAuthMiddleware asks the
+// backend whether the request may reach it at all.
+const (
+	StripPrefixMiddleware = "webtop-strip-prefix"
+	ForwardAuthMiddleware = "webtop-auth"
+)
+
+// ErrNoForwardAuth is returned when Traefik integration is enabled without
+// TRAEFIK_FORWARD_AUTH_URL. Without the check, Traefik would hand every
+// session to anyone who knows its URL.
+var ErrNoForwardAuth = errors.New("TRAEFIK_FORWARD_AUTH_URL is required to route sessions through Traefik")
+
+// Config is the subset of Traefik's dynamic configuration the launcher
+// produces. It marshals to both the JSON the HTTP provider expects and the
+// YAML the file provider reads.
+type Config struct {
+	HTTP HTTPConfig `json:"http" yaml:"http"`
+}
+
+type HTTPConfig struct {
+	Routers     map[string]Router     `json:"routers,omitempty" yaml:"routers,omitempty"`
+	Middlewares map[string]Middleware `json:"middlewares,omitempty" yaml:"middlewares,omitempty"`
+	Services    map[string]Service    `json:"services,omitempty" yaml:"services,omitempty"`
+}
+
+type Router struct {
+	Rule        string   `json:"rule" yaml:"rule"`
+	Service     string   `json:"service" yaml:"service"`
+	EntryPoints []string `json:"entryPoints,omitempty" yaml:"entryPoints,omitempty"`
+	Middlewares []string `json:"middlewares,omitempty" yaml:"middlewares,omitempty"`
+}
+
+type Middleware struct {
+	StripPrefixRegex *StripPrefixRegex `json:"stripPrefixRegex,omitempty" yaml:"stripPrefixRegex,omitempty"`
+	ForwardAuth      *ForwardAuth      `json:"forwardAuth,omitempty" yaml:"forwardAuth,omitempty"`
+}
+
+type ForwardAuth struct {
+	Address             string   `json:"address" yaml:"address"`
+	AuthResponseHeaders []string `json:"authResponseHeaders,omitempty" yaml:"authResponseHeaders,omitempty"`
+}
+
+type StripPrefixRegex struct {
+	Regex []string `json:"regex" yaml:"regex"`
+}
+
+type Service struct {
+	LoadBalancer LoadBalancer `json:"loadBalancer" yaml:"loadBalancer"`
+}
+
+type LoadBalancer struct {
+	Servers []Server `json:"servers" yaml:"servers"`
+}
+
+type Server struct {
+	URL string `json:"url" yaml:"url"`
+}
+
+// Route is a session that Traefik should route to.
+type Route struct {
+	SessionID string
+	// URL is the session container's web address, e.g. http://172.18.0.5:3000.
+	URL string
+}
+
+// Options tune the generated routers.
+type Options struct {
+	// EntryPoints restricts the routers to these entry points; empty means
+	// all of them.
+	EntryPoints []string
+	// Host, if set, is added to every router rule.
+	Host string
+	// ForwardAuthURL is the backend's /api/auth/forward endpoint as Traefik
+	// reaches it. Every router checks it before the request is passed on,
+	// so only the session owner or an admin gets through.
+	ForwardAuthURL string
+}
+
+// Validate reports ErrNoForwardAuth when ForwardAuthURL is empty.
+func (o Options) Validate() error {
+	if o.ForwardAuthURL == "" {
+		return ErrNoForwardAuth
+	}
+	return nil
+}
+
+// Name returns the router and service name for a session.
+func Name(sessionID string) string {
+	return "webtop-" + sessionID
+}
+
+// Build returns the configuration for routes. The output only depends on its
+// inputs, so it can be compared against a golden file. Callers must check
+// that opts.ForwardAuthURL is set; see Options.Validate.
+func Build(routes []Route, opts Options) *Config {
+	cfg := &Config{HTTP: HTTPConfig{
+		Routers:  map[string]Router{},
+		Services: map[string]Service{},
+		Middlewares: map[string]Middleware{
+			ForwardAuthMiddleware: {ForwardAuth: &ForwardAuth{
+				Address:             opts.ForwardAuthURL,
+				AuthResponseHeaders: []string{proxy.HeaderUser, proxy.HeaderUserID, proxy.HeaderSession},
+			}},
+			StripPrefixMiddleware: {StripPrefixRegex: &StripPrefixRegex{Regex: []string{"^/s/[^/]+"}}},
+		},
+	}}
+	// Authenticate first: the check needs the unstripped path.
+	middlewares := []string{ForwardAuthMiddleware, StripPrefixMiddleware}
+	for _, route := range routes {
+		name := Name(route.SessionID)
+		rule := fmt.Sprintf("PathPrefix(`/s/%s`)", route.SessionID)
+		if opts.Host != "" {
+			rule = fmt.Sprintf("Host(`%s`) && %s", opts.Host, rule)
+		}
+		cfg.HTTP.Routers[name] = Router{
+			Rule:        rule,
+			Service:     name,
+			EntryPoints: opts.EntryPoints,
+			Middlewares: middlewares,
+		}
+		cfg.HTTP.Services[name] = Service{LoadBalancer: LoadBalancer{Servers: []Server{{URL: route.URL}}}}
+	}
+	return cfg
+}
+
+// LoadRoutes returns the sessions whose container address is known,
+// ordered by session ID.
+func LoadRoutes(ctx context.Context, sessions store.SessionStore) ([]Route, error) {
+	all, err := sessions.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	routes := []Route{}
+	for _, s := range all {
+		if s.TargetURL != "" {
+			routes = append(routes, Route{SessionID: s.ID, URL: s.TargetURL})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].SessionID < routes[j].SessionID })
+	return routes, nil
+}
+
+// Provider serves and writes the configuration for the sessions in
+// Sessions.
+type Provider struct {
+	Sessions store.SessionStore
+	Options  Options
+	// File is where Sync writes the configuration for Traefik's file
+	// provider. Sync does nothing when it is empty.
+	File string
+	// Token must be sent as a bearer token to the HTTP endpoint. The
+	// endpoint answers 404 when it is empty.
+	Token string
+}
+
+// NewProvider returns a provider configured from the TRAEFIK_* settings. It
+// returns ErrNoForwardAuth if the file or the HTTP provider is enabled
+// without a forward auth URL.
+func NewProvider(sessions store.SessionStore, cfg *config.Config) (*Provider, error) {
+	p := &Provider{
+		Sessions: sessions,
+		Options: Options{
+			EntryPoints:    cfg.TraefikEntryPoints,
+			Host:           cfg.TraefikHost,
+			ForwardAuthURL: cfg.TraefikForwardAuthURL,
+		},
+		File:  cfg.TraefikDynamicFile,
+		Token: cfg.TraefikProviderToken,
+	}
+	if p.File != "" || p.Token != "" {
+		if err := p.Options.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// fileMu serialises Sync so an older snapshot never replaces a newer one.
+var fileMu sync.Mutex
+
+// Config builds the current configuration. It returns ErrNoForwardAuth
+// rather than routes without authentication.
+func (p *Provider) Config(ctx context.Context) (*Config, error) {
+	if err := p.Options.Validate(); err != nil {
+		return nil, err
+	}
+	routes, err := LoadRoutes(ctx, p.Sessions)
+	if err != nil {
+		return nil, err
+	}
+	return Build(routes, p.Options), nil
+}
+
+// Sync rewrites File with the current configuration. It is called whenever
+// a session starts or stops; Traefik watches the file for changes.
+func (p *Provider) Sync(ctx context.Context) error {
+	if p.File == "" {
+		return nil
+	}
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	cfg, err := p.Config(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename it into place, so Traefik never
+	// reads a half-written file.
+	tmp, err := os.CreateTemp(filepath.Dir(p.File), ".webtop-traefik-*.yml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p.File)
+}
+
+// ServeHTTP answers Traefik's HTTP provider polls.
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.Token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.Token)) != 1 {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	cfg, err := p.Config(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
@@ -0,0 +1,83 @@
+package traefik_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"webtop-launcher/internal/config"
+	"webtop-launcher/internal/models"
+	"webtop-launcher/internal/store"
+	"webtop-launcher/internal/traefik"
+)
+
+func TestBuild(t *testing.T) {
+	cfg := traefik.Build([]traefik.Route{{SessionID: "abc", URL: "http://172.18.0.5:3000"}}, traefik.Options{
+		Host:           "webtop.example.com",
+		ForwardAuthURL: "http://backend:8080/api/auth/forward",
+	})
+	router, ok := cfg.HTTP.Routers[traefik.Name("abc")]
+	if !ok {
+		t.Fatalf("routers = %+v", cfg.HTTP.Routers)
+	}
+	if router.Rule != "Host(`webtop.example.com`) && PathPrefix(`/s/abc`)" {
+		t.Errorf("rule = %q", router.Rule)
+	}
+	if len(router.Middlewares) != 2 || router.Middlewares[0] != traefik.ForwardAuthMiddleware || router.Middlewares[1] != traefik.StripPrefixMiddleware {
+		t.Errorf("middlewares = %q, want forward auth before the prefix is stripped", router.Middlewares)
+	}
+	auth := cfg.HTTP.Middlewares[traefik.ForwardAuthMiddleware].ForwardAuth
+	if auth == nil || auth.Address != "http://backend:8080/api/auth/forward" {
+		t.Errorf("forward auth = %+v", auth)
+	}
+}
+
+// Without forward auth, Traefik would serve every session to anyone, so no
+// configuration is produced at all.
+func TestForwardAuthRequired(t *testing.T) {
+	sessions := store.NewMemory().Sessions
+	if err := sessions.Create(context.Background(), &models.Session{ID: "abc", TargetURL: "http://172.18.0.5:3000"}); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "webtop.yml")
+
+	for _, cfg := range []*config.Config{{TraefikDynamicFile: file}, {TraefikProviderToken: "secret"}} {
+		if _, err := traefik.NewProvider(sessions, cfg); err != traefik.ErrNoForwardAuth {
+			t.Errorf("NewProvider(%+v) = %v, want ErrNoForwardAuth", cfg, err)
+		}
+	}
+	if _, err := traefik.NewProvider(sessions, &config.Config{}); err != nil {
+		t.Errorf("NewProvider without Traefik integration = %v", err)
+	}
+
+	p := &traefik.Provider{Sessions: sessions, File: file, Token: "secret"}
+	if err := p.Sync(context.Background()); err != traefik.ErrNoForwardAuth {
+		t.Errorf("Sync = %v, want ErrNoForwardAuth", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("Sync wrote a configuration without forward auth")
+	}
+	req := httptest.NewRequest("GET", "/api/traefik/config", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "routers") {
+		t.Errorf("ServeHTTP = %d %s, want an error and no routers", rec.Code, rec.Body)
+	}
+
+	p.Options.ForwardAuthURL = "http://backend:8080/api/auth/forward"
+	if err := p.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), traefik.ForwardAuthMiddleware) {
+		t.Errorf("written configuration has no forward auth:\n%s", data)
+	}
+}
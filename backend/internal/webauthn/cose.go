@@ -0,0 +1,116 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE algorithms the launcher accepts, in order of preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var supportedAlgs = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters (RFC 9053).
+const (
+	coseKty = 1
+	coseAlg = 3
+	// EC2 and OKP keys: curve and coordinates. RSA keys reuse -1 and -2
+	// for the modulus and exponent.
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// parsePublicKey decodes a COSE_Key into a crypto public key and its
+// algorithm.
+func parsePublicKey(coseKey []byte) (crypto.PublicKey, int, error) {
+	var params map[int]interface{}
+	if err := cbor.Unmarshal(coseKey, &params); err != nil {
+		return nil, 0, fmt.Errorf("COSE key: %w", err)
+	}
+	kty, _ := coseInt(params[coseKty])
+	alg, _ := coseInt(params[coseAlg])
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := coseInt(params[coseCrv])
+		x, _ := params[coseX].([]byte)
+		y, _ := params[coseY].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("COSE key: ES256 needs a P-256 point")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, errors.New("COSE key: point is not on the curve")
+		}
+		return pub, alg, nil
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := coseInt(params[coseCrv])
+		x, _ := params[coseX].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("COSE key: EdDSA needs an Ed25519 key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := params[coseCrv].([]byte)
+		e, _ := params[coseX].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("COSE key: RS256 needs a key of at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("COSE key: unsupported key type %d with algorithm %d", kty, alg)
+}
+
+// coseInt reads an integer parameter, which CBOR decodes as uint64 or
+// int64 depending on its sign.
+func coseInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return int(n), true
+	case int64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// verifySignature checks sig over data with a COSE_Key.
+func verifySignature(coseKey, data, sig []byte) error {
+	pub, _, err := parsePublicKey(coseKey)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(data)
+	ok := false
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(pub, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(pub, data, sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+	}
+	if !ok {
+		return errors.New("signature does not verify")
+	}
+	return nil
+}
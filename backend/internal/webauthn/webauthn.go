@@ -0,0 +1,476 @@
+// Package webauthn implements the relying party side of the WebAuthn
+// registration and authentication ceremonies, for passkeys and security
+// keys. Attestation statements are not verified: the launcher trusts any
+// authenticator the user chooses, so only "none" attestation is requested.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// ErrVerification is wrapped by every error about a response that does not
+// verify, as opposed to one that cannot be parsed at all.
+var ErrVerification = errors.New("webauthn: verification failed")
+
+// User verification requirements.
+const (
+	VerificationRequired    = "required"
+	VerificationPreferred   = "preferred"
+	VerificationDiscouraged = "discouraged"
+)
+
+// DefaultTimeout is how long the browser waits for the authenticator.
+const DefaultTimeout = 5 * time.Minute
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+// Base64URL is binary data that is base64url-encoded in JSON, as browsers
+// serialize credentials. Padding is accepted when decoding.
+type Base64URL []byte
+
+// MarshalJSON encodes b without padding.
+func (b Base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes base64url with or without padding.
+func (b *Base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// Config identifies the relying party.
+type Config struct {
+	// RPID is the domain credentials are bound to, e.g.
+	// "launcher.example.com". It cannot change without losing them.
+	RPID   string
+	RPName string
+	// Origins are the origins the browser may report, e.g.
+	// "https://launcher.example.com".
+	Origins []string
+	Timeout time.Duration
+}
+
+// RelyingParty runs ceremonies for one Config.
+type RelyingParty struct {
+	cfg Config
+}
+
+// New checks cfg and returns its relying party.
+func New(cfg Config) (*RelyingParty, error) {
+	if cfg.RPID == "" {
+		return nil, errors.New("webauthn: RP ID is required")
+	}
+	if len(cfg.Origins) == 0 {
+		return nil, errors.New("webauthn: at least one origin is required")
+	}
+	for _, o := range cfg.Origins {
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			return nil, fmt.Errorf("webauthn: invalid origin %q", o)
+		}
+		host := u.Hostname()
+		if host != cfg.RPID && !strings.HasSuffix(host, "."+cfg.RPID) {
+			return nil, fmt.Errorf("webauthn: origin %s is not within RP ID %s", o, cfg.RPID)
+		}
+	}
+	if cfg.RPName == "" {
+		cfg.RPName = cfg.RPID
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	return &RelyingParty{cfg: cfg}, nil
+}
+
+// User is the account a credential is registered for. ID is the opaque
+// user handle stored on the authenticator.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE_Key as the authenticator sent it.
+	PublicKey  []byte
+	SignCount  uint32
+	Transports []string
+}
+
+// Session is what a ceremony remembers between its two steps. It holds
+// nothing secret but must be kept from the client's tampering, e.g. by
+// signing it.
+type Session struct {
+	Challenge        Base64URL   `json:"challenge"`
+	UserID           Base64URL   `json:"userId,omitempty"`
+	AllowCredentials []Base64URL `json:"allowCredentials,omitempty"`
+	UserVerification string      `json:"userVerification"`
+}
+
+// CredentialParameter is a credential type and algorithm the relying party
+// accepts.
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor names a credential.
+type CredentialDescriptor struct {
+	Type       string    `json:"type"`
+	ID         Base64URL `json:"id"`
+	Transports []string  `json:"transports,omitempty"`
+}
+
+// RelyingPartyEntity and UserEntity describe the two parties to the
+// authenticator.
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Base64URL `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"displayName"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions are the publicKey options of navigator.credentials.create.
+type CreationOptions struct {
+	Challenge              Base64URL              `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the publicKey options of navigator.credentials.get.
+type RequestOptions struct {
+	Challenge        Base64URL              `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the JSON form of the credential
+// navigator.credentials.create returns.
+type RegistrationResponse struct {
+	ID       string                           `json:"id"`
+	RawID    Base64URL                        `json:"rawId"`
+	Type     string                           `json:"type"`
+	Response AuthenticatorAttestationResponse `json:"response"`
+}
+
+type AuthenticatorAttestationResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AttestationObject Base64URL `json:"attestationObject"`
+	Transports        []string  `json:"transports,omitempty"`
+}
+
+// AssertionResponse is the JSON form of the credential
+// navigator.credentials.get returns.
+type AssertionResponse struct {
+	ID       string                         `json:"id"`
+	RawID    Base64URL                      `json:"rawId"`
+	Type     string                         `json:"type"`
+	Response AuthenticatorAssertionResponse `json:"response"`
+}
+
+type AuthenticatorAssertionResponse struct {
+	ClientDataJSON    Base64URL `json:"clientDataJSON"`
+	AuthenticatorData Base64URL `json:"authenticatorData"`
+	Signature         Base64URL `json:"signature"`
+	UserHandle        Base64URL `json:"userHandle,omitempty"`
+}
+
+// BeginRegistration starts registering a credential for user, excluding
+// the credentials already registered so an authenticator is not added
+// twice. Credentials are discoverable where the authenticator allows, so
+// they can sign in without a username.
+func (rp *RelyingParty) BeginRegistration(user User, exclude []Credential) (*CreationOptions, *Session, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := &CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingPartyEntity{ID: rp.cfg.RPID, Name: rp.cfg.RPName},
+		User:      UserEntity{ID: user.ID, Name: user.Name, DisplayName: user.DisplayName},
+		Timeout:   rp.cfg.Timeout.Milliseconds(),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: VerificationPreferred,
+		},
+		ExcludeCredentials: descriptors(exclude),
+		Attestation:        "none",
+	}
+	for _, alg := range supportedAlgs {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	return opts, &Session{Challenge: challenge, UserID: user.ID, UserVerification: VerificationPreferred}, nil
+}
+
+// FinishRegistration verifies the response to BeginRegistration and
+// returns the new credential.
+func (rp *RelyingParty) FinishRegistration(s *Session, resp *RegistrationResponse) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, fmt.Errorf("%w: credential type %q", ErrVerification, resp.Type)
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", s.Challenge); err != nil {
+		return nil, err
+	}
+
+	var attestation struct {
+		Fmt      string          `cbor:"fmt"`
+		AttStmt  cbor.RawMessage `cbor:"attStmt"`
+		AuthData []byte          `cbor:"authData"`
+	}
+	if err := cbor.Unmarshal(resp.Response.AttestationObject, &attestation); err != nil {
+		return nil, fmt.Errorf("webauthn: attestation object: %w", err)
+	}
+	data, err := parseAuthData(attestation.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthData(data, s.UserVerification); err != nil {
+		return nil, err
+	}
+	if data.flags&flagAttested == 0 {
+		return nil, fmt.Errorf("%w: no attested credential data", ErrVerification)
+	}
+	if !bytes.Equal(data.credentialID, resp.RawID) {
+		return nil, fmt.Errorf("%w: credential ID does not match the attested one", ErrVerification)
+	}
+	if _, _, err := parsePublicKey(data.publicKey); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+	return &Credential{
+		ID:         data.credentialID,
+		PublicKey:  data.publicKey,
+		SignCount:  data.signCount,
+		Transports: resp.Response.Transports,
+	}, nil
+}
+
+// BeginLogin starts an authentication. With no allowed credentials the
+// authenticator offers its discoverable ones, and userID is learned from
+// the response; otherwise userID is the account being verified.
+func (rp *RelyingParty) BeginLogin(userID []byte, allow []Credential, userVerification string) (*RequestOptions, *Session, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &Session{Challenge: challenge, UserID: userID, UserVerification: userVerification}
+	for _, c := range allow {
+		s.AllowCredentials = append(s.AllowCredentials, c.ID)
+	}
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.cfg.Timeout.Milliseconds(),
+		RPID:             rp.cfg.RPID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: userVerification,
+	}, s, nil
+}
+
+// FinishLogin verifies the response to BeginLogin, made with cred, and
+// returns the credential's new signature counter. The caller looks cred up
+// by the response's RawID and must check that it belongs to the user
+// handle the response names.
+func (rp *RelyingParty) FinishLogin(s *Session, resp *AssertionResponse, cred *Credential) (uint32, error) {
+	if resp.Type != "public-key" {
+		return 0, fmt.Errorf("%w: credential type %q", ErrVerification, resp.Type)
+	}
+	if !bytes.Equal(resp.RawID, cred.ID) {
+		return 0, fmt.Errorf("%w: response is for another credential", ErrVerification)
+	}
+	if len(s.AllowCredentials) > 0 {
+		allowed := false
+		for _, id := range s.AllowCredentials {
+			allowed = allowed || bytes.Equal(id, cred.ID)
+		}
+		if !allowed {
+			return 0, fmt.Errorf("%w: credential was not allowed", ErrVerification)
+		}
+	}
+	if len(s.UserID) > 0 && len(resp.Response.UserHandle) > 0 && !bytes.Equal(s.UserID, resp.Response.UserHandle) {
+		return 0, fmt.Errorf("%w: user handle does not match", ErrVerification)
+	}
+	if err := rp.verifyClientData(resp.Response.ClientDataJSON, "webauthn.get", s.Challenge); err != nil {
+		return 0, err
+	}
+	data, err := parseAuthData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthData(data, s.UserVerification); err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := verifySignature(cred.PublicKey, signed, resp.Response.Signature); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+	// Authenticators without a counter, and synced passkeys, always report
+	// zero; the stored counter is kept for them. A counter that does not
+	// increase means the credential was cloned.
+	if data.signCount == 0 {
+		return cred.SignCount, nil
+	}
+	if data.signCount <= cred.SignCount {
+		return 0, fmt.Errorf("%w: signature counter went backwards, the authenticator may be cloned", ErrVerification)
+	}
+	return data.signCount, nil
+}
+
+// clientData is the part of the collected client data that is checked.
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("webauthn: client data: %w", err)
+	}
+	if cd.Type != ceremony {
+		return fmt.Errorf("%w: client data is for %q, not %q", ErrVerification, cd.Type, ceremony)
+	}
+	got, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return fmt.Errorf("%w: challenge does not match", ErrVerification)
+	}
+	if cd.CrossOrigin {
+		return fmt.Errorf("%w: cross-origin request", ErrVerification)
+	}
+	for _, o := range rp.cfg.Origins {
+		if cd.Origin == o {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: origin %s is not allowed", ErrVerification, cd.Origin)
+}
+
+// authData is parsed authenticator data.
+type authData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthData(raw []byte) (*authData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+	data := &authData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	rest := raw[37:]
+	if data.flags&flagAttested != 0 {
+		// AAGUID, credential ID length and ID, then the COSE key.
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data too short")
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < n {
+			return nil, errors.New("webauthn: credential ID truncated")
+		}
+		data.credentialID, rest = rest[:n], rest[n:]
+		var key cbor.RawMessage
+		after, err := cbor.UnmarshalFirst(rest, &key)
+		if err != nil {
+			return nil, fmt.Errorf("webauthn: credential public key: %w", err)
+		}
+		data.publicKey, rest = rest[:len(rest)-len(after)], after
+	}
+	if data.flags&flagExtensions != 0 {
+		var ext cbor.RawMessage
+		after, err := cbor.UnmarshalFirst(rest, &ext)
+		if err != nil {
+			return nil, fmt.Errorf("webauthn: extensions: %w", err)
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("webauthn: trailing bytes in authenticator data")
+	}
+	return data, nil
+}
+
+func (rp *RelyingParty) verifyAuthData(data *authData, userVerification string) error {
+	want := sha256.Sum256([]byte(rp.cfg.RPID))
+	if !bytes.Equal(data.rpIDHash, want[:]) {
+		return fmt.Errorf("%w: credential is for another RP ID", ErrVerification)
+	}
+	if data.flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user was not present", ErrVerification)
+	}
+	if userVerification == VerificationRequired && data.flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user was not verified", ErrVerification)
+	}
+	return nil
+}
+
+func descriptors(creds []Credential) []CredentialDescriptor {
+	out := []CredentialDescriptor{}
+	for _, c := range creds {
+		out = append(out, CredentialDescriptor{Type: "public-key", ID: c.ID, Transports: c.Transports})
+	}
+	return out
+}
+
+func newChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,165 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+
+	"webtop-launcher/internal/webauthn"
+	"webtop-launcher/internal/webauthn/webauthntest"
+)
+
+const origin = "https://launcher.test"
+
+func newRP(t *testing.T, rpID, origin string) *webauthn.RelyingParty {
+	t.Helper()
+	rp, err := webauthn.New(webauthn.Config{RPID: rpID, Origins: []string{origin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+// register registers a credential of a with rp, for the RP ID set by edit.
+func register(t *testing.T, rp *webauthn.RelyingParty, a *webauthntest.Authenticator, edit func(*webauthn.CreationOptions)) (*webauthn.Credential, error) {
+	t.Helper()
+	opts, session, err := rp.BeginRegistration(webauthn.User{ID: []byte("alice-id"), Name: "alice"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		edit(opts)
+	}
+	resp, err := a.Create(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp.FinishRegistration(session, resp)
+}
+
+// login signs in with a's credential for cred, asked for the RP ID set by
+// edit, and stores the new counter in cred.
+func login(t *testing.T, rp *webauthn.RelyingParty, a *webauthntest.Authenticator, cred *webauthn.Credential, edit func(*webauthn.RequestOptions)) error {
+	t.Helper()
+	opts, session, err := rp.BeginLogin(nil, nil, webauthn.VerificationRequired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		edit(opts)
+	}
+	resp, err := a.Get(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := rp.FinishLogin(session, resp, cred)
+	if err == nil {
+		cred.SignCount = count
+	}
+	return err
+}
+
+func TestCeremonies(t *testing.T) {
+	rp := newRP(t, "launcher.test", origin)
+	a := webauthntest.New(origin)
+	cred, err := register(t, rp, a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		if err := login(t, rp, a, cred, nil); err != nil {
+			t.Fatalf("login %d: %v", i, err)
+		}
+		if cred.SignCount != uint32(i) {
+			t.Errorf("counter after login %d = %d", i, cred.SignCount)
+		}
+	}
+
+	a.UserVerified = false
+	if err := login(t, rp, a, cred, nil); !errors.Is(err, webauthn.ErrVerification) {
+		t.Errorf("login without user verification: %v", err)
+	}
+}
+
+func TestWrongOrigin(t *testing.T) {
+	rp := newRP(t, "launcher.test", origin)
+	a := webauthntest.New(origin)
+	cred, err := register(t, rp, a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const phishing = "https://launcher.test.evil.example"
+	if _, err := register(t, rp, webauthntest.New(phishing), nil); !errors.Is(err, webauthn.ErrVerification) {
+		t.Errorf("registration from another origin: %v", err)
+	}
+	a.Origin = phishing
+	if err := login(t, rp, a, cred, nil); !errors.Is(err, webauthn.ErrVerification) {
+		t.Errorf("login from another origin: %v", err)
+	}
+}
+
+func TestWrongRPID(t *testing.T) {
+	rp := newRP(t, "launcher.test", origin)
+	if _, err := register(t, rp, webauthntest.New(origin), func(opts *webauthn.CreationOptions) {
+		opts.RP.ID = "other.test"
+	}); !errors.Is(err, webauthn.ErrVerification) {
+		t.Errorf("registration for another RP ID: %v", err)
+	}
+
+	// A credential of another site, used from the launcher's origin.
+	other := webauthntest.New("https://other.test")
+	cred, err := register(t, newRP(t, "other.test", "https://other.test"), other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Origin = origin
+	if err := login(t, rp, other, cred, func(opts *webauthn.RequestOptions) {
+		opts.RPID = "other.test"
+	}); !errors.Is(err, webauthn.ErrVerification) {
+		t.Errorf("login for another RP ID: %v", err)
+	}
+}
+
+func TestSignCount(t *testing.T) {
+	rp := newRP(t, "launcher.test", origin)
+	a := webauthntest.New(origin)
+	cred, err := register(t, rp, a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := a.Clone()
+	for i := 0; i < 2; i++ {
+		if err := login(t, rp, a, cred, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := login(t, rp, clone, cred, nil); !errors.Is(err, webauthn.ErrVerification) {
+		t.Errorf("login with a counter that went backwards: %v", err)
+	}
+	if cred.SignCount != 2 {
+		t.Errorf("counter = %d after a refused login, want 2", cred.SignCount)
+	}
+
+	// Synced passkeys always report zero, which keeps the stored counter.
+	a.Synced = true
+	for i := 0; i < 2; i++ {
+		if err := login(t, rp, a, cred, nil); err != nil {
+			t.Fatalf("login with a synced passkey: %v", err)
+		}
+	}
+	if cred.SignCount != 2 {
+		t.Errorf("counter = %d after synced logins, want 2", cred.SignCount)
+	}
+
+	synced := webauthntest.New(origin)
+	synced.Synced = true
+	cred, err = register(t, rp, synced, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := login(t, rp, synced, cred, nil); err != nil {
+			t.Fatalf("login %d with a synced passkey: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Package webauthntest provides a software authenticator that answers
+// WebAuthn ceremonies the way a browser and a passkey do, so registration
+// and login can be exercised in Go tests without either.
+package webauthntest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"webtop-launcher/internal/webauthn"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Authenticator is a platform authenticator holding discoverable ES256
+// credentials. Origin is what it reports as the calling page. Fields may
+// be changed between ceremonies, e.g. to report another origin.
+type Authenticator struct {
+	Origin string
+	// UserVerified makes it report that the user was verified, as a
+	// passkey unlocked with a fingerprint or PIN does.
+	UserVerified bool
+	// Synced makes it report a signature counter of zero, as passkeys
+	// synced between devices do.
+	Synced bool
+
+	mu    sync.Mutex
+	creds []*credential
+}
+
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	rpID       string
+	userHandle []byte
+	signCount  uint32
+}
+
+// ErrNoCredential is returned when no credential matches a request.
+var ErrNoCredential = errors.New("webauthntest: no matching credential")
+
+// New returns an authenticator used from origin, which verifies the user.
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, UserVerified: true}
+}
+
+// Clone returns an authenticator holding copies of a's credentials, with
+// their current signature counters, as a cloned security key would.
+func (a *Authenticator) Clone() *Authenticator {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	clone := &Authenticator{Origin: a.Origin, UserVerified: a.UserVerified, Synced: a.Synced}
+	for _, c := range a.creds {
+		copied := *c
+		clone.creds = append(clone.creds, &copied)
+	}
+	return clone
+}
+
+// Create makes a credential, like navigator.credentials.create.
+func (a *Authenticator) Create(opts *webauthn.CreationOptions) (*webauthn.RegistrationResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, ex := range opts.ExcludeCredentials {
+		if a.find(opts.RP.ID, ex.ID) != nil {
+			return nil, errors.New("webauthntest: credential already registered")
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	c := &credential{id: randomBytes(16), key: key, rpID: opts.RP.ID, userHandle: opts.User.ID}
+	a.creds = append(a.creds, c)
+
+	coseKey, err := encode(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: pad32(key.PublicKey.X.Bytes()),
+		-3: pad32(key.PublicKey.Y.Bytes()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(c.id)))
+	attested = append(append(attested, c.id...), coseKey...)
+	authData := a.authData(c, 0x40)
+	authData = append(authData, attested...)
+
+	attestation, err := encode(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(c.id),
+		RawID: c.id,
+		Type:  "public-key",
+		Response: webauthn.AuthenticatorAttestationResponse{
+			ClientDataJSON:    a.clientData("webauthn.create", opts.Challenge),
+			AttestationObject: attestation,
+			Transports:        []string{"internal"},
+		},
+	}, nil
+}
+
+// Get signs a challenge with a credential, like navigator.credentials.get:
+// one of the allowed credentials, or else the newest discoverable one for
+// the RP ID.
+func (a *Authenticator) Get(opts *webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var c *credential
+	for _, allowed := range opts.AllowCredentials {
+		if c = a.find(opts.RPID, allowed.ID); c != nil {
+			break
+		}
+	}
+	if len(opts.AllowCredentials) == 0 {
+		for _, cand := range a.creds {
+			if cand.rpID == opts.RPID {
+				c = cand
+			}
+		}
+	}
+	if c == nil {
+		return nil, ErrNoCredential
+	}
+
+	c.signCount++
+	authData := a.authData(c, 0)
+	clientData := a.clientData("webauthn.get", opts.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return &webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(c.id),
+		RawID: c.id,
+		Type:  "public-key",
+		Response: webauthn.AuthenticatorAssertionResponse{
+			ClientDataJSON:    clientData,
+			AuthenticatorData: authData,
+			Signature:         sig,
+			UserHandle:        c.userHandle,
+		},
+	}, nil
+}
+
+func (a *Authenticator) find(rpID string, id []byte) *credential {
+	for _, c := range a.creds {
+		if c.rpID == rpID && bytes.Equal(c.id, id) {
+			return c
+		}
+	}
+	return nil
+}
+
+// authData returns the RP ID hash, flags and counter of authenticator data.
+func (a *Authenticator) authData(c *credential, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	flags |= 0x01 // user present
+	if a.UserVerified {
+		flags |= 0x04
+	}
+	data := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	if !a.Synced {
+		binary.BigEndian.PutUint32(data[33:], c.signCount)
+	}
+	return data
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+// encode uses the canonical CBOR authenticators send.
+func encode(v interface{}) ([]byte, error) {
+	em, err := cbor.CTAP2EncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+	return em.Marshal(v)
+}
+
+func pad32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
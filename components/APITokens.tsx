@@ -0,0 +1,117 @@
+import React, { useState, useEffect } from 'react';
+import { useAuth } from '../hooks/useAuth';
+import { getAPITokens, createAPIToken, deleteAPIToken } from '../services/api';
+import { APIToken, Permission, User } from '../types';
+
+const inputClass = "mt-1 block w-full px-3 py-2 border border-gray-700 bg-gray-900 rounded-md shadow-sm placeholder-gray-500 focus:outline-none focus:ring-accent focus:border-accent sm:text-sm";
+const primaryButton = "px-4 py-2 bg-accent hover:bg-blue-600 rounded-md font-medium transition disabled:bg-gray-500";
+const secondaryButton = "px-4 py-2 bg-secondary hover:bg-gray-600 rounded-md font-medium transition";
+
+const errorMessage = (err: unknown) => err instanceof Error && err.message ? err.message : "An unexpected error occurred.";
+
+// Expiry choices, in days; 0 is never.
+const EXPIRIES = [30, 90, 365, 0];
+
+const expiry = (days: number) => days ? new Date(Date.now() + days * 24 * 60 * 60 * 1000).toISOString() : undefined;
+
+// Lists and issues API tokens for scripts: the signed-in user's own or,
+// given a user, those of a service account. Scopes can only be picked
+// among the signed-in user's permissions.
+export const APITokensModal: React.FC<{ user?: User; onClose: () => void }> = ({ user, onClose }) => {
+    const { user: me } = useAuth();
+    const [tokens, setTokens] = useState<APIToken[]>([]);
+    const [created, setCreated] = useState<APIToken | null>(null);
+    const [name, setName] = useState('');
+    const [scopes, setScopes] = useState<Permission[]>(['apps:read', 'sessions:launch']);
+    const [days, setDays] = useState(90);
+    const [error, setError] = useState('');
+    const [loading, setLoading] = useState(false);
+    const available = me?.permissions || [];
+
+    useEffect(() => {
+        getAPITokens(user?.id).then(setTokens).catch(err => setError(errorMessage(err)));
+    }, [user]);
+
+    const run = async (action: () => Promise<void>) => {
+        setError('');
+        setLoading(true);
+        try {
+            await action();
+            setTokens(await getAPITokens(user?.id));
+        } catch (err) {
+            setError(errorMessage(err));
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    const create = (e: React.FormEvent) => {
+        e.preventDefault();
+        run(async () => {
+            setCreated(await createAPIToken(name, scopes.filter(s => available.includes(s)), expiry(days), user?.id));
+            setName('');
+        });
+    };
+
+    const toggle = (scope: Permission) =>
+        setScopes(scopes.includes(scope) ? scopes.filter(s => s !== scope) : [...scopes, scope]);
+
+    return (
+        <div className="fixed inset-0 bg-black bg-opacity-70 flex items-center justify-center z-50 p-4">
+            <div className="bg-surface rounded-lg p-8 w-full max-w-lg max-h-[90vh] overflow-y-auto">
+                <h2 className="text-2xl font-bold mb-4">{user ? `API Tokens of ${user.username}` : 'API Tokens'}</h2>
+                <p className="text-sm text-gray-400 mb-4">Scripts send a token as <span className="font-mono">Authorization: Bearer</span> instead of signing in.</p>
+                {created?.token && (
+                    <div className="mb-4 space-y-2">
+                        <p className="text-sm text-gray-400">Copy the new token now. It will not be shown again.</p>
+                        <p className="font-mono text-sm break-all bg-gray-900 rounded-md p-3 select-all">{created.token}</p>
+                    </div>
+                )}
+                <ul className="space-y-2 mb-4">
+                    {tokens.length === 0 && <li className="text-sm text-gray-400">No tokens yet.</li>}
+                    {tokens.map(t => (
+                        <li key={t.id} className="flex items-center justify-between bg-gray-900 rounded-md px-3 py-2 text-sm">
+                            <span>
+                                {t.name}
+                                <span className="block text-xs text-gray-500">{t.scopes.join(', ')}</span>
+                                <span className="block text-xs text-gray-500">
+                                    {t.expiresAt ? `Expires ${new Date(t.expiresAt).toLocaleDateString()}` : 'Never expires'}
+                                    {t.lastUsedAt ? `, last used ${new Date(t.lastUsedAt).toLocaleString()}` : ', never used'}
+                                </span>
+                            </span>
+                            <button type="button" onClick={() => run(() => deleteAPIToken(t.id, user?.id))} disabled={loading} className="text-red-500 hover:text-red-400 disabled:text-gray-500">Revoke</button>
+                        </li>
+                    ))}
+                </ul>
+                <form onSubmit={create} className="space-y-4 border-t border-gray-700 pt-4">
+                    <div>
+                        <label className="block text-sm font-medium text-gray-300">Name</label>
+                        <input type="text" value={name} onChange={e => setName(e.target.value)} required maxLength={64} className={inputClass} placeholder="e.g. CI pipeline" />
+                    </div>
+                    <div>
+                        <span className="block text-sm font-medium text-gray-300 mb-1">Scopes</span>
+                        <div className="grid grid-cols-2 gap-1">
+                            {available.map(scope => (
+                                <label key={scope} className="flex items-center text-sm text-gray-200">
+                                    <input type="checkbox" checked={scopes.includes(scope)} onChange={() => toggle(scope)} className="h-4 w-4 text-accent bg-gray-700 border-gray-600 rounded focus:ring-accent" />
+                                    <span className="ml-2 font-mono text-xs">{scope}</span>
+                                </label>
+                            ))}
+                        </div>
+                    </div>
+                    <div>
+                        <label className="block text-sm font-medium text-gray-300">Expires</label>
+                        <select value={days} onChange={e => setDays(Number(e.target.value))} className={inputClass}>
+                            {EXPIRIES.map(d => <option key={d} value={d}>{d ? `In ${d} days` : 'Never'}</option>)}
+                        </select>
+                    </div>
+                    {error && <p className="text-red-500 text-sm">{error}</p>}
+                    <div className="flex justify-end space-x-4 pt-2">
+                        <button type="button" onClick={onClose} className={secondaryButton}>Close</button>
+                        <button type="submit" disabled={loading || scopes.length === 0} className={primaryButton}>Create Token</button>
+                    </div>
+                </form>
+            </div>
+        </div>
+    );
+};
@@ -4,9 +4,13 @@ import { useAuth } from '../hooks/useAuth';
 import { CubeTransparentIcon, Bars3Icon } from '@heroicons/react/24/outline';
 // Fix: Corrected import path for the api service.
 import { changePassword } from '../services/api';
+import { TwoFactorModal } from './TwoFactor';
+import { APITokensModal } from './APITokens';
+import { adminPages } from './Sidebar';
 
-const ChangePasswordModal: React.FC<{ onClose: () => void }> = ({ onClose }) => {
-    const { user } = useAuth();
+// A forced change (after a password reset) can only be left by logging out.
+export const ChangePasswordModal: React.FC<{ onClose: () => void; forced?: boolean }> = ({ onClose, forced }) => {
+    const { user, passwordChanged, logout } = useAuth();
     const [currentPassword, setCurrentPassword] = useState('');
     const [newPassword, setNewPassword] = useState('');
     const [confirmPassword, setConfirmPassword] = useState('');
@@ -28,14 +32,15 @@ const ChangePasswordModal: React.FC<{ onClose: () => void }> = ({ onClose }) =>
         setSuccess('');
         setLoading(true);
         try {
-      const result = await changePassword(currentPassword, newPassword);
-      // Assuming API returns nothing on success, or throws on error
+      await changePassword(currentPassword, newPassword);
       setSuccess('Password changed successfully.');
       setTimeout(() => {
+        passwordChanged();
         onClose();
       }, 2000);
         } catch (err) {
-            setError("An unexpected error occurred.");
+            // The server explains which password rules were broken.
+            setError(err instanceof Error && err.message ? err.message : "An unexpected error occurred.");
         } finally {
             setLoading(false);
         }
@@ -45,6 +50,7 @@ const ChangePasswordModal: React.FC<{ onClose: () => void }> = ({ onClose }) =>
          <div className="fixed inset-0 bg-black bg-opacity-70 flex items-center justify-center z-50 p-4">
             <div className="bg-surface rounded-lg p-8 w-full max-w-md">
                 <h2 className="text-2xl font-bold mb-4">Change Password</h2>
+                {forced && <p className="text-sm text-gray-400 mb-4">Your password was reset. Choose a new one to continue.</p>}
                 <form onSubmit={handleSubmit} className="space-y-4">
                      <div>
                         <label className="block text-sm font-medium text-gray-300">Current Password</label>
@@ -61,7 +67,7 @@ const ChangePasswordModal: React.FC<{ onClose: () => void }> = ({ onClose }) =>
                     {error && <p className="text-red-500 text-sm">{error}</p>}
                     {success && <p className="text-green-500 text-sm">{success}</p>}
                     <div className="flex justify-end space-x-4 pt-4">
-                        <button type="button" onClick={onClose} className="px-4 py-2 bg-secondary hover:bg-gray-600 rounded-md font-medium transition">Cancel</button>
+                        <button type="button" onClick={forced ? logout : onClose} className="px-4 py-2 bg-secondary hover:bg-gray-600 rounded-md font-medium transition">{forced ? 'Logout' : 'Cancel'}</button>
                         <button type="submit" disabled={loading} className="px-4 py-2 bg-accent hover:bg-blue-600 rounded-md font-medium transition disabled:bg-gray-500">{loading ? 'Saving...' : 'Save'}</button>
                     </div>
                 </form>
@@ -74,11 +80,13 @@ const ChangePasswordModal: React.FC<{ onClose: () => void }> = ({ onClose }) =>
 const Header: React.FC<{ onMenuClick: () => void }> = ({ onMenuClick }) => {
   const { user, logout } = useAuth();
   const [isPasswordModalOpen, setPasswordModalOpen] = useState(false);
+  const [isTwoFactorModalOpen, setTwoFactorModalOpen] = useState(false);
+  const [isTokensModalOpen, setTokensModalOpen] = useState(false);
 
   return (
     <header className="bg-surface shadow-md p-4 flex justify-between items-center z-10">
       <div className="flex items-center">
-        {user?.isAdmin && (
+        {adminPages(user).length > 0 && (
             <button onClick={onMenuClick} className="lg:hidden mr-4 text-text-secondary hover:text-text-primary">
                 <Bars3Icon className="h-6 w-6" />
             </button>
@@ -96,6 +104,18 @@ const Header: React.FC<{ onMenuClick: () => void }> = ({ onMenuClick }) => {
             >
               Change Password
             </button>
+            <button
+              onClick={() => setTwoFactorModalOpen(true)}
+              className="bg-secondary hover:bg-gray-600 text-white font-bold py-2 px-4 rounded transition duration-300 text-sm"
+            >
+              Two-Factor
+            </button>
+            <button
+              onClick={() => setTokensModalOpen(true)}
+              className="bg-secondary hover:bg-gray-600 text-white font-bold py-2 px-4 rounded transition duration-300 text-sm"
+            >
+              API Tokens
+            </button>
             <button
               onClick={logout}
               className="bg-red-600 hover:bg-red-700 text-white font-bold py-2 px-4 rounded transition duration-300 text-sm"
@@ -106,6 +126,8 @@ const Header: React.FC<{ onMenuClick: () => void }> = ({ onMenuClick }) => {
         )}
       </div>
       {isPasswordModalOpen && <ChangePasswordModal onClose={() => setPasswordModalOpen(false)} />}
+      {isTwoFactorModalOpen && <TwoFactorModal onClose={() => setTwoFactorModalOpen(false)} />}
+      {isTokensModalOpen && <APITokensModal onClose={() => setTokensModalOpen(false)} />}
     </header>
   );
 };
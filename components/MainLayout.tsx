@@ -1,13 +1,14 @@
 
 import React, { useState } from 'react';
 import Header from './Header';
-import Sidebar from './Sidebar';
-import { useAuth } from '../hooks/useAuth';
+import Sidebar, { adminPages } from './Sidebar';
+import { useAuth, can } from '../hooks/useAuth';
 import { useNavigation } from '../hooks/useNavigation';
 import DashboardPage from '../pages/DashboardPage';
 // Fix: Corrected import paths for admin pages.
 import UserManagementPage from '../pages/admin/UserManagementPage';
 import ConfigurationPage from '../pages/admin/ConfigurationPage';
+import GroupManagementPage from '../pages/admin/GroupManagementPage';
 import SessionManagementPage from '../pages/admin/SessionManagementPage';
 import ApplicationManagementPage from '../pages/admin/ApplicationManagementPage';
 
@@ -19,13 +20,15 @@ const PageContent: React.FC = () => {
         case 'dashboard':
             return <DashboardPage />;
         case 'admin/users':
-            return user?.isAdmin ? <UserManagementPage /> : <DashboardPage />;
+            return can(user, 'users:manage') ? <UserManagementPage /> : <DashboardPage />;
+        case 'admin/groups':
+            return can(user, 'groups:manage') ? <GroupManagementPage /> : <DashboardPage />;
         case 'admin/sessions':
-            return user?.isAdmin ? <SessionManagementPage /> : <DashboardPage />;
+            return can(user, 'sessions:read_all') ? <SessionManagementPage /> : <DashboardPage />;
         case 'admin/applications':
-            return user?.isAdmin ? <ApplicationManagementPage /> : <DashboardPage />;
+            return can(user, 'apps:manage') ? <ApplicationManagementPage /> : <DashboardPage />;
         case 'admin/configuration':
-            return user?.isAdmin ? <ConfigurationPage /> : <DashboardPage />;
+            return can(user, 'portainer:manage') ? <ConfigurationPage /> : <DashboardPage />;
         default:
             return <DashboardPage />;
     }
@@ -35,17 +38,18 @@ const PageContent: React.FC = () => {
 const MainLayout: React.FC = () => {
   const { user } = useAuth();
   const [isSidebarOpen, setSidebarOpen] = useState(false);
+  const hasAdminPages = adminPages(user).length > 0;
   
   return (
     <div className="flex h-screen bg-background text-text-primary">
-      {user?.isAdmin && <Sidebar isOpen={isSidebarOpen} setIsOpen={setSidebarOpen} />}
+      {hasAdminPages && <Sidebar isOpen={isSidebarOpen} setIsOpen={setSidebarOpen} />}
       <div className="flex flex-col flex-1">
         <Header onMenuClick={() => setSidebarOpen(true)} />
         <main className="flex-1 p-4 sm:p-6 md:p-8 overflow-y-auto">
           <PageContent />
         </main>
       </div>
-      {isSidebarOpen && user?.isAdmin && (
+      {isSidebarOpen && hasAdminPages && (
         <div 
             className="fixed inset-0 bg-black bg-opacity-50 z-20 lg:hidden"
             onClick={() => setSidebarOpen(false)}
@@ -1,7 +1,21 @@
 
 import React from 'react';
 import { useNavigation, Page } from '../hooks/useNavigation';
-import { HomeIcon, UsersIcon, Cog6ToothIcon, CommandLineIcon, RectangleStackIcon } from '@heroicons/react/24/outline';
+import { useAuth, can } from '../hooks/useAuth';
+import { User, Permission } from '../types';
+import { HomeIcon, UsersIcon, Cog6ToothIcon, CommandLineIcon, RectangleStackIcon, UserGroupIcon } from '@heroicons/react/24/outline';
+
+// The admin pages and the permission each needs.
+const ADMIN_PAGES: { page: Page; label: string; permission: Permission; icon: typeof UsersIcon }[] = [
+  { page: 'admin/users', label: 'User Management', permission: 'users:manage', icon: UsersIcon },
+  { page: 'admin/groups', label: 'Groups', permission: 'groups:manage', icon: UserGroupIcon },
+  { page: 'admin/sessions', label: 'Session Management', permission: 'sessions:read_all', icon: CommandLineIcon },
+  { page: 'admin/applications', label: 'App Management', permission: 'apps:manage', icon: RectangleStackIcon },
+  { page: 'admin/configuration', label: 'Configuration', permission: 'portainer:manage', icon: Cog6ToothIcon },
+];
+
+// adminPages lists the admin pages the user's roles give access to.
+export const adminPages = (user: User | null) => ADMIN_PAGES.filter(p => can(user, p.permission));
 
 interface SidebarProps {
     isOpen: boolean;
@@ -10,6 +24,7 @@ interface SidebarProps {
 
 const Sidebar: React.FC<SidebarProps> = ({ isOpen, setIsOpen }) => {
   const { page, navigateTo } = useNavigation();
+  const { user } = useAuth();
   
   const handleNavigate = (targetPage: Page) => {
       navigateTo(targetPage);
@@ -42,26 +57,14 @@ const Sidebar: React.FC<SidebarProps> = ({ isOpen, setIsOpen }) => {
         <div className="pt-4 mt-4 border-t border-gray-700">
           <h3 className="px-4 text-sm font-semibold text-gray-400 uppercase tracking-wider">Admin</h3>
           <div className="mt-2 space-y-2">
-            <NavButton 
-                targetPage='admin/users'
-                label="User Management"
-                icon={<UsersIcon className="h-6 w-6 mr-3" aria-hidden="true" />}
-            />
-            <NavButton 
-                targetPage='admin/sessions'
-                label="Session Management"
-                icon={<CommandLineIcon className="h-6 w-6 mr-3" aria-hidden="true" />}
-            />
-            <NavButton 
-                targetPage='admin/applications'
-                label="App Management"
-                icon={<RectangleStackIcon className="h-6 w-6 mr-3" aria-hidden="true" />}
-            />
-            <NavButton 
-                targetPage='admin/configuration'
-                label="Configuration"
-                icon={<Cog6ToothIcon className="h-6 w-6 mr-3" aria-hidden="true" />}
-            />
+            {adminPages(user).map(({ page: target, label, icon: Icon }) => (
+              <NavButton
+                  key={target}
+                  targetPage={target}
+                  label={label}
+                  icon={<Icon className="h-6 w-6 mr-3" aria-hidden="true" />}
+              />
+            ))}
           </div>
         </div>
       </nav>
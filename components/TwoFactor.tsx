@@ -0,0 +1,266 @@
+import React, { useState, useEffect } from 'react';
+import { useAuth } from '../hooks/useAuth';
+import { verifyMFA, verifyMFAWithPasskey, getMFAStatus, enrollTOTP, confirmTOTP, disableTOTP, regenerateRecoveryCodes, getPasskeys, registerPasskey, stepUp, deletePasskey, passkeysSupported, MFAStatus, LoginResult, Passkey } from '../services/api';
+
+const inputClass = "mt-1 block w-full px-3 py-2 border border-gray-700 bg-gray-900 rounded-md shadow-sm placeholder-gray-500 focus:outline-none focus:ring-accent focus:border-accent sm:text-sm";
+const primaryButton = "px-4 py-2 bg-accent hover:bg-blue-600 rounded-md font-medium transition disabled:bg-gray-500";
+const secondaryButton = "px-4 py-2 bg-secondary hover:bg-gray-600 rounded-md font-medium transition";
+
+const errorMessage = (err: unknown) => err instanceof Error && err.message ? err.message : "An unexpected error occurred.";
+// Cancelling the browser's passkey dialog is not an error worth showing.
+const cancelled = (err: unknown) => err instanceof DOMException && err.name === 'NotAllowedError';
+
+// The second step of a login with two-factor authentication.
+export const MFAVerifyModal: React.FC = () => {
+    const { user, mfaCompleted, logout } = useAuth();
+    const [code, setCode] = useState('');
+    const [error, setError] = useState('');
+    const [loading, setLoading] = useState(false);
+    const methods = user?.mfaMethods || ['totp'];
+    const withCode = methods.includes('totp');
+    const withPasskey = methods.includes('passkey') && passkeysSupported();
+
+    const handleSubmit = async (e: React.FormEvent) => {
+        e.preventDefault();
+        setError('');
+        setLoading(true);
+        try {
+            mfaCompleted(await verifyMFA(code));
+        } catch (err) {
+            setError(errorMessage(err));
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    const usePasskey = async () => {
+        setError('');
+        setLoading(true);
+        try {
+            mfaCompleted(await verifyMFAWithPasskey());
+        } catch (err) {
+            if (!cancelled(err)) setError(errorMessage(err));
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    return (
+        <div className="fixed inset-0 bg-black bg-opacity-70 flex items-center justify-center z-50 p-4">
+            <div className="bg-surface rounded-lg p-8 w-full max-w-md">
+                <h2 className="text-2xl font-bold mb-4">Two-Factor Authentication</h2>
+                <p className="text-sm text-gray-400 mb-4">
+                    {withCode ? 'Enter the code from your authenticator app, or one of your recovery codes.' : 'Confirm it is you with your passkey.'}
+                </p>
+                <form onSubmit={handleSubmit} className="space-y-4">
+                    {withCode && <input type="text" inputMode="numeric" autoComplete="one-time-code" autoFocus value={code} onChange={e => setCode(e.target.value)} required className={inputClass} placeholder="123456" />}
+                    {error && <p className="text-red-500 text-sm">{error}</p>}
+                    <div className="flex justify-end space-x-4 pt-4">
+                        <button type="button" onClick={logout} className={secondaryButton}>Logout</button>
+                        {withPasskey && <button type="button" onClick={usePasskey} disabled={loading} className={withCode ? secondaryButton : primaryButton}>Use a Passkey</button>}
+                        {withCode && <button type="submit" disabled={loading} className={primaryButton}>{loading ? 'Verifying...' : 'Verify'}</button>}
+                    </div>
+                </form>
+            </div>
+        </div>
+    );
+};
+
+// RecoveryCodes shows codes that cannot be displayed again.
+const RecoveryCodes: React.FC<{ codes: string[]; onDone: () => void }> = ({ codes, onDone }) => (
+    <div className="space-y-4">
+        <p className="text-sm text-gray-400">Store these recovery codes somewhere safe. Each signs you in once if you lose your device. They will not be shown again.</p>
+        <ul className="grid grid-cols-2 gap-2 font-mono text-sm bg-gray-900 rounded-md p-4">
+            {codes.map(c => <li key={c}>{c}</li>)}
+        </ul>
+        <div className="flex justify-end pt-4">
+            <button type="button" onClick={onDone} className={primaryButton}>Done</button>
+        </div>
+    </div>
+);
+
+// PasskeyList shows the user's passkeys, to add and remove them.
+const PasskeyList: React.FC<{ onChange: () => void }> = ({ onChange }) => {
+    const [passkeys, setPasskeys] = useState<Passkey[]>([]);
+    const [name, setName] = useState('');
+    const [password, setPassword] = useState('');
+    const [error, setError] = useState('');
+    const [loading, setLoading] = useState(false);
+
+    useEffect(() => {
+        getPasskeys().then(setPasskeys).catch(err => setError(errorMessage(err)));
+    }, []);
+
+    const run = async (action: () => Promise<void>) => {
+        setError('');
+        setLoading(true);
+        try {
+            await action();
+            setPasskeys(await getPasskeys());
+            onChange();
+        } catch (err) {
+            if (!cancelled(err)) setError(errorMessage(err));
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    const add = (e: React.FormEvent) => {
+        e.preventDefault();
+        run(async () => {
+            await registerPasskey(name, await stepUp(password));
+            setName('');
+            setPassword('');
+        });
+    };
+
+    return (
+        <div className="space-y-3 border-t border-gray-700 pt-4 mt-4">
+            <h3 className="font-semibold">Passkeys</h3>
+            {passkeys.length === 0 && <p className="text-sm text-gray-400">Sign in with your fingerprint, face or a security key instead of a password or code.</p>}
+            <ul className="space-y-2">
+                {passkeys.map(p => (
+                    <li key={p.id} className="flex items-center justify-between bg-gray-900 rounded-md px-3 py-2 text-sm">
+                        <span>
+                            {p.name}
+                            <span className="block text-xs text-gray-500">
+                                Added {new Date(p.createdAt).toLocaleDateString()}{p.lastUsedAt && `, last used ${new Date(p.lastUsedAt).toLocaleDateString()}`}
+                            </span>
+                        </span>
+                        <button type="button" onClick={() => run(() => deletePasskey(p.id))} disabled={loading} className="text-red-500 hover:text-red-400 disabled:text-gray-500">Remove</button>
+                    </li>
+                ))}
+            </ul>
+            {passkeysSupported() && (
+                <form onSubmit={add} className="flex items-end space-x-2">
+                    <input type="text" value={name} onChange={e => setName(e.target.value)} maxLength={64} className={inputClass} placeholder="Name, e.g. Laptop" />
+                    <input type="password" autoComplete="current-password" value={password} onChange={e => setPassword(e.target.value)} required className={inputClass} placeholder="Current password" />
+                    <button type="submit" disabled={loading} className={primaryButton + " whitespace-nowrap"}>Add Passkey</button>
+                </form>
+            )}
+            {error && <p className="text-red-500 text-sm">{error}</p>}
+        </div>
+    );
+};
+
+// Sets up an authenticator app or passkeys. When forced, enrolling is what
+// the login is waiting for, and it can only be left by logging out.
+export const TwoFactorModal: React.FC<{ onClose: () => void; forced?: boolean }> = ({ onClose, forced }) => {
+    const { mfaCompleted, logout } = useAuth();
+    const [status, setStatus] = useState<MFAStatus | null>(null);
+    const [enrollment, setEnrollment] = useState<{ secret: string; url: string } | null>(null);
+    const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+    const [login, setLogin] = useState<LoginResult | undefined>(undefined);
+    const [code, setCode] = useState('');
+    const [error, setError] = useState('');
+    const [loading, setLoading] = useState(false);
+
+    // A forced enrollment's token cannot read the status.
+    const loadStatus = () => {
+        if (!forced) getMFAStatus().then(setStatus).catch(err => setError(errorMessage(err)));
+    };
+    useEffect(loadStatus, [forced]);
+
+    const run = async (action: () => Promise<void>) => {
+        setError('');
+        setLoading(true);
+        try {
+            await action();
+            setCode('');
+        } catch (err) {
+            setError(errorMessage(err));
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    const startEnrollment = () => run(async () => setEnrollment(await enrollTOTP()));
+    const enrollPasskey = async () => {
+        setError('');
+        setLoading(true);
+        try {
+            const { login } = await registerPasskey('');
+            if (login) mfaCompleted(login);
+            onClose();
+        } catch (err) {
+            if (!cancelled(err)) setError(errorMessage(err));
+        } finally {
+            setLoading(false);
+        }
+    };
+    const confirm = (e: React.FormEvent) => {
+        e.preventDefault();
+        run(async () => {
+            const result = await confirmTOTP(code);
+            setLogin(result.login);
+            setRecoveryCodes(result.recoveryCodes);
+        });
+    };
+    const regenerate = () => run(async () => setRecoveryCodes((await regenerateRecoveryCodes(code)).recoveryCodes));
+    const disable = () => run(async () => {
+        await disableTOTP(code);
+        setStatus(await getMFAStatus());
+    });
+    const done = () => {
+        if (login) mfaCompleted(login);
+        onClose();
+    };
+
+    let body: React.ReactNode;
+    if (recoveryCodes) {
+        body = <RecoveryCodes codes={recoveryCodes} onDone={done} />;
+    } else if (enrollment) {
+        body = (
+            <form onSubmit={confirm} className="space-y-4">
+                <p className="text-sm text-gray-400">Add this key to your authenticator app, then enter the code it shows.</p>
+                <p className="font-mono text-sm break-all bg-gray-900 rounded-md p-3">{enrollment.secret}</p>
+                <a href={enrollment.url} className="block text-sm text-accent hover:underline break-all">{enrollment.url}</a>
+                <input type="text" inputMode="numeric" autoComplete="one-time-code" value={code} onChange={e => setCode(e.target.value)} required className={inputClass} placeholder="123456" />
+                {error && <p className="text-red-500 text-sm">{error}</p>}
+                <div className="flex justify-end space-x-4 pt-4">
+                    <button type="button" onClick={forced ? logout : onClose} className={secondaryButton}>{forced ? 'Logout' : 'Cancel'}</button>
+                    <button type="submit" disabled={loading} className={primaryButton}>{loading ? 'Verifying...' : 'Enable'}</button>
+                </div>
+            </form>
+        );
+    } else if (status?.enabled) {
+        body = (
+            <div className="space-y-4">
+                <p className="text-sm text-gray-400">Your authenticator app is set up. {status.recoveryCodesLeft} recovery codes left.</p>
+                <input type="text" value={code} onChange={e => setCode(e.target.value)} className={inputClass} placeholder="Current code or recovery code" />
+                {error && <p className="text-red-500 text-sm">{error}</p>}
+                <div className="flex justify-end space-x-4 pt-4">
+                    <button type="button" onClick={onClose} className={secondaryButton}>Close</button>
+                    <button type="button" onClick={regenerate} disabled={loading || !code} className={primaryButton}>New Recovery Codes</button>
+                    {(!status.required || status.passkeys > 0) && <button type="button" onClick={disable} disabled={loading || !code} className="px-4 py-2 bg-red-600 hover:bg-red-700 rounded-md font-medium transition disabled:bg-gray-500">Disable</button>}
+                </div>
+            </div>
+        );
+    } else {
+        const available = forced || status?.available;
+        body = (
+            <div className="space-y-4">
+                <p className="text-sm text-gray-400">
+                    {!available ? 'Your identity provider handles two-factor authentication.' : 'Protect your account with a code from an authenticator app, or with a passkey.'}
+                </p>
+                {error && <p className="text-red-500 text-sm">{error}</p>}
+                <div className="flex justify-end space-x-4 pt-4">
+                    <button type="button" onClick={forced ? logout : onClose} className={secondaryButton}>{forced ? 'Logout' : 'Close'}</button>
+                    {forced && passkeysSupported() && <button type="button" onClick={enrollPasskey} disabled={loading} className={secondaryButton}>Use a Passkey</button>}
+                    {available && <button type="button" onClick={startEnrollment} disabled={loading} className={primaryButton}>Set Up App</button>}
+                </div>
+            </div>
+        );
+    }
+
+    return (
+        <div className="fixed inset-0 bg-black bg-opacity-70 flex items-center justify-center z-50 p-4">
+            <div className="bg-surface rounded-lg p-8 w-full max-w-md">
+                <h2 className="text-2xl font-bold mb-4">Two-Factor Authentication</h2>
+                {forced && !recoveryCodes && <p className="text-sm text-gray-400 mb-4">Two-factor authentication is required. Set it up to continue.</p>}
+                {body}
+                {status?.available && !enrollment && !recoveryCodes && <PasskeyList onChange={loadStatus} />}
+            </div>
+        </div>
+    );
+};
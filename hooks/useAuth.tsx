@@ -1,15 +1,29 @@
 
-import React, { createContext, useState, useContext, ReactNode, useMemo } from 'react';
-import { User } from '../types';
+import React, { createContext, useState, useContext, ReactNode, useMemo, useEffect } from 'react';
+import { User, Permission } from '../types';
 // Fix: Corrected import path for the api service.
-import { login as apiLogin, logout as apiLogout } from '../services/api';
+import { login as apiLogin, logout as apiLogout, refreshToken, completeSSOLogin, loginWithPasskey, getMe, LoginResult } from '../services/api';
 
 interface AuthContextType {
   user: User | null;
+  // Set when single sign-on sent the browser back with an error.
+  ssoError: string | null;
   login: (username: string, password: string) => Promise<User | null>;
+  // Signs in with a passkey instead of a username and password. Errors,
+  // including the user cancelling, are thrown.
+  passkeyLogin: () => Promise<User>;
   logout: () => void;
+  passwordChanged: () => void;
+  // Called with the result of verifyMFA, or of confirmTOTP when enrolling
+  // was required to log in.
+  mfaCompleted: (result: LoginResult) => void;
 }
 
+// Access tokens live 15 minutes. Renewing them ahead of time keeps the
+// cookie that authenticates /s/{sessionId}/, when sessions share the
+// launcher's origin, valid while the launcher is open.
+const REFRESH_INTERVAL_MS = 10 * 60 * 1000;
+
 const AuthContext = createContext<AuthContextType | undefined>(undefined);
 
 export const AuthProvider: React.FC<{ children: ReactNode }> = ({ children }) => {
@@ -18,11 +32,55 @@ export const AuthProvider: React.FC<{ children: ReactNode }> = ({ children }) =>
     return storedUser ? JSON.parse(storedUser) : null;
   });
 
+  const [ssoError, setSSOError] = useState<string | null>(null);
+
+  // The identity provider sends the browser back to /?sso=1, or with
+  // sso_error when signing in failed.
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.search);
+    if (!params.has('sso') && !params.has('sso_error')) return;
+    window.history.replaceState(null, '', window.location.pathname);
+    if (params.has('sso_error')) {
+      setSSOError(params.get('sso_error'));
+      return;
+    }
+    completeSSOLogin().then(me => {
+      if (!me) {
+        setSSOError('Single sign-on failed, please try again.');
+        return;
+      }
+      const loggedInUser: User = { id: me.id, username: me.username, isAdmin: me.isAdmin, permissions: me.permissions };
+      localStorage.setItem('user', JSON.stringify(loggedInUser));
+      setUser(loggedInUser);
+    }).catch(() => setSSOError('Single sign-on failed, please try again.'));
+  }, []);
+
+  // A password login only names the user; once it is complete, /auth/me
+  // tells who they are and what their roles allow.
+  useEffect(() => {
+    if (!user || user.mustChangePassword || user.mfaPending || user.permissions) return;
+    getMe().then(me => {
+      const loggedInUser: User = { ...user, id: me.id, username: me.username, isAdmin: me.isAdmin, permissions: me.permissions };
+      localStorage.setItem('user', JSON.stringify(loggedInUser));
+      setUser(loggedInUser);
+    }).catch(() => undefined);
+  }, [user]);
+
+  useEffect(() => {
+    if (!user || user.mustChangePassword || user.mfaPending) return;
+    const timer = setInterval(refreshToken, REFRESH_INTERVAL_MS);
+    return () => clearInterval(timer);
+  }, [user]);
+
   const login = async (username: string, password: string): Promise<User | null> => {
     try {
-      await apiLogin(username, password);
+      const { mustChangePassword, mfaRequired, mfaEnrollmentRequired, mfaMethods } = await apiLogin(username, password);
       // Store minimal user info after successful login
-      const loggedInUser: User = { id: '', username, isAdmin: false };
+      const loggedInUser: User = { id: '', username, isAdmin: false, mustChangePassword };
+      if (mfaRequired) {
+        loggedInUser.mfaPending = 'verify';
+        loggedInUser.mfaMethods = mfaMethods;
+      } else if (mfaEnrollmentRequired) loggedInUser.mfaPending = 'enroll';
       localStorage.setItem('user', JSON.stringify(loggedInUser));
       setUser(loggedInUser);
       return loggedInUser;
@@ -31,13 +89,42 @@ export const AuthProvider: React.FC<{ children: ReactNode }> = ({ children }) =>
     }
   };
 
+  const passkeyLogin = async (): Promise<User> => {
+    const { mustChangePassword } = await loginWithPasskey();
+    // The passkey named the user; a token restricted to changing the
+    // password cannot look them up.
+    let loggedInUser: User = { id: '', username: '', isAdmin: false, mustChangePassword };
+    if (!mustChangePassword) {
+      const me = await getMe();
+      loggedInUser = { id: me.id, username: me.username, isAdmin: me.isAdmin, permissions: me.permissions };
+    }
+    localStorage.setItem('user', JSON.stringify(loggedInUser));
+    setUser(loggedInUser);
+    return loggedInUser;
+  };
+
   const logout = () => {
     apiLogout();
     setUser(null);
     localStorage.removeItem('user');
   };
 
-  const value = useMemo(() => ({ user, login, logout }), [user]);
+  // Called once a forced password change succeeded.
+  const passwordChanged = () => {
+    if (!user) return;
+    const updatedUser: User = { ...user, mustChangePassword: false };
+    localStorage.setItem('user', JSON.stringify(updatedUser));
+    setUser(updatedUser);
+  };
+
+  const mfaCompleted = ({ mustChangePassword }: LoginResult) => {
+    if (!user) return;
+    const updatedUser: User = { ...user, mfaPending: undefined, mfaMethods: undefined, mustChangePassword };
+    localStorage.setItem('user', JSON.stringify(updatedUser));
+    setUser(updatedUser);
+  };
+
+  const value = useMemo(() => ({ user, ssoError, login, passkeyLogin, logout, passwordChanged, mfaCompleted }), [user, ssoError]);
 
   return (
     <AuthContext.Provider value={value}>
@@ -46,6 +133,10 @@ export const AuthProvider: React.FC<{ children: ReactNode }> = ({ children }) =>
   );
 };
 
+// can reports whether the user's roles grant permission.
+export const can = (user: User | null, permission: Permission): boolean =>
+  !!user?.permissions?.includes(permission);
+
 export const useAuth = (): AuthContextType => {
   const context = useContext(AuthContext);
   if (context === undefined) {
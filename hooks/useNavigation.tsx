@@ -1,6 +1,6 @@
 import React, { createContext, useState, useContext, ReactNode, useMemo } from 'react';
 
-export type Page = 'dashboard' | 'admin/users' | 'admin/sessions' | 'admin/applications' | 'admin/configuration';
+export type Page = 'dashboard' | 'admin/users' | 'admin/groups' | 'admin/sessions' | 'admin/applications' | 'admin/configuration';
 
 interface NavigationContextType {
   page: Page;
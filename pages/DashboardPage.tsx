@@ -3,7 +3,7 @@ import React, { useState, useEffect } from 'react';
 import { useAuth } from '../hooks/useAuth';
 import { Session, Application } from '../types';
 // Fix: Corrected import path for the api service.
-import { getSessionsForUser, getAvailableApplications, startSession, stopSession } from '../services/api';
+import { getSessionsForUser, getAvailableApplications, startSession, stopSession, connectSession } from '../services/api';
 
 const DashboardPage: React.FC = () => {
   const { user } = useAuth();
@@ -50,8 +50,7 @@ const DashboardPage: React.FC = () => {
   const newSession = await startSession(selectedApp.id, isPersistent);
       if (newSession) {
           setSessions(prev => [...prev, newSession]);
-          // Open new tab - this may be blocked by the sandbox environment
-          window.open(`/session/${newSession.id}`, '_blank');
+          openSession(newSession.id);
       }
       setIsModalOpen(false);
       setSelectedApp(null);
@@ -63,9 +62,21 @@ const DashboardPage: React.FC = () => {
     setSessions(prev => prev.filter(s => s.id !== sessionId));
   };
 
+  // Opens the tab first, while the click still allows it, and points it at
+  // the session once the launcher has handed out its URL.
+  const openSession = async (sessionId: string) => {
+    const tab = window.open('', '_blank');
+    try {
+      const url = await connectSession(sessionId);
+      if (tab) tab.location.href = url;
+    } catch (error) {
+      tab?.close();
+      console.error("Failed to open the session:", error);
+    }
+  };
+
   const handleResumeSession = (sessionId: string) => {
-    // Open new tab - this may be blocked by the sandbox environment
-    window.open(`/session/${sessionId}`, '_blank');
+    openSession(sessionId);
   };
 
   if (loading) {
@@ -1,5 +1,6 @@
-import React, { useState } from 'react';
+import React, { useState, useEffect } from 'react';
 import { useAuth } from '../hooks/useAuth';
+import { getSSOConfig, SSO_LOGIN_URL, passkeysSupported } from '../services/api';
 import { CubeTransparentIcon } from '@heroicons/react/24/outline';
 
 
@@ -8,7 +9,12 @@ const LoginPage: React.FC = () => {
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
-  const { login } = useAuth();
+  const [ssoEnabled, setSSOEnabled] = useState(false);
+  const { login, passkeyLogin, ssoError } = useAuth();
+
+  useEffect(() => {
+    getSSOConfig().then(config => setSSOEnabled(config.enabled)).catch(() => undefined);
+  }, []);
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
@@ -28,6 +34,21 @@ const LoginPage: React.FC = () => {
     }
   };
 
+  const handlePasskey = async () => {
+    setError('');
+    setLoading(true);
+    try {
+      await passkeyLogin();
+    } catch (err) {
+      // Cancelling the browser's dialog is not worth an error message.
+      if (!(err instanceof DOMException && err.name === 'NotAllowedError')) {
+        setError(err instanceof Error && err.message ? err.message : 'Passkey sign-in failed.');
+      }
+    } finally {
+      setLoading(false);
+    }
+  };
+
   return (
     <div className="flex items-center justify-center min-h-screen bg-background p-4">
       <div className="w-full max-w-md p-8 space-y-8 bg-surface rounded-lg shadow-lg">
@@ -71,7 +92,7 @@ const LoginPage: React.FC = () => {
             </div>
           </div>
 
-          {error && <p className="text-center text-sm text-red-500">{error}</p>}
+          {(error || ssoError) && <p className="text-center text-sm text-red-500">{error || ssoError}</p>}
 
           <div>
             <button
@@ -81,6 +102,24 @@ const LoginPage: React.FC = () => {
             >
               {loading ? 'Signing in...' : 'Sign in'}
             </button>
+            {passkeysSupported() && (
+              <button
+                type="button"
+                onClick={handlePasskey}
+                disabled={loading}
+                className="mt-3 w-full flex justify-center py-2 px-4 border border-gray-600 text-sm font-medium rounded-md text-text-primary bg-secondary hover:bg-gray-600 disabled:opacity-50"
+              >
+                Sign in with a passkey
+              </button>
+            )}
+            {ssoEnabled && (
+              <a
+                href={SSO_LOGIN_URL}
+                className="mt-3 w-full flex justify-center py-2 px-4 border border-gray-600 text-sm font-medium rounded-md text-text-primary bg-secondary hover:bg-gray-600"
+              >
+                Sign in with SSO
+              </a>
+            )}
           </div>
         </form>
         <div className="text-center text-xs text-gray-500 mt-8">
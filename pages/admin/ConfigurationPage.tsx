@@ -31,6 +31,7 @@ const ConfigurationPage: React.FC = () => {
     const [saving, setSaving] = useState(false);
     const [isDeploying, setIsDeploying] = useState(false);
     const [message, setMessage] = useState({ text: '', type: '' });
+    const [adminPassword, setAdminPassword] = useState('');
 
     useEffect(() => {
         const fetchInitialData = async () => {
@@ -42,6 +43,7 @@ const ConfigurationPage: React.FC = () => {
                 ]);
                 setConfig(fetchedConfig);
                 setStatus(fetchedStatus);
+                if (fetchedStatus.adminPassword) setAdminPassword(fetchedStatus.adminPassword);
             } catch (error) {
                 console.error("Failed to fetch initial config data:", error);
                 setMessage({ text: 'Failed to load configuration.', type: 'error' });
@@ -76,20 +78,25 @@ const ConfigurationPage: React.FC = () => {
         setMessage({ text: 'Portainer deployment initiated...', type: 'info' });
         setStatus({ status: 'deploying', uptime: '', version: '' });
         try {
-            const result = await deployPortainer();
-            if (result.success && result.config) {
-                setConfig(result.config);
-                setMessage({ text: result.message, type: 'success' });
-                // fetch status again
-                const newStatus = await getPortainerStatus();
+            await deployPortainer();
+            // The deployment runs in the background; follow it through the status.
+            let newStatus = await getPortainerStatus();
+            while (newStatus.status === 'deploying') {
                 setStatus(newStatus);
+                if (newStatus.message) setMessage({ text: `${newStatus.message}...`, type: 'info' });
+                await new Promise(resolve => setTimeout(resolve, 2000));
+                newStatus = await getPortainerStatus();
+            }
+            setStatus(newStatus);
+            if (newStatus.message) {
+                setMessage({ text: newStatus.message, type: 'error' });
             } else {
-                setMessage({ text: result.message, type: 'error' });
-                const newStatus = await getPortainerStatus();
-                setStatus(newStatus);
+                setConfig(await getPortainerConfig());
+                setMessage({ text: 'Portainer deployed successfully!', type: 'success' });
             }
+            if (newStatus.adminPassword) setAdminPassword(newStatus.adminPassword);
         } catch (error) {
-            setMessage({ text: 'An unexpected error occurred during deployment.', type: 'error' });
+            setMessage({ text: error instanceof Error && error.message ? error.message : 'An unexpected error occurred during deployment.', type: 'error' });
             setStatus({ status: 'error', uptime: '', version: '' });
         } finally {
             setIsDeploying(false);
@@ -160,6 +167,13 @@ const ConfigurationPage: React.FC = () => {
                         </button>
                     </div>
 
+                    {adminPassword && (
+                        <div className="mt-6 bg-gray-900 p-4 rounded-lg space-y-2">
+                            <p className="text-sm text-gray-400">The Portainer <span className="font-mono">admin</span> password. Copy it now; it will not be shown again.</p>
+                            <p className="font-mono text-sm break-all select-all">{adminPassword}</p>
+                        </div>
+                    )}
+
                     {status && (
                          <div className="mt-6 bg-gray-900 p-4 rounded-lg">
                              <h3 className="text-lg font-semibold text-text-secondary mb-3">Container Status</h3>
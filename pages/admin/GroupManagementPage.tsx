@@ -0,0 +1,236 @@
+import React, { useState, useEffect } from 'react';
+import { Group, User, Application } from '../../types';
+import { getGroups, createGroup, deleteGroup, addGroupMember, removeGroupMember, setGroupApplications, getUsers, getApplications } from '../../services/api';
+import { PlusIcon, TrashIcon, UsersIcon, RectangleStackIcon } from '@heroicons/react/24/outline';
+
+const inputClass = "mt-1 block w-full px-3 py-2 border border-gray-700 bg-gray-900 rounded-md shadow-sm placeholder-gray-500 focus:outline-none focus:ring-accent focus:border-accent sm:text-sm";
+const errorMessage = (err: unknown) => err instanceof Error && err.message ? err.message : 'An unexpected error occurred.';
+
+const AddGroupModal: React.FC<{ onClose: () => void; onSaved: () => void }> = ({ onClose, onSaved }) => {
+    const [name, setName] = useState('');
+    const [description, setDescription] = useState('');
+    const [error, setError] = useState('');
+
+    const handleSubmit = async (e: React.FormEvent) => {
+        e.preventDefault();
+        setError('');
+        try {
+            await createGroup(name, description);
+            onSaved();
+        } catch (err) {
+            setError(errorMessage(err));
+        }
+    };
+
+    return (
+        <div className="fixed inset-0 bg-black bg-opacity-70 flex items-center justify-center z-50 p-4">
+            <div className="bg-surface rounded-lg p-8 w-full max-w-md">
+                <h2 className="text-2xl font-bold mb-4">Add New Group</h2>
+                <form onSubmit={handleSubmit} className="space-y-4">
+                    <div>
+                        <label className="block text-sm font-medium text-gray-300">Name</label>
+                        <input type="text" value={name} onChange={e => setName(e.target.value)} required maxLength={64} className={inputClass} />
+                    </div>
+                    <div>
+                        <label className="block text-sm font-medium text-gray-300">Description</label>
+                        <input type="text" value={description} onChange={e => setDescription(e.target.value)} maxLength={255} className={inputClass} />
+                    </div>
+                    {error && <p className="text-red-500 text-sm">{error}</p>}
+                    <div className="flex justify-end space-x-4 pt-4">
+                        <button type="button" onClick={onClose} className="px-4 py-2 bg-secondary hover:bg-gray-600 rounded-md font-medium transition">Cancel</button>
+                        <button type="submit" className="px-4 py-2 bg-accent hover:bg-blue-600 rounded-md font-medium transition">Add Group</button>
+                    </div>
+                </form>
+            </div>
+        </div>
+    );
+};
+
+// ChecklistModal picks the members or the applications of a group.
+const ChecklistModal: React.FC<{
+    title: string;
+    hint: string;
+    items: { id: string; label: string }[];
+    initial: string[];
+    onClose: () => void;
+    onSave: (selected: string[]) => Promise<void>;
+}> = ({ title, hint, items, initial, onClose, onSave }) => {
+    const [selected, setSelected] = useState<string[]>(initial);
+    const [error, setError] = useState('');
+
+    const toggle = (id: string) =>
+        setSelected(selected.includes(id) ? selected.filter(s => s !== id) : [...selected, id]);
+
+    const handleSubmit = async (e: React.FormEvent) => {
+        e.preventDefault();
+        setError('');
+        try {
+            await onSave(selected);
+        } catch (err) {
+            setError(errorMessage(err));
+        }
+    };
+
+    return (
+        <div className="fixed inset-0 bg-black bg-opacity-70 flex items-center justify-center z-50 p-4">
+            <div className="bg-surface rounded-lg p-8 w-full max-w-md max-h-[80vh] flex flex-col">
+                <h2 className="text-2xl font-bold mb-2">{title}</h2>
+                <p className="text-sm text-gray-400 mb-4">{hint}</p>
+                <form onSubmit={handleSubmit} className="flex flex-col min-h-0">
+                    <div className="space-y-2 overflow-y-auto">
+                        {items.map(item => (
+                            <label key={item.id} className="flex items-center">
+                                <input
+                                    type="checkbox"
+                                    checked={selected.includes(item.id)}
+                                    onChange={() => toggle(item.id)}
+                                    className="h-4 w-4 text-accent bg-gray-700 border-gray-600 rounded focus:ring-accent"
+                                />
+                                <span className="ml-2 text-sm text-gray-200">{item.label}</span>
+                            </label>
+                        ))}
+                    </div>
+                    {error && <p className="text-red-500 text-sm mt-4">{error}</p>}
+                    <div className="flex justify-end space-x-4 pt-6">
+                        <button type="button" onClick={onClose} className="px-4 py-2 bg-secondary hover:bg-gray-600 rounded-md font-medium transition">Cancel</button>
+                        <button type="submit" className="px-4 py-2 bg-accent hover:bg-blue-600 rounded-md font-medium transition">Save</button>
+                    </div>
+                </form>
+            </div>
+        </div>
+    );
+};
+
+const GroupManagementPage: React.FC = () => {
+    const [groups, setGroups] = useState<Group[]>([]);
+    const [users, setUsers] = useState<User[]>([]);
+    const [apps, setApps] = useState<Application[]>([]);
+    const [loading, setLoading] = useState(true);
+    const [error, setError] = useState('');
+    const [isAdding, setIsAdding] = useState(false);
+    const [editingMembers, setEditingMembers] = useState<Group | null>(null);
+    const [editingApps, setEditingApps] = useState<Group | null>(null);
+
+    const fetchGroups = async () => {
+        try {
+            setGroups(await getGroups());
+        } catch (err) {
+            setError(errorMessage(err));
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    useEffect(() => {
+        fetchGroups();
+        // Listing users and applications takes their own permissions.
+        getUsers().then(setUsers).catch(() => setUsers([]));
+        getApplications().then(setApps).catch(() => setApps([]));
+    }, []);
+
+    const username = (id: string) => users.find(u => u.id === id)?.username || id;
+    const appName = (id: string) => apps.find(a => a.id === id)?.name || id;
+
+    const handleDelete = async (group: Group) => {
+        if (!window.confirm(`Delete group "${group.name}"? Its applications in no other group become available to everyone.`)) return;
+        try {
+            await deleteGroup(group.id);
+            fetchGroups();
+        } catch (err) {
+            setError(errorMessage(err));
+        }
+    };
+
+    const saveMembers = (group: Group) => async (selected: string[]) => {
+        for (const id of selected.filter(id => !group.userIds.includes(id))) await addGroupMember(group.id, id);
+        for (const id of group.userIds.filter(id => !selected.includes(id))) await removeGroupMember(group.id, id);
+        setEditingMembers(null);
+        fetchGroups();
+    };
+
+    const saveApps = (group: Group) => async (selected: string[]) => {
+        await setGroupApplications(group.id, selected);
+        setEditingApps(null);
+        fetchGroups();
+    };
+
+    if (loading) return <p>Loading groups...</p>;
+
+    return (
+        <div>
+            <div className="flex flex-col sm:flex-row justify-between items-start sm:items-center mb-6 gap-4">
+                <div>
+                    <h1 className="text-3xl font-bold text-text-primary">Groups</h1>
+                    <p className="text-sm text-gray-400 mt-1">An application in a group can only be launched by the members of its groups. Applications in no group are available to everyone.</p>
+                </div>
+                <button onClick={() => setIsAdding(true)} className="flex items-center px-4 py-2 bg-accent hover:bg-blue-600 rounded-md font-medium transition w-full sm:w-auto justify-center">
+                    <PlusIcon className="h-5 w-5 mr-2" aria-hidden="true" />
+                    Add Group
+                </button>
+            </div>
+            {error && <p className="text-red-500 text-sm mb-4">{error}</p>}
+            <div className="bg-surface shadow-md rounded-lg overflow-hidden">
+                <div className="overflow-x-auto">
+                    <table className="min-w-full leading-normal">
+                        <thead>
+                            <tr className="border-b-2 border-gray-700 bg-secondary">
+                                <th className="px-5 py-3 text-left text-xs font-semibold text-gray-300 uppercase tracking-wider">Group</th>
+                                <th className="px-5 py-3 text-left text-xs font-semibold text-gray-300 uppercase tracking-wider">Members</th>
+                                <th className="px-5 py-3 text-left text-xs font-semibold text-gray-300 uppercase tracking-wider">Applications</th>
+                                <th className="px-5 py-3 text-left text-xs font-semibold text-gray-300 uppercase tracking-wider">Actions</th>
+                            </tr>
+                        </thead>
+                        <tbody>
+                            {groups.map(group => (
+                                <tr key={group.id} className="border-b border-gray-700 hover:bg-gray-700">
+                                    <td className="px-5 py-5 text-sm">
+                                        <p className="text-text-primary">{group.name}</p>
+                                        {group.description && <p className="text-xs text-gray-400">{group.description}</p>}
+                                    </td>
+                                    <td className="px-5 py-5 text-sm text-gray-300">{group.userIds.map(username).join(', ') || '—'}</td>
+                                    <td className="px-5 py-5 text-sm text-gray-300">{group.applicationIds.map(appName).join(', ') || '—'}</td>
+                                    <td className="px-5 py-5 text-sm">
+                                        <div className="flex items-center space-x-3">
+                                            <button onClick={() => setEditingMembers(group)} title="Edit Members" className="text-blue-400 hover:text-blue-300">
+                                                <UsersIcon className="h-5 w-5" />
+                                            </button>
+                                            <button onClick={() => setEditingApps(group)} title="Edit Applications" className="text-blue-400 hover:text-blue-300">
+                                                <RectangleStackIcon className="h-5 w-5" />
+                                            </button>
+                                            <button onClick={() => handleDelete(group)} title="Delete Group" className="text-red-500 hover:text-red-400">
+                                                <TrashIcon className="h-5 w-5" />
+                                            </button>
+                                        </div>
+                                    </td>
+                                </tr>
+                            ))}
+                        </tbody>
+                    </table>
+                </div>
+            </div>
+            {isAdding && <AddGroupModal onClose={() => setIsAdding(false)} onSaved={() => { setIsAdding(false); fetchGroups(); }} />}
+            {editingMembers && (
+                <ChecklistModal
+                    title={`Members of ${editingMembers.name}`}
+                    hint="Members can launch the applications of this group."
+                    items={users.map(u => ({ id: u.id, label: u.username }))}
+                    initial={editingMembers.userIds}
+                    onClose={() => setEditingMembers(null)}
+                    onSave={saveMembers(editingMembers)}
+                />
+            )}
+            {editingApps && (
+                <ChecklistModal
+                    title={`Applications of ${editingApps.name}`}
+                    hint="Only the members of their groups can launch these applications."
+                    items={apps.map(a => ({ id: a.id, label: a.name }))}
+                    initial={editingApps.applicationIds}
+                    onClose={() => setEditingApps(null)}
+                    onSave={saveApps(editingApps)}
+                />
+            )}
+        </div>
+    );
+};
+
+export default GroupManagementPage;
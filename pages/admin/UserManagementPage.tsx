@@ -1,13 +1,19 @@
 import React, { useState, useEffect } from 'react';
-import { User } from '../../types';
-import { getUsers, addUser, deleteUser, resetUserPassword } from '../../services/api';
-import { PlusIcon, TrashIcon, KeyIcon, ExclamationTriangleIcon, CheckCircleIcon } from '@heroicons/react/24/outline';
+import { User, Role } from '../../types';
+import { getUsers, addUser, deleteUser, resetUserPassword, unlockUser, revokeUserSessions, resetUserTwoFactor, getRoles, setUserRoles } from '../../services/api';
+import { useAuth, can } from '../../hooks/useAuth';
+import { APITokensModal } from '../../components/APITokens';
+import { PlusIcon, TrashIcon, KeyIcon, LockOpenIcon, ArrowRightStartOnRectangleIcon, ExclamationTriangleIcon, CheckCircleIcon, DevicePhoneMobileIcon, ShieldCheckIcon, CodeBracketIcon } from '@heroicons/react/24/outline';
 
+// Making a user an admin takes the permission to manage roles. A service
+// account has no password and only uses API tokens.
 const AddUserModal: React.FC<{
     onClose: () => void;
-    onSave: (user: Omit<User, 'id'>, password?: string) => void;
-}> = ({ onClose, onSave }) => {
+    onSave: (user: Omit<User, 'id'>, password?: string, serviceAccount?: boolean) => void;
+    canGrantAdmin: boolean;
+}> = ({ onClose, onSave, canGrantAdmin }) => {
     const [userData, setUserData] = useState({ username: '', isAdmin: false });
+    const [serviceAccount, setServiceAccount] = useState(false);
     const [password, setPassword] = useState('');
     const [confirmPassword, setConfirmPassword] = useState('');
     const [error, setError] = useState('');
@@ -15,7 +21,11 @@ const AddUserModal: React.FC<{
     const handleSubmit = (e: React.FormEvent) => {
         e.preventDefault();
         setError('');
-        
+
+        if (serviceAccount) {
+            onSave({ username: userData.username, isAdmin: userData.isAdmin }, undefined, true);
+            return;
+        }
         if (password !== confirmPassword) {
             setError("Passwords do not match.");
             return;
@@ -43,15 +53,27 @@ const AddUserModal: React.FC<{
                         />
                          <p className="text-xs text-gray-400 mt-1">Lowercase, no spaces.</p>
                     </div>
-                    <div>
-                        <label className="block text-sm font-medium text-gray-300">Password</label>
-                        <input type="password" value={password} onChange={e => setPassword(e.target.value)} required className="mt-1 block w-full px-3 py-2 border border-gray-700 bg-gray-900 rounded-md shadow-sm" />
-                    </div>
-                    <div>
-                        <label className="block text-sm font-medium text-gray-300">Confirm Password</label>
-                        <input type="password" value={confirmPassword} onChange={e => setConfirmPassword(e.target.value)} required className="mt-1 block w-full px-3 py-2 border border-gray-700 bg-gray-900 rounded-md shadow-sm" />
-                    </div>
                     <div className="flex items-center">
+                        <input
+                           id="serviceAccount"
+                           type="checkbox"
+                           checked={serviceAccount}
+                           onChange={e => setServiceAccount(e.target.checked)}
+                           className="h-4 w-4 text-accent bg-gray-700 border-gray-600 rounded focus:ring-accent"
+                         />
+                         <label htmlFor="serviceAccount" className="ml-2 block text-sm text-gray-300">Service account (API tokens only, cannot sign in)</label>
+                    </div>
+                    {!serviceAccount && <>
+                        <div>
+                            <label className="block text-sm font-medium text-gray-300">Password</label>
+                            <input type="password" value={password} onChange={e => setPassword(e.target.value)} required className="mt-1 block w-full px-3 py-2 border border-gray-700 bg-gray-900 rounded-md shadow-sm" />
+                        </div>
+                        <div>
+                            <label className="block text-sm font-medium text-gray-300">Confirm Password</label>
+                            <input type="password" value={confirmPassword} onChange={e => setConfirmPassword(e.target.value)} required className="mt-1 block w-full px-3 py-2 border border-gray-700 bg-gray-900 rounded-md shadow-sm" />
+                        </div>
+                    </>}
+                    {canGrantAdmin && <div className="flex items-center">
                         <input
                            id="isAdmin"
                            type="checkbox"
@@ -60,7 +82,61 @@ const AddUserModal: React.FC<{
                            className="h-4 w-4 text-accent bg-gray-700 border-gray-600 rounded focus:ring-accent"
                          />
                          <label htmlFor="isAdmin" className="ml-2 block text-sm text-gray-300">Is Admin?</label>
+                    </div>}
+                    {error && <p className="text-red-500 text-sm">{error}</p>}
+                    <div className="flex justify-end space-x-4 pt-4">
+                        <button type="button" onClick={onClose} className="px-4 py-2 bg-secondary hover:bg-gray-600 rounded-md font-medium transition">Cancel</button>
+                        <button type="submit" className="px-4 py-2 bg-accent hover:bg-blue-600 rounded-md font-medium transition">Save</button>
                     </div>
+                </form>
+            </div>
+        </div>
+    );
+};
+
+// RolesModal picks the roles of a user. Their permissions add up.
+const RolesModal: React.FC<{
+    user: User;
+    roles: Role[];
+    onClose: () => void;
+    onSaved: () => void;
+}> = ({ user, roles, onClose, onSaved }) => {
+    const [selected, setSelected] = useState<string[]>(user.roles || []);
+    const [error, setError] = useState('');
+
+    const toggle = (id: string) =>
+        setSelected(selected.includes(id) ? selected.filter(r => r !== id) : [...selected, id]);
+
+    const handleSubmit = async (e: React.FormEvent) => {
+        e.preventDefault();
+        setError('');
+        try {
+            await setUserRoles(user.id, selected);
+            onSaved();
+        } catch (err) {
+            setError(err instanceof Error && err.message ? err.message : 'An unexpected error occurred.');
+        }
+    };
+
+    return (
+        <div className="fixed inset-0 bg-black bg-opacity-70 flex items-center justify-center z-50 p-4">
+            <div className="bg-surface rounded-lg p-8 w-full max-w-md">
+                <h2 className="text-2xl font-bold mb-4">Roles of {user.username}</h2>
+                <form onSubmit={handleSubmit} className="space-y-4">
+                    {roles.map(role => (
+                        <label key={role.id} className="flex items-start">
+                            <input
+                                type="checkbox"
+                                checked={selected.includes(role.id)}
+                                onChange={() => toggle(role.id)}
+                                className="mt-1 h-4 w-4 text-accent bg-gray-700 border-gray-600 rounded focus:ring-accent"
+                            />
+                            <span className="ml-2 text-sm">
+                                <span className="block text-gray-200">{role.name}</span>
+                                <span className="block text-xs text-gray-400">{role.description}</span>
+                            </span>
+                        </label>
+                    ))}
                     {error && <p className="text-red-500 text-sm">{error}</p>}
                     <div className="flex justify-end space-x-4 pt-4">
                         <button type="button" onClick={onClose} className="px-4 py-2 bg-secondary hover:bg-gray-600 rounded-md font-medium transition">Cancel</button>
@@ -107,34 +183,87 @@ const ConfirmationModal: React.FC<{
     );
 };
 
-const NotificationToast: React.FC<{ message: string; onClose: () => void }> = ({ message, onClose }) => {
+// A sticky toast stays until it is dismissed, e.g. to copy a generated password.
+const NotificationToast: React.FC<{ message: string; sticky?: boolean; onClose: () => void }> = ({ message, sticky, onClose }) => {
     useEffect(() => {
+        if (sticky) return;
         const timer = setTimeout(onClose, 3000);
         return () => clearTimeout(timer);
-    }, [onClose]);
+    }, [onClose, sticky]);
 
     return (
         <div className="fixed bottom-5 right-5 bg-green-600 text-white py-2 px-4 rounded-lg shadow-lg flex items-center z-50">
             <CheckCircleIcon className="h-5 w-5 mr-2" />
-            <span>{message}</span>
+            <span className="select-all">{message}</span>
+            {sticky && <button onClick={onClose} className="ml-4 font-bold">&times;</button>}
         </div>
     );
 };
 
 
+const confirmationText = {
+    delete: {
+        title: 'Delete User',
+        message: (username: string) => `Are you sure you want to delete the user "${username}"? This action cannot be undone.`,
+        confirm: 'Delete',
+        color: 'bg-red-600 hover:bg-red-700',
+    },
+    reset: {
+        title: 'Reset Password',
+        message: (username: string) => `Are you sure you want to reset the password for "${username}"? A random password will be generated and shown once.`,
+        confirm: 'Reset',
+        color: 'bg-yellow-600 hover:bg-yellow-700',
+    },
+    revoke: {
+        title: 'Sign Out Everywhere',
+        message: (username: string) => `Sign "${username}" out of every browser and device? Their running sessions keep running.`,
+        confirm: 'Sign Out',
+        color: 'bg-yellow-600 hover:bg-yellow-700',
+    },
+    reset2fa: {
+        title: 'Reset Two-Factor',
+        message: (username: string) => `Remove the authenticator app, passkeys and recovery codes of "${username}"? Use this when they lost their device.`,
+        confirm: 'Reset',
+        color: 'bg-yellow-600 hover:bg-yellow-700',
+    },
+};
+
+// Users from an identity provider and service accounts have no password to
+// reset.
+const isLocal = (user: User) => !user.authProvider || user.authProvider === 'local';
+const isService = (user: User) => user.authProvider === 'service';
+
+const providerBadges: Record<string, { label: string; title: string }> = {
+    ldap: { label: 'LDAP', title: 'Signs in with their directory password' },
+    oidc: { label: 'SSO', title: 'Signs in through the identity provider' },
+    service: { label: 'Service', title: 'Cannot sign in; authenticates with API tokens' },
+};
+
+// Locked after too many failed logins, until an admin unlocks or it expires.
+const isLocked = (user: User) => !!user.lockedUntil && new Date(user.lockedUntil) > new Date();
+
 const UserManagementPage: React.FC = () => {
+    const { user: me } = useAuth();
+    const canManageRoles = can(me, 'roles:manage');
     const [users, setUsers] = useState<User[]>([]);
+    const [roles, setRoles] = useState<Role[]>([]);
+    const [editingRoles, setEditingRoles] = useState<User | null>(null);
+    const [editingTokens, setEditingTokens] = useState<User | null>(null);
     const [loading, setLoading] = useState(true);
     const [isAddModalOpen, setAddModalOpen] = useState(false);
     const [confirmation, setConfirmation] = useState<{
-        action: 'delete' | 'reset';
+        action: 'delete' | 'reset' | 'revoke' | 'reset2fa';
         user: User;
     } | null>(null);
-    const [notification, setNotification] = useState<string | null>(null);
+    const [notification, setNotification] = useState<{ message: string; sticky?: boolean } | null>(null);
 
     useEffect(() => {
         fetchUsers();
-    }, []);
+        if (canManageRoles) getRoles().then(setRoles).catch(() => undefined);
+    }, [canManageRoles]);
+
+    const roleNames = (user: User) =>
+        roles.filter(r => user.roles?.includes(r.id)).map(r => r.name);
 
     const fetchUsers = async () => {
         setLoading(true);
@@ -143,11 +272,11 @@ const UserManagementPage: React.FC = () => {
         setLoading(false);
     };
 
-    const handleSaveUser = async (user: Omit<User, 'id'>, password?: string) => {
-        await addUser(user, password!);
+    const handleSaveUser = async (user: Omit<User, 'id'>, password?: string, serviceAccount?: boolean) => {
+        await addUser(user, password, serviceAccount);
         fetchUsers();
         setAddModalOpen(false);
-        setNotification(`User "${user.username}" created successfully.`);
+        setNotification({ message: `User "${user.username}" created successfully.` });
     }
     
     const handleConfirmAction = async () => {
@@ -156,16 +285,30 @@ const UserManagementPage: React.FC = () => {
 
         if (action === 'delete') {
             await deleteUser(user.id);
-            setNotification(`User "${user.username}" has been deleted.`);
+            setNotification({ message: `User "${user.username}" has been deleted.` });
         } else if (action === 'reset') {
-            await resetUserPassword(user.id);
-            setNotification(`Password for "${user.username}" has been reset.`);
+            const result = await resetUserPassword(user.id);
+            setNotification(result?.password
+                ? { message: `Password for "${user.username}" has been reset to "${result.password}". It is shown only once; the user must change it at next login.`, sticky: true }
+                : { message: `Password for "${user.username}" has been reset.` });
+        } else if (action === 'revoke') {
+            await revokeUserSessions(user.id);
+            setNotification({ message: `User "${user.username}" has been signed out everywhere.` });
+        } else if (action === 'reset2fa') {
+            await resetUserTwoFactor(user.id);
+            setNotification({ message: `Two-factor authentication of "${user.username}" has been reset.` });
         }
         
         setConfirmation(null);
         fetchUsers();
     };
 
+    const handleUnlock = async (user: User) => {
+        await unlockUser(user.id);
+        setNotification({ message: `User "${user.username}" has been unlocked.` });
+        fetchUsers();
+    };
+
     if (loading) return <p>Loading users...</p>;
 
     return (
@@ -198,12 +341,38 @@ const UserManagementPage: React.FC = () => {
                                         <p className="text-text-primary whitespace-no-wrap">{user.username}</p>
                                     </td>
                                     <td className="px-5 py-5 text-sm">
-                                        <span className={`px-2 inline-flex text-xs leading-5 font-semibold rounded-full ${user.isAdmin ? 'bg-indigo-800 text-indigo-100' : 'bg-gray-600 text-gray-100'}`}>
-                                            {user.isAdmin ? 'Admin' : 'User'}
-                                        </span>
+                                        {roles.length > 0 ? roleNames(user).map(name => (
+                                            <span key={name} className={`mr-2 px-2 inline-flex text-xs leading-5 font-semibold rounded-full ${name === 'admin' ? 'bg-indigo-800 text-indigo-100' : 'bg-gray-600 text-gray-100'}`}>
+                                                {name}
+                                            </span>
+                                        )) : (
+                                            <span className={`px-2 inline-flex text-xs leading-5 font-semibold rounded-full ${user.isAdmin ? 'bg-indigo-800 text-indigo-100' : 'bg-gray-600 text-gray-100'}`}>
+                                                {user.isAdmin ? 'Admin' : 'User'}
+                                            </span>
+                                        )}
+                                        {!isLocal(user) && (
+                                            <span className="ml-2 px-2 inline-flex text-xs leading-5 font-semibold rounded-full bg-gray-700 text-gray-200" title={(providerBadges[user.authProvider!] || providerBadges.oidc).title}>
+                                                {(providerBadges[user.authProvider!] || providerBadges.oidc).label}
+                                            </span>
+                                        )}
+                                        {user.totpEnabled && (
+                                            <span className="ml-2 px-2 inline-flex text-xs leading-5 font-semibold rounded-full bg-green-800 text-green-100" title="Signs in with an authenticator app code">
+                                                2FA
+                                            </span>
+                                        )}
+                                        {isLocked(user) && (
+                                            <span className="ml-2 px-2 inline-flex text-xs leading-5 font-semibold rounded-full bg-red-800 text-red-100" title={`Locked until ${new Date(user.lockedUntil!).toLocaleString()}`}>
+                                                Locked
+                                            </span>
+                                        )}
                                     </td>
                                     <td className="px-5 py-5 text-sm space-x-2">
-                                        <button onClick={() => setConfirmation({ action: 'reset', user })} className="text-yellow-400 hover:text-yellow-300 p-1" title="Reset Password"><KeyIcon className="h-5 w-5" /></button>
+                                        {canManageRoles && <button onClick={() => setEditingRoles(user)} className="text-indigo-400 hover:text-indigo-300 p-1" title="Edit Roles"><ShieldCheckIcon className="h-5 w-5" /></button>}
+                                        {isLocked(user) && <button onClick={() => handleUnlock(user)} className="text-green-400 hover:text-green-300 p-1" title="Unlock User"><LockOpenIcon className="h-5 w-5" /></button>}
+                                        <button onClick={() => setConfirmation({ action: 'revoke', user })} className="text-blue-400 hover:text-blue-300 p-1" title="Sign Out Everywhere"><ArrowRightStartOnRectangleIcon className="h-5 w-5" /></button>
+                                        {isService(user) && <button onClick={() => setEditingTokens(user)} className="text-indigo-400 hover:text-indigo-300 p-1" title="API Tokens"><CodeBracketIcon className="h-5 w-5" /></button>}
+                                        {user.authProvider !== 'oidc' && !isService(user) && <button onClick={() => setConfirmation({ action: 'reset2fa', user })} className="text-yellow-400 hover:text-yellow-300 p-1" title="Reset Two-Factor"><DevicePhoneMobileIcon className="h-5 w-5" /></button>}
+                                        {isLocal(user) && <button onClick={() => setConfirmation({ action: 'reset', user })} className="text-yellow-400 hover:text-yellow-300 p-1" title="Reset Password"><KeyIcon className="h-5 w-5" /></button>}
                                         <button onClick={() => setConfirmation({ action: 'delete', user })} className="text-red-400 hover:text-red-300 p-1" title="Delete User"><TrashIcon className="h-5 w-5" /></button>
                                     </td>
                                 </tr>
@@ -213,24 +382,35 @@ const UserManagementPage: React.FC = () => {
                 </div>
             </div>
 
-            {isAddModalOpen && <AddUserModal onClose={() => setAddModalOpen(false)} onSave={handleSaveUser} />}
+            {isAddModalOpen && <AddUserModal onClose={() => setAddModalOpen(false)} onSave={handleSaveUser} canGrantAdmin={canManageRoles} />}
+
+            {editingRoles && (
+                <RolesModal
+                    user={editingRoles}
+                    roles={roles}
+                    onClose={() => setEditingRoles(null)}
+                    onSaved={() => {
+                        setNotification({ message: `Roles of "${editingRoles.username}" have been updated.` });
+                        setEditingRoles(null);
+                        fetchUsers();
+                    }}
+                />
+            )}
             
+            {editingTokens && <APITokensModal user={editingTokens} onClose={() => setEditingTokens(null)} />}
+
             {confirmation && (
                 <ConfirmationModal 
-                    title={confirmation.action === 'delete' ? 'Delete User' : 'Reset Password'}
-                    message={
-                        confirmation.action === 'delete' 
-                        ? `Are you sure you want to delete the user "${confirmation.user.username}"? This action cannot be undone.`
-                        : `Are you sure you want to reset the password for "${confirmation.user.username}"? The new password will be "password".`
-                    }
+                    title={confirmationText[confirmation.action].title}
+                    message={confirmationText[confirmation.action].message(confirmation.user.username)}
                     onConfirm={handleConfirmAction}
                     onCancel={() => setConfirmation(null)}
-                    confirmText={confirmation.action === 'delete' ? 'Delete' : 'Reset'}
-                    confirmColor={confirmation.action === 'delete' ? 'bg-red-600 hover:bg-red-700' : 'bg-yellow-600 hover:bg-yellow-700'}
+                    confirmText={confirmationText[confirmation.action].confirm}
+                    confirmColor={confirmationText[confirmation.action].color}
                 />
             )}
 
-            {notification && <NotificationToast message={notification} onClose={() => setNotification(null)} />}
+            {notification && <NotificationToast message={notification.message} sticky={notification.sticky} onClose={() => setNotification(null)} />}
         </div>
     );
 };
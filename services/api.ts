@@ -1,9 +1,12 @@
-import { User, Session, Application, PortainerConfig, PortainerStatus } from '../types';
+import { User, Session, Application, PortainerConfig, PortainerStatus, Role, Group, APIToken } from '../types';
 
 const API_BASE = (import.meta.env && import.meta.env.VITE_API_BASE) || process.env.API_BASE || '/api';
+// The access token lives only in an HttpOnly cookie, out of reach of
+// scripts. The server requires this header on cookie-authenticated writes,
+// which other origins, such as the one sessions are served from, cannot
+// send.
 function authHeaders() {
-    const token = localStorage.getItem('jwt');
-    return token ? { Authorization: `Bearer ${token}` } : {};
+    return { 'X-Requested-With': 'XMLHttpRequest' };
 }
 
 async function handleResponse(res: Response) {
@@ -14,18 +17,91 @@ async function handleResponse(res: Response) {
     if (res.status === 204) return null;
     return res.json();
 }
+
+let refreshing: Promise<boolean> | null = null;
+
+// Exchanges the refresh cookie for a new access token and cookie. Callers
+// share one request, as a refresh token can only be used once.
+export function refreshToken(): Promise<boolean> {
+    if (!refreshing) {
+        refreshing = (async () => {
+            for (let attempt = 0; attempt < 2; attempt++) {
+                const res = await fetch(`${API_BASE}/auth/refresh`, { method: 'POST' });
+                if (res.ok) return true;
+                // 409: another tab refreshed with the same cookie at the same
+                // time; the browser has its new cookie by now.
+                if (res.status !== 409) return false;
+            }
+            return false;
+        })().catch(() => false).finally(() => { refreshing = null; });
+    }
+    return refreshing;
+}
+
+// fetch for authenticated calls: on 401 the access token has expired or was
+// revoked, so refresh it once and retry.
+async function authFetch(input: string, init: RequestInit = {}): Promise<Response> {
+    const res = await fetch(input, init);
+    if (res.status !== 401 || !(await refreshToken())) return res;
+    return fetch(input, init);
+}
 // Auth
-export async function login(username: string, password: string): Promise<void> {
+// Users who must change their password get a token that only works for
+// changePassword. With two-factor authentication the token only works for
+// verifyMFA, and users who must enroll first get one for enrollTOTP and
+// confirmTOTP.
+export interface LoginResult {
+    mustChangePassword: boolean;
+    mfaRequired: boolean;
+    mfaEnrollmentRequired: boolean;
+    // The second factors a login waiting for one may use: 'totp' and
+    // 'passkey'.
+    mfaMethods: string[];
+}
+export async function login(username: string, password: string): Promise<LoginResult> {
     const res = await fetch(`${API_BASE}/auth/login`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify({ username, password })
     });
-    const data = await handleResponse(res) as { token: string };
-    localStorage.setItem('jwt', data.token);
+    return storeLogin(await handleResponse(res));
+}
+function storeLogin(data: { mustChangePassword?: boolean; mfaRequired?: boolean; mfaEnrollmentRequired?: boolean; methods?: string[] }): LoginResult {
+    return {
+        mustChangePassword: !!data.mustChangePassword,
+        mfaRequired: !!data.mfaRequired,
+        mfaEnrollmentRequired: !!data.mfaEnrollmentRequired,
+        mfaMethods: data.methods || [],
+    };
+}
+// Takes a code from the authenticator app or a recovery code.
+export async function verifyMFA(code: string): Promise<LoginResult> {
+    const res = await fetch(`${API_BASE}/auth/2fa/verify`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify({ code })
+    });
+    return storeLogin(await handleResponse(res));
+}
+// Single sign-on starts with a full page navigation to oidc/login. The
+// provider sends the browser back with the login cookies; completeSSOLogin
+// rotates them and loads the user.
+export async function getSSOConfig(): Promise<{ enabled: boolean }> {
+    const res = await fetch(`${API_BASE}/auth/oidc/config`);
+    return handleResponse(res);
+}
+export const SSO_LOGIN_URL = `${API_BASE}/auth/oidc/login`;
+export async function completeSSOLogin(): Promise<User | null> {
+    if (!(await refreshToken())) return null;
+    return getMe();
+}
+export async function getMe(): Promise<User> {
+    const res = await authFetch(`${API_BASE}/auth/me`, { headers: { ...authHeaders() } });
+    return handleResponse(res);
 }
 export function logout() {
-    localStorage.removeItem('jwt');
+    // Revokes the login server-side and clears the cookies.
+    fetch(`${API_BASE}/auth/logout`, { method: 'POST', headers: authHeaders() }).catch(() => undefined);
 }
 export async function changePassword(current: string, newPassword: string) {
     const res = await fetch(`${API_BASE}/auth/change-password`, {
@@ -33,47 +109,336 @@ export async function changePassword(current: string, newPassword: string) {
         headers: { 'Content-Type': 'application/json', ...authHeaders() },
         body: JSON.stringify({ currentPassword: current, newPassword })
     });
+    // The answer sets a new, unrestricted cookie; other logins are signed
+    // out.
+    await handleResponse(res);
+}
+
+// Two-factor authentication
+export interface MFAStatus {
+    enabled: boolean;
+    passkeys: number;
+    required: boolean;
+    available: boolean;
+    recoveryCodesLeft: number;
+}
+export async function getMFAStatus(): Promise<MFAStatus> {
+    const res = await authFetch(`${API_BASE}/auth/2fa`, { headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+// Returns the secret to add to an authenticator app, also as an otpauth://
+// URL. Nothing changes until confirmTOTP.
+export async function enrollTOTP(): Promise<{ secret: string; url: string }> {
+    const res = await authFetch(`${API_BASE}/auth/2fa/totp/enroll`, { method: 'POST', headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+// The recovery codes are only ever returned here and by
+// regenerateRecoveryCodes. When enrolling was required to log in, the
+// answer also carries the login's token.
+export async function confirmTOTP(code: string): Promise<{ recoveryCodes: string[]; login?: LoginResult }> {
+    const res = await authFetch(`${API_BASE}/auth/2fa/totp/confirm`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify({ code })
+    });
+    const data = await handleResponse(res);
+    return { recoveryCodes: data.recoveryCodes, login: data.token ? storeLogin(data) : undefined };
+}
+export async function disableTOTP(code: string): Promise<void> {
+    const res = await authFetch(`${API_BASE}/auth/2fa/totp/disable`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify({ code })
+    });
+    return handleResponse(res);
+}
+export async function regenerateRecoveryCodes(code: string): Promise<{ recoveryCodes: string[] }> {
+    const res = await authFetch(`${API_BASE}/auth/2fa/recovery-codes`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify({ code })
+    });
+    return handleResponse(res);
+}
+
+// Passkeys (WebAuthn). Every ceremony is a begin call, whose options go to
+// navigator.credentials, and a finish call with the browser's credential
+// and the state begin returned. Binary fields travel base64url-encoded.
+export interface Passkey {
+    id: string;
+    name: string;
+    transports: string[];
+    createdAt: string;
+    lastUsedAt?: string;
+}
+export const passkeysSupported = () => typeof window !== 'undefined' && !!window.PublicKeyCredential;
+
+function fromBase64URL(value: string): ArrayBuffer {
+    const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+    const binary = atob(base64 + '='.repeat((4 - base64.length % 4) % 4));
+    return Uint8Array.from(binary, c => c.charCodeAt(0)).buffer;
+}
+function toBase64URL(buffer: ArrayBuffer | null): string | undefined {
+    if (!buffer) return undefined;
+    const binary = String.fromCharCode(...Array.from(new Uint8Array(buffer)));
+    return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+type Descriptor = { type: 'public-key'; id: string; transports?: AuthenticatorTransport[] };
+const descriptors = (list: Descriptor[] | undefined) => (list || []).map(d => ({ ...d, id: fromBase64URL(d.id) }));
+
+async function beginCeremony(path: string, token: boolean, body: object = {}): Promise<{ publicKey: any; state: string }> {
+    const url = `${API_BASE}/auth/${path}`;
+    const res = token
+        ? await authFetch(url, { method: 'POST', headers: { 'Content-Type': 'application/json', ...authHeaders() }, body: JSON.stringify(body) })
+        : await fetch(url, { method: 'POST' });
+    return handleResponse(res);
+}
+async function finishCeremony(path: string, body: object) {
+    const res = await fetch(`${API_BASE}/auth/${path}`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify(body)
+    });
     return handleResponse(res);
 }
 
+async function createCredential(publicKey: any) {
+    const credential = await navigator.credentials.create({
+        publicKey: {
+            ...publicKey,
+            challenge: fromBase64URL(publicKey.challenge),
+            user: { ...publicKey.user, id: fromBase64URL(publicKey.user.id) },
+            excludeCredentials: descriptors(publicKey.excludeCredentials),
+        }
+    }) as PublicKeyCredential | null;
+    if (!credential) throw new Error('No passkey was created.');
+    const response = credential.response as AuthenticatorAttestationResponse;
+    return {
+        id: credential.id,
+        rawId: toBase64URL(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: toBase64URL(response.clientDataJSON),
+            attestationObject: toBase64URL(response.attestationObject),
+            transports: response.getTransports ? response.getTransports() : [],
+        },
+    };
+}
+async function getCredential(publicKey: any) {
+    const credential = await navigator.credentials.get({
+        publicKey: {
+            ...publicKey,
+            challenge: fromBase64URL(publicKey.challenge),
+            allowCredentials: descriptors(publicKey.allowCredentials),
+        }
+    }) as PublicKeyCredential | null;
+    if (!credential) throw new Error('No passkey was chosen.');
+    const response = credential.response as AuthenticatorAssertionResponse;
+    return {
+        id: credential.id,
+        rawId: toBase64URL(credential.rawId),
+        type: credential.type,
+        response: {
+            clientDataJSON: toBase64URL(response.clientDataJSON),
+            authenticatorData: toBase64URL(response.authenticatorData),
+            signature: toBase64URL(response.signature),
+            userHandle: toBase64URL(response.userHandle),
+        },
+    };
+}
+
+export async function getPasskeys(): Promise<Passkey[]> {
+    const res = await authFetch(`${API_BASE}/auth/webauthn/credentials`, { headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+// It takes a step-up token, except when enrolling was required to log in;
+// then the answer also carries the login's token.
+export async function registerPasskey(name: string, stepUpToken?: string): Promise<{ passkey: Passkey; login?: LoginResult }> {
+    const { publicKey, state } = await beginCeremony('webauthn/register/begin', true, { stepUpToken });
+    const credential = await createCredential(publicKey);
+    const data = await finishCeremony('webauthn/register/finish', { state, name, credential, stepUpToken });
+    return data.token ? { passkey: data.credential, login: storeLogin(data) } : { passkey: data };
+}
+// Confirms the current password for a few minutes, to register a passkey.
+export async function stepUp(password: string): Promise<string> {
+    const res = await authFetch(`${API_BASE}/auth/step-up`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify({ password })
+    });
+    const data = await handleResponse(res);
+    return data.stepUpToken;
+}
+export async function deletePasskey(id: string): Promise<void> {
+    const res = await authFetch(`${API_BASE}/auth/webauthn/credentials/${id}`, { method: 'DELETE', headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+// Signs in without a username or password.
+export async function loginWithPasskey(): Promise<LoginResult> {
+    const { publicKey, state } = await beginCeremony('webauthn/login/begin', false);
+    const credential = await getCredential(publicKey);
+    return storeLogin(await finishCeremony('webauthn/login/finish', { state, credential }));
+}
+// The second step of a login, like verifyMFA.
+export async function verifyMFAWithPasskey(): Promise<LoginResult> {
+    const { publicKey, state } = await beginCeremony('2fa/webauthn/begin', true);
+    const credential = await getCredential(publicKey);
+    return storeLogin(await finishCeremony('2fa/webauthn/finish', { state, credential }));
+}
+
 // Users (admin)
 export async function getUsers(): Promise<User[]> {
-    const res = await fetch(`${API_BASE}/admin/users`, { headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/admin/users`, { headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 
-export async function addUser(user: Omit<User, 'id'>, password: string): Promise<User> {
-    const res = await fetch(`${API_BASE}/admin/users`, {
+// A service account has no password: it only authenticates with the API
+// tokens issued to it.
+export async function addUser(user: Omit<User, 'id'>, password?: string, serviceAccount = false): Promise<User> {
+    const res = await authFetch(`${API_BASE}/admin/users`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json', ...authHeaders() },
-        body: JSON.stringify({ ...user, password })
+        body: JSON.stringify({ ...user, password, serviceAccount })
     });
     return handleResponse(res);
 }
 
 export async function deleteUser(userId: string): Promise<void> {
-    const res = await fetch(`${API_BASE}/admin/users/${userId}`, { method: 'DELETE', headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/admin/users/${userId}`, { method: 'DELETE', headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+
+export async function updateUser(userId: string, changes: Partial<Pick<User, 'username' | 'isAdmin'>>): Promise<User> {
+    const res = await authFetch(`${API_BASE}/admin/users/${userId}`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify(changes)
+    });
     return handleResponse(res);
 }
 
-export async function resetUserPassword(userId: string): Promise<void> {
-    const res = await fetch(`${API_BASE}/admin/users/${userId}/reset-password`, { method: 'POST', headers: { ...authHeaders() } });
+// Roles (admin). Built-in roles cannot be changed; the admin role is the
+// same as isAdmin.
+export async function getRoles(): Promise<Role[]> {
+    const res = await authFetch(`${API_BASE}/admin/roles`, { headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+
+export async function setUserRoles(userId: string, roleIds: string[]): Promise<User> {
+    const res = await authFetch(`${API_BASE}/admin/users/${userId}/roles`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify({ roles: roleIds })
+    });
+    return handleResponse(res);
+}
+
+// API tokens: the signed-in user's own or, given a userId, those of a
+// service account (admin).
+const tokensURL = (userId?: string) => userId ? `${API_BASE}/admin/users/${userId}/tokens` : `${API_BASE}/tokens`;
+
+export async function getAPITokens(userId?: string): Promise<APIToken[]> {
+    const res = await authFetch(tokensURL(userId), { headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+
+// The returned token carries the secret, which is not shown again.
+export async function createAPIToken(name: string, scopes: string[], expiresAt?: string, userId?: string): Promise<APIToken> {
+    const res = await authFetch(tokensURL(userId), {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify({ name, scopes, expiresAt })
+    });
+    return handleResponse(res);
+}
+
+export async function deleteAPIToken(tokenId: string, userId?: string): Promise<void> {
+    const res = await authFetch(`${tokensURL(userId)}/${tokenId}`, { method: 'DELETE', headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+
+// Groups (admin). An application in a group is only available to the
+// members of its groups.
+export async function getGroups(): Promise<Group[]> {
+    const res = await authFetch(`${API_BASE}/admin/groups`, { headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+
+export async function createGroup(name: string, description: string): Promise<Group> {
+    const res = await authFetch(`${API_BASE}/admin/groups`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify({ name, description })
+    });
+    return handleResponse(res);
+}
+
+export async function deleteGroup(groupId: string): Promise<void> {
+    const res = await authFetch(`${API_BASE}/admin/groups/${groupId}`, { method: 'DELETE', headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+
+export async function addGroupMember(groupId: string, userId: string): Promise<Group> {
+    const res = await authFetch(`${API_BASE}/admin/groups/${groupId}/members/${userId}`, { method: 'PUT', headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+
+export async function removeGroupMember(groupId: string, userId: string): Promise<Group> {
+    const res = await authFetch(`${API_BASE}/admin/groups/${groupId}/members/${userId}`, { method: 'DELETE', headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+
+export async function setGroupApplications(groupId: string, applicationIds: string[]): Promise<Group> {
+    const res = await authFetch(`${API_BASE}/admin/groups/${groupId}/apps`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify({ applicationIds })
+    });
+    return handleResponse(res);
+}
+
+// Without a password the server generates one and returns it only in this
+// response. Either way the user must change it at next login.
+export async function resetUserPassword(userId: string, password?: string): Promise<{ password?: string } | null> {
+    const res = await authFetch(`${API_BASE}/admin/users/${userId}/reset-password`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', ...authHeaders() },
+        body: JSON.stringify(password ? { password } : {})
+    });
+    return handleResponse(res);
+}
+
+// Lifts a lockout after too many failed logins.
+export async function unlockUser(userId: string): Promise<User> {
+    const res = await authFetch(`${API_BASE}/admin/users/${userId}/unlock`, { method: 'POST', headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+
+// Removes a user's second factor, e.g. after they lost their device.
+export async function resetUserTwoFactor(userId: string): Promise<User> {
+    const res = await authFetch(`${API_BASE}/admin/users/${userId}/reset-2fa`, { method: 'POST', headers: { ...authHeaders() } });
+    return handleResponse(res);
+}
+
+// Signs the user out everywhere: all their logins are revoked.
+export async function revokeUserSessions(userId: string): Promise<void> {
+    const res = await authFetch(`${API_BASE}/admin/users/${userId}/revoke-sessions`, { method: 'POST', headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 
 // Applications
 export async function getApplications(): Promise<Application[]> {
-    const res = await fetch(`${API_BASE}/admin/apps`, { headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/admin/apps`, { headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 
 export async function getAvailableApplications(): Promise<Application[]> {
-    const res = await fetch(`${API_BASE}/apps`, { headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/apps`, { headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 
 export async function updateApplication(app: Application): Promise<Application> {
-    const res = await fetch(`${API_BASE}/admin/apps/${app.id}`, {
+    const res = await authFetch(`${API_BASE}/admin/apps/${app.id}`, {
         method: 'PUT',
         headers: { 'Content-Type': 'application/json', ...authHeaders() },
         body: JSON.stringify(app)
@@ -82,22 +447,22 @@ export async function updateApplication(app: Application): Promise<Application>
 }
 
 export async function scrapeApps(): Promise<any> {
-    const res = await fetch(`${API_BASE}/admin/apps/scrape`, { method: 'POST', headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/admin/apps/scrape`, { method: 'POST', headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 // Sessions
 export async function getSessionsForUser(): Promise<Session[]> {
-    const res = await fetch(`${API_BASE}/sessions`, { headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/sessions`, { headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 
 export async function getAllSessions(): Promise<Session[]> {
-    const res = await fetch(`${API_BASE}/admin/sessions`, { headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/admin/sessions`, { headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 
 export async function startSession(applicationId: string, isPersistent: boolean): Promise<Session> {
-    const res = await fetch(`${API_BASE}/sessions/launch`, {
+    const res = await authFetch(`${API_BASE}/sessions/launch`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json', ...authHeaders() },
         body: JSON.stringify({ applicationId, isPersistent })
@@ -105,18 +470,26 @@ export async function startSession(applicationId: string, isPersistent: boolean)
     return handleResponse(res);
 }
 
+// Returns the URL to open a session at. When sessions have an origin of
+// their own, it carries a one-time ticket that signs the browser in there.
+export async function connectSession(sessionId: string): Promise<string> {
+    const res = await authFetch(`${API_BASE}/sessions/${sessionId}/connect`, { method: 'POST', headers: { ...authHeaders() } });
+    const { url } = await handleResponse(res);
+    return url;
+}
+
 export async function stopSession(sessionId: string): Promise<void> {
-    const res = await fetch(`${API_BASE}/sessions/${sessionId}/stop`, { method: 'POST', headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/sessions/${sessionId}/stop`, { method: 'POST', headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 // Portainer
 export async function getPortainerConfig(): Promise<PortainerConfig> {
-    const res = await fetch(`${API_BASE}/admin/portainer`, { headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/admin/portainer`, { headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 
 export async function updatePortainerConfig(config: PortainerConfig): Promise<PortainerConfig> {
-    const res = await fetch(`${API_BASE}/admin/portainer`, {
+    const res = await authFetch(`${API_BASE}/admin/portainer`, {
         method: 'PUT',
         headers: { 'Content-Type': 'application/json', ...authHeaders() },
         body: JSON.stringify(config)
@@ -125,12 +498,12 @@ export async function updatePortainerConfig(config: PortainerConfig): Promise<Po
 }
 
 export async function getPortainerStatus(): Promise<PortainerStatus> {
-    const res = await fetch(`${API_BASE}/admin/portainer/status`, { headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/admin/portainer/status`, { headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 
 export async function deployPortainer(): Promise<any> {
-    const res = await fetch(`${API_BASE}/admin/portainer/deploy`, { method: 'POST', headers: { ...authHeaders() } });
+    const res = await authFetch(`${API_BASE}/admin/portainer/deploy`, { method: 'POST', headers: { ...authHeaders() } });
     return handleResponse(res);
 }
 // (Removed duplicate import)
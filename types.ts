@@ -2,6 +2,64 @@ export interface User {
   id: string;
   username: string;
   isAdmin: boolean;
+  mustChangePassword?: boolean;
+  lockedUntil?: string;
+  authProvider?: string;
+  totpEnabled?: boolean;
+  // Set while a login waits for its second factor ('verify') or for the
+  // user to enroll one ('enroll').
+  mfaPending?: 'verify' | 'enroll';
+  // The second factors the pending login accepts: 'totp' and 'passkey'.
+  mfaMethods?: string[];
+  // What the user's roles allow, from /auth/me.
+  permissions?: Permission[];
+  // The IDs of the user's roles, in the user management list.
+  roles?: string[];
+}
+
+// Permissions roles grant; each API route requires one.
+export type Permission =
+  | 'apps:read'
+  | 'sessions:launch'
+  | 'sessions:read_all'
+  | 'sessions:stop_all'
+  | 'sessions:connect_all'
+  | 'apps:manage'
+  | 'users:manage'
+  | 'groups:manage'
+  | 'roles:manage'
+  | 'portainer:manage'
+  | 'security:manage';
+
+export interface Role {
+  id: string;
+  name: string;
+  description: string;
+  permissions: Permission[];
+  builtin: boolean;
+}
+
+// A group of users. Applications restricted to groups can only be launched
+// by their members; the others by everyone.
+export interface Group {
+  id: string;
+  name: string;
+  description: string;
+  userIds: string[];
+  applicationIds: string[];
+}
+
+// A personal access token. The token itself is only returned when it is
+// created; it grants those of its scopes its user's roles grant.
+export interface APIToken {
+  id: string;
+  userId: string;
+  name: string;
+  scopes: Permission[];
+  expiresAt?: string;
+  lastUsedAt?: string;
+  createdAt: string;
+  token?: string;
 }
 
 export interface Session {
@@ -32,4 +90,8 @@ export interface PortainerStatus {
     status: 'running' | 'stopped' | 'error' | 'deploying';
     uptime: string;
     version: string;
+    // The step of a running deployment or the error of a failed one.
+    message?: string;
+    // Sent once, after a deployment that created the Portainer admin.
+    adminPassword?: string;
 }